@@ -71,6 +71,17 @@ func ValidateAPIKey(key string) bool {
 	return !revokedAt.Valid // Valid if not revoked
 }
 
+// LookupAPIKeyID returns the ID of a valid (existing, not revoked) API key
+func LookupAPIKeyID(key string) (int, bool) {
+	var id int
+	err := DB.QueryRow(
+		"SELECT id FROM api_keys WHERE key = ? AND revoked_at IS NULL",
+		key,
+	).Scan(&id)
+
+	return id, err == nil
+}
+
 // GetAPIKey retrieves an API key by its key string
 func GetAPIKey(key string) (*APIKey, error) {
 	var apiKey APIKey
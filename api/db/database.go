@@ -113,6 +113,95 @@ func createSchema() error {
 	CREATE UNIQUE INDEX IF NOT EXISTS idx_metrics_api_keys_time_key
 		ON metrics_api_keys(timestamp, api_key_id);
 	CREATE INDEX IF NOT EXISTS idx_metrics_api_keys_timestamp ON metrics_api_keys(timestamp);
+
+	-- Optimization presets: named query parameter bundles (JSON object in params)
+	-- api_key_id NULL = global preset, otherwise only visible to that key
+	CREATE TABLE IF NOT EXISTS presets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		params TEXT NOT NULL,
+		api_key_id INTEGER NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_presets_name_key ON presets(name, IFNULL(api_key_id, 0));
+
+	-- URL signing secrets for /img URLs, one per API key
+	CREATE TABLE IF NOT EXISTS signing_secrets (
+		api_key_id INTEGER PRIMARY KEY,
+		secret TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+
+	-- Job callback signing secrets, one per API key (separate from URL signing)
+	CREATE TABLE IF NOT EXISTS webhook_secrets (
+		api_key_id INTEGER PRIMARY KEY,
+		secret TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+
+	-- Asynchronous jobs: a queue of stored requests (bodies and results live in files)
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		type TEXT NOT NULL,
+		status TEXT NOT NULL,
+		api_key_id INTEGER NULL,
+		client_ip TEXT NOT NULL DEFAULT '',
+		content_type TEXT NOT NULL DEFAULT '',
+		query TEXT NOT NULL DEFAULT '',
+		progress INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		result_status INTEGER NOT NULL DEFAULT 0,
+		result_type TEXT NOT NULL DEFAULT '',
+		result_disposition TEXT NOT NULL DEFAULT '',
+		callback_url TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		started_at DATETIME NULL,
+		finished_at DATETIME NULL,
+		expires_at DATETIME NULL,
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_jobs_status_created ON jobs(status, created_at);
+	CREATE INDEX IF NOT EXISTS idx_jobs_expires ON jobs(expires_at);
+
+	-- Webhook deliveries: job callbacks and their retry state (dead = retries exhausted)
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id TEXT NOT NULL,
+		api_key_id INTEGER NULL,
+		url TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NULL,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+
+	-- Webhook attempts: one row per HTTP request made for a delivery
+	CREATE TABLE IF NOT EXISTS webhook_attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		delivery_id INTEGER NOT NULL,
+		attempt INTEGER NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		attempted_at DATETIME NOT NULL,
+		FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery ON webhook_attempts(delivery_id);
 	`
 
 	_, err := DB.Exec(schema)
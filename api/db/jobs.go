@@ -0,0 +1,188 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrJobNotFound is returned for unknown (or already removed) jobs
+var ErrJobNotFound = errors.New("job not found")
+
+// Job statuses
+const (
+	JobQueued     = "queued"
+	JobProcessing = "processing"
+	JobCompleted  = "completed"
+	JobFailed     = "failed"
+)
+
+// Job is a queued request together with the outcome once it has been processed.
+// The request body and the response body are stored outside the database.
+type Job struct {
+	ID                string     `json:"id"`
+	Type              string     `json:"type"`
+	Status            string     `json:"status"`
+	APIKeyID          *int       `json:"-"`
+	ClientIP          string     `json:"-"`
+	ContentType       string     `json:"-"`        // Content-Type of the request body
+	Query             string     `json:"-"`        // Query string of the request
+	Progress          int        `json:"progress"` // 0-100
+	Error             string     `json:"error,omitempty"`
+	ResultStatus      int        `json:"-"`                     // HTTP status of the response
+	ResultType        string     `json:"-"`                     // Content-Type of the response
+	ResultDisposition string     `json:"-"`                     // Content-Disposition of the response
+	CallbackURL       string     `json:"callbackUrl,omitempty"` // Notified by webhook when the job finishes
+	CreatedAt         time.Time  `json:"createdAt"`
+	StartedAt         *time.Time `json:"startedAt,omitempty"`
+	FinishedAt        *time.Time `json:"finishedAt,omitempty"`
+	ExpiresAt         *time.Time `json:"expiresAt,omitempty"`
+}
+
+// jobColumns lists the columns read by scanJob, in order
+const jobColumns = `id, type, status, api_key_id, client_ip, content_type, query, progress, error,
+	result_status, result_type, result_disposition, callback_url, created_at, started_at, finished_at, expires_at`
+
+// CreateJob queues a new job. job.ID must already be set.
+func CreateJob(job *Job) error {
+	job.Status = JobQueued
+	job.CreatedAt = time.Now().UTC()
+
+	if _, err := DB.Exec(
+		`INSERT INTO jobs (id, type, status, api_key_id, client_ip, content_type, query, callback_url, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.Type, job.Status, job.APIKeyID, job.ClientIP, job.ContentType, job.Query, job.CallbackURL, job.CreatedAt,
+	); err != nil {
+		return fmt.Errorf("failed to insert job: %w", err)
+	}
+	return nil
+}
+
+// GetJob finds a job by ID
+func GetJob(id string) (*Job, error) {
+	job, err := scanJob(DB.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query job: %w", err)
+	}
+	return job, nil
+}
+
+// ClaimNextJob marks the oldest queued job as processing and returns it, or nil
+// if the queue is empty. Each job is claimed by exactly one caller.
+func ClaimNextJob() (*Job, error) {
+	job, err := scanJob(DB.QueryRow(
+		`UPDATE jobs SET status = ?, started_at = ?
+		WHERE id = (SELECT id FROM jobs WHERE status = ? ORDER BY created_at, id LIMIT 1)
+		RETURNING `+jobColumns,
+		JobProcessing, time.Now().UTC(), JobQueued,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+	return job, nil
+}
+
+// UpdateJobProgress records how far (0-100) a processing job has got
+func UpdateJobProgress(id string, progress int) error {
+	if _, err := DB.Exec("UPDATE jobs SET progress = ? WHERE id = ? AND status = ?", progress, id, JobProcessing); err != nil {
+		return fmt.Errorf("failed to update job progress: %w", err)
+	}
+	return nil
+}
+
+// FinishJob stores the outcome of a processed job: its status, error message and
+// response metadata. The result is kept until expiresAt.
+func FinishJob(job *Job, expiresAt time.Time) error {
+	now := time.Now().UTC()
+	expiresAt = expiresAt.UTC()
+
+	result, err := DB.Exec(
+		`UPDATE jobs SET status = ?, progress = 100, error = ?, result_status = ?, result_type = ?,
+			result_disposition = ?, finished_at = ?, expires_at = ?
+		WHERE id = ?`,
+		job.Status, job.Error, job.ResultStatus, job.ResultType, job.ResultDisposition, now, expiresAt, job.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to finish job: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrJobNotFound
+	}
+
+	job.Progress = 100
+	job.FinishedAt = &now
+	job.ExpiresAt = &expiresAt
+	return nil
+}
+
+// RequeueInterruptedJobs puts jobs that were processing when the server stopped
+// back in the queue and returns how many there were
+func RequeueInterruptedJobs() (int64, error) {
+	result, err := DB.Exec(
+		"UPDATE jobs SET status = ?, progress = 0, started_at = NULL WHERE status = ?",
+		JobQueued, JobProcessing,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue jobs: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpiredJobs removes jobs whose results expired before now and returns
+// their IDs, so the caller can remove the stored bodies
+func DeleteExpiredJobs(now time.Time) ([]string, error) {
+	rows, err := DB.Query("DELETE FROM jobs WHERE expires_at IS NOT NULL AND expires_at < ? RETURNING id", now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired jobs: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("warning: failed to close rows: %v", err)
+		}
+	}()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan job ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// scanJob reads one job (selected with jobColumns) from a query result row
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var job Job
+	var apiKeyID sql.NullInt64
+	var startedAt, finishedAt, expiresAt sql.NullTime
+
+	if err := row.Scan(&job.ID, &job.Type, &job.Status, &apiKeyID, &job.ClientIP, &job.ContentType, &job.Query,
+		&job.Progress, &job.Error, &job.ResultStatus, &job.ResultType, &job.ResultDisposition, &job.CallbackURL,
+		&job.CreatedAt, &startedAt, &finishedAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	if apiKeyID.Valid {
+		id := int(apiKeyID.Int64)
+		job.APIKeyID = &id
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	if expiresAt.Valid {
+		job.ExpiresAt = &expiresAt.Time
+	}
+	return &job, nil
+}
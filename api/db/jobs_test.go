@@ -0,0 +1,81 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobQueue(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if job, err := ClaimNextJob(); err != nil || job != nil {
+		t.Fatalf("Expected empty queue, got %+v, %v", job, err)
+	}
+
+	first := &Job{ID: "first", Type: "optimize", ContentType: "multipart/form-data; boundary=x", Query: "quality=70"}
+	second := &Job{ID: "second", Type: "batch-optimize"}
+	for _, job := range []*Job{first, second} {
+		if err := CreateJob(job); err != nil {
+			t.Fatalf("Failed to create job: %v", err)
+		}
+	}
+
+	claimed, err := ClaimNextJob()
+	if err != nil {
+		t.Fatalf("Failed to claim job: %v", err)
+	}
+	if claimed == nil || claimed.ID != "first" || claimed.Status != JobProcessing || claimed.StartedAt == nil {
+		t.Fatalf("Expected first job to be claimed, got %+v", claimed)
+	}
+	if claimed.Query != "quality=70" || claimed.ContentType != first.ContentType {
+		t.Errorf("Request not preserved: %+v", claimed)
+	}
+
+	if err := UpdateJobProgress("first", 40); err != nil {
+		t.Fatalf("Failed to update progress: %v", err)
+	}
+	got, _ := GetJob("first")
+	if got.Progress != 40 {
+		t.Errorf("Expected progress 40, got %d", got.Progress)
+	}
+
+	// A restart puts the job that was processing back in the queue
+	if n, err := RequeueInterruptedJobs(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 requeued job, got %d, %v", n, err)
+	}
+	claimed, _ = ClaimNextJob()
+	if claimed == nil || claimed.ID != "first" || claimed.Progress != 0 {
+		t.Fatalf("Expected requeued first job, got %+v", claimed)
+	}
+
+	claimed.Status = JobCompleted
+	claimed.ResultStatus = 200
+	claimed.ResultType = "image/webp"
+	if err := FinishJob(claimed, time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("Failed to finish job: %v", err)
+	}
+	got, _ = GetJob("first")
+	if got.Status != JobCompleted || got.Progress != 100 || got.ResultType != "image/webp" || got.FinishedAt == nil || got.ExpiresAt == nil {
+		t.Errorf("Unexpected finished job: %+v", got)
+	}
+
+	if err := FinishJob(&Job{ID: "missing", Status: JobFailed}, time.Now()); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound, got %v", err)
+	}
+
+	ids, err := DeleteExpiredJobs(time.Now())
+	if err != nil {
+		t.Fatalf("Failed to delete expired jobs: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != "first" {
+		t.Errorf("Expected [first] to expire, got %v", ids)
+	}
+	if _, err := GetJob("first"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound after expiry, got %v", err)
+	}
+	if got, _ := GetJob("second"); got == nil || got.Status != JobQueued {
+		t.Errorf("Expected second job to stay queued, got %+v", got)
+	}
+}
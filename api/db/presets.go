@@ -0,0 +1,197 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Preset errors
+var (
+	ErrPresetNotFound = errors.New("preset not found")
+	ErrPresetExists   = errors.New("preset already exists")
+)
+
+// Preset is a named bundle of optimization query parameters. Presets without an
+// API key are global; scoped presets are only visible to the key that owns them.
+type Preset struct {
+	ID          int               `json:"id,omitempty"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Params      map[string]string `json:"params"`
+	APIKeyID    *int              `json:"api_key_id,omitempty"`
+	BuiltIn     bool              `json:"built_in,omitempty"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time        `json:"updated_at,omitempty"`
+}
+
+// CreatePreset stores a new preset and fills in its ID and timestamps
+func CreatePreset(preset *Preset) error {
+	params, err := json.Marshal(preset.Params)
+	if err != nil {
+		return fmt.Errorf("failed to encode preset parameters: %w", err)
+	}
+
+	result, err := DB.Exec(
+		"INSERT INTO presets (name, description, params, api_key_id) VALUES (?, ?, ?, ?)",
+		preset.Name, preset.Description, string(params), preset.APIKeyID,
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return ErrPresetExists
+		}
+		return fmt.Errorf("failed to insert preset: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+
+	now := time.Now()
+	preset.ID = int(id)
+	preset.CreatedAt = &now
+	preset.UpdatedAt = &now
+	return nil
+}
+
+// GetPreset finds a preset by name as seen by an API key: the key's own preset
+// wins over a global one with the same name. apiKeyID nil only sees global presets.
+func GetPreset(name string, apiKeyID *int) (*Preset, error) {
+	row := DB.QueryRow(
+		`SELECT id, name, description, params, api_key_id, created_at, updated_at
+		FROM presets
+		WHERE name = ? AND (api_key_id IS NULL OR api_key_id = ?)
+		ORDER BY api_key_id IS NULL
+		LIMIT 1`,
+		name, apiKeyID,
+	)
+
+	preset, err := scanPreset(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrPresetNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query preset: %w", err)
+	}
+	return preset, nil
+}
+
+// GetOwnedPreset finds the preset named name that belongs to exactly apiKeyID
+// (nil = the global preset), ignoring presets of other owners
+func GetOwnedPreset(name string, apiKeyID *int) (*Preset, error) {
+	row := DB.QueryRow(
+		`SELECT id, name, description, params, api_key_id, created_at, updated_at
+		FROM presets
+		WHERE name = ? AND api_key_id IS ?`,
+		name, apiKeyID,
+	)
+
+	preset, err := scanPreset(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrPresetNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query preset: %w", err)
+	}
+	return preset, nil
+}
+
+// ListPresets returns the global presets plus those scoped to apiKeyID
+func ListPresets(apiKeyID *int) ([]Preset, error) {
+	rows, err := DB.Query(
+		`SELECT id, name, description, params, api_key_id, created_at, updated_at
+		FROM presets
+		WHERE api_key_id IS NULL OR api_key_id = ?
+		ORDER BY name, api_key_id IS NULL`,
+		apiKeyID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query presets: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("warning: failed to close rows: %v", err)
+		}
+	}()
+
+	var presets []Preset
+	for rows.Next() {
+		preset, err := scanPreset(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan preset row: %w", err)
+		}
+		presets = append(presets, *preset)
+	}
+
+	return presets, rows.Err()
+}
+
+// UpdatePreset replaces the description and parameters of a preset
+func UpdatePreset(id int, description string, params map[string]string) error {
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode preset parameters: %w", err)
+	}
+
+	result, err := DB.Exec(
+		"UPDATE presets SET description = ?, params = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		description, string(encoded), id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update preset: %w", err)
+	}
+
+	return expectOneRow(result)
+}
+
+// DeletePreset permanently deletes a preset
+func DeletePreset(id int) error {
+	result, err := DB.Exec("DELETE FROM presets WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete preset: %w", err)
+	}
+
+	return expectOneRow(result)
+}
+
+// expectOneRow maps an update or delete that matched nothing to ErrPresetNotFound
+func expectOneRow(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrPresetNotFound
+	}
+	return nil
+}
+
+// scanPreset reads one preset from a query result row
+func scanPreset(row interface{ Scan(...any) error }) (*Preset, error) {
+	var preset Preset
+	var params string
+	var apiKeyID sql.NullInt64
+	var createdAt, updatedAt time.Time
+
+	if err := row.Scan(&preset.ID, &preset.Name, &preset.Description, &params, &apiKeyID, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(params), &preset.Params); err != nil {
+		return nil, fmt.Errorf("invalid parameters for preset %q: %w", preset.Name, err)
+	}
+	if apiKeyID.Valid {
+		id := int(apiKeyID.Int64)
+		preset.APIKeyID = &id
+	}
+	preset.CreatedAt = &createdAt
+	preset.UpdatedAt = &updatedAt
+
+	return &preset, nil
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPresetCRUD(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	preset := &Preset{Name: "hero-banner", Description: "Hero images", Params: map[string]string{"quality": "85", "progressive": "true"}}
+	if err := CreatePreset(preset); err != nil {
+		t.Fatalf("Failed to create preset: %v", err)
+	}
+	if preset.ID == 0 {
+		t.Error("Expected preset ID to be set")
+	}
+
+	if err := CreatePreset(&Preset{Name: "hero-banner", Params: map[string]string{}}); !errors.Is(err, ErrPresetExists) {
+		t.Errorf("Expected ErrPresetExists for duplicate global preset, got %v", err)
+	}
+
+	got, err := GetPreset("hero-banner", nil)
+	if err != nil {
+		t.Fatalf("Failed to get preset: %v", err)
+	}
+	if got.Params["quality"] != "85" || got.Description != "Hero images" || got.APIKeyID != nil {
+		t.Errorf("Unexpected preset: %+v", got)
+	}
+
+	if err := UpdatePreset(preset.ID, "Updated", map[string]string{"quality": "70"}); err != nil {
+		t.Fatalf("Failed to update preset: %v", err)
+	}
+	got, _ = GetPreset("hero-banner", nil)
+	if got.Params["quality"] != "70" || got.Params["progressive"] != "" || got.Description != "Updated" {
+		t.Errorf("Update not applied: %+v", got)
+	}
+
+	if err := DeletePreset(preset.ID); err != nil {
+		t.Fatalf("Failed to delete preset: %v", err)
+	}
+	if _, err := GetPreset("hero-banner", nil); !errors.Is(err, ErrPresetNotFound) {
+		t.Errorf("Expected ErrPresetNotFound after delete, got %v", err)
+	}
+	if err := DeletePreset(preset.ID); !errors.Is(err, ErrPresetNotFound) {
+		t.Errorf("Expected ErrPresetNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestPresetScoping(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	owner, err := CreateAPIKey("owner")
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	other, err := CreateAPIKey("other")
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	if err := CreatePreset(&Preset{Name: "web", Params: map[string]string{"quality": "80"}}); err != nil {
+		t.Fatalf("Failed to create global preset: %v", err)
+	}
+	scoped := &Preset{Name: "web", Params: map[string]string{"quality": "60"}, APIKeyID: &owner.ID}
+	if err := CreatePreset(scoped); err != nil {
+		t.Fatalf("Failed to create scoped preset with a global name: %v", err)
+	}
+	if err := CreatePreset(&Preset{Name: "web", Params: map[string]string{}, APIKeyID: &owner.ID}); !errors.Is(err, ErrPresetExists) {
+		t.Errorf("Expected ErrPresetExists for duplicate scoped preset, got %v", err)
+	}
+
+	// The owner's preset shadows the global one; other keys only see the global one
+	if got, _ := GetPreset("web", &owner.ID); got == nil || got.Params["quality"] != "60" {
+		t.Errorf("Expected the owner's scoped preset, got %+v", got)
+	}
+	if got, _ := GetPreset("web", &other.ID); got == nil || got.Params["quality"] != "80" {
+		t.Errorf("Expected the global preset for another key, got %+v", got)
+	}
+	if got, _ := GetPreset("web", nil); got == nil || got.Params["quality"] != "80" {
+		t.Errorf("Expected the global preset without a key, got %+v", got)
+	}
+
+	ownerPresets, err := ListPresets(&owner.ID)
+	if err != nil {
+		t.Fatalf("Failed to list presets: %v", err)
+	}
+	if len(ownerPresets) != 2 {
+		t.Errorf("Expected owner to see 2 presets, got %d", len(ownerPresets))
+	}
+	otherPresets, _ := ListPresets(&other.ID)
+	if len(otherPresets) != 1 {
+		t.Errorf("Expected other key to see 1 preset, got %d", len(otherPresets))
+	}
+
+	// Owned lookups never fall back to the global preset
+	if got, _ := GetOwnedPreset("web", &owner.ID); got == nil || got.ID != scoped.ID {
+		t.Errorf("Expected the owner's scoped preset, got %+v", got)
+	}
+	if _, err := GetOwnedPreset("web", &other.ID); !errors.Is(err, ErrPresetNotFound) {
+		t.Errorf("Expected ErrPresetNotFound for another key, got %v", err)
+	}
+	if got, _ := GetOwnedPreset("web", nil); got == nil || got.APIKeyID != nil {
+		t.Errorf("Expected the global preset, got %+v", got)
+	}
+}
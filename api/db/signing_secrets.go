@@ -0,0 +1,77 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrSigningSecretNotFound is returned for API keys without a signing secret
+// (or revoked keys)
+var ErrSigningSecretNotFound = errors.New("signing secret not found")
+
+// generateSigningSecret generates a random secret for signing image URLs or webhooks
+func generateSigningSecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random secret: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// GetSigningSecret returns the URL signing secret of a valid (not revoked) API key
+func GetSigningSecret(apiKeyID int) (string, error) {
+	var secret string
+	err := DB.QueryRow(
+		`SELECT s.secret FROM signing_secrets s
+		JOIN api_keys k ON k.id = s.api_key_id
+		WHERE s.api_key_id = ? AND k.revoked_at IS NULL`,
+		apiKeyID,
+	).Scan(&secret)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrSigningSecretNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query signing secret: %w", err)
+	}
+	return secret, nil
+}
+
+// EnsureSigningSecret returns the API key's signing secret, creating one on first use
+func EnsureSigningSecret(apiKeyID int) (string, error) {
+	secret, err := generateSigningSecret()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := DB.Exec(
+		"INSERT OR IGNORE INTO signing_secrets (api_key_id, secret) VALUES (?, ?)",
+		apiKeyID, secret,
+	); err != nil {
+		return "", fmt.Errorf("failed to insert signing secret: %w", err)
+	}
+
+	return GetSigningSecret(apiKeyID)
+}
+
+// RotateSigningSecret replaces the API key's signing secret, invalidating every
+// URL signed with the previous one
+func RotateSigningSecret(apiKeyID int) (string, error) {
+	secret, err := generateSigningSecret()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := DB.Exec(
+		`INSERT INTO signing_secrets (api_key_id, secret) VALUES (?, ?)
+		ON CONFLICT(api_key_id) DO UPDATE SET secret = excluded.secret, created_at = CURRENT_TIMESTAMP`,
+		apiKeyID, secret,
+	); err != nil {
+		return "", fmt.Errorf("failed to store signing secret: %w", err)
+	}
+
+	return GetSigningSecret(apiKeyID)
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSigningSecrets(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	key, err := CreateAPIKey("signing")
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	if _, err := GetSigningSecret(key.ID); !errors.Is(err, ErrSigningSecretNotFound) {
+		t.Errorf("Expected ErrSigningSecretNotFound before first use, got %v", err)
+	}
+
+	secret, err := EnsureSigningSecret(key.ID)
+	if err != nil {
+		t.Fatalf("Failed to create signing secret: %v", err)
+	}
+	if len(secret) != 64 {
+		t.Errorf("Expected a 64 character secret, got %q", secret)
+	}
+	if again, _ := EnsureSigningSecret(key.ID); again != secret {
+		t.Error("Expected EnsureSigningSecret to keep the existing secret")
+	}
+
+	rotated, err := RotateSigningSecret(key.ID)
+	if err != nil {
+		t.Fatalf("Failed to rotate signing secret: %v", err)
+	}
+	if rotated == secret {
+		t.Error("Expected rotation to change the secret")
+	}
+	if got, _ := GetSigningSecret(key.ID); got != rotated {
+		t.Errorf("Expected rotated secret, got %q", got)
+	}
+
+	if err := RevokeAPIKey(key.ID); err != nil {
+		t.Fatalf("Failed to revoke API key: %v", err)
+	}
+	if _, err := GetSigningSecret(key.ID); !errors.Is(err, ErrSigningSecretNotFound) {
+		t.Errorf("Expected ErrSigningSecretNotFound for revoked key, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrWebhookSecretNotFound is returned for API keys without a webhook secret
+// (or revoked keys)
+var ErrWebhookSecretNotFound = errors.New("webhook secret not found")
+
+// GetWebhookSecret returns the job callback signing secret of a valid (not
+// revoked) API key
+func GetWebhookSecret(apiKeyID int) (string, error) {
+	var secret string
+	err := DB.QueryRow(
+		`SELECT s.secret FROM webhook_secrets s
+		JOIN api_keys k ON k.id = s.api_key_id
+		WHERE s.api_key_id = ? AND k.revoked_at IS NULL`,
+		apiKeyID,
+	).Scan(&secret)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrWebhookSecretNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query webhook secret: %w", err)
+	}
+	return secret, nil
+}
+
+// EnsureWebhookSecret returns the API key's webhook secret, creating one on first use
+func EnsureWebhookSecret(apiKeyID int) (string, error) {
+	secret, err := generateSigningSecret()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := DB.Exec(
+		"INSERT OR IGNORE INTO webhook_secrets (api_key_id, secret) VALUES (?, ?)",
+		apiKeyID, secret,
+	); err != nil {
+		return "", fmt.Errorf("failed to insert webhook secret: %w", err)
+	}
+
+	return GetWebhookSecret(apiKeyID)
+}
+
+// RotateWebhookSecret replaces the API key's webhook secret; deliveries sent
+// afterwards are signed with the new one
+func RotateWebhookSecret(apiKeyID int) (string, error) {
+	secret, err := generateSigningSecret()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := DB.Exec(
+		`INSERT INTO webhook_secrets (api_key_id, secret) VALUES (?, ?)
+		ON CONFLICT(api_key_id) DO UPDATE SET secret = excluded.secret, created_at = CURRENT_TIMESTAMP`,
+		apiKeyID, secret,
+	); err != nil {
+		return "", fmt.Errorf("failed to store webhook secret: %w", err)
+	}
+
+	return GetWebhookSecret(apiKeyID)
+}
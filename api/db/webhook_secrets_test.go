@@ -0,0 +1,42 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWebhookSecrets(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	key, err := CreateAPIKey("webhooks")
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	secret, err := EnsureWebhookSecret(key.ID)
+	if err != nil {
+		t.Fatalf("Failed to create webhook secret: %v", err)
+	}
+	if again, _ := EnsureWebhookSecret(key.ID); again != secret {
+		t.Error("Expected EnsureWebhookSecret to keep the existing secret")
+	}
+	if signing, _ := EnsureSigningSecret(key.ID); signing == secret {
+		t.Error("Expected the webhook secret to differ from the URL signing secret")
+	}
+
+	rotated, err := RotateWebhookSecret(key.ID)
+	if err != nil {
+		t.Fatalf("Failed to rotate webhook secret: %v", err)
+	}
+	if rotated == secret {
+		t.Error("Expected rotation to change the secret")
+	}
+
+	if err := RevokeAPIKey(key.ID); err != nil {
+		t.Fatalf("Failed to revoke API key: %v", err)
+	}
+	if _, err := GetWebhookSecret(key.ID); !errors.Is(err, ErrWebhookSecretNotFound) {
+		t.Errorf("Expected ErrWebhookSecretNotFound for revoked key, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrWebhookDeliveryNotFound is returned for unknown webhook deliveries
+var ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+
+// Webhook delivery statuses
+const (
+	WebhookPending   = "pending"   // Waiting for its next attempt
+	WebhookDelivered = "delivered" // Acknowledged with a 2xx response
+	WebhookDead      = "dead"      // Retries exhausted (dead letter)
+)
+
+// WebhookDelivery is one callback to send, with its retry state
+type WebhookDelivery struct {
+	ID            int64            `json:"id"`
+	JobID         string           `json:"jobId"`
+	APIKeyID      *int             `json:"apiKeyId,omitempty"` // Whose webhook secret signs the payload
+	URL           string           `json:"url"`
+	Event         string           `json:"event"`
+	Payload       string           `json:"payload"`
+	Status        string           `json:"status"`
+	Attempts      int              `json:"attempts"`
+	NextAttemptAt *time.Time       `json:"nextAttemptAt,omitempty"`
+	LastError     string           `json:"lastError,omitempty"`
+	CreatedAt     time.Time        `json:"createdAt"`
+	UpdatedAt     time.Time        `json:"updatedAt"`
+	AttemptLog    []WebhookAttempt `json:"attemptLog,omitempty"` // Only filled by GetWebhookDelivery
+}
+
+// WebhookAttempt is the outcome of one HTTP request made for a delivery
+type WebhookAttempt struct {
+	Attempt     int       `json:"attempt"`
+	StatusCode  int       `json:"statusCode,omitempty"` // 0 if no response was received
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"durationMs"`
+	AttemptedAt time.Time `json:"attemptedAt"`
+}
+
+// webhookDeliveryColumns lists the columns read by scanWebhookDelivery, in order
+const webhookDeliveryColumns = `id, job_id, api_key_id, url, event, payload, status, attempts,
+	next_attempt_at, last_error, created_at, updated_at`
+
+// CreateWebhookDelivery queues a delivery for immediate sending and fills in its ID
+func CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	now := time.Now().UTC()
+	delivery.Status = WebhookPending
+	delivery.NextAttemptAt = &now
+	delivery.CreatedAt = now
+	delivery.UpdatedAt = now
+
+	result, err := DB.Exec(
+		`INSERT INTO webhook_deliveries (job_id, api_key_id, url, event, payload, status, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		delivery.JobID, delivery.APIKeyID, delivery.URL, delivery.Event, delivery.Payload, delivery.Status, now, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook delivery: %w", err)
+	}
+
+	delivery.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	return nil
+}
+
+// DueWebhookDeliveries returns up to limit pending deliveries whose next attempt is due
+func DueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	return queryWebhookDeliveries(
+		"SELECT "+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, id LIMIT ?`,
+		WebhookPending, now.UTC(), limit,
+	)
+}
+
+// ListWebhookDeliveries returns the most recent deliveries, optionally only those
+// with the given status
+func ListWebhookDeliveries(status string, limit int) ([]WebhookDelivery, error) {
+	return queryWebhookDeliveries(
+		"SELECT "+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE ? = '' OR status = ?
+		ORDER BY id DESC LIMIT ?`,
+		status, status, limit,
+	)
+}
+
+// GetWebhookDelivery finds a delivery by ID, including its attempt log
+func GetWebhookDelivery(id int64) (*WebhookDelivery, error) {
+	delivery, err := scanWebhookDelivery(DB.QueryRow(
+		"SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE id = ?", id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook delivery: %w", err)
+	}
+
+	rows, err := DB.Query(
+		`SELECT attempt, status_code, error, duration_ms, attempted_at
+		FROM webhook_attempts WHERE delivery_id = ? ORDER BY attempt`,
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook attempts: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("warning: failed to close rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var attempt WebhookAttempt
+		if err := rows.Scan(&attempt.Attempt, &attempt.StatusCode, &attempt.Error, &attempt.DurationMs, &attempt.AttemptedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook attempt: %w", err)
+		}
+		delivery.AttemptLog = append(delivery.AttemptLog, attempt)
+	}
+	return delivery, rows.Err()
+}
+
+// RecordWebhookAttempt logs an attempt and stores the delivery's new state:
+// delivery.Status, LastError and NextAttemptAt must already be updated by the caller
+func RecordWebhookAttempt(delivery *WebhookDelivery, attempt WebhookAttempt) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(
+		`INSERT INTO webhook_attempts (delivery_id, attempt, status_code, error, duration_ms, attempted_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		delivery.ID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.DurationMs, attempt.AttemptedAt.UTC(),
+	); err != nil {
+		return fmt.Errorf("failed to insert webhook attempt: %w", err)
+	}
+
+	delivery.Attempts = attempt.Attempt
+	delivery.UpdatedAt = time.Now().UTC()
+	var nextAttemptAt any // NULL once delivered or dead
+	if delivery.NextAttemptAt != nil {
+		nextAttemptAt = delivery.NextAttemptAt.UTC()
+	}
+	if _, err := tx.Exec(
+		`UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?, updated_at = ?
+		WHERE id = ?`,
+		delivery.Status, delivery.Attempts, nextAttemptAt, delivery.LastError, delivery.UpdatedAt, delivery.ID,
+	); err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// RetryWebhookDelivery queues a dead delivery for another round of attempts
+func RetryWebhookDelivery(id int64) error {
+	now := time.Now().UTC()
+	result, err := DB.Exec(
+		`UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ?
+		WHERE id = ? AND status = ?`,
+		WebhookPending, now, now, id, WebhookDead,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to retry webhook delivery: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrWebhookDeliveryNotFound
+	}
+	return nil
+}
+
+// DeleteWebhookDeliveriesBefore removes delivered and dead deliveries (and their
+// attempts) last updated before cutoff, returning how many were removed
+func DeleteWebhookDeliveriesBefore(cutoff time.Time) (int64, error) {
+	if _, err := DB.Exec(
+		`DELETE FROM webhook_attempts WHERE delivery_id IN (
+			SELECT id FROM webhook_deliveries WHERE status != ? AND updated_at < ?)`,
+		WebhookPending, cutoff.UTC(),
+	); err != nil {
+		return 0, fmt.Errorf("failed to delete webhook attempts: %w", err)
+	}
+	result, err := DB.Exec(
+		"DELETE FROM webhook_deliveries WHERE status != ? AND updated_at < ?",
+		WebhookPending, cutoff.UTC(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete webhook deliveries: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// queryWebhookDeliveries runs a query selecting webhookDeliveryColumns
+func queryWebhookDeliveries(query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("warning: failed to close rows: %v", err)
+		}
+	}()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// scanWebhookDelivery reads one delivery (selected with webhookDeliveryColumns)
+func scanWebhookDelivery(row interface{ Scan(...any) error }) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	var apiKeyID sql.NullInt64
+	var nextAttemptAt sql.NullTime
+
+	if err := row.Scan(&delivery.ID, &delivery.JobID, &apiKeyID, &delivery.URL, &delivery.Event, &delivery.Payload,
+		&delivery.Status, &delivery.Attempts, &nextAttemptAt, &delivery.LastError, &delivery.CreatedAt, &delivery.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if apiKeyID.Valid {
+		id := int(apiKeyID.Int64)
+		delivery.APIKeyID = &id
+	}
+	if nextAttemptAt.Valid {
+		delivery.NextAttemptAt = &nextAttemptAt.Time
+	}
+	return &delivery, nil
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWebhookDeliveries(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	keyID := 3
+	delivery := &WebhookDelivery{JobID: "job1", APIKeyID: &keyID, URL: "https://example.com/hook", Event: "job.completed", Payload: `{"event":"job.completed"}`}
+	if err := CreateWebhookDelivery(delivery); err != nil {
+		t.Fatalf("Failed to create delivery: %v", err)
+	}
+	if delivery.ID == 0 || delivery.Status != WebhookPending {
+		t.Fatalf("Unexpected delivery: %+v", delivery)
+	}
+
+	due, err := DueWebhookDeliveries(time.Now().Add(time.Second), 10)
+	if err != nil || len(due) != 1 || due[0].ID != delivery.ID || due[0].APIKeyID == nil || *due[0].APIKeyID != 3 {
+		t.Fatalf("Expected the delivery to be due, got %+v, %v", due, err)
+	}
+
+	// A failed attempt schedules a retry
+	next := time.Now().Add(time.Hour)
+	delivery.NextAttemptAt = &next
+	delivery.LastError = "callback returned status 500"
+	if err := RecordWebhookAttempt(delivery, WebhookAttempt{Attempt: 1, StatusCode: 500, Error: delivery.LastError, AttemptedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to record attempt: %v", err)
+	}
+	if due, _ := DueWebhookDeliveries(time.Now(), 10); len(due) != 0 {
+		t.Errorf("Expected no due deliveries before the retry, got %+v", due)
+	}
+
+	// Exhausted retries dead-letter the delivery
+	delivery.Status = WebhookDead
+	delivery.NextAttemptAt = nil
+	if err := RecordWebhookAttempt(delivery, WebhookAttempt{Attempt: 2, Error: "connection refused", AttemptedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to record attempt: %v", err)
+	}
+
+	got, err := GetWebhookDelivery(delivery.ID)
+	if err != nil {
+		t.Fatalf("Failed to get delivery: %v", err)
+	}
+	if got.Status != WebhookDead || got.Attempts != 2 || got.NextAttemptAt != nil || len(got.AttemptLog) != 2 || got.AttemptLog[0].StatusCode != 500 {
+		t.Errorf("Unexpected dead delivery: %+v", got)
+	}
+
+	if dead, _ := ListWebhookDeliveries(WebhookDead, 10); len(dead) != 1 {
+		t.Errorf("Expected 1 dead delivery, got %d", len(dead))
+	}
+	if pending, _ := ListWebhookDeliveries(WebhookPending, 10); len(pending) != 0 {
+		t.Errorf("Expected no pending deliveries, got %d", len(pending))
+	}
+
+	if err := RetryWebhookDelivery(delivery.ID); err != nil {
+		t.Fatalf("Failed to retry delivery: %v", err)
+	}
+	if due, _ := DueWebhookDeliveries(time.Now().Add(time.Second), 10); len(due) != 1 || due[0].Attempts != 0 {
+		t.Errorf("Expected the retried delivery to be due, got %+v", due)
+	}
+	if err := RetryWebhookDelivery(delivery.ID); !errors.Is(err, ErrWebhookDeliveryNotFound) {
+		t.Errorf("Expected ErrWebhookDeliveryNotFound for a pending delivery, got %v", err)
+	}
+	if _, err := GetWebhookDelivery(999); !errors.Is(err, ErrWebhookDeliveryNotFound) {
+		t.Errorf("Expected ErrWebhookDeliveryNotFound, got %v", err)
+	}
+
+	// Pending deliveries are never pruned; finished ones are
+	if n, _ := DeleteWebhookDeliveriesBefore(time.Now().Add(time.Hour)); n != 0 {
+		t.Errorf("Expected pending delivery to be kept, removed %d", n)
+	}
+	delivery.Status = WebhookDelivered
+	delivery.NextAttemptAt = nil
+	_ = RecordWebhookAttempt(delivery, WebhookAttempt{Attempt: 1, StatusCode: 204, AttemptedAt: time.Now()})
+	if n, err := DeleteWebhookDeliveriesBefore(time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("Expected 1 pruned delivery, got %d, %v", n, err)
+	}
+}
@@ -105,6 +105,7 @@ func main() {
 			c.Set("Access-Control-Allow-Credentials", "true")
 			c.Set("Access-Control-Allow-Headers", "Origin,Content-Type,Accept,Authorization")
 			c.Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
+			c.Set("Access-Control-Expose-Headers", "Content-DPR,ETag,Location,X-Cache,X-Cache-Key,X-Optimization-Warnings,X-Page-Count,X-Trim-Offsets")
 		}
 
 		// Handle preflight
@@ -127,7 +128,10 @@ func main() {
 	// Register routes
 	routes.RegisterOptimizeRoutes(app)
 	routes.RegisterAPIKeyRoutes(app)
+	routes.RegisterPresetRoutes(app)
+	routes.RegisterSigningRoutes(app)
 	routes.SetupSpritesheetRoutes(app)
+	routes.RegisterJobRoutes(app)
 	routes.SetupMetricsRoutes(app)
 	routes.SetupAdminRoutes(app)
 
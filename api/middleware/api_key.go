@@ -11,6 +11,9 @@ import (
 	"github.com/keif/image-optimizer/db"
 )
 
+// APIKeyIDKey is the fiber.Ctx Locals key holding the authenticated API key's ID (int)
+const APIKeyIDKey = "api_key_id" // #nosec G101 - This is a context key name, not credentials
+
 // BypassRule defines a path and optional HTTP method that bypasses authentication
 type BypassRule struct {
 	Path   string // Path prefix to match
@@ -31,6 +34,8 @@ func GetAPIKeyConfig() APIKeyConfig {
 		{Path: "/health", Method: ""},       // Health check endpoint
 		{Path: "/swagger", Method: ""},      // API documentation
 		{Path: "/api/keys", Method: "POST"}, // Bootstrap: create first API key
+		{Path: "/img/", Method: ""},         // Image proxy: authenticated by URL signatures
+		{Path: "/imgproxy/", Method: ""},    // imgproxy-compatible URLs: authenticated by imgproxy signatures
 	}
 
 	config := APIKeyConfig{
@@ -55,6 +60,7 @@ func GetAPIKeyConfig() APIKeyConfig {
 			BypassRule{Path: "/batch-optimize", Method: ""},       // Public batch optimization
 			BypassRule{Path: "/pack-sprites", Method: ""},         // Public spritesheet packing
 			BypassRule{Path: "/optimize-spritesheet", Method: ""}, // Public spritesheet optimization
+			BypassRule{Path: "/jobs", Method: ""},                 // Public asynchronous jobs (IDs are unguessable)
 		)
 	}
 
@@ -168,6 +174,7 @@ func RequireAPIKey() fiber.Handler {
 
 		// Check if request comes from a trusted origin (bypass API key requirement)
 		if isTrustedOrigin(c, config.TrustedOrigins) {
+			identifyAPIKey(c)
 			return c.Next()
 		}
 
@@ -179,6 +186,7 @@ func RequireAPIKey() fiber.Handler {
 			if strings.HasPrefix(path, rule.Path) {
 				// If rule has no method specified, or method matches, bypass auth
 				if rule.Method == "" || rule.Method == method {
+					identifyAPIKey(c)
 					return c.Next()
 				}
 			}
@@ -195,17 +203,11 @@ func RequireAPIKey() fiber.Handler {
 			})
 		}
 
-		// Parse Bearer token format: "Bearer sk_..."
-		var apiKey string
-		if strings.HasPrefix(authHeader, "Bearer ") {
-			apiKey = strings.TrimPrefix(authHeader, "Bearer ")
-		} else {
-			// Also support direct key format without "Bearer" prefix
-			apiKey = authHeader
-		}
+		apiKey := apiKeyFromHeader(authHeader)
 
 		// Validate API key
-		if !db.ValidateAPIKey(apiKey) {
+		keyID, valid := db.LookupAPIKeyID(apiKey)
+		if !valid {
 			// SECURITY EVENT: Invalid or revoked API key
 			// Log partial key for debugging (first 8 chars only)
 			keyPrefix := apiKey
@@ -219,7 +221,50 @@ func RequireAPIKey() fiber.Handler {
 			})
 		}
 
-		// API key is valid, continue to next handler
+		// API key is valid - record it for per-key presets and metrics
+		c.Locals(APIKeyIDKey, keyID)
 		return c.Next()
 	}
 }
+
+// apiKeyFromHeader extracts the key from an Authorization header value
+func apiKeyFromHeader(authHeader string) string {
+	// Parse Bearer token format: "Bearer sk_..."
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	// Also support direct key format without "Bearer" prefix
+	return authHeader
+}
+
+// IsAdmin reports whether a request may manage shared server state such as global
+// presets: it was authenticated with an API key listed in ADMIN_API_KEY_IDS
+// (comma-separated key IDs), or API key authentication is disabled altogether
+func IsAdmin(c *fiber.Ctx) bool {
+	if enabled, err := strconv.ParseBool(os.Getenv("API_KEY_AUTH_ENABLED")); err == nil && !enabled {
+		return true
+	}
+	keyID, ok := c.Locals(APIKeyIDKey).(int)
+	if !ok {
+		return false
+	}
+	for _, id := range strings.Split(os.Getenv("ADMIN_API_KEY_IDS"), ",") {
+		if adminID, err := strconv.Atoi(strings.TrimSpace(id)); err == nil && adminID == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+// identifyAPIKey records the API key of a request that does not need one (bypass
+// rules, trusted origins), so key-scoped presets still apply. Invalid keys are
+// ignored rather than rejected.
+func identifyAPIKey(c *fiber.Ctx) {
+	authHeader := c.Get("Authorization")
+	if authHeader == "" {
+		return
+	}
+	if keyID, valid := db.LookupAPIKeyID(apiKeyFromHeader(authHeader)); valid {
+		c.Locals(APIKeyIDKey, keyID)
+	}
+}
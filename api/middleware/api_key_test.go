@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"fmt"
 	"io"
 	"net/http/httptest"
 	"os"
@@ -243,6 +244,34 @@ func TestAPIKeyMiddleware_BearerAndDirectFormat(t *testing.T) {
 	}
 }
 
+// TestAPIKeyMiddleware_SetsAPIKeyID tests that the authenticated key is recorded for later handlers
+func TestAPIKeyMiddleware_SetsAPIKeyID(t *testing.T) {
+	app, validAPIKey := setupTestApp(t)
+	defer func() { _ = db.Close() }()
+
+	keyID, ok := db.LookupAPIKeyID(validAPIKey)
+	if !ok {
+		t.Fatal("Expected the test API key to be valid")
+	}
+
+	app.Get("/whoami", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"id": c.Locals(APIKeyIDKey)})
+	})
+
+	req := httptest.NewRequest("GET", "/whoami", nil)
+	req.Header.Set("Authorization", "Bearer "+validAPIKey)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, _ := io.ReadAll(resp.Body)
+	if want := fmt.Sprintf(`{"id":%d}`, keyID); string(body) != want {
+		t.Errorf("Expected %s, got %s", want, string(body))
+	}
+}
+
 // TestAPIKeyMiddleware_Disabled tests that middleware can be disabled
 func TestAPIKeyMiddleware_Disabled(t *testing.T) {
 	// Disable API key authentication
@@ -615,3 +644,38 @@ func TestHelperFunctions(t *testing.T) {
 		}
 	})
 }
+
+func TestIsAdmin(t *testing.T) {
+	_ = os.Setenv("ADMIN_API_KEY_IDS", "3, 7")
+	defer func() { _ = os.Unsetenv("ADMIN_API_KEY_IDS") }()
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		if key := c.Query("key"); key != "" {
+			var id int
+			_, _ = fmt.Sscan(key, &id)
+			c.Locals(APIKeyIDKey, id)
+		}
+		return c.SendString(fmt.Sprint(IsAdmin(c)))
+	})
+	isAdmin := func(target string) string {
+		resp, err := app.Test(httptest.NewRequest("GET", target, nil))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	for target, want := range map[string]string{"/?key=7": "true", "/?key=4": "false", "/": "false"} {
+		if got := isAdmin(target); got != want {
+			t.Errorf("IsAdmin for %s = %s, want %s", target, got, want)
+		}
+	}
+
+	_ = os.Setenv("API_KEY_AUTH_ENABLED", "false")
+	defer func() { _ = os.Unsetenv("API_KEY_AUTH_ENABLED") }()
+	if got := isAdmin("/"); got != "true" {
+		t.Errorf("Expected every request to be an admin with authentication disabled, got %s", got)
+	}
+}
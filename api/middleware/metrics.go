@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -62,10 +63,18 @@ func NewMetricsCollector() fiber.Handler {
 			endpoint = "optimize"
 		case "/batch-optimize":
 			endpoint = "batch-optimize"
+		case "/transform":
+			endpoint = "transform"
 		case "/pack-sprites":
 			endpoint = "pack-sprites"
 		default:
 			endpoint = "other"
+			switch {
+			case strings.HasPrefix(path, "/img/"):
+				endpoint = "img"
+			case strings.HasPrefix(path, "/imgproxy/"):
+				endpoint = "imgproxy"
+			}
 		}
 		c.Locals(MetricsEndpointKey, endpoint)
 
@@ -86,7 +95,7 @@ func NewMetricsCollector() fiber.Handler {
 
 		// Get API key ID if available
 		var apiKeyID *int
-		if keyID := c.Locals("api_key_id"); keyID != nil {
+		if keyID := c.Locals(APIKeyIDKey); keyID != nil {
 			if id, ok := keyID.(int); ok {
 				apiKeyID = &id
 			}
@@ -148,7 +157,7 @@ func RecordOptimizationMetric(c *fiber.Ctx, inputFormat, outputFormat string, by
 
 	// Get API key ID if available
 	var apiKeyID *int
-	if keyID := c.Locals("api_key_id"); keyID != nil {
+	if keyID := c.Locals(APIKeyIDKey); keyID != nil {
 		if id, ok := keyID.(int); ok {
 			apiKeyID = &id
 		}
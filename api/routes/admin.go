@@ -6,6 +6,8 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/keif/image-optimizer/db"
+	"github.com/keif/image-optimizer/middleware"
+	"github.com/keif/image-optimizer/services"
 )
 
 // SetupAdminRoutes configures all admin-related routes
@@ -14,6 +16,23 @@ func SetupAdminRoutes(app *fiber.App) {
 
 	// Cleanup endpoint - delete old metrics data
 	admin.Post("/cleanup-metrics", handleCleanupMetrics)
+
+	// Result cache statistics and purging
+	admin.Get("/cache/stats", handleCacheStats)
+	admin.Delete("/cache", handleCachePurge)
+
+	// Job callback deliveries, including dead letters
+	admin.Get("/webhooks", handleListWebhooks)
+	admin.Get("/webhooks/:id", handleGetWebhook)
+	admin.Post("/webhooks/:id/retry", handleRetryWebhook)
+}
+
+// adminRequiredResponse rejects requests to admin endpoints that manage every
+// client's data, made without an admin API key
+func adminRequiredResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error": "This endpoint requires an admin API key.",
+	})
 }
 
 // @Summary Cleanup old metrics data
@@ -52,3 +71,79 @@ func handleCleanupMetrics(c *fiber.Ctx) error {
 		"message":        "Successfully deleted metrics older than " + strconv.Itoa(retentionDays) + " days",
 	})
 }
+
+// CacheStatsResponse reports whether the result cache is enabled and, if so, its statistics
+type CacheStatsResponse struct {
+	Enabled bool `json:"enabled"`
+	*services.ResultCacheStats
+}
+
+// @Summary Result cache statistics
+// @Description Get the size, hit rate and eviction counts of the optimization result cache
+// @Tags admin
+// @Produce json
+// @Success 200 {object} CacheStatsResponse
+// @Failure 403 {object} map[string]string "Not an admin API key"
+// @Router /admin/cache/stats [get]
+func handleCacheStats(c *fiber.Ctx) error {
+	if !middleware.IsAdmin(c) {
+		return adminRequiredResponse(c)
+	}
+	if resultCache == nil {
+		return c.JSON(CacheStatsResponse{Enabled: false})
+	}
+	stats := resultCache.Stats()
+	return c.JSON(CacheStatsResponse{Enabled: true, ResultCacheStats: &stats})
+}
+
+// @Summary Purge the result cache
+// @Description Remove one cached result (key), every variant of an input (prefix, e.g. the input's SHA-256), or everything (all=true)
+// @Tags admin
+// @Produce json
+// @Param key query string false "Exact cache key, as returned in X-Cache-Key"
+// @Param prefix query string false "Cache key prefix"
+// @Param all query bool false "Purge the whole cache"
+// @Success 200 {object} map[string]interface{} "Number of purged results"
+// @Failure 400 {object} map[string]string "Invalid parameters or cache disabled"
+// @Failure 403 {object} map[string]string "Not an admin API key"
+// @Router /admin/cache [delete]
+func handleCachePurge(c *fiber.Ctx) error {
+	if !middleware.IsAdmin(c) {
+		return adminRequiredResponse(c)
+	}
+	if resultCache == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Result cache is not enabled",
+		})
+	}
+
+	key, prefix, all := c.Query("key"), c.Query("prefix"), c.QueryBool("all")
+	selectors := 0
+	for _, set := range []bool{key != "", prefix != "", all} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Specify exactly one of key, prefix or all=true",
+		})
+	}
+
+	purged := 0
+	switch {
+	case key != "":
+		if resultCache.Purge(key) {
+			purged = 1
+		}
+	case prefix != "":
+		purged = resultCache.PurgePrefix(prefix)
+	default:
+		purged = resultCache.PurgePrefix("")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"purged":  purged,
+	})
+}
@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/services"
+)
+
+// handleAnalyze handles POST /analyze requests
+// @Summary Analyze an image and recommend optimization settings
+// @Description Dry run: reads image metadata (dimensions, alpha usage, color count, color profile, EXIF, animation, progressive encoding), runs quick trial encodes on a downscaled copy to estimate output size for each candidate format and quality, and recommends settings without producing the full output
+// @Tags optimization
+// @Accept multipart/form-data
+// @Produce json
+// @Param formats query string false "Comma-separated candidate formats" default(jpeg,webp,avif,png)
+// @Param qualities query string false "Comma-separated candidate qualities for lossy formats (1-100)" default(60,70,80,90)
+// @Param image formData file false "Image file to analyze (multipart upload)"
+// @Param url formData string false "Image URL to fetch and analyze (alternative to file upload)"
+// @Success 200 {object} services.ImageAnalysis "Analysis and recommended settings"
+// @Failure 400 {object} map[string]string "Invalid parameters or file"
+// @Failure 403 {object} map[string]string "URL domain not allowed"
+// @Failure 500 {object} map[string]string "Image analysis error"
+// @Router /analyze [post]
+func handleAnalyze(c *fiber.Ctx) error {
+	options := services.AnalyzeOptions{}
+
+	var err error
+	if options.Formats, err = parseFormatList(c.Query("formats"), "formats"); err != nil {
+		return inputErrorResponse(c, err)
+	}
+	if options.Qualities, err = parseIntList(c.Query("qualities"), "qualities", 1, 100); err != nil {
+		return inputErrorResponse(c, err)
+	}
+
+	imgData, _, err := readImageInput(c)
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+
+	analysis, err := services.AnalyzeImage(imgData, options)
+	if err != nil {
+		return errorResponse(c, fiber.StatusInternalServerError, "Failed to analyze image", err)
+	}
+
+	return c.JSON(analysis)
+}
+
+// parseFormatList parses a comma-separated list of encoder formats (jpg is accepted as jpeg)
+func parseFormatList(value, param string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	validFormats := map[string]bool{"jpeg": true, "png": true, "webp": true, "avif": true}
+	formats := make([]string, 0)
+	for _, format := range strings.Split(value, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		if !validFormats[format] {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid %s parameter. Supported formats: jpeg, png, webp, avif", param))
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// parseIntList parses a comma-separated list of integers within [minValue, maxValue]
+func parseIntList(value, param string, minValue, maxValue int) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	values := make([]int, 0)
+	for _, item := range strings.Split(value, ",") {
+		parsed, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || parsed < minValue || parsed > maxValue {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid %s parameter. Each value must be between %d and %d.", param, minValue, maxValue))
+		}
+		values = append(values, parsed)
+	}
+	return values, nil
+}
@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/textproto"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// archiveFile is one file of a ZIP or multipart/mixed response
+type archiveFile struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	Compress    bool // Deflate in ZIP archives (images are already compressed and stored as-is)
+}
+
+// sendZipArchive streams files as a ZIP archive downloaded as filename. Each
+// file's data is released as soon as it has been written, so the response never
+// holds a second copy of the archive.
+func sendZipArchive(c *fiber.Ctx, filename string, files []archiveFile) error {
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		zipWriter := zip.NewWriter(w)
+		for i := range files {
+			method := zip.Store
+			if files[i].Compress {
+				method = zip.Deflate
+			}
+			entry, err := zipWriter.CreateHeader(&zip.FileHeader{
+				Name:     files[i].Name,
+				Method:   method,
+				Modified: time.Now(),
+			})
+			if err == nil {
+				_, err = entry.Write(files[i].Data)
+			}
+			files[i].Data = nil
+			if err != nil {
+				// The status line is already sent, so the client sees a truncated archive
+				log.Printf("warning: failed to stream ZIP archive: %v", err)
+				return
+			}
+		}
+		if err := zipWriter.Close(); err != nil {
+			log.Printf("warning: failed to stream ZIP archive: %v", err)
+		}
+	})
+	return nil
+}
+
+// sendMultipartArchive streams files as a multipart/mixed body, one part per file
+// in order, releasing each file's data once it has been written
+func sendMultipartArchive(c *fiber.Ctx, files []archiveFile) error {
+	// The boundary goes in the header before the body is written
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	c.Set(fiber.HeaderContentType, "multipart/mixed; boundary="+boundary)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		writer := multipart.NewWriter(w)
+		if err := writer.SetBoundary(boundary); err != nil {
+			log.Printf("warning: failed to stream multipart response: %v", err)
+			return
+		}
+		for i := range files {
+			header := textproto.MIMEHeader{}
+			header.Set(fiber.HeaderContentType, files[i].ContentType)
+			header.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", files[i].Name))
+			part, err := writer.CreatePart(header)
+			if err == nil {
+				_, err = part.Write(files[i].Data)
+			}
+			files[i].Data = nil
+			if err != nil {
+				log.Printf("warning: failed to stream multipart response: %v", err)
+				return
+			}
+		}
+		if err := writer.Close(); err != nil {
+			log.Printf("warning: failed to stream multipart response: %v", err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/services"
+)
+
+// batchManifestField is the form field holding per-file batch options
+const batchManifestField = "manifest"
+
+// batchManifestEntry is one manifest entry: the options for the batch files whose
+// name matches Pattern
+type batchManifestEntry struct {
+	Pattern string
+	Options services.OptimizeOptions
+}
+
+// parseBatchManifest parses and validates a batch manifest: a JSON object mapping
+// file names or globs to query-style parameters plus an optional preset, e.g.
+//
+//	{"photos/*.jpg": {"format": "webp", "quality": 75}, "logo.png": {"preset": "lossless-archive"}}
+//
+// Parameters an entry does not set (directly or through its preset) fall back to
+// the request's query string. Entries keep their order, which decides between
+// globs matching the same file.
+func parseBatchManifest(c *fiber.Ctx, manifest string) ([]batchManifestEntry, error) {
+	decoder := json.NewDecoder(strings.NewReader(manifest))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid manifest. Must be a JSON object mapping file names or globs to options.")
+	}
+
+	// Query parameters fill in whatever the entries leave unset
+	defaults := make(map[string]string)
+	c.Request().URI().QueryArgs().VisitAll(func(key, value []byte) {
+		if _, ok := presetParams[string(key)]; ok {
+			defaults[string(key)] = string(value)
+		}
+	})
+
+	var entries []batchManifestEntry
+	seen := make(map[string]bool)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid manifest: "+err.Error())
+		}
+		pattern := token.(string) // Object keys are always strings
+		var params services.PipelineParams
+		if err := decoder.Decode(&params); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Manifest entry %q: %v", pattern, err))
+		}
+
+		if pattern == "" || seen[pattern] {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Manifest entry %q: pattern must be non-empty and unique", pattern))
+		}
+		seen[pattern] = true
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Manifest entry %q: invalid glob", pattern))
+		}
+
+		options, err := batchManifestOptions(c, params, defaults)
+		if err != nil {
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				err = fiber.NewError(fiberErr.Code, fmt.Sprintf("Manifest entry %q: %s", pattern, fiberErr.Message))
+			}
+			return nil, err
+		}
+		entries = append(entries, batchManifestEntry{Pattern: pattern, Options: options})
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid manifest: "+err.Error())
+	}
+	if decoder.More() {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid manifest: unexpected data after the JSON object")
+	}
+
+	return entries, nil
+}
+
+// batchManifestOptions resolves one entry's parameters and preset, then the
+// query-string defaults, into optimization options
+func batchManifestOptions(c *fiber.Ctx, params services.PipelineParams, defaults map[string]string) (services.OptimizeOptions, error) {
+	presetName := params["preset"]
+	delete(params, "preset")
+
+	merged, err := mergePresetParams(c, presetName, params)
+	if err != nil {
+		return services.OptimizeOptions{}, err
+	}
+	for key, value := range defaults {
+		if _, set := merged[key]; !set {
+			merged[key] = value
+		}
+	}
+	return optionsFromParams(c, merged)
+}
+
+// matchBatchManifest returns the entry that applies to a file, or -1: an entry
+// naming the file exactly wins, then the first matching glob. Globs containing
+// a slash are matched against the relative name, others against the base name.
+func matchBatchManifest(entries []batchManifestEntry, relativeName string) int {
+	baseName := path.Base(relativeName)
+	for i, entry := range entries {
+		if entry.Pattern == relativeName || entry.Pattern == baseName {
+			return i
+		}
+	}
+	for i, entry := range entries {
+		name := baseName
+		if strings.Contains(entry.Pattern, "/") {
+			name = relativeName
+		}
+		if matched, _ := path.Match(entry.Pattern, name); matched {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,155 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/bmp"
+)
+
+// parseTestManifest runs parseBatchManifest on a request with the given query string
+func parseTestManifest(t *testing.T, query, manifest string) ([]batchManifestEntry, error) {
+	t.Helper()
+	var entries []batchManifestEntry
+	var parseErr error
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		entries, parseErr = parseBatchManifest(c, manifest)
+		return nil
+	})
+	_, err := app.Test(httptest.NewRequest("GET", "/?"+query, nil))
+	require.NoError(t, err)
+	return entries, parseErr
+}
+
+func TestParseBatchManifest(t *testing.T) {
+	entries, err := parseTestManifest(t, "quality=60&width=100&output=zip", `{
+		"logo.png": {"format": "png", "quality": 90},
+		"photos/*": {"preset": "thumbnail", "width": 200},
+		"*.jpg": {}
+	}`)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, "logo.png", entries[0].Pattern)
+	assert.Equal(t, 90, entries[0].Options.Quality)
+	assert.Equal(t, 100, entries[0].Options.Width, "unset parameters fall back to the query string")
+
+	// The entry's own parameters win over its preset, which wins over the query string
+	assert.Equal(t, 200, entries[1].Options.Width)
+	assert.Equal(t, 320, entries[1].Options.Height)
+	assert.Equal(t, 75, entries[1].Options.Quality)
+
+	assert.Equal(t, 60, entries[2].Options.Quality)
+	assert.Equal(t, 100, entries[2].Options.Width)
+
+	for _, manifest := range []string{
+		`[]`,
+		`{"a.png": {"quality": 90}`,
+		`{"a.png": {}} {}`,
+		`{"": {}}`,
+		`{"a.png": {}, "a.png": {}}`,
+		`{"[a.png": {}}`,
+		`{"a.png": {"quality": 0}}`,
+		`{"a.png": {"page": 2}}`,
+		`{"a.png": {"unknown": 1}}`,
+		`{"a.png": {"preset": "missing"}}`,
+		`{"a.png": {"quality": [90]}}`,
+	} {
+		_, err := parseTestManifest(t, "", manifest)
+		assert.Error(t, err, manifest)
+	}
+}
+
+func TestMatchBatchManifest(t *testing.T) {
+	entries := []batchManifestEntry{{Pattern: "*.png"}, {Pattern: "photos/*.png"}, {Pattern: "logo.png"}}
+
+	assert.Equal(t, 2, matchBatchManifest(entries, "assets/logo.png"), "exact names win over globs")
+	assert.Equal(t, 0, matchBatchManifest(entries, "photos/beach.png"), "the first matching glob wins")
+	assert.Equal(t, 0, matchBatchManifest(entries[1:], "photos/beach.png"), "globs with a slash match the relative name")
+	assert.Equal(t, -1, matchBatchManifest(entries[1:2], "beach.png"))
+	assert.Equal(t, -1, matchBatchManifest(entries, "beach.jpg"))
+}
+
+func TestBatchOptimizeEndpoint_Manifest(t *testing.T) {
+	// Registering the routes initializes the result cache, so replace it afterwards
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	cache, err := services.NewResultCache(services.ResultCacheConfig{Dir: t.TempDir(), MaxBytes: 1 << 20})
+	require.NoError(t, err)
+	resultCache = cache
+	defer func() { resultCache = nil }()
+
+	// BMP dimensions are read from the header and results come from the cache,
+	// so the batch runs without libvips
+	encode := func(t *testing.T, size int) []byte {
+		var encoded bytes.Buffer
+		require.NoError(t, bmp.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, size, size))))
+		return encoded.Bytes()
+	}
+	photo, logo := encode(t, 8), encode(t, 9)
+
+	const query = "width=4"
+	const manifest = `{"logo.bmp": {"format": "png", "quality": 90}}`
+	entries, err := parseTestManifest(t, query, manifest)
+	require.NoError(t, err)
+	defaults, err := parseTestManifest(t, query, `{"*": {}}`)
+	require.NoError(t, err)
+	require.NoError(t, cache.Put(services.ResultCacheKey(photo, defaults[0].Options), &services.OptimizeResult{Format: "jpeg"}))
+	require.NoError(t, cache.Put(services.ResultCacheKey(logo, entries[0].Options), &services.OptimizeResult{Format: "png"}))
+
+	send := func(t *testing.T, manifest string) (int, BatchOptimizeResponse) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for name, data := range map[string][]byte{"photo.bmp": photo, "logo.bmp": logo} {
+			part, err := writer.CreatePart(map[string][]string{
+				"Content-Disposition": {`form-data; name="images"; filename="` + name + `"`},
+				"Content-Type":        {"image/bmp"},
+			})
+			require.NoError(t, err)
+			_, _ = part.Write(data)
+		}
+		require.NoError(t, writer.WriteField(batchManifestField, manifest))
+		require.NoError(t, writer.Close())
+
+		req := httptest.NewRequest("POST", "/batch-optimize?"+query, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+		data, _ := io.ReadAll(resp.Body)
+		var response BatchOptimizeResponse
+		_ = json.Unmarshal(data, &response)
+		return resp.StatusCode, response
+	}
+
+	status, response := send(t, manifest)
+	require.Equal(t, 200, status)
+	require.Equal(t, 2, response.Summary.Successful)
+	for _, result := range response.Results {
+		switch result.Filename {
+		case "logo.bmp":
+			assert.Equal(t, "png", result.Format)
+			assert.Equal(t, "logo.bmp", result.ManifestEntry)
+		case "photo.bmp":
+			assert.Equal(t, "jpeg", result.Format)
+			assert.Empty(t, result.ManifestEntry)
+		}
+	}
+
+	// Entries are validated before anything is processed
+	status, _ = send(t, `{"logo.bmp": {"quality": 500}}`)
+	assert.Equal(t, 400, status)
+	status, _ = send(t, `{"*.png": {"quality": 50}}`)
+	assert.Equal(t, 400, status, "entries matching no file are rejected")
+	assert.Equal(t, int64(2), cache.Stats().Hits, "rejected batches must not be processed")
+}
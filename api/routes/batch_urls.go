@@ -0,0 +1,145 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// batchURLsField is the form field holding a JSON list of image URLs to optimize
+const batchURLsField = "urls"
+
+// Batch URL defaults, overridable with BATCH_MAX_URLS, BATCH_FETCH_CONCURRENCY
+// and BATCH_FETCH_PER_HOST
+const (
+	defaultBatchMaxURLs          = 100
+	defaultBatchFetchConcurrency = batchWorkers
+	defaultBatchFetchPerHost     = 2
+)
+
+// batchURLLimit returns a positive integer setting from the environment, or fallback
+func batchURLLimit(name string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// parseBatchURLs parses the JSON list of URLs of a batch request. The URLs
+// themselves are checked when fetched, so one bad URL only fails its own result.
+func parseBatchURLs(raw string) ([]string, error) {
+	var urls []string
+	if err := json.Unmarshal([]byte(raw), &urls); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid urls parameter. Must be a JSON array of URLs.")
+	}
+	if maxURLs := batchURLLimit("BATCH_MAX_URLS", defaultBatchMaxURLs); len(urls) > maxURLs {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Too many URLs. At most %d are allowed per batch.", maxURLs))
+	}
+	for _, imgURL := range urls {
+		if strings.TrimSpace(imgURL) == "" {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid urls parameter. URLs must not be empty.")
+		}
+	}
+	return urls, nil
+}
+
+// batchURLName returns the host and path of a URL, used like an upload's
+// relative name (e.g. cdn.example.com/images/hero.jpg)
+func batchURLName(imgURL string) string {
+	parsed, err := url.Parse(imgURL)
+	if err != nil {
+		return "image"
+	}
+	// Clean the path on its own so ".." cannot climb above the host
+	name := strings.TrimSuffix(parsed.Hostname()+path.Clean("/"+parsed.Path), "/")
+	if name == "" {
+		return "image"
+	}
+	return name
+}
+
+// batchFetcher downloads the URL sources of one batch. At most
+// BATCH_FETCH_CONCURRENCY downloads run at once, and at most BATCH_FETCH_PER_HOST
+// of them against the same host, so a sweep of one CDN does not hammer it while
+// other hosts wait. Downloads happen inside the batch workers, so there are never
+// more than batchWorkers at once either.
+type batchFetcher struct {
+	clientIP    string
+	requestPath string
+	slots       chan struct{}
+	perHost     int
+
+	mu        sync.Mutex
+	hostSlots map[string]chan struct{}
+}
+
+// newBatchFetcher returns a fetcher for the URL sources of a batch request
+func newBatchFetcher(c *fiber.Ctx) *batchFetcher {
+	return &batchFetcher{
+		clientIP:    c.IP(),
+		requestPath: c.Path(),
+		slots:       make(chan struct{}, batchURLLimit("BATCH_FETCH_CONCURRENCY", defaultBatchFetchConcurrency)),
+		perHost:     batchURLLimit("BATCH_FETCH_PER_HOST", defaultBatchFetchPerHost),
+		hostSlots:   make(map[string]chan struct{}),
+	}
+}
+
+// fetch downloads and validates one batch URL within the concurrency limits
+func (f *batchFetcher) fetch(imgURL string) ([]byte, error) {
+	parsed, err := url.Parse(imgURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid URL. Only absolute http and https URLs are supported.")
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	f.mu.Lock()
+	hostSlot := f.hostSlots[host]
+	if hostSlot == nil {
+		hostSlot = make(chan struct{}, f.perHost)
+		f.hostSlots[host] = hostSlot
+	}
+	f.mu.Unlock()
+
+	// Take the host slot first so downloads queued for a busy host never
+	// hold a global slot that another host could use
+	hostSlot <- struct{}{}
+	f.slots <- struct{}{}
+	defer func() {
+		<-f.slots
+		<-hostSlot
+	}()
+
+	return loadBatchURL(imgURL, f.clientIP, f.requestPath)
+}
+
+// loadBatchURL fetches a single batch URL through the SSRF guards and validates
+// the image like an upload
+func loadBatchURL(imgURL, clientIP, requestPath string) ([]byte, error) {
+	imgData, err := fetchRemoteImage(imgURL, clientIP, requestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkImageSignature(imgData, "", false); err != nil {
+		log.Printf("[SECURITY] File signature mismatch in batch - IP: %s, URL: %s, Error: %v", clientIP, imgURL, err)
+		return nil, err
+	}
+
+	// Validate decoded image size (decompression bomb protection)
+	if err := validateDecodedImageSize(imgData, imgURL); err != nil {
+		// SECURITY EVENT: Decompression bomb in batch processing
+		log.Printf("[SECURITY] Decompression bomb in batch - IP: %s, URL: %s, Size: %d bytes, Error: %v",
+			clientIP, imgURL, len(imgData), err)
+		return nil, err
+	}
+
+	return imgData, nil
+}
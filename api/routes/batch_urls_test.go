@@ -0,0 +1,136 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/bmp"
+)
+
+func TestParseBatchURLs(t *testing.T) {
+	urls, err := parseBatchURLs(`["https://cdn.example.com/a.jpg", "https://cdn.example.com/b.png"]`)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+
+	for _, raw := range []string{``, `"https://cdn.example.com/a.jpg"`, `[1]`, `["https://cdn.example.com/a.jpg", " "]`} {
+		_, err := parseBatchURLs(raw)
+		assert.Error(t, err, raw)
+	}
+
+	_ = os.Setenv("BATCH_MAX_URLS", "1")
+	defer func() { _ = os.Unsetenv("BATCH_MAX_URLS") }()
+	_, err = parseBatchURLs(`["https://cdn.example.com/a.jpg", "https://cdn.example.com/b.png"]`)
+	assert.Error(t, err)
+}
+
+func TestBatchURLName(t *testing.T) {
+	assert.Equal(t, "cdn.example.com/images/hero.jpg", batchURLName("https://cdn.example.com:8443/images/hero.jpg?v=2"))
+	assert.Equal(t, "cdn.example.com/etc/passwd", batchURLName("https://cdn.example.com/a/../../etc/passwd"))
+	assert.Equal(t, "cdn.example.com", batchURLName("https://cdn.example.com"))
+	assert.Equal(t, "image", batchURLName("%"))
+}
+
+func TestBatchOptimizeEndpoint_URLs(t *testing.T) {
+	originalDomains := os.Getenv("ALLOWED_DOMAINS")
+	_ = os.Setenv("ALLOWED_DOMAINS", "")
+	_ = os.Setenv("ALLOW_PRIVATE_IPS", "true")
+	_ = os.Setenv("BATCH_FETCH_PER_HOST", "1")
+	defer func() {
+		_ = os.Setenv("ALLOWED_DOMAINS", originalDomains)
+		_ = os.Unsetenv("ALLOW_PRIVATE_IPS")
+		_ = os.Unsetenv("BATCH_FETCH_PER_HOST")
+	}()
+
+	// Registering the routes initializes the result cache, so replace it afterwards
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	cache, err := services.NewResultCache(services.ResultCacheConfig{Dir: t.TempDir(), MaxBytes: 1 << 20})
+	require.NoError(t, err)
+	resultCache = cache
+	defer func() { resultCache = nil }()
+
+	// BMP dimensions are read from the header and results come from the cache,
+	// so the batch runs without libvips
+	var source bytes.Buffer
+	require.NoError(t, bmp.Encode(&source, image.NewRGBA(image.Rect(0, 0, 8, 8))))
+	defaults, err := parseTestManifest(t, "", `{"*": {}}`)
+	require.NoError(t, err)
+	require.NoError(t, cache.Put(services.ResultCacheKey(source.Bytes(), defaults[0].Options), &services.OptimizeResult{Format: "png"}))
+
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, ".bmp"):
+			w.Header().Set("Content-Type", "image/bmp")
+			_, _ = w.Write(source.Bytes())
+		case r.URL.Path == "/page.html":
+			_, _ = w.Write([]byte("<html></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer testServer.Close()
+
+	urls := []string{
+		testServer.URL + "/images/a.bmp",
+		testServer.URL + "/images/b.bmp",
+		testServer.URL + "/missing.bmp.gone",
+		testServer.URL + "/page.html",
+		"ftp://example.com/c.bmp",
+	}
+	encoded, _ := json.Marshal(urls)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	require.NoError(t, writer.WriteField(batchURLsField, string(encoded)))
+	require.NoError(t, writer.Close())
+	req := httptest.NewRequest("POST", "/batch-optimize", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := app.Test(req, 30000)
+	require.NoError(t, err)
+	data, _ := io.ReadAll(resp.Body)
+	require.Equal(t, 200, resp.StatusCode, string(data))
+
+	var response BatchOptimizeResponse
+	require.NoError(t, json.Unmarshal(data, &response))
+	require.Len(t, response.Results, len(urls))
+	for i, result := range response.Results {
+		assert.Equal(t, urls[i], result.Filename)
+	}
+	assert.True(t, response.Results[0].Success)
+	assert.True(t, response.Results[1].Success)
+	assert.Contains(t, response.Results[2].Error, "404")
+	assert.Contains(t, response.Results[3].Error, "not a supported image format")
+	assert.Contains(t, response.Results[4].Error, "http and https")
+	assert.Equal(t, 2, response.Summary.Successful)
+	assert.Equal(t, 3, response.Summary.Failed)
+	assert.Equal(t, 1, maxInFlight, "fetches to one host must respect BATCH_FETCH_PER_HOST")
+}
@@ -0,0 +1,91 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/middleware"
+	"github.com/keif/image-optimizer/services"
+)
+
+// handleBenchmark handles POST /benchmark requests
+// @Summary Benchmark encoder settings on an image
+// @Description Encode one image with every combination of the requested formats, qualities and efforts using the regular optimization pipeline, and report output size, encode time, peak memory and SSIM for each combination. Peak memory is a whole-process approximation (memoryScope "process"): requests running at the same time are counted in it. JPEG has no effort setting and PNG is lossless, so those axes are not varied. Runs are serialized server-side so timings are comparable. Every cell flushes the shared libvips cache, so benchmarks require an admin API key.
+// @Tags optimization
+// @Accept multipart/form-data
+// @Produce json
+// @Produce text/csv
+// @Param formats query string false "Comma-separated formats" default(jpeg,webp,avif,png)
+// @Param qualities query string false "Comma-separated qualities (1-100)" default(60,75,90)
+// @Param efforts query string false "Comma-separated encoder efforts (0-6)" default(1,4,6)
+// @Param output query string false "Response format" Enums(json,csv) default(json)
+// @Param image formData file false "Image file to benchmark (multipart upload)"
+// @Param url formData string false "Image URL to fetch and benchmark (alternative to file upload)"
+// @Success 200 {object} services.BenchmarkResult "Benchmark matrix"
+// @Failure 400 {object} map[string]string "Invalid parameters or file"
+// @Failure 403 {object} map[string]string "Not an admin API key, or URL domain not allowed"
+// @Failure 500 {object} map[string]string "Benchmark error"
+// @Router /benchmark [post]
+func handleBenchmark(c *fiber.Ctx) error {
+	// Every cell drops the libvips operation cache, which slows down every other
+	// request in flight, so only operators may run benchmarks
+	if !middleware.IsAdmin(c) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Benchmarks can only be run with an admin API key.",
+		})
+	}
+
+	options := services.BenchmarkOptions{}
+
+	var err error
+	if options.Formats, err = parseFormatList(c.Query("formats"), "formats"); err != nil {
+		return inputErrorResponse(c, err)
+	}
+	if options.Qualities, err = parseIntList(c.Query("qualities"), "qualities", 1, 100); err != nil {
+		return inputErrorResponse(c, err)
+	}
+	if options.Efforts, err = parseIntList(c.Query("efforts"), "efforts", 0, 6); err != nil {
+		return inputErrorResponse(c, err)
+	}
+
+	output := c.Query("output", "json")
+	if output != "json" && output != "csv" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid output parameter. Supported: json, csv",
+		})
+	}
+
+	// Reject oversized matrices before reading the upload
+	if cells := services.CountBenchmarkCells(options); cells > services.MaxBenchmarkCells {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Benchmark matrix too large: %d combinations (max %d). Reduce formats, qualities or efforts.", cells, services.MaxBenchmarkCells),
+		})
+	}
+
+	imgData, _, err := readImageInput(c)
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+
+	result, err := services.RunBenchmark(imgData, options)
+	if err != nil {
+		if errors.Is(err, services.ErrBenchmarkTooLarge) {
+			return errorResponse(c, fiber.StatusBadRequest, "Benchmark matrix too large", err)
+		}
+		return errorResponse(c, fiber.StatusInternalServerError, "Failed to run benchmark", err)
+	}
+
+	if output == "csv" {
+		var buf bytes.Buffer
+		if err := result.WriteCSV(&buf); err != nil {
+			return errorResponse(c, fiber.StatusInternalServerError, "Failed to encode benchmark CSV", err)
+		}
+		c.Set("Content-Type", "text/csv; charset=utf-8")
+		c.Set("Content-Disposition", `attachment; filename="benchmark.csv"`)
+		return c.Send(buf.Bytes())
+	}
+
+	return c.JSON(result)
+}
@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/services"
+)
+
+// Result cache defaults
+const (
+	defaultResultCacheDir   = "./data/cache"
+	defaultResultCacheMaxMB = 1024
+	defaultResultCacheTTL   = 24 * time.Hour
+)
+
+// X-Cache header values
+const (
+	cacheHit  = "HIT"
+	cacheMiss = "MISS"
+)
+
+// resultCache keeps optimization results across requests; nil unless RESULT_CACHE_ENABLED
+var resultCache *services.ResultCache
+
+// initResultCache opens the result cache configured by the RESULT_CACHE_* environment
+// variables. Invalid values fall back to the defaults; a cache that cannot be opened
+// is disabled rather than failing startup.
+func initResultCache() {
+	resultCache = nil
+	if enabled, _ := strconv.ParseBool(os.Getenv("RESULT_CACHE_ENABLED")); !enabled {
+		return
+	}
+
+	config := services.ResultCacheConfig{
+		Dir:      defaultResultCacheDir,
+		MaxBytes: defaultResultCacheMaxMB << 20,
+		TTL:      defaultResultCacheTTL,
+	}
+	if dir := os.Getenv("RESULT_CACHE_DIR"); dir != "" {
+		config.Dir = dir
+	}
+	if value := os.Getenv("RESULT_CACHE_MAX_MB"); value != "" {
+		if megabytes, err := strconv.ParseInt(value, 10, 64); err == nil && megabytes > 0 {
+			config.MaxBytes = megabytes << 20
+		} else {
+			log.Printf("warning: invalid RESULT_CACHE_MAX_MB %q, using %d", value, defaultResultCacheMaxMB)
+		}
+	}
+	if value := os.Getenv("RESULT_CACHE_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err == nil && ttl >= 0 {
+			config.TTL = ttl
+		} else {
+			log.Printf("warning: invalid RESULT_CACHE_TTL %q, using %s", value, defaultResultCacheTTL)
+		}
+	}
+
+	cache, err := services.NewResultCache(config)
+	if err != nil {
+		log.Printf("warning: result cache disabled: %v", err)
+		return
+	}
+	resultCache = cache
+	log.Printf("Result cache: %s (max %d MB, TTL %s)", config.Dir, config.MaxBytes>>20, config.TTL)
+}
+
+// optimizeCached runs services.OptimizeImage through the result cache, coalescing
+// concurrent identical requests into one computation. It returns the cache key
+// and status (cacheHit or cacheMiss), both empty when the cache is disabled.
+// Failing to store a result is logged, not returned.
+func optimizeCached(data []byte, options services.OptimizeOptions) (*services.OptimizeResult, string, string, error) {
+	key := services.ResultCacheKey(data, options)
+	if resultCache != nil {
+		if result, ok := resultCache.Get(key); ok {
+			return result, key, cacheHit, nil
+		}
+	}
+
+	value, err := optimizeFlights.do(key, func() (interface{}, error) {
+		result, err := services.OptimizeImage(data, options)
+		if err != nil {
+			return nil, err
+		}
+		if resultCache != nil {
+			if err := resultCache.Put(key, result); err != nil {
+				log.Printf("warning: failed to cache result %s: %v", key, err)
+			}
+		}
+		return result, nil
+	})
+
+	status := ""
+	if resultCache != nil {
+		status = cacheMiss
+	} else {
+		key = ""
+	}
+	if err != nil {
+		return nil, key, status, err
+	}
+
+	// Coalesced callers share one result; give each its own copy to annotate
+	result := *value.(*services.OptimizeResult)
+	return &result, key, status, nil
+}
+
+// setCacheHeaders reports the cache status and key of a response, if the cache is enabled
+func setCacheHeaders(c *fiber.Ctx, key, status string) {
+	if status == "" {
+		return
+	}
+	c.Set("X-Cache", status)
+	c.Set("X-Cache-Key", key)
+}
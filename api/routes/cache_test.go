@@ -0,0 +1,142 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/middleware"
+	"github.com/keif/image-optimizer/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitResultCache(t *testing.T) {
+	defer func() { resultCache = nil }()
+
+	_ = os.Unsetenv("RESULT_CACHE_ENABLED")
+	initResultCache()
+	assert.Nil(t, resultCache, "cache should be disabled by default")
+
+	_ = os.Setenv("RESULT_CACHE_ENABLED", "true")
+	_ = os.Setenv("RESULT_CACHE_DIR", t.TempDir())
+	_ = os.Setenv("RESULT_CACHE_MAX_MB", "bogus")
+	_ = os.Setenv("RESULT_CACHE_TTL", "1h")
+	defer func() {
+		_ = os.Unsetenv("RESULT_CACHE_ENABLED")
+		_ = os.Unsetenv("RESULT_CACHE_DIR")
+		_ = os.Unsetenv("RESULT_CACHE_MAX_MB")
+		_ = os.Unsetenv("RESULT_CACHE_TTL")
+	}()
+	initResultCache()
+	require.NotNil(t, resultCache)
+	stats := resultCache.Stats()
+	assert.Equal(t, int64(defaultResultCacheMaxMB<<20), stats.MaxBytes)
+	assert.Equal(t, int64(3600), stats.TTLSeconds)
+}
+
+func TestOptimizeCached(t *testing.T) {
+	cache, err := services.NewResultCache(services.ResultCacheConfig{Dir: t.TempDir(), MaxBytes: 1 << 20})
+	require.NoError(t, err)
+	resultCache = cache
+	defer func() { resultCache = nil }()
+
+	input := []byte("not really an image")
+	options := services.OptimizeOptions{Quality: 80, Width: 100}
+	key := services.ResultCacheKey(input, options)
+	require.NoError(t, cache.Put(key, &services.OptimizeResult{Format: "webp", OptimizedImage: []byte("cached")}))
+
+	result, gotKey, status, err := optimizeCached(input, options)
+	require.NoError(t, err)
+	assert.Equal(t, cacheHit, status)
+	assert.Equal(t, key, gotKey)
+	assert.Equal(t, []byte("cached"), result.OptimizedImage)
+
+	// Different options are a different result; the input cannot be decoded, so nothing is stored
+	options.Quality = 60
+	_, _, status, err = optimizeCached(input, options)
+	assert.Error(t, err)
+	assert.Equal(t, cacheMiss, status)
+	assert.Equal(t, 1, cache.Stats().Entries)
+
+	resultCache = nil
+	_, gotKey, status, _ = optimizeCached(input, options)
+	assert.Empty(t, gotKey)
+	assert.Empty(t, status)
+}
+
+func TestCacheAdminEndpoints(t *testing.T) {
+	_ = os.Setenv("ADMIN_API_KEY_IDS", "1")
+	defer func() { _ = os.Unsetenv("ADMIN_API_KEY_IDS") }()
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if id, err := strconv.Atoi(c.Get("X-Test-Key")); err == nil {
+			c.Locals(middleware.APIKeyIDKey, id)
+		}
+		return c.Next()
+	})
+	SetupAdminRoutes(app)
+
+	requestAs := func(key, method, target string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("X-Test-Key", key)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		var decoded map[string]interface{}
+		require.NoError(t, json.Unmarshal(body, &decoded))
+		return resp.StatusCode, decoded
+	}
+	request := func(method, target string) (int, map[string]interface{}) {
+		return requestAs("1", method, target)
+	}
+
+	resultCache = nil
+	status, body := request("GET", "/admin/cache/stats")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, false, body["enabled"])
+	status, _ = request("DELETE", "/admin/cache?all=true")
+	assert.Equal(t, 400, status)
+
+	cache, err := services.NewResultCache(services.ResultCacheConfig{Dir: t.TempDir(), MaxBytes: 1 << 20})
+	require.NoError(t, err)
+	resultCache = cache
+	defer func() { resultCache = nil }()
+
+	for _, key := range []string{"aaaa-01", "aaaa-02", "bbbb-01"} {
+		require.NoError(t, cache.Put(key, &services.OptimizeResult{Format: "png"}))
+	}
+
+	status, body = request("GET", "/admin/cache/stats")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, true, body["enabled"])
+	assert.Equal(t, float64(3), body["entries"])
+
+	// The cache is shared by every client: other keys can neither probe nor purge it
+	for _, key := range []string{"", "2"} {
+		for _, target := range [][2]string{{"GET", "/admin/cache/stats"}, {"DELETE", "/admin/cache?all=true"}, {"DELETE", "/admin/cache?prefix=aaaa"}} {
+			status, _ = requestAs(key, target[0], target[1])
+			assert.Equal(t, 403, status, "%s %s with key %q", target[0], target[1], key)
+		}
+	}
+	assert.Equal(t, 3, cache.Stats().Entries)
+
+	for _, target := range []string{"/admin/cache", "/admin/cache?key=aaaa-01&all=true"} {
+		status, _ = request("DELETE", target)
+		assert.Equal(t, 400, status, target)
+	}
+
+	status, body = request("DELETE", "/admin/cache?key=bbbb-01")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, float64(1), body["purged"])
+
+	status, body = request("DELETE", "/admin/cache?prefix=aaaa")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, float64(2), body["purged"])
+	assert.Equal(t, 0, cache.Stats().Entries)
+}
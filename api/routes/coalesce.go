@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CoalescingStats counts how many calls of one kind shared another call's work
+type CoalescingStats struct {
+	Requests  int64 `json:"requests"`  // Calls made
+	Executed  int64 `json:"executed"`  // Calls that did the work
+	Coalesced int64 `json:"coalesced"` // Calls that waited for an identical in-flight call instead
+}
+
+// coalescer runs at most one computation per key at a time: callers arriving
+// while one is in flight wait for it and share its result
+type coalescer struct {
+	group    singleflight.Group
+	requests atomic.Int64
+	executed atomic.Int64
+}
+
+// optimizeFlights coalesces encoding of identical input and options; fetchFlights
+// coalesces downloads of the same source URL
+var (
+	optimizeFlights coalescer
+	fetchFlights    coalescer
+)
+
+// do runs fn for key unless an identical call is already in flight, in which case
+// it waits for that call's result. Results are shared between callers, so they
+// must be treated as read-only.
+func (fc *coalescer) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	fc.requests.Add(1)
+	value, err, _ := fc.group.Do(key, func() (interface{}, error) {
+		fc.executed.Add(1)
+		return fn()
+	})
+	return value, err
+}
+
+// stats returns a snapshot of the coalescer's counters
+func (fc *coalescer) stats() CoalescingStats {
+	// Requests are counted before executions, so loading in this order never
+	// yields more executions than requests
+	executed := fc.executed.Load()
+	requests := fc.requests.Load()
+	return CoalescingStats{Requests: requests, Executed: executed, Coalesced: requests - executed}
+}
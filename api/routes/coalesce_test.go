@@ -0,0 +1,69 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoalescer(t *testing.T) {
+	var fc coalescer
+	release := make(chan struct{})
+	const callers = 8
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value, err := fc.do("same", func() (interface{}, error) {
+				<-release
+				return "shared", nil
+			})
+			assert.NoError(t, err)
+			results[i] = value
+		}(i)
+	}
+
+	// Let every caller join the flight before the leader finishes
+	for fc.requests.Load() < callers {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for _, value := range results {
+		assert.Equal(t, "shared", value)
+	}
+	assert.Equal(t, CoalescingStats{Requests: callers, Executed: 1, Coalesced: callers - 1}, fc.stats())
+
+	// Once the flight has finished, the next call runs again, and errors are shared too
+	_, err := fc.do("same", func() (interface{}, error) { return nil, errors.New("boom") })
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, int64(2), fc.stats().Executed)
+}
+
+func TestMetricsCoalescingEndpoint(t *testing.T) {
+	app := fiber.New()
+	SetupMetricsRoutes(app)
+
+	_, _ = fetchFlights.do("https://example.com/cat.jpg", func() (interface{}, error) { return []byte{}, nil })
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics/coalescing", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var metrics CoalescingMetrics
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&metrics))
+	assert.GreaterOrEqual(t, metrics.Fetch.Executed, int64(1))
+	assert.Equal(t, metrics.Fetch.Requests-metrics.Fetch.Executed, metrics.Fetch.Coalesced)
+}
@@ -0,0 +1,168 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/h2non/bimg"
+	"github.com/keif/image-optimizer/middleware"
+	"github.com/keif/image-optimizer/services"
+)
+
+// parseDocumentOptions parses the page selection and rendering parameters used when
+// the input is a PDF or TIFF document. background is the already validated
+// background parameter, shared with alpha flattening.
+func parseDocumentOptions(c *fiber.Ctx, background string) (services.DocumentOptions, bool, error) {
+	options := services.DocumentOptions{
+		Page:       1,
+		DPI:        services.DefaultDocumentDPI,
+		Background: background,
+	}
+
+	if pageStr := c.Query("page"); pageStr != "" {
+		page, err := strconv.Atoi(pageStr)
+		if err != nil || page < 1 {
+			return options, false, fiber.NewError(fiber.StatusBadRequest, "Invalid page parameter. Must be a page number starting at 1.")
+		}
+		options.Page = page
+	}
+
+	if dpiStr := c.Query("dpi"); dpiStr != "" {
+		dpi, err := strconv.Atoi(dpiStr)
+		if err != nil || dpi < services.MinDocumentDPI || dpi > services.MaxDocumentDPI {
+			return options, false, fiber.NewError(fiber.StatusBadRequest,
+				fmt.Sprintf("Invalid dpi parameter. Must be between %d and %d.", services.MinDocumentDPI, services.MaxDocumentDPI))
+		}
+		options.DPI = dpi
+	}
+
+	return options, c.QueryBool("allPages", false), nil
+}
+
+// isDocumentInput reports whether the upload goes through page rendering. PDFs always
+// do; TIFFs only when they have several pages or a page was asked for, so single
+// scans are optimized (and can stay TIFF) like any other image.
+func isDocumentInput(c *fiber.Ctx, data []byte, allPages bool) bool {
+	switch services.DocumentKind(data) {
+	case services.DocumentPDF:
+		return true
+	case services.DocumentTIFF:
+		return allPages || c.Query("page") != "" || services.TIFFPageCount(data) > 1
+	}
+	return false
+}
+
+// optimizeDocument rasterizes the selected page of a PDF or TIFF and optimizes it like
+// any other image, or with allPages=true returns a ZIP with every optimized page
+func optimizeDocument(c *fiber.Ctx, data []byte, filename string, options services.OptimizeOptions,
+	docOptions services.DocumentOptions, allPages, returnImage bool) error {
+	doc, err := services.OpenDocument(data)
+	if err != nil {
+		return errorResponse(c, fiber.StatusInternalServerError, "Failed to open document", err)
+	}
+	defer doc.Close()
+
+	// Documents cannot be written back as documents - default to a web format
+	if options.Format == 0 {
+		options.Format = bimg.WEBP
+	}
+	c.Set("X-Page-Count", strconv.Itoa(doc.Pages))
+
+	if !allPages {
+		if err := checkDocumentPageSize(c, doc, docOptions.Page, docOptions.DPI, filename); err != nil {
+			return documentErrorResponse(c, err)
+		}
+		result, err := optimizeDocumentPage(c, doc, docOptions, options)
+		if err != nil {
+			return documentErrorResponse(c, err)
+		}
+		return sendOptimizeResult(c, result, options, returnImage)
+	}
+
+	if doc.Pages > services.MaxDocumentPages {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Document has %d pages; allPages supports at most %d. Request pages individually with the page parameter.",
+				doc.Pages, services.MaxDocumentPages),
+		})
+	}
+
+	// Check every page against the pixel limit before rendering any of them
+	for page := 1; page <= doc.Pages; page++ {
+		if err := checkDocumentPageSize(c, doc, page, docOptions.DPI, filename); err != nil {
+			return documentErrorResponse(c, err)
+		}
+	}
+
+	files := make([]archiveFile, 0, doc.Pages)
+	for page := 1; page <= doc.Pages; page++ {
+		pageOptions := docOptions
+		pageOptions.Page = page
+
+		result, err := optimizeDocumentPage(c, doc, pageOptions, options)
+		if err != nil {
+			return documentErrorResponse(c, err)
+		}
+		files = append(files, archiveFile{
+			Name:        fmt.Sprintf("page-%03d.%s", page, result.Format),
+			ContentType: "image/" + result.Format,
+			Data:        result.OptimizedImage,
+		})
+	}
+
+	return sendZipArchive(c, "pages.zip", files)
+}
+
+// optimizeDocumentPage renders one page and optimizes it. The page size must
+// already have been checked with checkDocumentPageSize.
+func optimizeDocumentPage(c *fiber.Ctx, doc *services.Document, docOptions services.DocumentOptions,
+	options services.OptimizeOptions) (*services.OptimizeResult, error) {
+	pageData, err := doc.RenderPage(docOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := services.OptimizeImage(pageData, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process page %d: %w", docOptions.Page, err)
+	}
+	result.OriginalFormat = doc.Kind
+	result.Page = docOptions.Page
+	result.PageCount = doc.Pages
+
+	middleware.RecordOptimizationMetric(c, doc.Kind, result.Format, int64(len(pageData)), result.OptimizedSize)
+	return result, nil
+}
+
+// checkDocumentPageSize rejects missing pages and pages that would exceed the
+// decoded pixel limit at the requested DPI, without rendering them
+func checkDocumentPageSize(c *fiber.Ctx, doc *services.Document, page, dpi int, filename string) error {
+	if page > doc.Pages {
+		return fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("Invalid page parameter. The document has %d page(s).", doc.Pages))
+	}
+
+	width, height, err := doc.PageSize(page, dpi)
+	if err != nil {
+		return err
+	}
+	if err := checkDecodedPixels(width, height, fmt.Sprintf("%s (page %d)", filename, page)); err != nil {
+		// SECURITY EVENT: Decompression bomb attempt
+		log.Printf("[SECURITY] Decompression bomb attempt - IP: %s, Filename: %s, Page: %d, DPI: %d, Error: %v",
+			c.IP(), filename, page, dpi, err)
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+// documentErrorResponse reports input problems (fiber errors) as-is and anything
+// else as a rendering failure
+func documentErrorResponse(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return inputErrorResponse(c, err)
+	}
+	return errorResponse(c, fiber.StatusInternalServerError, "Failed to render document page", err)
+}
@@ -0,0 +1,362 @@
+package routes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/services"
+)
+
+// imgproxyResizingTypes maps imgproxy resizing types to resize fit modes
+var imgproxyResizingTypes = map[string]string{
+	"fit":   services.FitInside,
+	"fill":  services.FitCover,
+	"force": services.FitFill,
+}
+
+// imgproxyGravities maps imgproxy gravity types to crop anchors
+var imgproxyGravities = map[string]string{
+	"ce": services.GravityCentre,
+	"no": services.GravityNorth,
+	"so": services.GravitySouth,
+	"ea": services.GravityEast,
+	"we": services.GravityWest,
+	"sm": services.GravitySmart,
+}
+
+// imgproxyRequest is an imgproxy URL translated into optimizer parameters
+type imgproxyRequest struct {
+	Params      map[string]string // /optimize query parameters
+	Preset      string
+	Fit         string
+	Gravity     string
+	Expires     int64 // Unix timestamp, 0 = never
+	SourceURL   string
+	Unsupported []string // Options (or option values) that cannot be honored
+}
+
+// handleImgproxy handles GET /imgproxy/{signature}/{options...}/{source} requests
+// @Summary imgproxy-compatible image URLs
+// @Description Serve imgproxy-style URLs so existing links keep working after a migration: processing options such as rs:fill:300:200, s, w, h, rt, g (ce, no, so, ea, we, sm), q, f/ext, bg, sh, dpr, t, pr, exp, cb and sm, followed by a plain/ (optionally percent-encoded, with @extension) or base64url (with .extension) source URL. The signature is verified with IMGPROXY_KEY and IMGPROXY_SALT like imgproxy does. Options that cannot be honored are rejected with a list instead of being ignored. Responses are cached like /img.
+// @Tags optimization
+// @Produce image/jpeg,image/png,image/webp,image/gif,image/avif,image/tiff
+// @Param signature path string true "imgproxy signature (any value in dev mode without IMGPROXY_KEY)"
+// @Param path path string true "Processing options and source URL, e.g. rs:fill:300:200/g:sm/plain/https://example.com/a.jpg@webp"
+// @Success 200 {file} binary "Optimized image"
+// @Success 304 "Not modified"
+// @Failure 400 {object} map[string]interface{} "Invalid or unsupported options, or invalid source URL"
+// @Failure 403 {object} map[string]string "Invalid signature, expired URL or URL domain not allowed"
+// @Failure 500 {object} map[string]string "Image processing error"
+// @Router /imgproxy/{signature}/{path} [get]
+func handleImgproxy(c *fiber.Ctx) error {
+	c.Vary(fiber.HeaderAccept)
+
+	path := c.Params("*")
+	if err := verifyImgproxySignature(c, c.Params("signature"), path); err != nil {
+		return inputErrorResponse(c, err)
+	}
+
+	req, err := parseImgproxyPath(path)
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+	if len(req.Unsupported) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":       "Unsupported imgproxy options: " + strings.Join(req.Unsupported, ", "),
+			"unsupported": req.Unsupported,
+		})
+	}
+	if req.Expires > 0 && time.Now().Unix() > req.Expires {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "URL has expired.",
+		})
+	}
+
+	options, err := optionsWithPreset(c, req.Preset, req.Params)
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+	options.Fit = req.Fit
+	options.Gravity = req.Gravity
+
+	return sendProxiedImage(c, req.SourceURL, options)
+}
+
+// verifyImgproxySignature checks an imgproxy signature: the base64url HMAC-SHA256
+// of salt + "/" + path, keyed with IMGPROXY_KEY (both hex-encoded, as in imgproxy)
+// and truncated to IMGPROXY_SIGNATURE_SIZE bytes. Without a key, signatures are
+// only skipped in dev mode.
+func verifyImgproxySignature(c *fiber.Ctx, signature, path string) error {
+	keyHex, saltHex := os.Getenv("IMGPROXY_KEY"), os.Getenv("IMGPROXY_SALT")
+	if keyHex == "" && saltHex == "" {
+		if proxyDevMode() {
+			return nil
+		}
+		return fiber.NewError(fiber.StatusForbidden, "imgproxy URL signatures are not configured (IMGPROXY_KEY, IMGPROXY_SALT).")
+	}
+
+	key, keyErr := hex.DecodeString(keyHex)
+	salt, saltErr := hex.DecodeString(saltHex)
+	if keyErr != nil || saltErr != nil {
+		log.Printf("error: IMGPROXY_KEY and IMGPROXY_SALT must be hex-encoded")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to verify URL signature.")
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(salt)
+	mac.Write([]byte("/" + path))
+	expected := mac.Sum(nil)[:imgproxySignatureSize()]
+
+	provided, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(signature, "="))
+	if err != nil || !hmac.Equal(provided, expected) {
+		log.Printf("[SECURITY] Invalid imgproxy URL signature - IP: %s, Path: %s", c.IP(), c.Path())
+		return fiber.NewError(fiber.StatusForbidden, "Invalid URL signature.")
+	}
+	return nil
+}
+
+// imgproxySignatureSize reads IMGPROXY_SIGNATURE_SIZE (1-32 bytes, default 32)
+func imgproxySignatureSize() int {
+	if size, err := strconv.Atoi(os.Getenv("IMGPROXY_SIGNATURE_SIZE")); err == nil && size >= 1 && size <= sha256.Size {
+		return size
+	}
+	return sha256.Size
+}
+
+// parseImgproxyPath parses the processing options and source URL of an imgproxy
+// path (everything after the signature). Options that cannot be translated are
+// collected in Unsupported; malformed values are errors.
+func parseImgproxyPath(path string) (*imgproxyRequest, error) {
+	req := &imgproxyRequest{
+		Params: make(map[string]string),
+		Fit:    services.FitInside, // imgproxy's default resizing type
+	}
+
+	segments := strings.Split(path, "/")
+	extension := ""
+	for i, segment := range segments {
+		if segment == "plain" {
+			source := strings.Join(segments[i+1:], "/")
+			if at := strings.LastIndex(source, "@"); at >= 0 {
+				source, extension = source[:at], source[at+1:]
+			}
+			unescaped, err := url.PathUnescape(source)
+			if err != nil {
+				return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid source URL encoding.")
+			}
+			req.SourceURL = unescaped
+			break
+		}
+		if segment == "enc" {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Encrypted imgproxy source URLs are not supported.")
+		}
+
+		if name, value, isOption := strings.Cut(segment, ":"); isOption {
+			if err := req.applyOption(name, strings.Split(value, ":")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Base64 source URLs may be split into several segments
+		source := strings.Join(segments[i:], "")
+		if dot := strings.LastIndex(source, "."); dot >= 0 {
+			source, extension = source[:dot], source[dot+1:]
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(source, "="))
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid base64-encoded source URL.")
+		}
+		req.SourceURL = string(decoded)
+		break
+	}
+
+	if req.SourceURL == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Missing source URL.")
+	}
+	if err := validateProxySource(req.SourceURL); err != nil {
+		return nil, err
+	}
+	if extension != "" {
+		if _, ok := req.Params["format"]; !ok {
+			req.Params["format"] = imgproxyFormat(extension)
+		}
+	}
+	return req, nil
+}
+
+// applyOption translates one imgproxy processing option
+func (r *imgproxyRequest) applyOption(name string, args []string) error {
+	switch name {
+	case "resize", "rs":
+		r.setResizingType(args[0])
+		r.setDimensions(args[1:])
+	case "size", "s":
+		r.setDimensions(args)
+	case "resizing_type", "rt":
+		r.setResizingType(args[0])
+	case "width", "w":
+		r.setParam("width", args[0])
+	case "height", "h":
+		r.setParam("height", args[0])
+	case "enlarge", "el":
+		r.requireFalse("enlarge", args[0])
+	case "extend", "ex":
+		r.requireFalse("extend", args[0])
+	case "gravity", "g":
+		gravity, ok := imgproxyGravities[args[0]]
+		if !ok {
+			r.Unsupported = append(r.Unsupported, "gravity:"+args[0])
+			break
+		}
+		r.Gravity = gravity
+		for _, offset := range args[1:] {
+			if value, err := strconv.ParseFloat(offset, 64); err != nil || value != 0 {
+				r.Unsupported = append(r.Unsupported, "gravity offsets")
+				break
+			}
+		}
+	case "quality", "q":
+		// q:0 means the default quality
+		if args[0] != "0" {
+			r.setParam("quality", args[0])
+		}
+	case "format", "f", "ext":
+		r.setParam("format", imgproxyFormat(args[0]))
+	case "background", "bg":
+		background, err := imgproxyBackground(args)
+		if err != nil {
+			return err
+		}
+		r.setParam("background", background)
+	case "sharpen", "sh":
+		r.setParam("sharpen", args[0])
+	case "dpr":
+		r.setParam("dpr", args[0])
+	case "trim", "t":
+		threshold, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || threshold < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid imgproxy option trim: threshold must be a non-negative number")
+		}
+		r.Params["trim"] = "true"
+		r.Params["trimThreshold"] = strconv.Itoa(int(math.Round(threshold)))
+		if len(args) > 1 && args[1] != "" {
+			r.Unsupported = append(r.Unsupported, "trim color")
+		}
+		if len(args) > 2 {
+			for _, equal := range args[2:] {
+				r.requireFalse("trim equal_hor/equal_ver", equal)
+			}
+		}
+	case "preset", "pr":
+		if len(args) > 1 {
+			r.Unsupported = append(r.Unsupported, "multiple presets")
+		}
+		r.Preset = args[0]
+	case "expires", "exp":
+		expires, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || expires < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid imgproxy option expires: expected a Unix timestamp")
+		}
+		r.Expires = expires
+	case "strip_metadata", "sm":
+		// Metadata is always stripped
+		r.requireTrue("strip_metadata", args[0])
+	case "cachebuster", "cb":
+		// Only changes the URL, so caches fetch it again - nothing to do
+	default:
+		r.Unsupported = append(r.Unsupported, name)
+	}
+	return nil
+}
+
+// setResizingType sets the fit mode from an imgproxy resizing type ("" keeps it)
+func (r *imgproxyRequest) setResizingType(resizingType string) {
+	if resizingType == "" {
+		return
+	}
+	fit, ok := imgproxyResizingTypes[resizingType]
+	if !ok {
+		r.Unsupported = append(r.Unsupported, "resizing_type:"+resizingType)
+		return
+	}
+	r.Fit = fit
+}
+
+// setDimensions applies the width:height:enlarge:extend arguments of resize and size
+func (r *imgproxyRequest) setDimensions(args []string) {
+	names := []string{"width", "height"}
+	for i, value := range args {
+		switch {
+		case i < len(names):
+			r.setParam(names[i], value)
+		case i == 2:
+			r.requireFalse("enlarge", value)
+		default:
+			r.requireFalse("extend", value)
+			return // Extend gravity arguments only matter when extending
+		}
+	}
+}
+
+// setParam sets an /optimize parameter; empty values and 0 (imgproxy's "auto") are skipped
+func (r *imgproxyRequest) setParam(name, value string) {
+	if value == "" || ((name == "width" || name == "height") && value == "0") {
+		return
+	}
+	r.Params[name] = value
+}
+
+// requireFalse records an unsupported boolean option that was switched on
+func (r *imgproxyRequest) requireFalse(name, value string) {
+	if enabled, err := strconv.ParseBool(value); value != "" && (err != nil || enabled) {
+		r.Unsupported = append(r.Unsupported, name)
+	}
+}
+
+// requireTrue records an unsupported boolean option that was switched off
+func (r *imgproxyRequest) requireTrue(name, value string) {
+	if enabled, err := strconv.ParseBool(value); value != "" && (err != nil || !enabled) {
+		r.Unsupported = append(r.Unsupported, name+":"+value)
+	}
+}
+
+// imgproxyFormat normalizes an imgproxy format or extension
+func imgproxyFormat(format string) string {
+	if strings.EqualFold(format, "jpg") {
+		return "jpeg"
+	}
+	return strings.ToLower(format)
+}
+
+// imgproxyBackground converts an imgproxy background (R:G:B or a hex color) to
+// the hex color /optimize expects
+func imgproxyBackground(args []string) (string, error) {
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	if len(args) != 3 {
+		return "", fiber.NewError(fiber.StatusBadRequest, "Invalid imgproxy option background: use R:G:B or a hex color")
+	}
+
+	var rgb [3]int
+	for i, arg := range args {
+		value, err := strconv.Atoi(arg)
+		if err != nil || value < 0 || value > 255 {
+			return "", fiber.NewError(fiber.StatusBadRequest, "Invalid imgproxy option background: channels must be 0-255")
+		}
+		rgb[i] = value
+	}
+	return fmt.Sprintf("%02x%02x%02x", rgb[0], rgb[1], rgb[2]), nil
+}
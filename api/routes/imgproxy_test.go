@@ -0,0 +1,210 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/services"
+	"golang.org/x/image/bmp"
+)
+
+func TestParseImgproxyPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		params  map[string]string
+		fit     string
+		gravity string
+		source  string
+	}{
+		{
+			name:    "resize with plain source and extension",
+			path:    "rs:fill:300:200/g:sm/q:80/plain/https://example.com/images/cat.jpg@webp",
+			params:  map[string]string{"width": "300", "height": "200", "quality": "80", "format": "webp"},
+			fit:     services.FitCover,
+			gravity: services.GravitySmart,
+			source:  "https://example.com/images/cat.jpg",
+		},
+		{
+			name:   "percent-encoded plain source",
+			path:   "w:400/f:jpg/plain/https%3A%2F%2Fexample.com%2Fa%20b.png%3Fv%3D2",
+			params: map[string]string{"width": "400", "format": "jpeg"},
+			fit:    services.FitInside,
+			source: "https://example.com/a b.png?v=2",
+		},
+		{
+			name:   "split base64 source with extension",
+			path:   "s:0:150/rt:force/bg:255:0:128/aHR0cHM6Ly9leGFt/cGxlLmNvbS9jYXQucG5n.avif",
+			params: map[string]string{"height": "150", "background": "ff0080", "format": "avif"},
+			fit:    services.FitFill,
+			source: "https://example.com/cat.png",
+		},
+		{
+			name:   "format option wins over extension",
+			path:   "f:png/cb:123/sm:1/pr:thumbnail/plain/https://example.com/cat.jpg@webp",
+			params: map[string]string{"format": "png"},
+			fit:    services.FitInside,
+			source: "https://example.com/cat.jpg",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := parseImgproxyPath(tt.path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(req.Unsupported) > 0 {
+				t.Errorf("Unexpected unsupported options: %v", req.Unsupported)
+			}
+			if !reflect.DeepEqual(req.Params, tt.params) {
+				t.Errorf("Params = %v, want %v", req.Params, tt.params)
+			}
+			if req.Fit != tt.fit || req.Gravity != tt.gravity {
+				t.Errorf("Fit/Gravity = %q/%q, want %q/%q", req.Fit, req.Gravity, tt.fit, tt.gravity)
+			}
+			if req.SourceURL != tt.source {
+				t.Errorf("SourceURL = %q, want %q", req.SourceURL, tt.source)
+			}
+		})
+	}
+}
+
+func TestParseImgproxyPath_Unsupported(t *testing.T) {
+	req, err := parseImgproxyPath("rs:auto:100:100:1/g:noea/bl:5/sm:0/wm:0.5/plain/https://example.com/cat.jpg")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"resizing_type:auto", "enlarge", "gravity:noea", "bl", "strip_metadata:0", "wm"}
+	if !reflect.DeepEqual(req.Unsupported, want) {
+		t.Errorf("Unsupported = %v, want %v", req.Unsupported, want)
+	}
+
+	for _, path := range []string{
+		"rs:fit:100:100",
+		"enc/abcdef",
+		"bg:300:0:0/plain/https://example.com/cat.jpg",
+		"exp:soon/plain/https://example.com/cat.jpg",
+		"plain/file:///etc/passwd",
+		"w:100/!!!",
+	} {
+		if _, err := parseImgproxyPath(path); err == nil {
+			t.Errorf("Expected error for %q", path)
+		}
+	}
+}
+
+func TestImgproxyEndpoint(t *testing.T) {
+	originalDomains := os.Getenv("ALLOWED_DOMAINS")
+	_ = os.Setenv("ALLOWED_DOMAINS", "")
+	_ = os.Setenv("ALLOW_PRIVATE_IPS", "true")
+	_ = os.Setenv("IMGPROXY_KEY", "6b6579")    // "key"
+	_ = os.Setenv("IMGPROXY_SALT", "73616c74") // "salt"
+	defer func() {
+		_ = os.Setenv("ALLOWED_DOMAINS", originalDomains)
+		_ = os.Unsetenv("ALLOW_PRIVATE_IPS")
+		_ = os.Unsetenv("IMGPROXY_KEY")
+		_ = os.Unsetenv("IMGPROXY_SALT")
+	}()
+
+	var source bytes.Buffer
+	if err := bmp.Encode(&source, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("Failed to encode BMP: %v", err)
+	}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/bmp")
+		_, _ = w.Write(source.Bytes())
+	}))
+	defer testServer.Close()
+
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	sign := func(path string) string {
+		mac := hmac.New(sha256.New, []byte("key"))
+		mac.Write([]byte("salt/" + path))
+		return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	fetch := func(t *testing.T, url string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("If-None-Match", "*")
+		resp, err := app.Test(req, 30000)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		var body bytes.Buffer
+		_, _ = body.ReadFrom(resp.Body)
+		return resp.StatusCode, body.String()
+	}
+
+	path := "rs:fill:4:4/g:sm/plain/" + testServer.URL + "/test.bmp@png"
+
+	t.Run("valid signature", func(t *testing.T) {
+		if status, body := fetch(t, "/imgproxy/"+sign(path)+"/"+path); status != http.StatusNotModified {
+			t.Errorf("Expected status 304, got %d: %s", status, body)
+		}
+	})
+
+	t.Run("truncated signature", func(t *testing.T) {
+		_ = os.Setenv("IMGPROXY_SIGNATURE_SIZE", "8")
+		defer func() { _ = os.Unsetenv("IMGPROXY_SIGNATURE_SIZE") }()
+
+		full, _ := base64.RawURLEncoding.DecodeString(sign(path))
+		truncated := base64.RawURLEncoding.EncodeToString(full[:8])
+		if status, body := fetch(t, "/imgproxy/"+truncated+"/"+path); status != http.StatusNotModified {
+			t.Errorf("Expected status 304, got %d: %s", status, body)
+		}
+	})
+
+	t.Run("tampered path", func(t *testing.T) {
+		tampered := "rs:fill:4000:4000/g:sm/plain/" + testServer.URL + "/test.bmp@png"
+		if status, _ := fetch(t, "/imgproxy/"+sign(path)+"/"+tampered); status != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", status)
+		}
+	})
+
+	t.Run("unsupported options", func(t *testing.T) {
+		unsupported := "bl:2/wm:0.5/plain/" + testServer.URL + "/test.bmp"
+		status, body := fetch(t, "/imgproxy/"+sign(unsupported)+"/"+unsupported)
+		if status != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d", status)
+		}
+		var response struct {
+			Unsupported []string `json:"unsupported"`
+		}
+		if err := json.Unmarshal([]byte(body), &response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if !reflect.DeepEqual(response.Unsupported, []string{"bl", "wm"}) {
+			t.Errorf("Expected unsupported [bl wm], got %v", response.Unsupported)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expired := "exp:" + strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10) + "/plain/" + testServer.URL + "/test.bmp"
+		if status, _ := fetch(t, "/imgproxy/"+sign(expired)+"/"+expired); status != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", status)
+		}
+	})
+
+	t.Run("no key outside dev mode", func(t *testing.T) {
+		_ = os.Unsetenv("IMGPROXY_KEY")
+		_ = os.Unsetenv("IMGPROXY_SALT")
+		if status, _ := fetch(t, "/imgproxy/insecure/"+path); status != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", status)
+		}
+	})
+}
@@ -0,0 +1,487 @@
+package routes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/keif/image-optimizer/db"
+	"github.com/keif/image-optimizer/middleware"
+	"github.com/valyala/fasthttp"
+)
+
+// Job queue defaults
+const (
+	defaultJobDir       = "./data/jobs"
+	defaultJobWorkers   = 2
+	defaultJobResultTTL = 24 * time.Hour
+)
+
+const (
+	jobPollInterval  = time.Second // How often idle workers check the queue without being woken
+	jobSweepInterval = time.Minute // How often expired results are removed
+	defaultJobType   = "optimize"
+)
+
+// jobProgressKey is the fiber.Ctx Locals key of a job's progress callback (func(done, total int))
+const jobProgressKey = "job_progress"
+
+// jobHandlers maps the job types accepted by POST /jobs to the endpoints that process them
+var jobHandlers = map[string]fiber.Handler{
+	"optimize":       handleOptimize,
+	"batch-optimize": handleBatchOptimize,
+	"pack-sprites":   PackSprites,
+}
+
+// JobResponse describes a job and, once it has finished, where to download its result
+type JobResponse struct {
+	*db.Job
+	ResultURL string `json:"resultUrl,omitempty"`
+}
+
+// jobQueue runs queued jobs on a pool of workers. Requests are replayed against an
+// internal app holding only the job handlers, so jobs accept exactly the inputs
+// of the synchronous endpoints.
+type jobQueue struct {
+	dir     string
+	workers int
+	ttl     time.Duration
+	handler fasthttp.RequestHandler // Internal app holding the job handlers
+
+	wake        chan struct{} // Signalled when a job is queued
+	webhookWake chan struct{} // Signalled when a webhook delivery is queued
+	done        chan struct{} // Closed to stop the workers
+	stopOnce    sync.Once
+	wg          sync.WaitGroup
+}
+
+// jobs is the running job queue
+var jobs *jobQueue
+
+// RegisterJobRoutes registers the asynchronous job routes and starts the workers
+// configured by JOB_WORKERS, JOB_DIR and JOB_RESULT_TTL
+func RegisterJobRoutes(app *fiber.App) {
+	queue, err := newJobQueue()
+	if err != nil {
+		log.Printf("warning: job queue disabled: %v", err)
+	} else {
+		jobs = queue
+		jobs.start()
+	}
+
+	app.Post("/jobs", handleCreateJob)
+	app.Post("/jobs/webhook-secret", handleRotateWebhookSecret)
+	app.Get("/jobs/:id", handleGetJob)
+	app.Get("/jobs/:id/result", handleGetJobResult)
+}
+
+// newJobQueue reads the job configuration from the environment and creates the
+// job directory. Invalid values fall back to the defaults.
+func newJobQueue() (*jobQueue, error) {
+	queue := &jobQueue{
+		dir:         defaultJobDir,
+		workers:     defaultJobWorkers,
+		ttl:         defaultJobResultTTL,
+		wake:        make(chan struct{}, 1),
+		webhookWake: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	if dir := os.Getenv("JOB_DIR"); dir != "" {
+		queue.dir = dir
+	}
+	if value := os.Getenv("JOB_WORKERS"); value != "" {
+		if workers, err := strconv.Atoi(value); err == nil && workers > 0 {
+			queue.workers = workers
+		} else {
+			log.Printf("warning: invalid JOB_WORKERS %q, using %d", value, defaultJobWorkers)
+		}
+	}
+	if value := os.Getenv("JOB_RESULT_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err == nil && ttl > 0 {
+			queue.ttl = ttl
+		} else {
+			log.Printf("warning: invalid JOB_RESULT_TTL %q, using %s", value, defaultJobResultTTL)
+		}
+	}
+
+	if err := os.MkdirAll(queue.dir, 0750); err != nil {
+		return nil, err
+	}
+
+	app := fiber.New()
+	app.Use(recover.New())
+	for jobType, handler := range jobHandlers {
+		app.Post("/"+jobType, handler)
+	}
+	queue.handler = app.Handler()
+	return queue, nil
+}
+
+// start requeues jobs interrupted by a restart and starts the workers and the
+// expiry sweeper
+func (q *jobQueue) start() {
+	if n, err := db.RequeueInterruptedJobs(); err != nil {
+		log.Printf("warning: failed to requeue interrupted jobs: %v", err)
+	} else if n > 0 {
+		log.Printf("Requeued %d interrupted jobs", n)
+	}
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	q.wg.Add(2)
+	go q.sweep()
+	go q.deliverWebhooks()
+
+	log.Printf("Job queue: %d workers, results kept for %s in %s", q.workers, q.ttl, q.dir)
+}
+
+// stop stops the workers after their current job
+func (q *jobQueue) stop() {
+	q.stopOnce.Do(func() { close(q.done) })
+	q.wg.Wait()
+}
+
+// notify wakes an idle worker after a job was queued
+func (q *jobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work processes queued jobs until the queue is stopped
+func (q *jobQueue) work() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.done:
+			return
+		default:
+		}
+
+		job, err := db.ClaimNextJob()
+		if err != nil {
+			log.Printf("warning: %v", err)
+		}
+		if job != nil {
+			q.process(job)
+			continue
+		}
+
+		select {
+		case <-q.done:
+			return
+		case <-q.wake:
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// sweep removes expired jobs and their files, and old webhook deliveries, until
+// the queue is stopped
+func (q *jobQueue) sweep() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(jobSweepInterval)
+	defer ticker.Stop()
+	for {
+		ids, err := db.DeleteExpiredJobs(time.Now())
+		if err != nil {
+			log.Printf("warning: %v", err)
+		}
+		for _, id := range ids {
+			q.removeFiles(id)
+		}
+		if _, err := db.DeleteWebhookDeliveriesBefore(time.Now().Add(-webhookRetention)); err != nil {
+			log.Printf("warning: %v", err)
+		}
+
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// process runs a claimed job through its handler and stores the response
+func (q *jobQueue) process(job *db.Job) {
+	body, err := os.ReadFile(q.inputPath(job.ID))
+	if err != nil {
+		log.Printf("warning: input of job %s is unreadable: %v", job.ID, err)
+		job.Status = db.JobFailed
+		job.Error = "Job input is missing"
+		job.ResultStatus = fiber.StatusInternalServerError
+		q.finish(job, nil)
+		return
+	}
+
+	var req fasthttp.Request
+	req.Header.SetMethod(fiber.MethodPost)
+	req.SetRequestURI("/" + job.Type + "?" + job.Query)
+	req.Header.SetContentType(job.ContentType)
+	req.SetBody(body)
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP(job.ClientIP)}, nil)
+	if job.APIKeyID != nil {
+		ctx.SetUserValue(middleware.APIKeyIDKey, *job.APIKeyID)
+	}
+	var progressMu sync.Mutex
+	lastProgress := 0
+	ctx.SetUserValue(jobProgressKey, func(done, total int) {
+		// 100 is reserved for finished jobs
+		progress := min(done*100/max(total, 1), 99)
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		if progress <= lastProgress {
+			return
+		}
+		lastProgress = progress
+		if err := db.UpdateJobProgress(job.ID, progress); err != nil {
+			log.Printf("warning: %v", err)
+		}
+	})
+
+	q.handler(&ctx)
+
+	response := &ctx.Response
+	job.ResultStatus = response.StatusCode()
+	job.ResultType = string(response.Header.ContentType())
+	job.ResultDisposition = string(response.Header.Peek(fiber.HeaderContentDisposition))
+	if job.ResultStatus < fiber.StatusBadRequest {
+		job.Status = db.JobCompleted
+	} else {
+		job.Status = db.JobFailed
+		job.Error = responseErrorMessage(response)
+	}
+	q.finish(job, response.Body())
+}
+
+// finish stores a job's result and outcome, removes its input and queues its
+// callback, if any
+func (q *jobQueue) finish(job *db.Job, result []byte) {
+	if err := os.WriteFile(q.resultPath(job.ID), result, 0600); err != nil {
+		log.Printf("warning: failed to store result of job %s: %v", job.ID, err)
+		job.Status = db.JobFailed
+		job.Error = "Failed to store job result"
+		job.ResultStatus = fiber.StatusInternalServerError
+	}
+	if err := db.FinishJob(job, time.Now().Add(q.ttl)); err != nil {
+		log.Printf("warning: %v", err)
+	}
+	if err := os.Remove(q.inputPath(job.ID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("warning: failed to remove input of job %s: %v", job.ID, err)
+	}
+	if job.CallbackURL != "" {
+		q.queueWebhook(job)
+	}
+}
+
+// removeFiles deletes a job's stored input and result
+func (q *jobQueue) removeFiles(id string) {
+	for _, path := range []string{q.inputPath(id), q.resultPath(id)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("warning: failed to remove %s: %v", path, err)
+		}
+	}
+}
+
+func (q *jobQueue) inputPath(id string) string  { return filepath.Join(q.dir, id+".input") }
+func (q *jobQueue) resultPath(id string) string { return filepath.Join(q.dir, id+".result") }
+
+// responseErrorMessage extracts the "error" field of a JSON error response, or
+// returns the plain text body
+func responseErrorMessage(response *fasthttp.Response) string {
+	var decoded struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(response.Body(), &decoded); err == nil && decoded.Error != "" {
+		return decoded.Error
+	}
+	if message := strings.TrimSpace(string(response.Body())); message != "" && len(message) <= 500 {
+		return message
+	}
+	return "Request failed with status " + strconv.Itoa(response.StatusCode())
+}
+
+// reportJobProgress reports that done of total items have been processed when the
+// request runs as a job; otherwise it does nothing. Safe for concurrent use.
+func reportJobProgress(c *fiber.Ctx, done, total int) {
+	if report, ok := c.Locals(jobProgressKey).(func(int, int)); ok {
+		report(done, total)
+	}
+}
+
+// newJobID generates a random, unguessable job ID
+func newJobID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// handleCreateJob handles POST /jobs requests
+// @Summary Queue an asynchronous job
+// @Description Queue an /optimize, /batch-optimize or /pack-sprites request (same query parameters and form fields) and return its job ID immediately
+// @Tags jobs
+// @Accept multipart/form-data
+// @Produce json
+// @Param type query string false "Endpoint to run" Enums(optimize,batch-optimize,pack-sprites) default(optimize)
+// @Param callbackUrl query string false "URL notified with a signed POST when the job completes or fails"
+// @Success 202 {object} JobResponse
+// @Failure 400 {object} map[string]string "Unknown job type, empty request or invalid callback URL"
+// @Failure 403 {object} map[string]string "Callback URL domain not allowed"
+// @Failure 503 {object} map[string]string "Job queue unavailable"
+// @Router /jobs [post]
+func handleCreateJob(c *fiber.Ctx) error {
+	if jobs == nil {
+		return errorResponse(c, fiber.StatusServiceUnavailable, "Job queue is not available", nil)
+	}
+
+	jobType := c.Query("type", defaultJobType)
+	if _, ok := jobHandlers[jobType]; !ok {
+		return errorResponse(c, fiber.StatusBadRequest, "Unknown job type. Supported: optimize, batch-optimize, pack-sprites", nil)
+	}
+	if len(c.Body()) == 0 {
+		return errorResponse(c, fiber.StatusBadRequest, "Request body is empty. Send the same form as the synchronous endpoint.", nil)
+	}
+
+	callbackURL := c.Query("callbackUrl")
+	if callbackURL != "" {
+		if err := validateCallbackURL(callbackURL, requestAPIKeyID(c)); err != nil {
+			return inputErrorResponse(c, err)
+		}
+	}
+
+	// Forward every query parameter except those of the job itself
+	var args fasthttp.Args
+	c.Request().URI().QueryArgs().CopyTo(&args)
+	args.Del("type")
+	args.Del("callbackUrl")
+
+	id, err := newJobID()
+	if err != nil {
+		return errorResponse(c, fiber.StatusInternalServerError, "Failed to create job", err)
+	}
+	job := &db.Job{
+		ID:          id,
+		Type:        jobType,
+		APIKeyID:    requestAPIKeyID(c),
+		ClientIP:    c.IP(),
+		ContentType: string(c.Request().Header.ContentType()),
+		Query:       args.String(),
+		CallbackURL: callbackURL,
+	}
+
+	if err := os.WriteFile(jobs.inputPath(id), c.Body(), 0600); err != nil {
+		return errorResponse(c, fiber.StatusInternalServerError, "Failed to store job input", err)
+	}
+	if err := db.CreateJob(job); err != nil {
+		jobs.removeFiles(id)
+		return errorResponse(c, fiber.StatusInternalServerError, "Failed to create job", err)
+	}
+	jobs.notify()
+
+	c.Location("/jobs/" + id)
+	return c.Status(fiber.StatusAccepted).JSON(JobResponse{Job: job})
+}
+
+// findJob loads the job named in the path for the calling API key. Jobs created
+// with an API key are only visible to that key.
+func findJob(c *fiber.Ctx) (*db.Job, error) {
+	job, err := db.GetJob(c.Params("id"))
+	if errors.Is(err, db.ErrJobNotFound) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Job not found")
+	}
+	if err != nil {
+		log.Printf("Failed to load job: %v", err)
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to load job")
+	}
+
+	if job.APIKeyID != nil {
+		if caller := requestAPIKeyID(c); caller == nil || *caller != *job.APIKeyID {
+			return nil, fiber.NewError(fiber.StatusNotFound, "Job not found")
+		}
+	}
+	if job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt) {
+		return nil, fiber.NewError(fiber.StatusGone, "Job result has expired")
+	}
+	return job, nil
+}
+
+// handleGetJob handles GET /jobs/{id} requests
+// @Summary Get job status
+// @Description Get the status (queued, processing, completed, failed) and progress (0-100) of a job
+// @Tags jobs
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} JobResponse
+// @Failure 404 {object} map[string]string "Job not found"
+// @Failure 410 {object} map[string]string "Job result has expired"
+// @Router /jobs/{id} [get]
+func handleGetJob(c *fiber.Ctx) error {
+	job, err := findJob(c)
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+
+	response := JobResponse{Job: job}
+	if job.Status == db.JobCompleted || job.Status == db.JobFailed {
+		response.ResultURL = "/jobs/" + job.ID + "/result"
+	}
+	return c.JSON(response)
+}
+
+// handleGetJobResult handles GET /jobs/{id}/result requests
+// @Summary Download a job result
+// @Description Download the response the synchronous endpoint produced for the job, with its original status code and content type
+// @Tags jobs
+// @Produce json,image/jpeg,image/png,image/webp,image/gif,image/avif,image/tiff,application/zip
+// @Param id path string true "Job ID"
+// @Success 200 {file} binary "Job result"
+// @Failure 404 {object} map[string]string "Job not found"
+// @Failure 409 {object} map[string]string "Job has not finished"
+// @Failure 410 {object} map[string]string "Job result has expired"
+// @Router /jobs/{id}/result [get]
+func handleGetJobResult(c *fiber.Ctx) error {
+	job, err := findJob(c)
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+	if job.Status != db.JobCompleted && job.Status != db.JobFailed {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":  "Job has not finished yet",
+			"status": job.Status,
+		})
+	}
+	if jobs == nil {
+		return errorResponse(c, fiber.StatusServiceUnavailable, "Job queue is not available", nil)
+	}
+
+	result, err := os.ReadFile(jobs.resultPath(job.ID))
+	if err != nil {
+		return errorResponse(c, fiber.StatusInternalServerError, "Failed to read job result", err)
+	}
+
+	if job.ResultType != "" {
+		c.Set(fiber.HeaderContentType, job.ResultType)
+	}
+	if job.ResultDisposition != "" {
+		c.Set(fiber.HeaderContentDisposition, job.ResultDisposition)
+	}
+	return c.Status(job.ResultStatus).Send(result)
+}
@@ -0,0 +1,169 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/db"
+	"github.com/keif/image-optimizer/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupJobTestApp starts a job queue on a temporary database, with an "echo" job
+// type that completes without an image encoder. X-Test-Key sets the caller's API key ID.
+func setupJobTestApp(t *testing.T) *fiber.App {
+	dir := t.TempDir()
+	_ = os.Setenv("DB_PATH", filepath.Join(dir, "jobs.db"))
+	_ = os.Setenv("JOB_DIR", filepath.Join(dir, "jobs"))
+	_ = os.Setenv("JOB_WORKERS", "1")
+	require.NoError(t, db.Initialize())
+
+	jobHandlers["echo"] = func(c *fiber.Ctx) error {
+		reportJobProgress(c, 1, 2)
+		keyID, _ := c.Locals(middleware.APIKeyIDKey).(int)
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="echo.txt"`)
+		return c.Type("txt").SendString(c.Query("msg") + ":" + c.FormValue("text") + ":" + strconv.Itoa(keyID))
+	}
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if key := c.Get("X-Test-Key"); key != "" {
+			id, _ := strconv.Atoi(key)
+			c.Locals(middleware.APIKeyIDKey, id)
+		}
+		return c.Next()
+	})
+	RegisterOptimizeRoutes(app)
+	RegisterJobRoutes(app)
+	SetupAdminRoutes(app)
+	require.NotNil(t, jobs)
+
+	t.Cleanup(func() {
+		jobs.stop()
+		jobs = nil
+		delete(jobHandlers, "echo")
+		_ = db.Close()
+		_ = os.Unsetenv("DB_PATH")
+		_ = os.Unsetenv("JOB_DIR")
+		_ = os.Unsetenv("JOB_WORKERS")
+	})
+	return app
+}
+
+// sendJobRequest sends a form-encoded request as the API key with ID key ("" = none)
+func sendJobRequest(t *testing.T, app *fiber.App, method, target, key, body string) (int, jobResponseHeaders, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if key != "" {
+		req.Header.Set("X-Test-Key", key)
+	}
+	resp, err := app.Test(req, 10000)
+	require.NoError(t, err)
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, jobResponseHeaders{resp.Header.Get("Content-Type"), resp.Header.Get("Content-Disposition"), resp.Header.Get("Location")}, data
+}
+
+func TestJobEndpoints(t *testing.T) {
+	app := setupJobTestApp(t)
+	send := func(t *testing.T, method, target, key, body string) (int, jobResponseHeaders, []byte) {
+		t.Helper()
+		return sendJobRequest(t, app, method, target, key, body)
+	}
+	create := func(t *testing.T, target, key, body string) string {
+		status, headers, data := send(t, "POST", target, key, body)
+		require.Equal(t, fiber.StatusAccepted, status, string(data))
+		var job JobResponse
+		require.NoError(t, json.Unmarshal(data, &job))
+		assert.Equal(t, db.JobQueued, job.Status)
+		assert.Equal(t, "/jobs/"+job.ID, headers.location)
+		return job.ID
+	}
+	wait := func(t *testing.T, id, key string) JobResponse {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			status, _, data := send(t, "GET", "/jobs/"+id, key, "")
+			require.Equal(t, 200, status, string(data))
+			var job JobResponse
+			require.NoError(t, json.Unmarshal(data, &job))
+			if job.Status == db.JobCompleted || job.Status == db.JobFailed {
+				return job
+			}
+			require.True(t, time.Now().Before(deadline), "job %s did not finish", id)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("completed job", func(t *testing.T) {
+		id := create(t, "/jobs?type=echo&msg=hi", "7", "text=hello")
+		job := wait(t, id, "7")
+		assert.Equal(t, db.JobCompleted, job.Status)
+		assert.Equal(t, 100, job.Progress)
+		assert.Equal(t, "/jobs/"+id+"/result", job.ResultURL)
+		require.NotNil(t, job.ExpiresAt)
+
+		status, headers, data := send(t, "GET", "/jobs/"+id+"/result", "7", "")
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "hi:hello:7", string(data))
+		assert.Contains(t, headers.contentType, "text/plain")
+		assert.Equal(t, `attachment; filename="echo.txt"`, headers.disposition)
+
+		// Jobs are private to the API key that created them
+		status, _, _ = send(t, "GET", "/jobs/"+id, "8", "")
+		assert.Equal(t, 404, status)
+		status, _, _ = send(t, "GET", "/jobs/"+id+"/result", "", "")
+		assert.Equal(t, 404, status)
+	})
+
+	t.Run("failed job keeps the endpoint's error response", func(t *testing.T) {
+		id := create(t, "/jobs?type=optimize&quality=500", "", "url=https://example.com/cat.png")
+		job := wait(t, id, "")
+		assert.Equal(t, db.JobFailed, job.Status)
+		assert.Contains(t, job.Error, "Invalid quality parameter")
+
+		status, _, data := send(t, "GET", "/jobs/"+id+"/result", "", "")
+		assert.Equal(t, 400, status)
+		assert.Contains(t, string(data), "Invalid quality parameter")
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		status, _, _ := send(t, "POST", "/jobs?type=similar", "", "text=x")
+		assert.Equal(t, 400, status)
+		status, _, _ = send(t, "POST", "/jobs", "", "")
+		assert.Equal(t, 400, status)
+		status, _, _ = send(t, "GET", "/jobs/unknown", "", "")
+		assert.Equal(t, 404, status)
+	})
+
+	jobs.stop()
+
+	t.Run("unfinished and expired jobs", func(t *testing.T) {
+		queued := &db.Job{ID: "queued", Type: "echo"}
+		require.NoError(t, db.CreateJob(queued))
+		status, _, _ := send(t, "GET", "/jobs/queued/result", "", "")
+		assert.Equal(t, 409, status)
+
+		queued.Status = db.JobCompleted
+		require.NoError(t, db.FinishJob(queued, time.Now().Add(-time.Second)))
+		status, _, _ = send(t, "GET", "/jobs/queued", "", "")
+		assert.Equal(t, 410, status)
+	})
+}
+
+// jobResponseHeaders holds the response headers checked by the job tests
+type jobResponseHeaders struct {
+	contentType string
+	disposition string
+	location    string
+}
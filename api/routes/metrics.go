@@ -20,6 +20,9 @@ func SetupMetricsRoutes(app *fiber.App) {
 
 	// Timeline endpoint - time-series data
 	metrics.Get("/timeline", handleMetricsTimeline)
+
+	// Coalescing endpoint - in-memory request coalescing counters since startup
+	metrics.Get("/coalescing", handleMetricsCoalescing)
 }
 
 // @Summary Get metrics summary
@@ -169,3 +172,22 @@ func handleMetricsTimeline(c *fiber.Ctx) error {
 		"data":     dataPoints,
 	})
 }
+
+// CoalescingMetrics reports how often identical concurrent requests shared work
+type CoalescingMetrics struct {
+	Optimize CoalescingStats `json:"optimize"` // Encodes of identical input and options
+	Fetch    CoalescingStats `json:"fetch"`    // Downloads of the same source URL
+}
+
+// @Summary Get request coalescing statistics
+// @Description Get how many optimizations and URL fetches were shared with an identical in-flight request since the server started
+// @Tags metrics
+// @Produce json
+// @Success 200 {object} CoalescingMetrics
+// @Router /metrics/coalescing [get]
+func handleMetricsCoalescing(c *fiber.Ctx) error {
+	return c.JSON(CoalescingMetrics{
+		Optimize: optimizeFlights.stats(),
+		Fetch:    fetchFlights.stats(),
+	})
+}
@@ -2,17 +2,23 @@ package routes
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
+	"mime"
 	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -51,6 +57,15 @@ func errorResponse(c *fiber.Ctx, status int, message string, err error) error {
 // validateDecodedImageSize checks if a decoded image exceeds safe pixel limits
 // This protects against decompression bombs (small compressed files that expand to huge images)
 func validateDecodedImageSize(imgData []byte, filename string) error {
+	// libvips cannot read BMP - take the dimensions from the header instead
+	if services.IsBMP(imgData) {
+		width, height, err := services.BMPSize(imgData)
+		if err != nil {
+			return fmt.Errorf("failed to read image metadata: %w", err)
+		}
+		return checkDecodedPixels(width, height, filename)
+	}
+
 	// Decode image to get dimensions
 	// Use bimg metadata which is faster than full decode
 	metadata, err := bimg.NewImage(imgData).Metadata()
@@ -58,9 +73,12 @@ func validateDecodedImageSize(imgData []byte, filename string) error {
 		return fmt.Errorf("failed to read image metadata: %w", err)
 	}
 
-	// Calculate total pixels
-	width := metadata.Size.Width
-	height := metadata.Size.Height
+	return checkDecodedPixels(metadata.Size.Width, metadata.Size.Height, filename)
+}
+
+// checkDecodedPixels applies the decompression bomb limit to known dimensions.
+// Used directly for document pages, which are measured before they are rendered.
+func checkDecodedPixels(width, height int, filename string) error {
 	totalPixels := int64(width) * int64(height)
 
 	// Check against maximum allowed pixels
@@ -146,6 +164,16 @@ func isPrivateIP(host string) bool {
 		return true
 	}
 
+	// Check for unspecified (0.0.0.0, ::), which reaches the local host, and multicast
+	if ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+
+	// Check for carrier-grade NAT (100.64.0.0/10, RFC 6598)
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+		return true
+	}
+
 	// Block cloud metadata endpoints (AWS, GCP, Azure, etc.)
 	ipStr := ip.String()
 	cloudMetadata := []string{
@@ -183,42 +211,182 @@ func isAllowedDomain(u *url.URL) bool {
 	return false
 }
 
+// inputErrorResponse converts an image input error into a JSON error response
+// Errors created with fiber.NewError keep their status code, anything else is a 400
+func inputErrorResponse(c *fiber.Ctx, err error) error {
+	status := fiber.StatusBadRequest
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// readImageInput loads the image for a request - preferring the uploaded 'image' file
+// and falling back to fetching the 'url' form value. The upload whitelist, SSRF
+// protection, size limits and decompression bomb checks are all applied here.
+// Returns the image data and a filename (or URL) used in logs and error messages.
+func readImageInput(c *fiber.Ctx) ([]byte, string, error) {
+	var imgData []byte
+	filename := "uploaded_image"
+	contentType := ""
+
+	file, err := c.FormFile("image")
+	if err == nil && file != nil {
+		// Handle uploaded file
+		// Validate file type
+		contentType = file.Header.Get("Content-Type")
+		if err := checkUploadType(contentType, true); err != nil {
+			return nil, "", err
+		}
+
+		// Read file contents
+		f, err := file.Open()
+		if err != nil {
+			return nil, "", fiber.NewError(fiber.StatusBadRequest, "Failed to open uploaded file.")
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Printf("warning: failed to close file: %v", err)
+			}
+		}()
+
+		imgData, err = io.ReadAll(io.LimitReader(f, maxImageSize))
+		if err != nil {
+			return nil, "", fiber.NewError(fiber.StatusBadRequest, "Failed to read uploaded file.")
+		}
+
+		// Check if we hit the size limit
+		if len(imgData) >= int(maxImageSize) {
+			return nil, "", fiber.NewError(fiber.StatusRequestEntityTooLarge, "Uploaded file exceeds maximum size of 500MB.")
+		}
+
+		filename = file.Filename
+	} else {
+		// Try URL-based fetching
+		imgURL := c.FormValue("url")
+		if imgURL == "" {
+			return nil, "", fiber.NewError(fiber.StatusBadRequest, "Must provide either 'image' file or 'url' parameter.")
+		}
+
+		imgData, err = fetchRemoteImage(imgURL, c.IP(), c.Path())
+		if err != nil {
+			return nil, "", err
+		}
+
+		filename = imgURL
+	}
+
+	if err := validateImageContent(c, imgData, filename, contentType, true); err != nil {
+		return nil, "", err
+	}
+
+	return imgData, filename, nil
+}
+
+// validateImageContent checks loaded image data before it is decoded: the file
+// signature must match contentType ("" for fetched URLs, which only need a
+// supported format) and the decoded size must stay within the pixel limit
+func validateImageContent(c *fiber.Ctx, imgData []byte, filename, contentType string, allowDocuments bool) error {
+	// The content must really be the declared (or, for URLs, a supported) format
+	if err := checkImageSignature(imgData, contentType, allowDocuments); err != nil {
+		log.Printf("[SECURITY] File signature mismatch - IP: %s, Filename: %s, Declared type: %q, Error: %v",
+			c.IP(), filename, contentType, err)
+		return err
+	}
+
+	// Validate decoded image size (decompression bomb protection)
+	if err := validateDecodedImageSize(imgData, filename); err != nil {
+		// SECURITY EVENT: Decompression bomb attempt
+		log.Printf("[SECURITY] Decompression bomb attempt - IP: %s, Filename: %s, Size: %d bytes, Error: %v",
+			c.IP(), filename, len(imgData), err)
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return nil
+}
+
+// fetchRemoteImage downloads an image from a URL after validating it against the
+// domain whitelist and SSRF protections. clientIP and path are only used for
+// security logging.
+func fetchRemoteImage(imgURL, clientIP, path string) ([]byte, error) {
+	// Parse and validate URL
+	parsed, err := url.Parse(imgURL)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid URL format.")
+	}
+
+	// Check domain whitelist
+	if !isAllowedDomain(parsed) {
+		// SECURITY EVENT: SSRF attempt - blocked domain or private IP
+		log.Printf("[SECURITY] SSRF attempt blocked - IP: %s, URL: %s, Host: %s, Path: %s",
+			clientIP, imgURL, parsed.Hostname(), path)
+		return nil, fiber.NewError(fiber.StatusForbidden, "URL domain not allowed. Please contact administrator to whitelist the domain.")
+	}
+
+	// Concurrent requests for the same URL share one download
+	value, err := fetchFlights.do(imgURL, func() (interface{}, error) {
+		return downloadImage(imgURL)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]byte), nil
+}
+
+// downloadImage fetches an already validated URL with a timeout and size limit
+func downloadImage(imgURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", imgURL, nil)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Failed to create request for URL.")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Failed to fetch image from URL. Check that the URL is accessible.")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Failed to fetch image from URL. Server returned status: "+resp.Status)
+	}
+
+	imgData, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Failed to read image data from URL.")
+	}
+
+	// Check if we hit the size limit
+	if len(imgData) >= int(maxImageSize) {
+		return nil, fiber.NewError(fiber.StatusRequestEntityTooLarge, "Image from URL exceeds maximum size of 500MB.")
+	}
+
+	return imgData, nil
+}
+
 // RegisterOptimizeRoutes registers the image optimization routes
 func RegisterOptimizeRoutes(app *fiber.App) {
 	// Initialize configuration from environment
 	InitializeConfig()
+	initResultCache()
 
 	app.Post("/optimize", handleOptimize)
 	app.Post("/batch-optimize", handleBatchOptimize)
+	app.Post("/transform", handleTransform)
+	app.Get("/img/:signature/:options/*", handleImageProxy)
+	app.Get("/imgproxy/:signature/*", handleImgproxy)
+	app.Post("/similar", handleSimilar)
+	app.Post("/analyze", handleAnalyze)
+	app.Post("/benchmark", handleBenchmark)
 }
 
-// handleOptimize handles POST /optimize requests
-// @Summary Optimize an image
-// @Description Optimize an image file or URL with custom quality, dimensions, and format
-// @Tags optimization
-// @Accept multipart/form-data
-// @Produce json,image/jpeg,image/png,image/webp,image/gif,image/avif
-// @Param quality query int false "Quality level (1-100)" default(80) minimum(1) maximum(100)
-// @Param width query int false "Target width in pixels (0 = no resize)" default(0) minimum(0)
-// @Param height query int false "Target height in pixels (0 = no resize)" default(0) minimum(0)
-// @Param format query string false "Target format" Enums(jpeg,png,webp,gif,avif)
-// @Param returnImage query bool false "Return optimized image file instead of JSON metadata" default(false)
-// @Param losslessMode query bool false "Enable lossless mode (perfect quality preservation)" default(false)
-// @Param interpolator query string false "Resizing interpolation algorithm" Enums(nearest,bilinear,bicubic,nohalo,vsqbs,lanczos2,lanczos3)
-// @Param image formData file false "Image file to optimize (multipart upload)"
-// @Param url formData string false "Image URL to fetch and optimize (alternative to file upload)"
-// @Success 200 {object} services.OptimizeResult "JSON metadata response (when returnImage=false)"
-// @Success 200 {file} binary "Optimized image file (when returnImage=true)"
-// @Failure 400 {object} map[string]string "Invalid parameters or file"
-// @Failure 403 {object} map[string]string "URL domain not allowed"
-// @Failure 413 {object} map[string]string "File too large (max 10MB)"
-// @Failure 500 {object} map[string]string "Image processing error"
-// @Router /optimize [post]
-func handleOptimize(c *fiber.Ctx) error {
-	// Parse returnImage parameter
-	returnImage := c.QueryBool("returnImage", false)
-
-	// Parse optimization options from query parameters
+// parseOptimizeOptions parses the optimization options shared by /optimize and /batch-optimize
+func parseOptimizeOptions(c *fiber.Ctx) (services.OptimizeOptions, error) {
 	options := services.OptimizeOptions{
 		Quality: 80, // Default quality
 	}
@@ -227,9 +395,7 @@ func handleOptimize(c *fiber.Ctx) error {
 	if qualityStr := c.Query("quality"); qualityStr != "" {
 		quality, err := strconv.Atoi(qualityStr)
 		if err != nil || quality < 1 || quality > 100 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid quality parameter. Must be between 1 and 100.",
-			})
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid quality parameter. Must be between 1 and 100.")
 		}
 		options.Quality = quality
 	}
@@ -238,9 +404,7 @@ func handleOptimize(c *fiber.Ctx) error {
 	if widthStr := c.Query("width"); widthStr != "" {
 		width, err := strconv.Atoi(widthStr)
 		if err != nil || width < 0 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid width parameter. Must be a positive integer.",
-			})
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid width parameter. Must be a positive integer.")
 		}
 		options.Width = width
 	}
@@ -249,9 +413,7 @@ func handleOptimize(c *fiber.Ctx) error {
 	if heightStr := c.Query("height"); heightStr != "" {
 		height, err := strconv.Atoi(heightStr)
 		if err != nil || height < 0 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid height parameter. Must be a positive integer.",
-			})
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid height parameter. Must be a positive integer.")
 		}
 		options.Height = height
 	}
@@ -270,10 +432,32 @@ func handleOptimize(c *fiber.Ctx) error {
 			options.Format = bimg.GIF
 		case "avif":
 			options.Format = bimg.AVIF
+		case "tiff", "tif":
+			options.Format = bimg.TIFF
 		default:
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid format parameter. Supported formats: jpeg, png, webp, gif, avif",
-			})
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid format parameter. Supported formats: jpeg, png, webp, gif, avif, tiff")
+		}
+	}
+
+	// Parse TIFF compression
+	if compression := c.Query("tiffCompression"); compression != "" {
+		normalized, err := services.NormalizeTIFFCompression(compression)
+		if err != nil {
+			return options, fiber.NewError(fiber.StatusBadRequest,
+				"Invalid tiffCompression parameter. Must be one of: lzw, deflate, zip, jpeg, none.")
+		}
+		options.TIFFCompression = normalized
+	}
+
+	// Parse allowOriginalFallback - unset means only when the original is equivalent
+	if fallbackStr := c.Query("allowOriginalFallback"); fallbackStr != "" {
+		allow, err := strconv.ParseBool(fallbackStr)
+		if err != nil {
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid allowOriginalFallback parameter. Must be true or false.")
+		}
+		options.OriginalFallback = services.OriginalFallbackNever
+		if allow {
+			options.OriginalFallback = services.OriginalFallbackAlways
 		}
 	}
 
@@ -285,19 +469,8 @@ func handleOptimize(c *fiber.Ctx) error {
 
 	// Parse interpolator
 	if interpolator := c.Query("interpolator"); interpolator != "" {
-		validInterpolators := map[string]bool{
-			"nearest":  true,
-			"bilinear": true,
-			"bicubic":  true,
-			"nohalo":   true,
-			"vsqbs":    true,
-			"lanczos2": true,
-			"lanczos3": true,
-		}
-		if !validInterpolators[interpolator] {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid interpolator. Supported: nearest, bilinear, bicubic, nohalo, vsqbs, lanczos2, lanczos3",
-			})
+		if !services.IsValidInterpolator(interpolator) {
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid interpolator. Supported: "+strings.Join(services.Interpolators, ", "))
 		}
 		options.Interpolator = interpolator
 	}
@@ -308,18 +481,14 @@ func handleOptimize(c *fiber.Ctx) error {
 	if subsampleStr := c.Query("subsample"); subsampleStr != "" {
 		subsample, err := strconv.Atoi(subsampleStr)
 		if err != nil || subsample < 0 || subsample > 3 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid subsample parameter. Must be between 0 and 3.",
-			})
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid subsample parameter. Must be between 0 and 3.")
 		}
 		options.Subsample = subsample
 	}
 	if smoothStr := c.Query("smooth"); smoothStr != "" {
 		smooth, err := strconv.Atoi(smoothStr)
 		if err != nil || smooth < 0 || smooth > 100 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid smooth parameter. Must be between 0 and 100.",
-			})
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid smooth parameter. Must be between 0 and 100.")
 		}
 		options.Smooth = smooth
 	}
@@ -328,9 +497,7 @@ func handleOptimize(c *fiber.Ctx) error {
 	if compressionStr := c.Query("compression"); compressionStr != "" {
 		compression, err := strconv.Atoi(compressionStr)
 		if err != nil || compression < 0 || compression > 9 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid compression parameter. Must be between 0 and 9.",
-			})
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid compression parameter. Must be between 0 and 9.")
 		}
 		options.Compression = compression
 	}
@@ -339,21 +506,19 @@ func handleOptimize(c *fiber.Ctx) error {
 
 	// Parse advanced WebP options
 	options.Lossless = c.QueryBool("lossless", false)
-	if effortStr := c.Query("effort"); effortStr != "" {
+	if effortStr := c.Query("effort"); strings.EqualFold(effortStr, "max") {
+		options.EffortMax = true
+	} else if effortStr != "" {
 		effort, err := strconv.Atoi(effortStr)
 		if err != nil || effort < 0 || effort > 6 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid effort parameter. Must be between 0 and 6.",
-			})
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid effort parameter. Must be between 0 and 6, or max.")
 		}
 		options.Effort = effort
 	}
 	if webpMethodStr := c.Query("webpMethod"); webpMethodStr != "" {
 		webpMethod, err := strconv.Atoi(webpMethodStr)
 		if err != nil || webpMethod < 0 || webpMethod > 6 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid webpMethod parameter. Must be between 0 and 6.",
-			})
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid webpMethod parameter. Must be between 0 and 6.")
 		}
 		options.WebpMethod = webpMethod
 	}
@@ -362,158 +527,166 @@ func handleOptimize(c *fiber.Ctx) error {
 	if oxipngLevelStr := c.Query("oxipngLevel"); oxipngLevelStr != "" {
 		oxipngLevel, err := strconv.Atoi(oxipngLevelStr)
 		if err != nil || oxipngLevel < 0 || oxipngLevel > 6 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid oxipngLevel parameter. Must be between 0 and 6.",
-			})
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid oxipngLevel parameter. Must be between 0 and 6.")
 		}
 		options.OxipngLevel = oxipngLevel
 	}
 
-	// Get image data - prefer uploaded file, fall back to URL fetch
-	var imgData []byte
-	var err error
-
-	file, err := c.FormFile("image")
-	if err == nil && file != nil {
-		// Handle uploaded file
-		// Validate file type
-		contentType := file.Header.Get("Content-Type")
-		validTypes := map[string]bool{
-			"image/jpeg": true,
-			"image/jpg":  true,
-			"image/png":  true,
-			"image/webp": true,
-			"image/gif":  true,
-			"image/avif": true,
+	// Parse device pixel ratio
+	if dprStr := c.Query("dpr"); dprStr != "" {
+		dpr, err := strconv.ParseFloat(dprStr, 64)
+		if err != nil || dpr < services.MinDPR || dpr > services.MaxDPR {
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid dpr parameter. Must be between 1 and 4.")
 		}
-
-		if !validTypes[contentType] {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid file type. Supported types: jpeg, jpg, png, webp, gif, avif",
-			})
-		}
-
-		// Read file contents
-		f, err := file.Open()
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Failed to open uploaded file.",
-			})
+		options.DPR = dpr
+	}
+	options.DPRQuality = c.QueryBool("dprQuality", false)
+
+	// Parse high bit depth and HDR options
+	options.KeepBitDepth = c.QueryBool("keepBitDepth", false)
+	options.ToneMap = c.QueryBool("toneMap", false)
+
+	// Parse resampling options
+	options.LinearResize = c.QueryBool("linearResize", false)
+	switch sharpenStr := c.Query("sharpen"); sharpenStr {
+	case "", "auto":
+		// Automatic, scale-dependent sharpening after large downscales
+	case "off":
+		options.Sharpen = services.SharpenOff
+	default:
+		sharpen, err := strconv.ParseFloat(sharpenStr, 64)
+		if err != nil || sharpen < 0 || sharpen > services.MaxSharpen {
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid sharpen parameter. Use auto, off or a strength between 0 and 10.")
 		}
-		defer func() {
-			if err := f.Close(); err != nil {
-				log.Printf("warning: failed to close file: %v", err)
-			}
-		}()
-
-		imgData, err = io.ReadAll(io.LimitReader(f, maxImageSize))
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Failed to read uploaded file.",
-			})
+		if sharpen == 0 {
+			options.Sharpen = services.SharpenOff
+		} else {
+			options.Sharpen = sharpen
 		}
+	}
 
-		// Check if we hit the size limit
-		if len(imgData) >= int(maxImageSize) {
-			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
-				"error": "Uploaded file exceeds maximum size of 500MB.",
-			})
+	// Parse border trimming options
+	options.Trim = c.QueryBool("trim", false)
+	options.TrimThreshold = services.DefaultTrimThreshold
+	if thresholdStr := c.Query("trimThreshold"); thresholdStr != "" {
+		threshold, err := strconv.Atoi(thresholdStr)
+		if err != nil || threshold < 0 || threshold > 255 {
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid trimThreshold parameter. Must be between 0 and 255.")
 		}
-	} else {
-		// Try URL-based fetching
-		imgURL := c.FormValue("url")
-		if imgURL == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Must provide either 'image' file or 'url' parameter.",
-			})
+		options.TrimThreshold = threshold
+	}
+	if marginStr := c.Query("trimMargin"); marginStr != "" {
+		margin, err := strconv.Atoi(marginStr)
+		if err != nil || margin < 0 || margin > 10000 {
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid trimMargin parameter. Must be between 0 and 10000.")
 		}
+		options.TrimMargin = margin
+	}
 
-		// Parse and validate URL
-		parsed, err := url.Parse(imgURL)
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid URL format.",
-			})
+	// Parse alpha flattening options (used when the output format has no alpha channel)
+	if background := c.Query("background"); background != "" {
+		if err := services.ValidateBackground(background); err != nil {
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid background parameter. Use a hex color (#rrggbb or #rgb), white, black or auto.")
 		}
-
-		// Check domain whitelist
-		if !isAllowedDomain(parsed) {
-			// SECURITY EVENT: SSRF attempt - blocked domain or private IP
-			log.Printf("[SECURITY] SSRF attempt blocked - IP: %s, URL: %s, Host: %s, Path: %s",
-				c.IP(), imgURL, parsed.Hostname(), c.Path())
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-				"error": "URL domain not allowed. Please contact administrator to whitelist the domain.",
-			})
+		options.Background = background
+	}
+	if alphaCompositing := c.Query("alphaCompositing"); alphaCompositing != "" {
+		if !services.IsValidAlphaCompositing(alphaCompositing) {
+			return options, fiber.NewError(fiber.StatusBadRequest, "Invalid alphaCompositing parameter. Supported: straight, premultiplied")
 		}
+		options.AlphaCompositing = alphaCompositing
+	}
 
-		// Fetch image from URL with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
-		defer cancel()
-
-		req, err := http.NewRequestWithContext(ctx, "GET", imgURL, nil)
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Failed to create request for URL.",
-			})
-		}
+	return options, nil
+}
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Failed to fetch image from URL. Check that the URL is accessible.",
-			})
-		}
-		defer func() { _ = resp.Body.Close() }()
+// handleOptimize handles POST /optimize requests
+// @Summary Optimize an image
+// @Description Optimize an image file or URL with custom quality, dimensions, and format. PDF and TIFF documents are rasterized page by page (WebP unless another format is requested).
+// @Tags optimization
+// @Accept multipart/form-data
+// @Produce json,image/jpeg,image/png,image/webp,image/gif,image/avif,image/tiff,application/zip
+// @Param preset query string false "Named preset (e.g. thumbnail, lossless-archive, social-card) - parameters given in the request override it"
+// @Param quality query int false "Quality level (1-100)" default(80) minimum(1) maximum(100)
+// @Param width query int false "Target width in pixels (0 = no resize)" default(0) minimum(0)
+// @Param height query int false "Target height in pixels (0 = no resize)" default(0) minimum(0)
+// @Param format query string false "Target format" Enums(jpeg,png,webp,gif,avif,tiff)
+// @Param tiffCompression query string false "Compression for TIFF output" Enums(lzw,deflate,zip,jpeg,none) default(lzw)
+// @Param returnImage query bool false "Return optimized image file instead of JSON metadata" default(false)
+// @Param losslessMode query bool false "Enable lossless mode (perfect quality preservation)" default(false)
+// @Param interpolator query string false "Resizing interpolation algorithm" Enums(nearest,bilinear,bicubic,nohalo,vsqbs,lanczos2,lanczos3)
+// @Param effort query string false "WebP/AVIF encoder effort (0-6), or max to try several encoding strategies and keep the smallest that matches the default quality"
+// @Param allowOriginalFallback query bool false "When the result is larger than the original: true returns the original even if requested transforms are skipped, false never returns it; unset returns it only when equivalent"
+// @Param dpr query number false "Device pixel ratio (1-4): multiplies width/height, capped at the source resolution" minimum(1) maximum(4)
+// @Param dprQuality query bool false "Lower quality for high-DPR renditions (2x uses ~85% of quality)" default(false)
+// @Param keepBitDepth query bool false "Keep 16-bit PNG or high-bit-depth AVIF output instead of reducing to 8-bit" default(false)
+// @Param toneMap query bool false "Tone-map HDR (PQ/HLG) sources to SDR" default(false)
+// @Param linearResize query bool false "Resize in linear light (keeps thin high-contrast lines from darkening)" default(false)
+// @Param sharpen query string false "Sharpening after resize: auto (scale-dependent, 2x+ downscales only), off, or a strength from 0 to 10" default(auto)
+// @Param trim query bool false "Trim uniform or transparent borders (border color taken from the top-left pixel)" default(false)
+// @Param trimThreshold query int false "Max per-channel color difference (0-255) still treated as border" default(10) minimum(0) maximum(255)
+// @Param trimMargin query int false "Pixels of border to keep on each side when trimming" default(0) minimum(0)
+// @Param background query string false "Background for flattening transparency when the output has no alpha (JPEG): hex color, white, black or auto (average edge color)" default(#ffffff)
+// @Param alphaCompositing query string false "How transparent pixels are blended onto the background" Enums(straight,premultiplied) default(straight)
+// @Param page query int false "Page to render for PDF and multi-page TIFF input (1-based)" default(1) minimum(1)
+// @Param dpi query int false "Render resolution for PDF pages" default(150) minimum(36) maximum(600)
+// @Param allPages query bool false "Render every page of a PDF/TIFF and return a ZIP of optimized pages" default(false)
+// @Param image formData file false "Image file to optimize (multipart upload)"
+// @Param url formData string false "Image URL to fetch and optimize (alternative to file upload)"
+// @Success 200 {object} services.OptimizeResult "JSON metadata response (when returnImage=false)"
+// @Success 200 {file} binary "Optimized image file (when returnImage=true)"
+// @Failure 400 {object} map[string]string "Invalid parameters or file"
+// @Failure 403 {object} map[string]string "URL domain not allowed"
+// @Failure 413 {object} map[string]string "File too large (max 10MB)"
+// @Failure 500 {object} map[string]string "Image processing error"
+// @Router /optimize [post]
+func handleOptimize(c *fiber.Ctx) error {
+	// Parse returnImage parameter
+	returnImage := c.QueryBool("returnImage", false)
 
-		if resp.StatusCode != http.StatusOK {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Failed to fetch image from URL. Server returned status: " + resp.Status,
-			})
-		}
+	// Fill in parameters from ?preset= that the request does not set itself
+	if err := applyPreset(c); err != nil {
+		return inputErrorResponse(c, err)
+	}
 
-		imgData, err = io.ReadAll(io.LimitReader(resp.Body, maxImageSize))
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Failed to read image data from URL.",
-			})
-		}
+	// Parse optimization options from query parameters
+	options, err := parseOptimizeOptions(c)
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
 
-		// Check if we hit the size limit
-		if len(imgData) >= int(maxImageSize) {
-			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
-				"error": "Image from URL exceeds maximum size of 500MB.",
-			})
-		}
+	// Page selection only applies to documents, but is validated for every request
+	docOptions, allPages, err := parseDocumentOptions(c, options.Background)
+	if err != nil {
+		return inputErrorResponse(c, err)
 	}
 
-	// Validate decoded image size (decompression bomb protection)
-	// This must happen after we have imgData from either file upload or URL fetch
-	filename := "uploaded_image"
-	if file != nil {
-		filename = file.Filename
-	} else if imgURL := c.FormValue("url"); imgURL != "" {
-		filename = imgURL
+	// Get image data - prefer uploaded file, fall back to URL fetch
+	imgData, filename, err := readImageInput(c)
+	if err != nil {
+		return inputErrorResponse(c, err)
 	}
 
-	if err := validateDecodedImageSize(imgData, filename); err != nil {
-		// SECURITY EVENT: Decompression bomb attempt
-		log.Printf("[SECURITY] Decompression bomb attempt - IP: %s, Filename: %s, Size: %d bytes, Error: %v",
-			c.IP(), filename, len(imgData), err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+	// PDFs and multi-page TIFFs are rasterized page by page
+	if isDocumentInput(c, imgData, allPages) {
+		return optimizeDocument(c, imgData, filename, options, docOptions, allPages, returnImage)
 	}
 
-	// Process the image
-	result, err := services.OptimizeImage(imgData, options)
+	// Process the image (or reuse the result of an identical earlier request)
+	result, cacheKey, cacheStatus, err := optimizeCached(imgData, options)
 	if err != nil {
 		return errorResponse(c, fiber.StatusInternalServerError, "Failed to process image", err)
 	}
+	setCacheHeaders(c, cacheKey, cacheStatus)
 
 	// Record metrics for this optimization
 	middleware.RecordOptimizationMetric(c, result.OriginalFormat, result.Format, result.OriginalSize, result.OptimizedSize)
 
-	// Return either the image file or JSON metadata
+	return sendOptimizeResult(c, result, options, returnImage)
+}
+
+// sendOptimizeResult writes the optimized image (returnImage=true) or its JSON metadata
+func sendOptimizeResult(c *fiber.Ctx, result *services.OptimizeResult, options services.OptimizeOptions, returnImage bool) error {
 	if returnImage {
 		// Determine content type from format
 		contentType := "image/webp" // default
@@ -535,6 +708,9 @@ func handleOptimize(c *fiber.Ctx) error {
 			case bimg.AVIF:
 				contentType = "image/avif"
 				formatName = "avif"
+			case bimg.TIFF:
+				contentType = "image/tiff"
+				formatName = "tiff"
 			}
 		} else {
 			// Use the result format if no specific format was requested
@@ -544,6 +720,17 @@ func handleOptimize(c *fiber.Ctx) error {
 
 		c.Type(contentType)
 		c.Set("Content-Disposition", "inline; filename=\"optimized."+formatName+"\"")
+		if result.DPR != nil {
+			c.Set("Content-DPR", strconv.FormatFloat(result.DPR.Effective, 'f', -1, 64))
+		}
+		if result.Trim != nil {
+			c.Set("X-Trim-Offsets", fmt.Sprintf("left=%d, top=%d, right=%d, bottom=%d",
+				result.Trim.Left, result.Trim.Top, result.Trim.Right, result.Trim.Bottom))
+		}
+		if len(result.Warnings) > 0 {
+			// Binary responses have no JSON body to carry warnings
+			c.Set("X-Optimization-Warnings", strings.Join(result.Warnings, "; "))
+		}
 		return c.Send(result.OptimizedImage)
 	}
 
@@ -553,24 +740,45 @@ func handleOptimize(c *fiber.Ctx) error {
 
 // BatchImageResult represents the result of optimizing a single image in a batch
 type BatchImageResult struct {
-	Filename      string `json:"filename"`
-	Success       bool   `json:"success"`
-	Error         string `json:"error,omitempty"`
-	OriginalSize  int64  `json:"originalSize,omitempty"`
-	OptimizedSize int64  `json:"optimizedSize,omitempty"`
-	Format        string `json:"format,omitempty"`
-	Width         int    `json:"width,omitempty"`
-	Height        int    `json:"height,omitempty"`
-	Savings       string `json:"savings,omitempty"`
+	Filename      string                `json:"filename"`
+	Success       bool                  `json:"success"`
+	Error         string                `json:"error,omitempty"`
+	OriginalSize  int64                 `json:"originalSize,omitempty"`
+	OptimizedSize int64                 `json:"optimizedSize,omitempty"`
+	Format        string                `json:"format,omitempty"`
+	Width         int                   `json:"width,omitempty"`
+	Height        int                   `json:"height,omitempty"`
+	Savings       string                `json:"savings,omitempty"`
+	Hashes        *services.ImageHashes `json:"hashes,omitempty"`        // Perceptual hashes (when duplicate detection or includeHashes is enabled)
+	DuplicateOf   string                `json:"duplicateOf,omitempty"`   // Canonical file this image is a near-duplicate of
+	Distance      int                   `json:"distance,omitempty"`      // Hamming distance to the canonical file
+	Skipped       bool                  `json:"skipped,omitempty"`       // True if optimization was skipped because the image is a near-duplicate
+	DPR           *services.DPRInfo     `json:"dpr,omitempty"`           // How the device pixel ratio was applied
+	Trim          *services.TrimInfo    `json:"trim,omitempty"`          // Trimmed offsets (when trim is enabled)
+	Warnings      []string              `json:"warnings,omitempty"`      // Non-fatal issues, e.g. transparency discarded
+	Cache         string                `json:"cache,omitempty"`         // Result cache status (HIT or MISS), when the cache is enabled
+	Output        string                `json:"output,omitempty"`        // Name of the optimized file in zip or multipart responses
+	ManifestEntry string                `json:"manifestEntry,omitempty"` // Manifest pattern whose options were used
+
+	optimized []byte // Optimized image, kept only for zip and multipart responses
+}
+
+// DuplicateGroup lists files whose perceptual hashes are within the similarity threshold
+// The first filename is the canonical image that is always optimized
+type DuplicateGroup struct {
+	Canonical  string   `json:"canonical"`
+	Duplicates []string `json:"duplicates"`
 }
 
 // BatchOptimizeResponse represents the complete batch optimization response
 type BatchOptimizeResponse struct {
-	Results []BatchImageResult `json:"results"`
-	Summary struct {
+	Results         []BatchImageResult `json:"results"`
+	DuplicateGroups []DuplicateGroup   `json:"duplicateGroups,omitempty"`
+	Summary         struct {
 		Total              int    `json:"total"`
 		Successful         int    `json:"successful"`
 		Failed             int    `json:"failed"`
+		Skipped            int    `json:"skipped,omitempty"`
 		TotalOriginalSize  int64  `json:"totalOriginalSize"`
 		TotalOptimizedSize int64  `json:"totalOptimizedSize"`
 		TotalSavings       string `json:"totalSavings"`
@@ -578,36 +786,83 @@ type BatchOptimizeResponse struct {
 	} `json:"summary"`
 }
 
-// processSingleImage processes a single image file and returns the result
-// This is extracted for use in parallel batch processing
-func processSingleImage(file *multipart.FileHeader, options services.OptimizeOptions) BatchImageResult {
-	result := BatchImageResult{
-		Filename: file.Filename,
+// batchSource is one input of a batch: an uploaded file or a URL to fetch
+type batchSource struct {
+	name         string // Reported as the result's filename: the upload's file name or the URL
+	relativeName string // Matched against the manifest and used for archive entries
+	file         *multipart.FileHeader
+	url          string
+}
+
+// batchImage holds a loaded batch file between the validation and optimization phases
+type batchImage struct {
+	data   []byte
+	hashes *services.ImageHashes
+	err    error
+}
+
+// Batch response formats: JSON results only, or the optimized files plus a manifest
+const (
+	batchOutputJSON      = "json"
+	batchOutputZip       = "zip"
+	batchOutputMultipart = "multipart"
+)
+
+// batchManifestName is the name of the JSON results in zip and multipart batch responses
+const batchManifestName = "manifest.json"
+
+// batchWorkers is the number of images processed concurrently in a batch
+// 4 workers gives good CPU utilization without overwhelming the system
+const batchWorkers = 4
+
+// runBatchWorkers calls fn for every index in [0, n) using a bounded worker pool
+func runBatchWorkers(n int, fn func(i int)) {
+	numWorkers := batchWorkers
+	if n < numWorkers {
+		numWorkers = n
 	}
 
-	// Validate file type
-	contentType := file.Header.Get("Content-Type")
-	validTypes := map[string]bool{
-		"image/jpeg": true,
-		"image/jpg":  true,
-		"image/png":  true,
-		"image/webp": true,
-		"image/gif":  true,
-		"image/avif": true,
+	jobs := make(chan int, n)
+	var wg sync.WaitGroup
+
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
 	}
 
-	if !validTypes[contentType] {
-		result.Success = false
-		result.Error = "Invalid file type. Supported types: jpeg, jpg, png, webp, gif, avif"
-		return result
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// loadBatchSource loads and validates one batch file, reading the upload or
+// fetching the URL
+func loadBatchSource(fetcher *batchFetcher, source batchSource) ([]byte, error) {
+	if source.file != nil {
+		return loadBatchImage(source.file)
+	}
+	return fetcher.fetch(source.url)
+}
+
+// loadBatchImage reads and validates a single uploaded batch file
+func loadBatchImage(file *multipart.FileHeader) ([]byte, error) {
+	// Validate file type
+	contentType := file.Header.Get("Content-Type")
+	if err := checkUploadType(contentType, false); err != nil {
+		return nil, err
 	}
 
 	// Read file contents
 	f, err := file.Open()
 	if err != nil {
-		result.Success = false
-		result.Error = "Failed to open file"
-		return result
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Failed to open file")
 	}
 
 	imgData, err := io.ReadAll(io.LimitReader(f, maxImageSize))
@@ -616,16 +871,18 @@ func processSingleImage(file *multipart.FileHeader, options services.OptimizeOpt
 	}
 
 	if err != nil {
-		result.Success = false
-		result.Error = "Failed to read file"
-		return result
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Failed to read file")
 	}
 
 	// Check size limit
 	if len(imgData) >= int(maxImageSize) {
-		result.Success = false
-		result.Error = "File exceeds maximum size of 500MB"
-		return result
+		return nil, fiber.NewError(fiber.StatusRequestEntityTooLarge, "File exceeds maximum size of 500MB")
+	}
+
+	if err := checkImageSignature(imgData, contentType, false); err != nil {
+		log.Printf("[SECURITY] File signature mismatch in batch - Filename: %s, Declared type: %q, Error: %v",
+			file.Filename, contentType, err)
+		return nil, err
 	}
 
 	// Validate decoded image size (decompression bomb protection)
@@ -633,13 +890,22 @@ func processSingleImage(file *multipart.FileHeader, options services.OptimizeOpt
 		// SECURITY EVENT: Decompression bomb in batch processing
 		log.Printf("[SECURITY] Decompression bomb in batch - Filename: %s, Size: %d bytes, Error: %v",
 			file.Filename, len(imgData), err)
-		result.Success = false
-		result.Error = err.Error()
-		return result
+		return nil, err
+	}
+
+	return imgData, nil
+}
+
+// processSingleImage optimizes a single loaded image and returns the result
+// This is extracted for use in parallel batch processing
+func processSingleImage(filename string, imgData []byte, options services.OptimizeOptions) BatchImageResult {
+	result := BatchImageResult{
+		Filename: filename,
 	}
 
 	// Process the image
-	optimizeResult, err := services.OptimizeImage(imgData, options)
+	optimizeResult, _, cacheStatus, err := optimizeCached(imgData, options)
+	result.Cache = cacheStatus
 	if err != nil {
 		result.Success = false
 		result.Error = "Failed to process image: " + err.Error()
@@ -654,22 +920,68 @@ func processSingleImage(file *multipart.FileHeader, options services.OptimizeOpt
 	result.Width = optimizeResult.Width
 	result.Height = optimizeResult.Height
 	result.Savings = optimizeResult.Savings
+	result.DPR = optimizeResult.DPR
+	result.Trim = optimizeResult.Trim
+	result.Warnings = optimizeResult.Warnings
+	result.optimized = optimizeResult.OptimizedImage
 
 	return result
 }
 
+// parseSimilarityOptions parses the hashAlgorithm and similarityThreshold query parameters
+func parseSimilarityOptions(c *fiber.Ctx) (string, int, error) {
+	algorithm := strings.ToLower(c.Query("hashAlgorithm", services.DefaultHashAlgorithm))
+	if !services.IsValidHashAlgorithm(algorithm) {
+		return "", 0, fiber.NewError(fiber.StatusBadRequest, "Invalid hashAlgorithm parameter. Supported: ahash, dhash, phash")
+	}
+
+	threshold := services.DefaultSimilarityThreshold
+	if thresholdStr := c.Query("similarityThreshold"); thresholdStr != "" {
+		parsed, err := strconv.Atoi(thresholdStr)
+		if err != nil || parsed < 0 || parsed > 64 {
+			return "", 0, fiber.NewError(fiber.StatusBadRequest, "Invalid similarityThreshold parameter. Must be between 0 and 64.")
+		}
+		threshold = parsed
+	}
+
+	return algorithm, threshold, nil
+}
+
 // handleBatchOptimize handles POST /batch-optimize requests
 // @Summary Optimize multiple images in a single request
-// @Description Optimize multiple image files with the same quality, dimensions, and format settings
+// @Description Optimize multiple uploaded image files and/or image URLs with the same quality, dimensions, and format settings
 // @Tags optimization
 // @Accept multipart/form-data
-// @Produce json
+// @Produce json,application/zip,multipart/mixed
+// @Param preset query string false "Named preset (e.g. thumbnail, lossless-archive, social-card) - parameters given in the request override it"
 // @Param quality query int false "Quality level (1-100)" default(80) minimum(1) maximum(100)
 // @Param width query int false "Target width in pixels (0 = no resize)" default(0) minimum(0)
 // @Param height query int false "Target height in pixels (0 = no resize)" default(0) minimum(0)
-// @Param format query string false "Target format" Enums(jpeg,png,webp,gif,avif)
-// @Param images formData file true "Image files to optimize (multiple files)"
-// @Success 200 {object} BatchOptimizeResponse "Batch optimization results"
+// @Param format query string false "Target format" Enums(jpeg,png,webp,gif,avif,tiff)
+// @Param output query string false "Response format: json (results only), or zip / multipart (manifest.json plus the optimized files under their original relative names)" Enums(json,zip,multipart) default(json)
+// @Param tiffCompression query string false "Compression for TIFF output" Enums(lzw,deflate,zip,jpeg,none) default(lzw)
+// @Param effort query string false "WebP/AVIF encoder effort (0-6), or max to try several encoding strategies and keep the smallest that matches the default quality"
+// @Param allowOriginalFallback query bool false "When the result is larger than the original: true returns the original even if requested transforms are skipped, false never returns it; unset returns it only when equivalent"
+// @Param dpr query number false "Device pixel ratio (1-4): multiplies width/height, capped at the source resolution" minimum(1) maximum(4)
+// @Param dprQuality query bool false "Lower quality for high-DPR renditions (2x uses ~85% of quality)" default(false)
+// @Param keepBitDepth query bool false "Keep 16-bit PNG or high-bit-depth AVIF output instead of reducing to 8-bit" default(false)
+// @Param toneMap query bool false "Tone-map HDR (PQ/HLG) sources to SDR" default(false)
+// @Param linearResize query bool false "Resize in linear light (keeps thin high-contrast lines from darkening)" default(false)
+// @Param sharpen query string false "Sharpening after resize: auto (scale-dependent, 2x+ downscales only), off, or a strength from 0 to 10" default(auto)
+// @Param trim query bool false "Trim uniform or transparent borders (border color taken from the top-left pixel)" default(false)
+// @Param trimThreshold query int false "Max per-channel color difference (0-255) still treated as border" default(10) minimum(0) maximum(255)
+// @Param trimMargin query int false "Pixels of border to keep on each side when trimming" default(0) minimum(0)
+// @Param background query string false "Background for flattening transparency when the output has no alpha (JPEG): hex color, white, black or auto (average edge color)" default(#ffffff)
+// @Param alphaCompositing query string false "How transparent pixels are blended onto the background" Enums(straight,premultiplied) default(straight)
+// @Param detectDuplicates query bool false "Group near-duplicate images by perceptual hash" default(false)
+// @Param skipDuplicates query bool false "Skip optimizing near-duplicates (implies detectDuplicates)" default(false)
+// @Param includeHashes query bool false "Include perceptual hashes in results without grouping" default(false)
+// @Param hashAlgorithm query string false "Perceptual hash used for grouping" Enums(ahash,dhash,phash) default(phash)
+// @Param similarityThreshold query int false "Maximum Hamming distance (0-64) for near-duplicates" default(10) minimum(0) maximum(64)
+// @Param images formData file false "Image files to optimize (multiple files)"
+// @Param urls formData string false "JSON array of image URLs to fetch and optimize, e.g. [\"https://cdn.example.com/a.jpg\"]; per-URL fetch errors are reported in the results"
+// @Param manifest formData string false "Per-file options: JSON object mapping file names or globs to parameters and an optional preset, e.g. {\"*.png\":{\"preset\":\"lossless-archive\"},\"photos/*\":{\"format\":\"webp\",\"quality\":75}}; unset parameters fall back to the query string"
+// @Success 200 {object} BatchOptimizeResponse "Batch optimization results (output=json)"
 // @Failure 400 {object} map[string]string "Invalid parameters or no files provided"
 // @Failure 500 {object} map[string]string "Batch processing error"
 // @Router /batch-optimize [post]
@@ -677,131 +989,30 @@ func handleBatchOptimize(c *fiber.Ctx) error {
 	startTime := time.Now()
 
 	// Parse optimization options from query parameters (same as single optimize)
-	options := services.OptimizeOptions{
-		Quality: 80, // Default quality
+	if err := applyPreset(c); err != nil {
+		return inputErrorResponse(c, err)
 	}
-
-	// Parse quality
-	if qualityStr := c.Query("quality"); qualityStr != "" {
-		quality, err := strconv.Atoi(qualityStr)
-		if err != nil || quality < 1 || quality > 100 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid quality parameter. Must be between 1 and 100.",
-			})
-		}
-		options.Quality = quality
-	}
-
-	// Parse width
-	if widthStr := c.Query("width"); widthStr != "" {
-		width, err := strconv.Atoi(widthStr)
-		if err != nil || width < 0 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid width parameter. Must be a positive integer.",
-			})
-		}
-		options.Width = width
-	}
-
-	// Parse height
-	if heightStr := c.Query("height"); heightStr != "" {
-		height, err := strconv.Atoi(heightStr)
-		if err != nil || height < 0 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid height parameter. Must be a positive integer.",
-			})
-		}
-		options.Height = height
-	}
-
-	// Parse format
-	if formatStr := c.Query("format"); formatStr != "" {
-		formatStr = strings.ToLower(formatStr)
-		switch formatStr {
-		case "jpeg", "jpg":
-			options.Format = bimg.JPEG
-		case "png":
-			options.Format = bimg.PNG
-		case "webp":
-			options.Format = bimg.WEBP
-		case "gif":
-			options.Format = bimg.GIF
-		case "avif":
-			options.Format = bimg.AVIF
-		default:
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid format parameter. Supported formats: jpeg, png, webp, gif, avif",
-			})
-		}
-	}
-
-	// Parse advanced options (same as single optimize)
-	options.ForceSRGB = c.QueryBool("forceSRGB", false)
-	options.Progressive = c.QueryBool("progressive", false)
-	options.OptimizeCoding = c.QueryBool("optimizeCoding", false)
-
-	if subsampleStr := c.Query("subsample"); subsampleStr != "" {
-		subsample, err := strconv.Atoi(subsampleStr)
-		if err != nil || subsample < 0 || subsample > 3 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid subsample parameter. Must be between 0 and 3.",
-			})
-		}
-		options.Subsample = subsample
-	}
-
-	if smoothStr := c.Query("smooth"); smoothStr != "" {
-		smooth, err := strconv.Atoi(smoothStr)
-		if err != nil || smooth < 0 || smooth > 100 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid smooth parameter. Must be between 0 and 100.",
-			})
-		}
-		options.Smooth = smooth
-	}
-
-	if compressionStr := c.Query("compression"); compressionStr != "" {
-		compression, err := strconv.Atoi(compressionStr)
-		if err != nil || compression < 0 || compression > 9 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid compression parameter. Must be between 0 and 9.",
-			})
-		}
-		options.Compression = compression
-	}
-
-	options.Interlace = c.QueryBool("interlace", false)
-	options.Palette = c.QueryBool("palette", false)
-	options.Lossless = c.QueryBool("lossless", false)
-
-	if effortStr := c.Query("effort"); effortStr != "" {
-		effort, err := strconv.Atoi(effortStr)
-		if err != nil || effort < 0 || effort > 6 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid effort parameter. Must be between 0 and 6.",
-			})
-		}
-		options.Effort = effort
+	options, err := parseOptimizeOptions(c)
+	if err != nil {
+		return inputErrorResponse(c, err)
 	}
 
-	if webpMethodStr := c.Query("webpMethod"); webpMethodStr != "" {
-		webpMethod, err := strconv.Atoi(webpMethodStr)
-		if err != nil || webpMethod < 0 || webpMethod > 6 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid webpMethod parameter. Must be between 0 and 6.",
-			})
-		}
-		options.WebpMethod = webpMethod
+	output := strings.ToLower(c.Query("output", batchOutputJSON))
+	if output != batchOutputJSON && output != batchOutputZip && output != batchOutputMultipart {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid output parameter. Supported: json, zip, multipart",
+		})
 	}
 
-	if oxipngLevelStr := c.Query("oxipngLevel"); oxipngLevelStr != "" {
-		oxipngLevel, err := strconv.Atoi(oxipngLevelStr)
-		if err != nil || oxipngLevel < 0 || oxipngLevel > 6 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid oxipngLevel parameter. Must be between 0 and 6.",
-			})
-		}
-		options.OxipngLevel = oxipngLevel
+	// Parse near-duplicate detection options
+	includeHashes := c.QueryBool("includeHashes", false)
+	skipDuplicates := c.QueryBool("skipDuplicates", false)
+	detectDuplicates := c.QueryBool("detectDuplicates", false) || skipDuplicates
+	hashAlgorithm, similarityThreshold, err := parseSimilarityOptions(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
 	}
 
 	// Get multipart form
@@ -812,76 +1023,165 @@ func handleBatchOptimize(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get all uploaded files
-	files := form.File["images"]
-	if len(files) == 0 {
+	// Collect the uploaded files and the URLs to fetch
+	sources := make([]batchSource, 0, len(form.File["images"]))
+	for _, file := range form.File["images"] {
+		sources = append(sources, batchSource{name: file.Filename, relativeName: batchRelativeName(file), file: file})
+	}
+	if values := form.Value[batchURLsField]; len(values) > 0 {
+		urls, err := parseBatchURLs(values[0])
+		if err != nil {
+			return inputErrorResponse(c, err)
+		}
+		for _, imgURL := range urls {
+			sources = append(sources, batchSource{name: imgURL, relativeName: batchURLName(imgURL), url: imgURL})
+		}
+	}
+	if len(sources) == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "No images provided. Use 'images' field name for file uploads.",
+			"error": "No images provided. Use 'images' field name for file uploads, or 'urls' for a JSON list of image URLs.",
 		})
 	}
 
+	// Resolve per-file options from the manifest before any file is processed
+	fileOptions := make([]services.OptimizeOptions, len(sources))
+	fileEntries := make([]string, len(sources))
+	for i := range sources {
+		fileOptions[i] = options
+	}
+	if manifest := c.FormValue(batchManifestField); manifest != "" {
+		entries, err := parseBatchManifest(c, manifest)
+		if err != nil {
+			return inputErrorResponse(c, err)
+		}
+		used := make([]bool, len(entries))
+		for i, source := range sources {
+			if match := matchBatchManifest(entries, source.relativeName); match >= 0 {
+				fileOptions[i] = entries[match].Options
+				fileEntries[i] = entries[match].Pattern
+				used[match] = true
+			}
+		}
+		for i, entry := range entries {
+			if !used[i] {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Sprintf("Manifest entry %q matches no uploaded file", entry.Pattern),
+				})
+			}
+		}
+	}
+
 	// Initialize response
 	response := BatchOptimizeResponse{
-		Results: make([]BatchImageResult, len(files)),
+		Results: make([]BatchImageResult, len(sources)),
 	}
 
 	var totalOriginalSize int64
 	var totalOptimizedSize int64
 
-	// Parallel processing with worker pool
-	// Use 4 workers for optimal CPU utilization without overwhelming the system
-	numWorkers := 4
-	if len(files) < numWorkers {
-		numWorkers = len(files)
-	}
+	// Phase 1: when hashes are needed, load, validate and hash every file in parallel
+	// Hashing happens before any encoding so near-duplicates can be skipped entirely
+	// Otherwise each file is loaded by the worker that optimizes it, so at most one
+	// file per worker is held in memory
+	computeHashes := detectDuplicates || includeHashes
+	fetcher := newBatchFetcher(c)
+	images := make([]batchImage, len(sources))
+	if computeHashes {
+		runBatchWorkers(len(sources), func(i int) {
+			data, err := loadBatchSource(fetcher, sources[i])
+			images[i] = batchImage{data: data, err: err}
+			if err != nil {
+				return
+			}
 
-	type job struct {
-		index int
-		file  *multipart.FileHeader
+			// Hash failures are not fatal - the optimization step reports decode errors itself
+			hashes, err := services.ComputePerceptualHashes(data)
+			if err == nil {
+				images[i].hashes = hashes
+			}
+		})
 	}
 
-	jobs := make(chan job, len(files))
-	results := make(chan struct {
-		index  int
-		result BatchImageResult
-	}, len(files))
+	// Phase 2: group near-duplicates by Hamming distance
+	skip := make([]bool, len(sources))
+	if detectDuplicates {
+		hashedIndexes := make([]int, 0, len(sources))
+		hashes := make([]services.ImageHashes, 0, len(sources))
+		for i, img := range images {
+			if img.hashes != nil {
+				hashedIndexes = append(hashedIndexes, i)
+				hashes = append(hashes, *img.hashes)
+			}
+		}
 
-	// Start workers
-	for w := 0; w < numWorkers; w++ {
-		go func() {
-			for j := range jobs {
-				result := processSingleImage(j.file, options)
-				results <- struct {
-					index  int
-					result BatchImageResult
-				}{j.index, result}
+		for _, group := range services.GroupNearDuplicates(hashes, hashAlgorithm, similarityThreshold) {
+			canonical := hashedIndexes[group[0]]
+			duplicateGroup := DuplicateGroup{Canonical: sources[canonical].name}
+
+			for _, member := range group[1:] {
+				index := hashedIndexes[member]
+				duplicateGroup.Duplicates = append(duplicateGroup.Duplicates, sources[index].name)
+				response.Results[index].DuplicateOf = sources[canonical].name
+				response.Results[index].Distance = hashes[group[0]].Distance(hashes[member], hashAlgorithm)
+				skip[index] = skipDuplicates
 			}
-		}()
-	}
 
-	// Send jobs
-	for i, file := range files {
-		jobs <- job{index: i, file: file}
+			response.DuplicateGroups = append(response.DuplicateGroups, duplicateGroup)
+		}
 	}
-	close(jobs)
+
+	// Phase 3: optimize every valid, non-skipped image in parallel
+	var processed atomic.Int64
+	runBatchWorkers(len(sources), func(i int) {
+		defer func() { reportJobProgress(c, int(processed.Add(1)), len(sources)) }()
+		if images[i].err != nil || skip[i] {
+			return
+		}
+		data := images[i].data
+		images[i].data = nil
+		if !computeHashes {
+			if data, images[i].err = loadBatchSource(fetcher, sources[i]); images[i].err != nil {
+				return
+			}
+		}
+		result := processSingleImage(sources[i].name, data, fileOptions[i])
+		result.ManifestEntry = fileEntries[i]
+		if output == batchOutputJSON {
+			result.optimized = nil
+		}
+		result.DuplicateOf = response.Results[i].DuplicateOf
+		result.Distance = response.Results[i].Distance
+		response.Results[i] = result
+	})
 
 	// Collect results
-	for i := 0; i < len(files); i++ {
-		r := <-results
-		response.Results[r.index] = r.result
+	for i, img := range images {
+		result := &response.Results[i]
+		result.Filename = sources[i].name
+		if computeHashes {
+			result.Hashes = img.hashes
+		}
 
-		if r.result.Success {
-			totalOriginalSize += r.result.OriginalSize
-			totalOptimizedSize += r.result.OptimizedSize
+		switch {
+		case img.err != nil:
+			result.Success = false
+			result.Error = img.err.Error()
+			response.Summary.Failed++
+		case skip[i]:
+			result.Success = true
+			result.Skipped = true
+			response.Summary.Skipped++
+		case result.Success:
+			totalOriginalSize += result.OriginalSize
+			totalOptimizedSize += result.OptimizedSize
 			response.Summary.Successful++
-		} else {
+		default:
 			response.Summary.Failed++
 		}
 	}
-	close(results)
 
 	// Calculate summary statistics
-	response.Summary.Total = len(files)
+	response.Summary.Total = len(sources)
 	response.Summary.TotalOriginalSize = totalOriginalSize
 	response.Summary.TotalOptimizedSize = totalOptimizedSize
 
@@ -894,5 +1194,65 @@ func handleBatchOptimize(c *fiber.Ctx) error {
 
 	response.Summary.ProcessingTime = fmt.Sprintf("%dms", time.Since(startTime).Milliseconds())
 
+	if output != batchOutputJSON {
+		return sendBatchArchive(c, output, sources, &response)
+	}
 	return c.JSON(response)
 }
+
+// sendBatchArchive returns manifest.json and the optimized files as a ZIP archive
+// or multipart/mixed body. Failed and skipped files are left out; their results
+// are still in the manifest.
+func sendBatchArchive(c *fiber.Ctx, output string, sources []batchSource, response *BatchOptimizeResponse) error {
+	used := map[string]bool{batchManifestName: true}
+	archive := []archiveFile{{Name: batchManifestName, ContentType: fiber.MIMEApplicationJSON, Compress: true}}
+	for i := range response.Results {
+		result := &response.Results[i]
+		if !result.Success || result.optimized == nil {
+			continue
+		}
+		result.Output = batchOutputName(sources[i].relativeName, result.Format, used)
+		archive = append(archive, archiveFile{Name: result.Output, ContentType: "image/" + result.Format, Data: result.optimized})
+		result.optimized = nil // The archive releases each file once it is written
+	}
+
+	manifest, err := json.Marshal(response)
+	if err != nil {
+		return errorResponse(c, fiber.StatusInternalServerError, "Failed to encode batch manifest", err)
+	}
+	archive[0].Data = manifest
+
+	if output == batchOutputMultipart {
+		return sendMultipartArchive(c, archive)
+	}
+	return sendZipArchive(c, "optimized.zip", archive)
+}
+
+// batchRelativeName returns an uploaded file's name including the directories the
+// client sent (e.g. from a folder upload). mime/multipart strips them from
+// FileHeader.Filename, so the name is read from the raw Content-Disposition.
+// ".." elements and leading slashes are removed so names stay inside the archive.
+func batchRelativeName(file *multipart.FileHeader) string {
+	name := file.Filename
+	if _, params, err := mime.ParseMediaType(file.Header.Get(fiber.HeaderContentDisposition)); err == nil && params["filename"] != "" {
+		name = params["filename"]
+	}
+	name = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+	if name == "" {
+		return "image"
+	}
+	return name
+}
+
+// batchOutputName replaces a file name's extension with the output format's and
+// appends -2, -3, ... when the name is already used (e.g. a.png and a.jpg both
+// converted to WebP)
+func batchOutputName(name, format string, used map[string]bool) string {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	candidate := base + "." + format
+	for n := 2; used[strings.ToLower(candidate)]; n++ {
+		candidate = fmt.Sprintf("%s-%d.%s", base, n, format)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
@@ -1,15 +1,27 @@
 package routes
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/json"
+	"image"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/h2non/bimg"
+	"github.com/keif/image-optimizer/db"
+	"github.com/keif/image-optimizer/middleware"
+	"github.com/keif/image-optimizer/services"
+	"golang.org/x/image/bmp"
 )
 
 // loadTestFixture loads a test image fixture
@@ -577,3 +589,974 @@ func TestBatchOptimizeEndpoint_MixedResults(t *testing.T) {
 		t.Fatalf("Expected status 200, got %d. Body: %s", resp.StatusCode, string(body))
 	}
 }
+
+// retargetRequest points a prepared request at a different path and query string
+// httptest.NewRequest sets RequestURI, which takes precedence over URL when the request is dumped
+func retargetRequest(req *http.Request, target string) {
+	req.URL, _ = url.Parse(target)
+	req.RequestURI = target
+}
+
+func TestBatchOptimizeEndpoint_InvalidSimilarityThreshold(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	images := []struct {
+		data        []byte
+		filename    string
+		contentType string
+	}{
+		{loadTestFixture(t, "test-100x100.jpg"), "test1.jpg", "image/jpeg"},
+	}
+
+	req, _ := createBatchMultipartRequest(t, images)
+	retargetRequest(req, "/batch-optimize?detectDuplicates=true&similarityThreshold=65")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestBatchOptimizeEndpoint_ArchiveOutput(t *testing.T) {
+	// Registering the routes initializes the result cache, so replace it afterwards
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	cache, err := services.NewResultCache(services.ResultCacheConfig{Dir: t.TempDir(), MaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("Failed to create result cache: %v", err)
+	}
+	resultCache = cache
+	defer func() { resultCache = nil }()
+
+	// Optimized results are served from the cache, so no encoder is needed
+	// BMP dimensions are read from the header, so loading needs no decoder either
+	query := "width=4&format=webp"
+	var options services.OptimizeOptions
+	optionsApp := fiber.New()
+	optionsApp.Get("/", func(c *fiber.Ctx) error {
+		options, err = parseOptimizeOptions(c)
+		return err
+	})
+	if resp, err := optionsApp.Test(httptest.NewRequest("GET", "/?"+query, nil)); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to parse options: %v", err)
+	}
+
+	images := []struct {
+		data        []byte
+		filename    string
+		contentType string
+	}{
+		{nil, "photos/beach.bmp", "image/bmp"},
+		{[]byte("invalid image data"), "photos/broken.bmp", "image/bmp"},
+		{nil, "../logos/beach.bmp", "image/bmp"},
+		{nil, "photos/beach.jpg", "image/bmp"},
+	}
+	for i, size := range map[int]int{0: 8, 2: 9, 3: 10} {
+		var encoded bytes.Buffer
+		if err := bmp.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatalf("Failed to encode BMP: %v", err)
+		}
+		images[i].data = encoded.Bytes()
+		key := services.ResultCacheKey(images[i].data, options)
+		result := &services.OptimizeResult{Format: "webp", OptimizedImage: []byte(images[i].filename)}
+		if err := cache.Put(key, result); err != nil {
+			t.Fatalf("Failed to populate cache: %v", err)
+		}
+	}
+
+	// Optimized file name (from the result) -> expected name in the response
+	expected := map[string]string{
+		"photos/beach.bmp":   "photos/beach.webp",
+		"../logos/beach.bmp": "logos/beach.webp",
+		"photos/beach.jpg":   "photos/beach-2.webp",
+	}
+	checkManifest := func(t *testing.T, data []byte) {
+		t.Helper()
+		var manifest BatchOptimizeResponse
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			t.Fatalf("Failed to decode manifest: %v", err)
+		}
+		if manifest.Summary.Successful != 3 || manifest.Summary.Failed != 1 {
+			t.Errorf("Expected 3 successful and 1 failed file, got %+v", manifest.Summary)
+		}
+		if manifest.Results[1].Success || manifest.Results[1].Output != "" {
+			t.Errorf("Expected the broken file to fail without an output, got %+v", manifest.Results[1])
+		}
+		if got := manifest.Results[3].Output; got != "photos/beach-2.webp" {
+			t.Errorf("Expected output photos/beach-2.webp, got %q", got)
+		}
+	}
+
+	send := func(t *testing.T, output string) *http.Response {
+		t.Helper()
+		req, _ := createBatchMultipartRequest(t, images)
+		retargetRequest(req, "/batch-optimize?output="+output+"&"+query)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			t.Fatalf("Expected status 200, got %d. Body: %s", resp.StatusCode, string(body))
+		}
+		return resp
+	}
+
+	t.Run("zip", func(t *testing.T) {
+		resp := send(t, "zip")
+		body, _ := io.ReadAll(resp.Body)
+		archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("Failed to read ZIP: %v", err)
+		}
+
+		names := make([]string, 0, len(archive.File))
+		for _, file := range archive.File {
+			names = append(names, file.Name)
+			reader, err := file.Open()
+			if err != nil {
+				t.Fatalf("Failed to open %s: %v", file.Name, err)
+			}
+			data, _ := io.ReadAll(reader)
+			_ = reader.Close()
+
+			if file.Name == batchManifestName {
+				checkManifest(t, data)
+				continue
+			}
+			if want := expected[string(data)]; want != file.Name {
+				t.Errorf("Expected %s to be named %q, got %q", data, want, file.Name)
+			}
+		}
+		if len(names) != 4 || names[0] != batchManifestName {
+			t.Errorf("Expected manifest.json and 3 files, got %v", names)
+		}
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		resp := send(t, "multipart")
+		mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/mixed" {
+			t.Fatalf("Expected multipart/mixed, got %q", resp.Header.Get("Content-Type"))
+		}
+
+		reader := multipart.NewReader(resp.Body, params["boundary"])
+		var parts int
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to read part: %v", err)
+			}
+			data, _ := io.ReadAll(part)
+			_, disposition, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+			if parts == 0 {
+				checkManifest(t, data)
+			} else if want := expected[string(data)]; want != disposition["filename"] {
+				t.Errorf("Expected %s to be named %q, got %q", data, want, disposition["filename"])
+			}
+			parts++
+		}
+		if parts != 4 {
+			t.Errorf("Expected 4 parts, got %d", parts)
+		}
+	})
+
+	t.Run("invalid output", func(t *testing.T) {
+		req, _ := createBatchMultipartRequest(t, images)
+		retargetRequest(req, "/batch-optimize?output=tar")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestSimilarEndpoint_MissingCandidates(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	imageData := loadTestFixture(t, "test-100x100.jpg")
+	req, _ := createMultipartRequest(t, imageData, "reference.jpg")
+	retargetRequest(req, "/similar")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", resp.StatusCode)
+	}
+	if !bytes.Contains(body, []byte("candidate")) {
+		t.Errorf("Expected error about missing candidates, got %s", string(body))
+	}
+}
+
+func TestSimilarEndpoint_InvalidAlgorithm(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	imageData := loadTestFixture(t, "test-100x100.jpg")
+	req, _ := createMultipartRequest(t, imageData, "reference.jpg")
+	retargetRequest(req, "/similar?hashAlgorithm=md5")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", resp.StatusCode)
+	}
+	if !bytes.Contains(body, []byte("hashAlgorithm")) {
+		t.Errorf("Expected error about hashAlgorithm, got %s", string(body))
+	}
+}
+
+func TestAnalyzeEndpoint_InvalidQualities(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	imageData := loadTestFixture(t, "test-100x100.jpg")
+	req, _ := createMultipartRequest(t, imageData, "test.jpg")
+	retargetRequest(req, "/analyze?qualities=80,150")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestBenchmarkEndpoint_InvalidParameters(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+	imageData := loadTestFixture(t, "test-100x100.jpg")
+
+	// Benchmarks flush the shared libvips cache, so they are admin-only
+	_ = os.Unsetenv("API_KEY_AUTH_ENABLED")
+	req, _ := createMultipartRequest(t, imageData, "test.jpg")
+	retargetRequest(req, "/benchmark")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status 403 without an admin key, got %d", resp.StatusCode)
+	}
+
+	// With API key auth disabled every caller is an admin
+	_ = os.Setenv("API_KEY_AUTH_ENABLED", "false")
+	defer func() { _ = os.Unsetenv("API_KEY_AUTH_ENABLED") }()
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"effort out of range", "efforts=7"},
+		{"unknown format", "formats=bmp"},
+		{"unknown output", "output=xml"},
+		{"matrix too large", "formats=webp,avif&qualities=10,20,30,40,50,60,70,80&efforts=0,1,2,3,4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := createMultipartRequest(t, imageData, "test.jpg")
+			retargetRequest(req, "/benchmark?"+tt.query)
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestOptimizeEndpoint_InvalidAlphaOptions(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"invalid background", "format=jpeg&background=notacolor"},
+		{"invalid alphaCompositing", "format=jpeg&alphaCompositing=multiply"},
+	}
+
+	imageData := loadTestFixture(t, "test-100x100.jpg")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := createMultipartRequest(t, imageData, "test.jpg")
+			retargetRequest(req, "/optimize?"+tt.query)
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestOptimizeEndpoint_InvalidTrimOptions(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	for _, query := range []string{"trim=true&trimThreshold=300", "trim=true&trimMargin=-1"} {
+		imageData := loadTestFixture(t, "test-100x100.jpg")
+		req, _ := createMultipartRequest(t, imageData, "test.jpg")
+		retargetRequest(req, "/optimize?"+query)
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestOptimizeEndpoint_InvalidDPR(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	for _, query := range []string{"width=50&dpr=5", "width=50&dpr=0.5", "width=50&dpr=abc"} {
+		imageData := loadTestFixture(t, "test-100x100.jpg")
+		req, _ := createMultipartRequest(t, imageData, "test.jpg")
+		retargetRequest(req, "/optimize?"+query)
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestOptimizeEndpoint_InvalidSharpen(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	for _, query := range []string{"width=50&sharpen=11", "width=50&sharpen=-1", "width=50&sharpen=strong"} {
+		imageData := loadTestFixture(t, "test-100x100.jpg")
+		req, _ := createMultipartRequest(t, imageData, "test.jpg")
+		retargetRequest(req, "/optimize?"+query)
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestOptimizeEndpoint_InvalidDocumentOptions(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	for _, query := range []string{"page=0", "page=first", "dpi=10", "dpi=1200"} {
+		imageData := loadTestFixture(t, "test-100x100.jpg")
+		req, _ := createMultipartRequest(t, imageData, "test.jpg")
+		retargetRequest(req, "/optimize?"+query)
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestOptimizeEndpoint_InvalidTIFFCompression(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	imageData := loadTestFixture(t, "test-100x100.jpg")
+	req, _ := createMultipartRequest(t, imageData, "test.jpg")
+	retargetRequest(req, "/optimize?format=tiff&tiffCompression=ccitt")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestOptimizeEndpoint_SignatureMismatch(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	// createMultipartRequest declares image/jpeg
+	for name, data := range map[string][]byte{
+		"png content":  loadTestFixture(t, "test-200x150.png"),
+		"not an image": []byte("<?php echo 'hello'; ?>"),
+	} {
+		req, _ := createMultipartRequest(t, data, "test.jpg")
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", name, resp.StatusCode)
+		}
+	}
+}
+
+func TestSniffImageFormat(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"\xff\xd8\xff\xe0", "jpeg"},
+		{"\x89PNG\r\n\x1a\n", "png"},
+		{"GIF89a", "gif"},
+		{"RIFF\x00\x00\x00\x00WEBPVP8 ", "webp"},
+		{"\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00avifmif1", "avif"},
+		{"\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic", ""},
+		{"II*\x00\x08\x00\x00\x00", "tiff"},
+		{"BM\x36\x00\x00\x00", "bmp"},
+		{"%PDF-1.7", "pdf"},
+		{"<svg", ""},
+	}
+
+	for _, tt := range tests {
+		if got := sniffImageFormat([]byte(tt.data)); got != tt.expected {
+			t.Errorf("sniffImageFormat(%q) = %q, expected %q", tt.data, got, tt.expected)
+		}
+	}
+}
+
+func TestOptimizeEndpoint_InvalidEffort(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	for _, query := range []string{"effort=7", "effort=-1", "effort=maximum"} {
+		imageData := loadTestFixture(t, "test-100x100.jpg")
+		req, _ := createMultipartRequest(t, imageData, "test.jpg")
+		retargetRequest(req, "/optimize?"+query)
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestOptimizeEndpoint_InvalidAllowOriginalFallback(t *testing.T) {
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	imageData := loadTestFixture(t, "test-100x100.jpg")
+	req, _ := createMultipartRequest(t, imageData, "test.jpg")
+	retargetRequest(req, "/optimize?allowOriginalFallback=sometimes")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestOptimizeEndpoint_UnknownPreset(t *testing.T) {
+	_ = os.Setenv("DB_PATH", ":memory:")
+	if err := db.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	imageData := loadTestFixture(t, "test-100x100.jpg")
+	req, _ := createMultipartRequest(t, imageData, "test.jpg")
+	retargetRequest(req, "/optimize?preset=does-not-exist")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestApplyPreset_RequestOverridesPreset(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		if err := applyPreset(c); err != nil {
+			return err
+		}
+		return c.SendString(c.Query("format") + "," + c.Query("quality") + "," + c.Query("width"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/?preset=thumbnail&quality=90", nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if got := string(body); got != "webp,90,320" {
+		t.Errorf("Expected preset values with the quality override, got %q", got)
+	}
+}
+
+func TestValidatePresetParams(t *testing.T) {
+	app := fiber.New()
+	var errs []error
+	app.Get("/", func(c *fiber.Ctx) error {
+		for _, params := range []map[string]string{
+			{"quality": "85", "progressive": "true", "subsample": "1"},
+			{"returnImage": "true"},
+			{"progressive": "yes"},
+			{"quality": "101"},
+			{"dpi": "10"},
+		} {
+			errs = append(errs, validatePresetParams(c, params))
+		}
+		return nil
+	})
+
+	if _, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil), -1); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if errs[0] != nil {
+		t.Errorf("Expected valid parameters to pass, got %v", errs[0])
+	}
+	for i, err := range errs[1:] {
+		if err == nil {
+			t.Errorf("Case %d: expected an error", i+1)
+		}
+	}
+}
+
+func TestBuiltinPresetsAreValid(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		for name, preset := range builtinPresets {
+			if err := validatePresetParams(c, preset.Params); err != nil {
+				t.Errorf("Built-in preset %s is invalid: %v", name, err)
+			}
+		}
+		return nil
+	})
+
+	if _, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil), -1); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+}
+
+func TestTransformEndpoint_InvalidPipeline(t *testing.T) {
+	_ = os.Setenv("DB_PATH", ":memory:")
+	if err := db.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+
+	tests := []struct {
+		name     string
+		pipeline string
+	}{
+		{"missing pipeline", ""},
+		{"invalid JSON", `{"steps": [`},
+		{"unknown op", `{"steps": [{"op": "posterize"}, {"op": "encode"}]}`},
+		{"invalid encode parameter", `{"steps": [{"op": "encode", "params": {"quality": 0}}]}`},
+		{"unsupported encode parameter", `{"steps": [{"op": "encode", "params": {"returnImage": true}}]}`},
+		{"unknown encode preset", `{"steps": [{"op": "encode", "preset": "does-not-exist"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			if tt.pipeline != "" {
+				_ = writer.WriteField("pipeline", tt.pipeline)
+			}
+			part, err := writer.CreatePart(map[string][]string{
+				"Content-Disposition": {`form-data; name="image"; filename="test.jpg"`},
+				"Content-Type":        {"image/jpeg"},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create multipart part: %v", err)
+			}
+			_, _ = part.Write(loadTestFixture(t, "test-100x100.jpg"))
+			_ = writer.Close()
+
+			req := httptest.NewRequest("POST", "/transform", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestParseProxyOptions(t *testing.T) {
+	params, presetName, err := parseProxyOptions("w_400,q_75,f_auto,p_thumbnail,forceSRGB_true")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if presetName != "thumbnail" {
+		t.Errorf("Expected preset thumbnail, got %q", presetName)
+	}
+	expected := map[string]string{"width": "400", "quality": "75", "format": "auto", "forceSRGB": "true"}
+	for key, value := range expected {
+		if params[key] != value {
+			t.Errorf("Expected %s=%s, got %q", key, value, params[key])
+		}
+	}
+
+	if params, _, err := parseProxyOptions("-"); err != nil || len(params) != 0 {
+		t.Errorf("Expected no options for \"-\", got %v (err %v)", params, err)
+	}
+
+	for _, segment := range []string{"w400", "w_", "zoom_2", "w_100,width_200", "page_2"} {
+		if _, _, err := parseProxyOptions(segment); err == nil {
+			t.Errorf("Expected error for options %q", segment)
+		}
+	}
+}
+
+func TestDecodeProxySource(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		want    string
+		wantErr bool
+	}{
+		{"percent-encoded", "https%3A%2F%2Fexample.com%2Fa%20b.jpg", "https://example.com/a b.jpg", false},
+		{"plain", "https://example.com/cat.png", "https://example.com/cat.png", false},
+		{"base64url", "aHR0cHM6Ly9leGFtcGxlLmNvbS9jYXQucG5nP3NpemU9MQ", "https://example.com/cat.png?size=1", false},
+		{"padded base64url", "aHR0cHM6Ly9leGFtcGxlLmNvbS9jYXQucG5nP3NpemU9MQ==", "https://example.com/cat.png?size=1", false},
+		{"unsupported scheme", "ZmlsZTovLy9ldGMvcGFzc3dk", "", true},
+		{"not base64", "!!!", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeProxySource(tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeProxySource(%q) error = %v, wantErr %v", tt.encoded, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("decodeProxySource(%q) = %q, want %q", tt.encoded, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bimg.ImageType
+	}{
+		{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", bimg.AVIF},
+		{"image/webp,image/*,*/*;q=0.8", bimg.WEBP},
+		{"image/avif;q=0,image/webp", bimg.WEBP},
+		{"image/*,*/*", 0},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateFormat(tt.accept); got != tt.want {
+			t.Errorf("negotiateFormat(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	etag := `"abc123"`
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{`"abc123"`, true},
+		{`W/"abc123"`, true},
+		{`"other", "abc123"`, true},
+		{"*", true},
+		{`"other"`, false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, etag); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+		}
+	}
+}
+
+func TestImageProxyEndpoint(t *testing.T) {
+	originalDomains := os.Getenv("ALLOWED_DOMAINS")
+	_ = os.Setenv("ALLOWED_DOMAINS", "")
+	_ = os.Setenv("ALLOW_PRIVATE_IPS", "true")
+	_ = os.Setenv("IMG_PROXY_DEV_MODE", "true")
+	defer func() {
+		_ = os.Setenv("ALLOWED_DOMAINS", originalDomains)
+		_ = os.Unsetenv("ALLOW_PRIVATE_IPS")
+		_ = os.Unsetenv("IMG_PROXY_DEV_MODE")
+	}()
+
+	var source bytes.Buffer
+	if err := bmp.Encode(&source, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("Failed to encode BMP: %v", err)
+	}
+	var served atomic.Pointer[[]byte]
+	sourceBytes := source.Bytes()
+	served.Store(&sourceBytes)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/bmp")
+		_, _ = w.Write(*served.Load())
+	}))
+	defer testServer.Close()
+
+	app := fiber.New()
+	RegisterOptimizeRoutes(app)
+	sourceURL := url.PathEscape(testServer.URL + "/test.bmp")
+
+	t.Run("invalid options", func(t *testing.T) {
+		resp, err := app.Test(httptest.NewRequest("GET", "/img/_/zoom_2/"+sourceURL, nil), -1)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", resp.StatusCode)
+		}
+		if vary := resp.Header.Get("Vary"); vary != "Accept" {
+			t.Errorf("Expected Vary: Accept on errors, got %q", vary)
+		}
+	})
+
+	t.Run("conditional request", func(t *testing.T) {
+		conditional := func(t *testing.T) string {
+			t.Helper()
+			req := httptest.NewRequest("GET", "/img/_/w_50,f_auto/"+sourceURL, nil)
+			req.Header.Set("If-None-Match", "*")
+			resp, err := app.Test(req, 30000)
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			if resp.StatusCode != http.StatusNotModified {
+				body, _ := io.ReadAll(resp.Body)
+				t.Fatalf("Expected status 304, got %d. Body: %s", resp.StatusCode, string(body))
+			}
+			if cacheControl := resp.Header.Get("Cache-Control"); cacheControl != "public, max-age=86400" {
+				t.Errorf("Unexpected Cache-Control: %q", cacheControl)
+			}
+			return resp.Header.Get("ETag")
+		}
+
+		etag := conditional(t)
+		if etag == "" {
+			t.Fatal("Expected an ETag header")
+		}
+		if again := conditional(t); again != etag {
+			t.Errorf("Expected a stable ETag for unchanged content, got %q then %q", etag, again)
+		}
+
+		// The same URL serving a different image gets a new ETag
+		var changed bytes.Buffer
+		if err := bmp.Encode(&changed, image.NewRGBA(image.Rect(0, 0, 9, 9))); err != nil {
+			t.Fatalf("Failed to encode BMP: %v", err)
+		}
+		changedBytes := changed.Bytes()
+		served.Store(&changedBytes)
+		if updated := conditional(t); updated == etag {
+			t.Errorf("Expected the ETag to change with the source image, still %q", updated)
+		}
+	})
+}
+
+func TestImageProxyEndpoint_SignedURLs(t *testing.T) {
+	_ = os.Setenv("DB_PATH", ":memory:")
+	if err := db.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	originalDomains := os.Getenv("ALLOWED_DOMAINS")
+	_ = os.Setenv("ALLOWED_DOMAINS", "")
+	_ = os.Setenv("ALLOW_PRIVATE_IPS", "true")
+	defer func() {
+		_ = os.Setenv("ALLOWED_DOMAINS", originalDomains)
+		_ = os.Unsetenv("ALLOW_PRIVATE_IPS")
+	}()
+
+	var source bytes.Buffer
+	if err := bmp.Encode(&source, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("Failed to encode BMP: %v", err)
+	}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/bmp")
+		_, _ = w.Write(source.Bytes())
+	}))
+	defer testServer.Close()
+
+	apiKey, err := db.CreateAPIKey("signing")
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	app := fiber.New()
+	app.Use(middleware.RequireAPIKey())
+	RegisterOptimizeRoutes(app)
+	RegisterSigningRoutes(app)
+
+	signURL := func(t *testing.T, body string) SignURLResponse {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/sign", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+apiKey.Key)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			respBody, _ := io.ReadAll(resp.Body)
+			t.Fatalf("Expected status 200, got %d. Body: %s", resp.StatusCode, string(respBody))
+		}
+		var signed SignURLResponse
+		if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return signed
+	}
+	fetch := func(t *testing.T, path string) int {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("If-None-Match", "*")
+		resp, err := app.Test(req, 30000)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	signed := signURL(t, `{"url":"`+testServer.URL+`/test.bmp","options":"w_50,f_auto","expiresIn":3600}`)
+	if signed.ExpiresAt == nil || !strings.HasPrefix(signed.Path, "/img/"+signed.Signature+"/w_50,f_auto/") {
+		t.Fatalf("Unexpected signed URL: %+v", signed)
+	}
+
+	t.Run("valid signature", func(t *testing.T) {
+		if status := fetch(t, signed.Path); status != http.StatusNotModified {
+			t.Errorf("Expected status 304, got %d", status)
+		}
+	})
+
+	t.Run("tampered options", func(t *testing.T) {
+		path := strings.Replace(signed.Path, "w_50", "w_5000", 1)
+		if status := fetch(t, path); status != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", status)
+		}
+	})
+
+	t.Run("unsigned without dev mode", func(t *testing.T) {
+		path := strings.Replace(signed.Path, signed.Signature, "_", 1)
+		if status := fetch(t, path); status != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", status)
+		}
+	})
+
+	t.Run("revoked key", func(t *testing.T) {
+		if err := db.RevokeAPIKey(apiKey.ID); err != nil {
+			t.Fatalf("Failed to revoke API key: %v", err)
+		}
+		if status := fetch(t, signed.Path); status != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", status)
+		}
+	})
+}
+
+func TestSignURLEndpoint_Validation(t *testing.T) {
+	_ = os.Setenv("DB_PATH", ":memory:")
+	if err := db.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	apiKey, err := db.CreateAPIKey("signing")
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	app := fiber.New()
+	app.Use(middleware.RequireAPIKey())
+	RegisterSigningRoutes(app)
+
+	tests := []struct {
+		name   string
+		body   string
+		auth   bool
+		status int
+	}{
+		{"no API key", `{"url":"https://example.com/a.jpg"}`, false, http.StatusUnauthorized},
+		{"unsupported option", `{"url":"https://example.com/a.jpg","options":"zoom_2"}`, true, http.StatusBadRequest},
+		{"invalid option value", `{"url":"https://example.com/a.jpg","options":"q_500"}`, true, http.StatusBadRequest},
+		{"unsupported scheme", `{"url":"file:///etc/passwd"}`, true, http.StatusBadRequest},
+		{"negative expiry", `{"url":"https://example.com/a.jpg","expiresIn":-1}`, true, http.StatusBadRequest},
+		{"expiry past one year", `{"url":"https://example.com/a.jpg","expiresIn":31536001}`, true, http.StatusBadRequest},
+		{"overflowing expiry", `{"url":"https://example.com/a.jpg","expiresIn":9223372037}`, true, http.StatusBadRequest},
+		{"no options", `{"url":"https://example.com/a.jpg"}`, true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/sign", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.auth {
+				req.Header.Set("Authorization", "Bearer "+apiKey.Key)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,441 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/db"
+	"github.com/keif/image-optimizer/middleware"
+	"github.com/keif/image-optimizer/services"
+	"github.com/valyala/fasthttp"
+)
+
+// presetNamePattern restricts preset names to URL-friendly slugs
+var presetNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// presetParams lists the query parameters a preset may set, and whether each is
+// a boolean. Output selection (returnImage, allPages) stays per-request.
+var presetParams = map[string]bool{
+	"quality":               false,
+	"width":                 false,
+	"height":                false,
+	"format":                false,
+	"tiffCompression":       false,
+	"allowOriginalFallback": true,
+	"forceSRGB":             true,
+	"losslessMode":          true,
+	"interpolator":          false,
+	"progressive":           true,
+	"optimizeCoding":        true,
+	"subsample":             false,
+	"smooth":                false,
+	"compression":           false,
+	"interlace":             true,
+	"palette":               true,
+	"lossless":              true,
+	"effort":                false,
+	"webpMethod":            false,
+	"oxipngLevel":           false,
+	"dpr":                   false,
+	"dprQuality":            true,
+	"keepBitDepth":          true,
+	"toneMap":               true,
+	"linearResize":          true,
+	"sharpen":               false,
+	"trim":                  true,
+	"trimThreshold":         false,
+	"trimMargin":            false,
+	"background":            false,
+	"alphaCompositing":      false,
+	"page":                  false,
+	"dpi":                   false,
+}
+
+// builtinPresets ship with the server. They are not stored in the database and
+// cannot be changed or shadowed.
+var builtinPresets = map[string]db.Preset{
+	"thumbnail": {
+		Name:        "thumbnail",
+		Description: "Small WebP preview fitting 320x320",
+		Params:      map[string]string{"width": "320", "height": "320", "format": "webp", "quality": "75"},
+	},
+	"lossless-archive": {
+		Name:        "lossless-archive",
+		Description: "Lossless optimization keeping the source bit depth",
+		Params:      map[string]string{"losslessMode": "true", "keepBitDepth": "true"},
+	},
+	"social-card": {
+		Name:        "social-card",
+		Description: "1200x630 progressive JPEG for Open Graph and Twitter cards",
+		Params:      map[string]string{"width": "1200", "height": "630", "format": "jpeg", "quality": "82", "progressive": "true"},
+	},
+}
+
+// presetRequest is the body of preset create and update requests
+type presetRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Params      map[string]string `json:"params"`
+	Scoped      bool              `json:"scoped"` // Only visible to the calling API key
+}
+
+// RegisterPresetRoutes registers the preset management routes
+func RegisterPresetRoutes(app *fiber.App) {
+	api := app.Group("/api/presets")
+
+	api.Get("/", listPresets)
+	api.Get("/:name", getPreset)
+	api.Post("/", createPreset)
+	api.Put("/:name", updatePreset)
+	api.Delete("/:name", deletePreset)
+}
+
+// requestAPIKeyID returns the ID of the API key the request was made with, if any
+func requestAPIKeyID(c *fiber.Ctx) *int {
+	if id, ok := c.Locals(middleware.APIKeyIDKey).(int); ok {
+		return &id
+	}
+	return nil
+}
+
+// findPreset resolves a preset name for the calling API key: built-ins first, then
+// the key's own presets, then global ones
+func findPreset(c *fiber.Ctx, name string) (*db.Preset, error) {
+	if preset, ok := builtinPresets[name]; ok {
+		preset.BuiltIn = true
+		return &preset, nil
+	}
+	if db.DB == nil {
+		return nil, db.ErrPresetNotFound
+	}
+	return db.GetPreset(name, requestAPIKeyID(c))
+}
+
+// applyPreset merges the parameters of the preset named by ?preset= into the
+// request's query string. Parameters given explicitly in the request win, so a
+// preset can be used with per-request overrides.
+func applyPreset(c *fiber.Ctx) error {
+	name := c.Query("preset")
+	if name == "" {
+		return nil
+	}
+
+	preset, err := findPreset(c, name)
+	if errors.Is(err, db.ErrPresetNotFound) {
+		return fiber.NewError(fiber.StatusBadRequest, "Unknown preset: "+name)
+	}
+	if err != nil {
+		log.Printf("Failed to load preset %q: %v", name, err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to load preset")
+	}
+
+	args := c.Request().URI().QueryArgs()
+	for key, value := range preset.Params {
+		if !args.Has(key) {
+			args.Set(key, value)
+		}
+	}
+	return nil
+}
+
+// validatePresetParams checks that every parameter may be set by a preset and
+// parses them exactly as a request would, so invalid presets are rejected when
+// saved rather than when used
+func validatePresetParams(c *fiber.Ctx, params map[string]string) error {
+	for key, value := range params {
+		isBool, ok := presetParams[key]
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Unsupported parameter: "+key)
+		}
+		if isBool {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid "+key+" parameter. Must be true or false.")
+			}
+		}
+	}
+
+	_, err := optionsFromParams(c, params)
+	return err
+}
+
+// optionsWithPreset resolves query-style parameters plus an optional preset into
+// optimization options, for requests that do not carry them in the query string.
+// Parameters override the preset's. Document parameters (page, dpi) are not
+// accepted, and ignored when a preset sets them.
+func optionsWithPreset(c *fiber.Ctx, presetName string, params map[string]string) (services.OptimizeOptions, error) {
+	merged, err := mergePresetParams(c, presetName, params)
+	if err != nil {
+		return services.OptimizeOptions{}, err
+	}
+	return optionsFromParams(c, merged)
+}
+
+// mergePresetParams fills in the parameters a preset sets and params does not,
+// and validates the result as optionsWithPreset describes
+func mergePresetParams(c *fiber.Ctx, presetName string, params map[string]string) (map[string]string, error) {
+	merged := make(map[string]string, len(params))
+	for key, value := range params {
+		if key == "page" || key == "dpi" {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Unsupported parameter: "+key)
+		}
+		merged[key] = value
+	}
+
+	if presetName != "" {
+		preset, err := findPreset(c, presetName)
+		if errors.Is(err, db.ErrPresetNotFound) {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Unknown preset: "+presetName)
+		}
+		if err != nil {
+			log.Printf("Failed to load preset %q: %v", presetName, err)
+			return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to load preset")
+		}
+		for key, value := range preset.Params {
+			if _, set := merged[key]; !set && key != "page" && key != "dpi" {
+				merged[key] = value
+			}
+		}
+	}
+
+	if err := validatePresetParams(c, merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// optionsFromParams parses query-style parameters exactly as a request's query
+// string, including the document options, on a scratch context
+func optionsFromParams(c *fiber.Ctx, params map[string]string) (services.OptimizeOptions, error) {
+	probe := c.App().AcquireCtx(&fasthttp.RequestCtx{})
+	defer c.App().ReleaseCtx(probe)
+
+	args := probe.Request().URI().QueryArgs()
+	for key, value := range params {
+		args.Set(key, value)
+	}
+
+	options, err := parseOptimizeOptions(probe)
+	if err != nil {
+		return options, err
+	}
+	_, _, err = parseDocumentOptions(probe, options.Background)
+	return options, err
+}
+
+// presetErrorResponse converts a preset validation or storage error into a JSON error response
+func presetErrorResponse(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &fiberErr):
+		return c.Status(fiberErr.Code).JSON(fiber.Map{"error": fiberErr.Message})
+	case errors.Is(err, db.ErrPresetNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Preset not found"})
+	case errors.Is(err, db.ErrPresetExists):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A preset with this name already exists"})
+	}
+	return apiErrorResponse(c, fiber.StatusInternalServerError, "Failed to access presets", err)
+}
+
+// listPresets lists the built-in presets plus the stored presets visible to the caller
+// @Summary List presets
+// @Description List the built-in presets, global presets and presets scoped to the calling API key
+// @Tags presets
+// @Produce json
+// @Success 200 {array} db.Preset
+// @Failure 500 {object} map[string]string
+// @Router /api/presets [get]
+// @Security ApiKeyAuth
+func listPresets(c *fiber.Ctx) error {
+	stored, err := db.ListPresets(requestAPIKeyID(c))
+	if err != nil {
+		return presetErrorResponse(c, err)
+	}
+
+	presets := make([]db.Preset, 0, len(builtinPresets)+len(stored))
+	for _, preset := range builtinPresets {
+		preset.BuiltIn = true
+		presets = append(presets, preset)
+	}
+	sort.Slice(presets, func(i, j int) bool { return presets[i].Name < presets[j].Name })
+
+	return c.JSON(append(presets, stored...))
+}
+
+// getPreset retrieves a preset by name
+// @Summary Get a preset
+// @Description Get a preset by name; a preset scoped to the calling API key takes precedence over a global one
+// @Tags presets
+// @Produce json
+// @Param name path string true "Preset name"
+// @Success 200 {object} db.Preset
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/presets/{name} [get]
+// @Security ApiKeyAuth
+func getPreset(c *fiber.Ctx) error {
+	preset, err := findPreset(c, c.Params("name"))
+	if err != nil {
+		return presetErrorResponse(c, err)
+	}
+	return c.JSON(preset)
+}
+
+// createPreset stores a new preset
+// @Summary Create a preset
+// @Description Create a named bundle of /optimize query parameters. With scoped=true the preset is only visible to the calling API key; global presets require an admin API key (ADMIN_API_KEY_IDS).
+// @Tags presets
+// @Accept json
+// @Produce json
+// @Param body body object{name=string,description=string,params=map[string]string,scoped=bool} true "Preset"
+// @Success 201 {object} db.Preset
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/presets [post]
+// @Security ApiKeyAuth
+func createPreset(c *fiber.Ctx) error {
+	var req presetRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if !presetNamePattern.MatchString(req.Name) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid preset name. Use 1-64 lowercase letters, digits and hyphens.",
+		})
+	}
+	if _, ok := builtinPresets[req.Name]; ok {
+		return presetErrorResponse(c, db.ErrPresetExists)
+	}
+	if err := validatePresetParams(c, req.Params); err != nil {
+		return presetErrorResponse(c, err)
+	}
+
+	preset := &db.Preset{Name: req.Name, Description: req.Description, Params: req.Params}
+	if preset.Params == nil {
+		preset.Params = map[string]string{}
+	}
+	if req.Scoped {
+		preset.APIKeyID = requestAPIKeyID(c)
+		if preset.APIKeyID == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Scoped presets require a request authenticated with an API key",
+			})
+		}
+	} else if !middleware.IsAdmin(c) {
+		// Global presets apply to every client's ?preset= lookups
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Global presets can only be created with an admin API key. Use scoped=true for a preset of your own.",
+		})
+	}
+
+	if err := db.CreatePreset(preset); err != nil {
+		return presetErrorResponse(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(preset)
+}
+
+// updatePreset replaces the description and parameters of a preset
+// @Summary Update a preset
+// @Description Replace the description and parameters of a preset owned by the calling API key. Global presets require an admin API key; built-in presets cannot be changed.
+// @Tags presets
+// @Accept json
+// @Produce json
+// @Param name path string true "Preset name"
+// @Param body body object{description=string,params=map[string]string} true "Preset"
+// @Success 200 {object} db.Preset
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/presets/{name} [put]
+// @Security ApiKeyAuth
+func updatePreset(c *fiber.Ctx) error {
+	var req presetRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	preset, err := findStoredPreset(c)
+	if err != nil {
+		return presetErrorResponse(c, err)
+	}
+	if err := validatePresetParams(c, req.Params); err != nil {
+		return presetErrorResponse(c, err)
+	}
+	if req.Params == nil {
+		req.Params = map[string]string{}
+	}
+
+	if err := db.UpdatePreset(preset.ID, req.Description, req.Params); err != nil {
+		return presetErrorResponse(c, err)
+	}
+
+	preset.Description = req.Description
+	preset.Params = req.Params
+	return c.JSON(preset)
+}
+
+// deletePreset deletes a preset
+// @Summary Delete a preset
+// @Description Delete a preset owned by the calling API key. Global presets require an admin API key; built-in presets cannot be deleted.
+// @Tags presets
+// @Produce json
+// @Param name path string true "Preset name"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/presets/{name} [delete]
+// @Security ApiKeyAuth
+func deletePreset(c *fiber.Ctx) error {
+	preset, err := findStoredPreset(c)
+	if err != nil {
+		return presetErrorResponse(c, err)
+	}
+
+	if err := db.DeletePreset(preset.ID); err != nil {
+		return presetErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Preset deleted successfully",
+	})
+}
+
+// findStoredPreset resolves the :name parameter to a database preset the caller
+// may change: its API key's own preset, or - for admins only - the global one.
+// Built-ins and other keys' presets are never returned.
+func findStoredPreset(c *fiber.Ctx) (*db.Preset, error) {
+	name := c.Params("name")
+	if _, ok := builtinPresets[name]; ok {
+		return nil, fiber.NewError(fiber.StatusForbidden, "Built-in presets cannot be modified")
+	}
+
+	if apiKeyID := requestAPIKeyID(c); apiKeyID != nil {
+		preset, err := db.GetOwnedPreset(name, apiKeyID)
+		if !errors.Is(err, db.ErrPresetNotFound) {
+			return preset, err
+		}
+	}
+
+	preset, err := db.GetOwnedPreset(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !middleware.IsAdmin(c) {
+		return nil, fiber.NewError(fiber.StatusForbidden, "Global presets can only be modified with an admin API key")
+	}
+	return preset, nil
+}
@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/db"
+	"github.com/keif/image-optimizer/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresetOwnership(t *testing.T) {
+	_ = os.Setenv("DB_PATH", filepath.Join(t.TempDir(), "presets.db"))
+	_ = os.Setenv("ADMIN_API_KEY_IDS", "1")
+	defer func() { _ = os.Unsetenv("ADMIN_API_KEY_IDS") }()
+	require.NoError(t, db.Initialize())
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if key := c.Get("X-Test-Key"); key != "" {
+			id, _ := strconv.Atoi(key)
+			c.Locals(middleware.APIKeyIDKey, id)
+		}
+		return c.Next()
+	})
+	RegisterPresetRoutes(app)
+
+	// Key 1 is an admin (ADMIN_API_KEY_IDS), keys 2 and 3 are regular
+	request := func(method, target, key, body string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+		if key != "" {
+			req.Header.Set("X-Test-Key", key)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	const body = `{"name": "web", "params": {"quality": "80"}}`
+	const scopedBody = `{"name": "web", "params": {"quality": "60"}, "scoped": true}`
+
+	assert.Equal(t, 403, request("POST", "/api/presets", "", body), "keyless requests cannot create global presets")
+	assert.Equal(t, 403, request("POST", "/api/presets", "2", body), "regular keys cannot create global presets")
+	assert.Equal(t, 201, request("POST", "/api/presets", "1", body))
+	assert.Equal(t, 201, request("POST", "/api/presets", "2", scopedBody))
+
+	// Key 2 changes its own preset, never the global one it shadows
+	assert.Equal(t, 200, request("PUT", "/api/presets/web", "2", `{"params": {"quality": "50"}}`))
+	assert.Equal(t, 200, request("DELETE", "/api/presets/web", "2", ""))
+	global, err := db.GetOwnedPreset("web", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "80", global.Params["quality"])
+
+	// Without a preset of their own, regular keys and keyless requests get 403 for the global one
+	for _, key := range []string{"", "2", "3"} {
+		assert.Equal(t, 403, request("PUT", "/api/presets/web", key, `{"params": {"quality": "10"}}`), key)
+		assert.Equal(t, 403, request("DELETE", "/api/presets/web", key, ""), key)
+	}
+	assert.Equal(t, 404, request("DELETE", "/api/presets/missing", "2", ""))
+
+	assert.Equal(t, 200, request("PUT", "/api/presets/web", "1", `{"params": {"quality": "70"}}`))
+	assert.Equal(t, 200, request("DELETE", "/api/presets/web", "1", ""))
+	_, err = db.GetOwnedPreset("web", nil)
+	assert.ErrorIs(t, err, db.ErrPresetNotFound)
+}
@@ -0,0 +1,304 @@
+package routes
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/h2non/bimg"
+	"github.com/keif/image-optimizer/db"
+	"github.com/keif/image-optimizer/middleware"
+	"github.com/keif/image-optimizer/services"
+)
+
+// defaultProxyMaxAge is the Cache-Control max-age of proxied images (one day)
+const defaultProxyMaxAge = 86400
+
+// proxyOptionAliases maps the short option names of /img URLs to query parameters.
+// Every other preset parameter (and preset itself) may be used by its full name.
+var proxyOptionAliases = map[string]string{
+	"w":  "width",
+	"h":  "height",
+	"q":  "quality",
+	"f":  "format",
+	"bg": "background",
+	"p":  "preset",
+	"ll": "losslessMode",
+	"s":  "sharpen",
+}
+
+// proxyMaxAge reads IMG_PROXY_MAX_AGE (seconds) for the Cache-Control header of proxied images
+func proxyMaxAge() int {
+	if value := os.Getenv("IMG_PROXY_MAX_AGE"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return seconds
+		}
+	}
+	return defaultProxyMaxAge
+}
+
+// proxyDevMode reports whether IMG_PROXY_DEV_MODE allows unsigned /img URLs.
+// Never enable it on a public instance: anyone could request arbitrary sizes.
+func proxyDevMode() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("IMG_PROXY_DEV_MODE"))
+	return enabled
+}
+
+// handleImageProxy handles GET /img/{signature}/{options}/{source} requests
+// @Summary Proxy and optimize a remote image
+// @Description Fetch a remote image (with the same SSRF protection and domain whitelist as /optimize), optimize it and return it directly, for use as a CDN origin. The signature segment is "{keyId}.{expires}.{hmac}" as produced by POST /api/sign, verified before anything is fetched; "_" (unsigned) is only accepted when IMG_PROXY_DEV_MODE is enabled. Options are comma-separated key_value pairs, e.g. w_400,q_75,f_auto (w, h, q, f, bg, p=preset, ll=losslessMode, s=sharpen, dpr, or any /optimize parameter by name); "-" means none. f_auto picks AVIF or WebP from the Accept header. The source is the percent-encoded or base64url-encoded URL. Responses carry Cache-Control, Vary: Accept and an ETag derived from the source image's content and the effective options; a matching If-None-Match returns 304 without encoding anything, and the tag changes whenever the source image does.
+// @Tags optimization
+// @Produce image/jpeg,image/png,image/webp,image/gif,image/avif,image/tiff
+// @Param signature path string true "URL signature from POST /api/sign, or _ in dev mode"
+// @Param options path string true "Comma-separated options, e.g. w_400,q_75,f_auto"
+// @Param source path string true "Percent-encoded or base64url-encoded source URL"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {file} binary "Optimized image"
+// @Success 304 "Not modified"
+// @Failure 400 {object} map[string]string "Invalid options, URL or image"
+// @Failure 403 {object} map[string]string "Missing, invalid or expired signature, or URL domain not allowed"
+// @Failure 500 {object} map[string]string "Image processing error"
+// @Router /img/{signature}/{options}/{source} [get]
+func handleImageProxy(c *fiber.Ctx) error {
+	// Vary on every response, errors included, so caches never mix up negotiated formats
+	c.Vary(fiber.HeaderAccept)
+
+	// The signature guards everything else: nothing is parsed, fetched or
+	// processed for a URL that was not signed
+	if err := verifyProxySignature(c); err != nil {
+		return inputErrorResponse(c, err)
+	}
+
+	params, presetName, err := parseProxyOptions(c.Params("options"))
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+	autoFormat := strings.EqualFold(params["format"], "auto")
+	if autoFormat {
+		delete(params, "format")
+	}
+
+	options, err := optionsWithPreset(c, presetName, params)
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+	if autoFormat {
+		options.Format = negotiateFormat(c.Get(fiber.HeaderAccept))
+	}
+
+	sourceURL, err := decodeProxySource(c.Params("*"))
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+
+	return sendProxiedImage(c, sourceURL, options)
+}
+
+// sendProxiedImage fetches, optimizes and returns a proxied image with caching
+// headers. Options and signatures must already have been checked.
+func sendProxiedImage(c *fiber.Ctx, sourceURL string, options services.OptimizeOptions) error {
+	data, err := fetchRemoteImage(sourceURL, c.IP(), c.Path())
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+	if err := validateImageContent(c, data, sourceURL, "", false); err != nil {
+		return inputErrorResponse(c, err)
+	}
+
+	// The ETag covers the fetched bytes and the effective options, so a matching
+	// If-None-Match is answered without encoding anything
+	etag := proxyETag(data, options)
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", proxyMaxAge()))
+	c.Set(fiber.HeaderETag, etag)
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	result, cacheKey, cacheStatus, err := optimizeCached(data, options)
+	if err != nil {
+		return errorResponse(c, fiber.StatusInternalServerError, "Failed to process image", err)
+	}
+	setCacheHeaders(c, cacheKey, cacheStatus)
+	middleware.RecordOptimizationMetric(c, result.OriginalFormat, result.Format, result.OriginalSize, result.OptimizedSize)
+
+	if len(result.Warnings) > 0 {
+		c.Set("X-Optimization-Warnings", strings.Join(result.Warnings, "; "))
+	}
+	c.Set(fiber.HeaderContentType, "image/"+result.Format)
+	return c.Send(result.OptimizedImage)
+}
+
+// verifyProxySignature checks the signature segment against the rest of the path
+// and the signing key's secret. A valid signature identifies the API key, so its
+// presets apply and its metrics are recorded.
+func verifyProxySignature(c *fiber.Ctx) error {
+	segment := c.Params("signature")
+	if segment == services.UnsignedSignature {
+		if proxyDevMode() {
+			return nil
+		}
+		return fiber.NewError(fiber.StatusForbidden, "Unsigned image URLs are disabled. Sign the URL with POST /api/sign.")
+	}
+
+	signature, err := services.ParseURLSignature(segment)
+	if err != nil {
+		log.Printf("[SECURITY] Malformed image URL signature - IP: %s, Path: %s", c.IP(), c.Path())
+		return fiber.NewError(fiber.StatusForbidden, "Invalid URL signature.")
+	}
+	if db.DB == nil {
+		return fiber.NewError(fiber.StatusForbidden, "Invalid URL signature.")
+	}
+
+	secret, err := db.GetSigningSecret(signature.KeyID)
+	if errors.Is(err, db.ErrSigningSecretNotFound) {
+		log.Printf("[SECURITY] Image URL signed with unknown or revoked key - IP: %s, Key ID: %d, Path: %s",
+			c.IP(), signature.KeyID, c.Path())
+		return fiber.NewError(fiber.StatusForbidden, "Invalid URL signature.")
+	}
+	if err != nil {
+		log.Printf("error: failed to load signing secret for key %d: %v", signature.KeyID, err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to verify URL signature.")
+	}
+
+	signedPath := c.Params("options") + "/" + c.Params("*")
+	switch err := services.VerifyImagePath(secret, signature, signedPath, time.Now()); {
+	case errors.Is(err, services.ErrSignatureExpired):
+		return fiber.NewError(fiber.StatusForbidden, "Signed URL has expired.")
+	case err != nil:
+		log.Printf("[SECURITY] Invalid image URL signature - IP: %s, Key ID: %d, Path: %s",
+			c.IP(), signature.KeyID, c.Path())
+		return fiber.NewError(fiber.StatusForbidden, "Invalid URL signature.")
+	}
+
+	c.Locals(middleware.APIKeyIDKey, signature.KeyID)
+	return nil
+}
+
+// parseProxyOptions parses the options segment of an /img URL ("w_400,q_75,f_auto"
+// or "-" for none) into query parameters and the preset name
+func parseProxyOptions(segment string) (map[string]string, string, error) {
+	params := make(map[string]string)
+	presetName := ""
+	if segment == "-" {
+		return params, presetName, nil
+	}
+
+	for _, option := range strings.Split(segment, ",") {
+		key, value, found := strings.Cut(option, "_")
+		if !found || key == "" || value == "" {
+			return nil, "", fiber.NewError(fiber.StatusBadRequest,
+				fmt.Sprintf("Invalid option %q. Options are key_value pairs separated by commas, e.g. w_400,q_75,f_auto.", option))
+		}
+		if alias, ok := proxyOptionAliases[key]; ok {
+			key = alias
+		}
+
+		if key == "preset" {
+			presetName = value
+			continue
+		}
+		if _, ok := presetParams[key]; !ok || key == "page" || key == "dpi" {
+			return nil, "", fiber.NewError(fiber.StatusBadRequest, "Unsupported option: "+key)
+		}
+		if _, duplicate := params[key]; duplicate {
+			return nil, "", fiber.NewError(fiber.StatusBadRequest, "Duplicate option: "+key)
+		}
+		params[key] = value
+	}
+	return params, presetName, nil
+}
+
+// decodeProxySource decodes the source URL of an /img request. It may be
+// percent-encoded (or plain, without a query string) or base64url-encoded.
+func decodeProxySource(encoded string) (string, error) {
+	var source string
+	if strings.HasPrefix(strings.ToLower(encoded), "http") {
+		unescaped, err := url.PathUnescape(encoded)
+		if err != nil {
+			return "", fiber.NewError(fiber.StatusBadRequest, "Invalid source URL encoding.")
+		}
+		source = unescaped
+	} else {
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+		if err != nil {
+			return "", fiber.NewError(fiber.StatusBadRequest, "Invalid source URL. Use a percent-encoded or base64url-encoded http(s) URL.")
+		}
+		source = string(decoded)
+	}
+
+	if err := validateProxySource(source); err != nil {
+		return "", err
+	}
+	return source, nil
+}
+
+// validateProxySource accepts absolute http(s) source URLs only
+func validateProxySource(source string) error {
+	parsed, err := url.Parse(source)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid source URL. Only http and https URLs are supported.")
+	}
+	return nil
+}
+
+// negotiateFormat picks the output format for f_auto from the Accept header:
+// AVIF, then WebP, otherwise the source format (0)
+func negotiateFormat(accept string) bimg.ImageType {
+	switch {
+	case acceptsMediaType(accept, "image/avif"):
+		return bimg.AVIF
+	case acceptsMediaType(accept, "image/webp"):
+		return bimg.WEBP
+	}
+	return 0
+}
+
+// acceptsMediaType reports whether an Accept header lists mediaType explicitly
+// with a non-zero quality. Wildcards do not count: browsers send image/* for
+// formats they cannot decode.
+func acceptsMediaType(accept, mediaType string) bool {
+	for _, entry := range strings.Split(accept, ",") {
+		fields := strings.Split(entry, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), mediaType) {
+			continue
+		}
+		for _, param := range fields[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if q, err := strconv.ParseFloat(value, 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// proxyETag derives a strong ETag from the source image and the options that
+// shape the output (including the negotiated format): the result cache key, so
+// the tag changes whenever the source image does
+func proxyETag(data []byte, options services.OptimizeOptions) string {
+	return `"` + services.ResultCacheKey(data, options) + `"`
+}
+
+// etagMatches implements the If-None-Match comparison: "*" or any listed tag
+// (weak tags compare by their opaque value)
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
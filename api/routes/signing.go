@@ -0,0 +1,137 @@
+package routes
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/db"
+	"github.com/keif/image-optimizer/services"
+)
+
+// maxSignedURLLifetime caps expiresIn of signed image URLs (one year)
+const maxSignedURLLifetime = 365 * 24 * time.Hour
+
+// SignURLRequest is the body of POST /api/sign
+type SignURLRequest struct {
+	URL       string `json:"url"`
+	Options   string `json:"options"`
+	ExpiresIn int64  `json:"expiresIn"`
+}
+
+// SignURLResponse is a signed image proxy URL
+type SignURLResponse struct {
+	URL       string     `json:"url"`
+	Path      string     `json:"path"`
+	Signature string     `json:"signature"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// RegisterSigningRoutes registers the image URL signing routes
+func RegisterSigningRoutes(app *fiber.App) {
+	api := app.Group("/api/sign")
+
+	api.Post("/", handleSignURL)
+	api.Post("/secret", handleRotateSigningSecret)
+}
+
+// missingSigningKeyResponse rejects signing requests made without a valid API key
+// (possible with API key auth disabled, or from a trusted origin)
+func missingSigningKeyResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": "URL signing requires an API key in the Authorization header.",
+	})
+}
+
+// handleSignURL signs an image proxy URL with the calling API key's secret
+// @Summary Sign an image proxy URL
+// @Description Produce a signed /img URL for a source URL and options (validated as the proxy would), optionally expiring after expiresIn seconds. The signature uses the calling API key's secret, which is created on first use.
+// @Tags signing
+// @Accept json
+// @Produce json
+// @Param body body SignURLRequest true "Source URL, options (e.g. w_400,q_75,f_auto; default -) and optional expiresIn seconds"
+// @Success 200 {object} SignURLResponse
+// @Failure 400 {object} map[string]string "Invalid URL, options or expiry"
+// @Failure 401 {object} map[string]string "No API key"
+// @Failure 500 {object} map[string]string
+// @Router /api/sign [post]
+// @Security ApiKeyAuth
+func handleSignURL(c *fiber.Ctx) error {
+	keyID := requestAPIKeyID(c)
+	if keyID == nil {
+		return missingSigningKeyResponse(c)
+	}
+
+	var req SignURLRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if req.ExpiresIn < 0 || req.ExpiresIn > int64(maxSignedURLLifetime/time.Second) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "expiresIn must be between 0 (never) and 31536000 seconds",
+		})
+	}
+	if req.Options == "" {
+		req.Options = "-"
+	}
+
+	// Refuse to sign URLs the proxy would reject anyway
+	params, presetName, err := parseProxyOptions(req.Options)
+	if err == nil {
+		if strings.EqualFold(params["format"], "auto") {
+			delete(params, "format")
+		}
+		_, err = optionsWithPreset(c, presetName, params)
+	}
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+	if err := validateProxySource(req.URL); err != nil {
+		return inputErrorResponse(c, err)
+	}
+
+	secret, err := db.EnsureSigningSecret(*keyID)
+	if err != nil {
+		return apiErrorResponse(c, fiber.StatusInternalServerError, "Failed to load signing secret", err)
+	}
+
+	var expires int64
+	response := SignURLResponse{}
+	if req.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second).UTC().Truncate(time.Second)
+		expires = expiresAt.Unix()
+		response.ExpiresAt = &expiresAt
+	}
+
+	signedPath := req.Options + "/" + base64.RawURLEncoding.EncodeToString([]byte(req.URL))
+	response.Signature = services.SignImagePath(secret, *keyID, expires, signedPath)
+	response.Path = "/img/" + response.Signature + "/" + signedPath
+	response.URL = c.BaseURL() + response.Path
+	return c.JSON(response)
+}
+
+// handleRotateSigningSecret replaces the calling API key's signing secret
+// @Summary Rotate the URL signing secret
+// @Description Replace the calling API key's URL signing secret and return it, for signing URLs without calling the API. Every URL signed with the previous secret stops working.
+// @Tags signing
+// @Produce json
+// @Success 200 {object} map[string]string "The new secret"
+// @Failure 401 {object} map[string]string "No API key"
+// @Failure 500 {object} map[string]string
+// @Router /api/sign/secret [post]
+// @Security ApiKeyAuth
+func handleRotateSigningSecret(c *fiber.Ctx) error {
+	keyID := requestAPIKeyID(c)
+	if keyID == nil {
+		return missingSigningKeyResponse(c)
+	}
+
+	secret, err := db.RotateSigningSecret(*keyID)
+	if err != nil {
+		return apiErrorResponse(c, fiber.StatusInternalServerError, "Failed to rotate signing secret", err)
+	}
+	return c.JSON(fiber.Map{"secret": secret})
+}
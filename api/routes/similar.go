@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/services"
+)
+
+// SimilarImage describes an image and its perceptual hashes
+type SimilarImage struct {
+	Filename string                `json:"filename"`
+	Hashes   *services.ImageHashes `json:"hashes,omitempty"`
+}
+
+// SimilarMatch is the comparison of one candidate image against the reference image
+type SimilarMatch struct {
+	SimilarImage
+	Distance   int    `json:"distance"`        // Hamming distance to the reference (0-64)
+	Similarity string `json:"similarity"`      // Percentage of matching hash bits
+	Similar    bool   `json:"similar"`         // True if distance <= threshold
+	Error      string `json:"error,omitempty"` // Set if the candidate could not be loaded or hashed
+}
+
+// SimilarResponse represents the response of the /similar endpoint
+type SimilarResponse struct {
+	Reference      SimilarImage   `json:"reference"`
+	Algorithm      string         `json:"algorithm"`
+	Threshold      int            `json:"threshold"`
+	Matches        []SimilarMatch `json:"matches"` // Sorted by distance, closest first
+	SimilarCount   int            `json:"similarCount"`
+	ProcessingTime string         `json:"processingTime"`
+}
+
+// handleSimilar handles POST /similar requests
+// @Summary Find near-duplicates of an image
+// @Description Compare a reference image against a set of candidate images using perceptual hashing (aHash, dHash or pHash) and report the Hamming distance of each candidate
+// @Tags optimization
+// @Accept multipart/form-data
+// @Produce json
+// @Param hashAlgorithm query string false "Perceptual hash used for comparison" Enums(ahash,dhash,phash) default(phash)
+// @Param similarityThreshold query int false "Maximum Hamming distance (0-64) for a candidate to be considered similar" default(10) minimum(0) maximum(64)
+// @Param image formData file true "Reference image"
+// @Param images formData file true "Candidate images to compare against the reference (multiple files)"
+// @Success 200 {object} SimilarResponse "Comparison results"
+// @Failure 400 {object} map[string]string "Invalid parameters or files"
+// @Router /similar [post]
+func handleSimilar(c *fiber.Ctx) error {
+	startTime := time.Now()
+
+	algorithm, threshold, err := parseSimilarityOptions(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse multipart form.",
+		})
+	}
+
+	references := form.File["image"]
+	if len(references) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "No reference image provided. Use 'image' field name for the reference file.",
+		})
+	}
+	candidates := form.File["images"]
+	if len(candidates) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "No candidate images provided. Use 'images' field name for candidate files.",
+		})
+	}
+
+	// Hash the reference image - failures here fail the whole request
+	referenceData, err := loadBatchImage(references[0])
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+	referenceHashes, err := services.ComputePerceptualHashes(referenceData)
+	if err != nil {
+		return errorResponse(c, fiber.StatusBadRequest, "Failed to hash reference image", err)
+	}
+
+	// Hash candidates in parallel - per-file failures are reported in the match list
+	matches := make([]SimilarMatch, len(candidates))
+	runBatchWorkers(len(candidates), func(i int) {
+		match := SimilarMatch{SimilarImage: SimilarImage{Filename: candidates[i].Filename}}
+
+		data, err := loadBatchImage(candidates[i])
+		if err != nil {
+			match.Error = err.Error()
+			matches[i] = match
+			return
+		}
+
+		hashes, err := services.ComputePerceptualHashes(data)
+		if err != nil {
+			match.Error = "Failed to hash image: " + err.Error()
+			matches[i] = match
+			return
+		}
+
+		match.Hashes = hashes
+		match.Distance = referenceHashes.Distance(*hashes, algorithm)
+		match.Similarity = fmt.Sprintf("%.2f%%", services.Similarity(match.Distance))
+		match.Similar = match.Distance <= threshold
+		matches[i] = match
+	})
+
+	// Closest matches first, failed candidates last
+	sort.SliceStable(matches, func(a, b int) bool {
+		if (matches[a].Error == "") != (matches[b].Error == "") {
+			return matches[a].Error == ""
+		}
+		return matches[a].Distance < matches[b].Distance
+	})
+
+	response := SimilarResponse{
+		Reference: SimilarImage{
+			Filename: references[0].Filename,
+			Hashes:   referenceHashes,
+		},
+		Algorithm: algorithm,
+		Threshold: threshold,
+		Matches:   matches,
+	}
+	for _, match := range matches {
+		if match.Similar {
+			response.SimilarCount++
+		}
+	}
+	response.ProcessingTime = fmt.Sprintf("%dms", time.Since(startTime).Milliseconds())
+
+	return c.JSON(response)
+}
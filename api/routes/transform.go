@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/middleware"
+	"github.com/keif/image-optimizer/services"
+)
+
+// transformReportName is the name of the timing report in transform responses
+const transformReportName = "report.json"
+
+// handleTransform handles POST /transform requests
+// @Summary Run a transformation pipeline
+// @Description Apply an ordered JSON pipeline of operations (crop, resize, rotate, flip, sharpen, blur, grayscale, watermark) to an image. Each encode step writes an output from the image at that point; the outputs and a per-step timing report (report.json) are returned as a ZIP or multipart/mixed response.
+// @Tags optimization
+// @Accept multipart/form-data
+// @Produce application/zip,multipart/mixed
+// @Param pipeline formData string true "Pipeline JSON, e.g. {\"steps\":[{\"op\":\"resize\",\"width\":800},{\"op\":\"encode\",\"name\":\"large\",\"params\":{\"format\":\"webp\"}}],\"output\":\"zip\"}"
+// @Param image formData file false "Image file to transform (multipart upload)"
+// @Param url formData string false "Image URL to fetch and transform (alternative to file upload)"
+// @Success 200 {file} binary "ZIP archive or multipart/mixed body with report.json and the outputs"
+// @Failure 400 {object} map[string]string "Invalid pipeline, parameters or file"
+// @Failure 403 {object} map[string]string "URL domain not allowed"
+// @Failure 500 {object} map[string]string "Image processing error"
+// @Router /transform [post]
+func handleTransform(c *fiber.Ctx) error {
+	definition := c.FormValue("pipeline")
+	if definition == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing 'pipeline' field. Provide the pipeline as JSON.",
+		})
+	}
+
+	// Validate the whole pipeline before fetching or decoding anything
+	pipeline, err := services.ParsePipeline([]byte(definition))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	for i := range pipeline.Steps {
+		if pipeline.Steps[i].Op != services.OpEncode {
+			continue
+		}
+		options, err := optionsWithPreset(c, pipeline.Steps[i].Preset, pipeline.Steps[i].Params)
+		if err != nil {
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				err = fiber.NewError(fiberErr.Code, fmt.Sprintf("Step %d (encode): %s", i+1, fiberErr.Message))
+			}
+			return inputErrorResponse(c, err)
+		}
+		pipeline.Steps[i].Options = options
+	}
+
+	data, filename, err := readImageInput(c)
+	if err != nil {
+		return inputErrorResponse(c, err)
+	}
+	if services.DocumentKind(data) == services.DocumentPDF {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "PDF documents are not supported by /transform. Render the page with /optimize first.",
+		})
+	}
+
+	pipeline.MaxPixels = maxDecodedPixels
+	result, err := services.RunPipeline(data, pipeline)
+	if errors.Is(err, services.ErrInvalidPipeline) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return errorResponse(c, fiber.StatusInternalServerError, "Failed to run pipeline for "+filename, err)
+	}
+
+	inputFormat := sniffImageFormat(data)
+	for _, output := range result.Outputs {
+		middleware.RecordOptimizationMetric(c, inputFormat, output.Format, int64(len(data)), output.Size)
+	}
+
+	report, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(c, fiber.StatusInternalServerError, "Failed to encode pipeline report", err)
+	}
+
+	// The report comes first so streaming clients can read it before the outputs
+	files := []archiveFile{{Name: transformReportName, ContentType: fiber.MIMEApplicationJSON, Data: report, Compress: true}}
+	for i, output := range result.Outputs {
+		files = append(files, archiveFile{Name: output.Name, ContentType: "image/" + output.Format, Data: output.Data})
+		result.Outputs[i].Data = nil // The archive releases each file once it is written
+	}
+
+	if pipeline.Output == services.PipelineOutputMultipart {
+		return sendMultipartArchive(c, files)
+	}
+	return sendZipArchive(c, "transform.zip", files)
+}
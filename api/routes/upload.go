@@ -0,0 +1,103 @@
+package routes
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/services"
+)
+
+// uploadTypes maps each accepted upload Content-Type to the format its file
+// signature must match
+var uploadTypes = map[string]string{
+	"image/jpeg":      "jpeg",
+	"image/jpg":       "jpeg",
+	"image/png":       "png",
+	"image/webp":      "webp",
+	"image/gif":       "gif",
+	"image/avif":      "avif",
+	"image/tiff":      "tiff",
+	"image/bmp":       "bmp",
+	"image/x-ms-bmp":  "bmp",
+	"application/pdf": "pdf", // Only where documents are supported - see optimizeDocument
+}
+
+// checkUploadType validates the declared Content-Type of an uploaded file against
+// the whitelist. PDFs are only accepted when allowDocuments is set.
+func checkUploadType(contentType string, allowDocuments bool) error {
+	format, ok := uploadTypes[contentType]
+	if !ok || (format == "pdf" && !allowDocuments) {
+		supported := "jpeg, jpg, png, webp, gif, avif, tiff, bmp"
+		if allowDocuments {
+			supported += ", pdf"
+		}
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid file type. Supported types: "+supported)
+	}
+	return nil
+}
+
+// checkImageSignature verifies the magic bytes of the data, so a file cannot get
+// past the whitelist by lying about its Content-Type. contentType is the declared
+// upload type, or "" for fetched URLs, which only need a supported signature.
+func checkImageSignature(data []byte, contentType string, allowDocuments bool) error {
+	detected := sniffImageFormat(data)
+	if detected == "" || (detected == "pdf" && !allowDocuments) {
+		return fiber.NewError(fiber.StatusBadRequest, "File content is not a supported image format.")
+	}
+
+	if contentType != "" && uploadTypes[contentType] != detected {
+		return fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("File content (%s) does not match the declared type %s.", detected, contentType))
+	}
+	return nil
+}
+
+// sniffImageFormat identifies the format from the file signature, returning the
+// uploadTypes format name or "" when it is not an accepted format
+func sniffImageFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp"
+	case isAVIF(data):
+		return "avif"
+	case services.IsBMP(data):
+		return "bmp"
+	}
+
+	switch services.DocumentKind(data) {
+	case services.DocumentTIFF:
+		return "tiff"
+	case services.DocumentPDF:
+		return "pdf"
+	}
+	return ""
+}
+
+// isAVIF checks the ISOBMFF ftyp box for an AVIF major or compatible brand
+func isAVIF(data []byte) bool {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+
+	size := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if size < 16 || size > len(data) {
+		return false
+	}
+
+	// Major brand, minor version, then compatible brands
+	brands := append([]byte{}, data[8:12]...)
+	brands = append(brands, data[16:size]...)
+	for i := 0; i+4 <= len(brands); i += 4 {
+		if brand := string(brands[i : i+4]); brand == "avif" || brand == "avis" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,447 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/keif/image-optimizer/db"
+	"github.com/keif/image-optimizer/middleware"
+	"github.com/keif/image-optimizer/services"
+)
+
+// Webhook events
+const (
+	webhookJobCompleted = "job.completed"
+	webhookJobFailed    = "job.failed"
+)
+
+// Webhook delivery settings
+const (
+	defaultWebhookMaxAttempts = 8
+	webhookTimeout            = 10 * time.Second
+	webhookMaxBackoff         = time.Hour
+	webhookPollInterval       = time.Second
+	webhookBatchSize          = 10
+	webhookRetention          = 30 * 24 * time.Hour // Finished deliveries are kept this long
+)
+
+// webhookRetryBase is the delay before the first retry; each further retry
+// doubles it (a variable so tests can shorten it)
+var webhookRetryBase = 30 * time.Second
+
+// webhookClient sends callbacks. It connects only to the address sendWebhook
+// resolved and checked, so the host cannot be re-resolved to a private address
+// in between, and it uses no proxy. Redirects are not followed, so a callback
+// cannot be bounced to a host the allowlist would reject.
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext:         dialPinnedIP,
+		TLSHandshakeTimeout: webhookTimeout,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// pinnedIPKey is the request context key of the address a callback connects to
+type pinnedIPKey struct{}
+
+// dialPinnedIP connects to the address pinned in the request context instead of
+// resolving the host again. TLS still verifies the certificate against the host.
+func dialPinnedIP(ctx context.Context, network, addr string) (net.Conn, error) {
+	ip, ok := ctx.Value(pinnedIPKey{}).(net.IP)
+	if !ok {
+		return nil, errors.New("callback address was not resolved")
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+}
+
+// errPermanentWebhook marks delivery errors that retrying cannot fix
+var errPermanentWebhook = errors.New("permanent webhook failure")
+
+// WebhookPayload is the JSON body POSTed to a job's callback URL
+type WebhookPayload struct {
+	Event string      `json:"event"` // job.completed or job.failed
+	Job   JobResponse `json:"job"`
+}
+
+// validateCallbackURL checks a job's callback URL against the same allowlist and
+// SSRF protections as fetched images, and that its payload can be signed
+func validateCallbackURL(rawURL string, apiKeyID *int) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid callbackUrl. Use an absolute http or https URL.")
+	}
+	if !isAllowedDomain(parsed) {
+		return fiber.NewError(fiber.StatusForbidden, "Callback URL domain not allowed. Please contact administrator to whitelist the domain.")
+	}
+	if _, err := resolveCallbackIP(parsed.Hostname()); err != nil {
+		return fiber.NewError(fiber.StatusForbidden, "Callback URL must resolve to a public address.")
+	}
+	if apiKeyID == nil && os.Getenv("WEBHOOK_SECRET") == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Callbacks for requests without an API key require WEBHOOK_SECRET to be configured.")
+	}
+	return nil
+}
+
+// resolveCallbackIP resolves a callback host and returns the address to connect
+// to. Every address the host resolves to must be public, so a lookup answering
+// with a mix cannot slip a private one through.
+func resolveCallbackIP(host string) (net.IP, error) {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		ips, err = net.LookupIP(host)
+		if err != nil || len(ips) == 0 {
+			return nil, fmt.Errorf("failed to resolve callback host %s", host)
+		}
+	}
+	for _, ip := range ips {
+		if isPrivateIP(ip.String()) {
+			return nil, errors.New("callback URL resolves to a private address")
+		}
+	}
+	return ips[0], nil
+}
+
+// webhookSecret returns the secret signing a delivery: the API key's webhook
+// secret, or WEBHOOK_SECRET for jobs created without a key
+func webhookSecret(apiKeyID *int) (string, error) {
+	if apiKeyID == nil {
+		if secret := os.Getenv("WEBHOOK_SECRET"); secret != "" {
+			return secret, nil
+		}
+		return "", fmt.Errorf("%w: WEBHOOK_SECRET is not configured", errPermanentWebhook)
+	}
+
+	secret, err := db.EnsureWebhookSecret(*apiKeyID)
+	if errors.Is(err, db.ErrWebhookSecretNotFound) {
+		return "", fmt.Errorf("%w: API key has been revoked", errPermanentWebhook)
+	}
+	return secret, err
+}
+
+// webhookMaxAttempts returns how many times a delivery is attempted before it
+// is dead-lettered (WEBHOOK_MAX_ATTEMPTS)
+func webhookMaxAttempts() int {
+	if attempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		return attempts
+	}
+	return defaultWebhookMaxAttempts
+}
+
+// webhookBackoff returns the delay after a delivery's nth failed attempt
+func webhookBackoff(attempt int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempt && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxBackoff)
+}
+
+// queueWebhook records the callback of a finished job for delivery
+func (q *jobQueue) queueWebhook(job *db.Job) {
+	event := webhookJobCompleted
+	if job.Status == db.JobFailed {
+		event = webhookJobFailed
+	}
+	payload, err := json.Marshal(WebhookPayload{
+		Event: event,
+		Job:   JobResponse{Job: job, ResultURL: "/jobs/" + job.ID + "/result"},
+	})
+	if err != nil {
+		log.Printf("warning: failed to encode webhook of job %s: %v", job.ID, err)
+		return
+	}
+
+	delivery := &db.WebhookDelivery{
+		JobID:    job.ID,
+		APIKeyID: job.APIKeyID,
+		URL:      job.CallbackURL,
+		Event:    event,
+		Payload:  string(payload),
+	}
+	if err := db.CreateWebhookDelivery(delivery); err != nil {
+		log.Printf("warning: failed to queue webhook of job %s: %v", job.ID, err)
+		return
+	}
+
+	q.wakeWebhooks()
+}
+
+// wakeWebhooks makes the webhook sender check for due deliveries now
+func (q *jobQueue) wakeWebhooks() {
+	select {
+	case q.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// deliverWebhooks sends due webhook deliveries until the queue is stopped
+func (q *jobQueue) deliverWebhooks() {
+	defer q.wg.Done()
+	for {
+		due, err := db.DueWebhookDeliveries(time.Now(), webhookBatchSize)
+		if err != nil {
+			log.Printf("warning: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		for i := range due {
+			wg.Add(1)
+			go func(delivery *db.WebhookDelivery) {
+				defer wg.Done()
+				deliverWebhook(delivery)
+			}(&due[i])
+		}
+		wg.Wait()
+
+		if len(due) == webhookBatchSize {
+			continue // More may be due
+		}
+		select {
+		case <-q.done:
+			return
+		case <-q.webhookWake:
+		case <-time.After(webhookPollInterval):
+		}
+	}
+}
+
+// deliverWebhook makes one attempt at a delivery and records the outcome,
+// scheduling a retry with exponential backoff or dead-lettering it
+func deliverWebhook(delivery *db.WebhookDelivery) {
+	attempt := db.WebhookAttempt{Attempt: delivery.Attempts + 1, AttemptedAt: time.Now()}
+	statusCode, err := sendWebhook(delivery)
+	attempt.StatusCode = statusCode
+	attempt.DurationMs = time.Since(attempt.AttemptedAt).Milliseconds()
+
+	switch {
+	case err == nil:
+		delivery.Status = db.WebhookDelivered
+		delivery.NextAttemptAt = nil
+		delivery.LastError = ""
+	case errors.Is(err, errPermanentWebhook) || attempt.Attempt >= webhookMaxAttempts():
+		attempt.Error = err.Error()
+		delivery.Status = db.WebhookDead
+		delivery.NextAttemptAt = nil
+		delivery.LastError = attempt.Error
+		log.Printf("[WEBHOOK] Delivery %d for job %s dead-lettered after %d attempts: %v",
+			delivery.ID, delivery.JobID, attempt.Attempt, err)
+	default:
+		attempt.Error = err.Error()
+		next := time.Now().Add(webhookBackoff(attempt.Attempt))
+		delivery.NextAttemptAt = &next
+		delivery.LastError = attempt.Error
+	}
+
+	if err := db.RecordWebhookAttempt(delivery, attempt); err != nil {
+		log.Printf("warning: %v", err)
+	}
+}
+
+// sendWebhook POSTs a delivery's signed payload and returns the response status.
+// The callback host is resolved again for every attempt, since its DNS may have
+// changed, and the request goes to the checked address. A failed check is
+// retried like any other failure, as DNS errors also fail it.
+func sendWebhook(delivery *db.WebhookDelivery) (int, error) {
+	parsed, err := url.Parse(delivery.URL)
+	if err != nil || !isAllowedDomain(parsed) {
+		log.Printf("[SECURITY] Webhook to disallowed URL blocked - Job: %s, URL: %s", delivery.JobID, delivery.URL)
+		return 0, errors.New("callback URL domain not allowed")
+	}
+	ip, err := resolveCallbackIP(parsed.Hostname())
+	if err != nil {
+		log.Printf("[SECURITY] Webhook to unresolvable or private address blocked - Job: %s, URL: %s, Error: %v", delivery.JobID, delivery.URL, err)
+		return 0, err
+	}
+	secret, err := webhookSecret(delivery.APIKeyID)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), pinnedIPKey{}, ip), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", delivery.URL, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errPermanentWebhook, err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "image-optimizer-webhook/1.0")
+	req.Header.Set("X-Webhook-Id", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", services.SignWebhook(secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("callback request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("callback returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// handleRotateWebhookSecret replaces the calling API key's webhook secret
+// @Summary Rotate the webhook secret
+// @Description Replace the calling API key's job callback secret and return it, for verifying X-Webhook-Signature. Deliveries sent afterwards, including retries, are signed with the new secret.
+// @Tags jobs
+// @Produce json
+// @Success 200 {object} map[string]string "The new secret"
+// @Failure 401 {object} map[string]string "No API key"
+// @Failure 500 {object} map[string]string
+// @Router /jobs/webhook-secret [post]
+// @Security ApiKeyAuth
+func handleRotateWebhookSecret(c *fiber.Ctx) error {
+	keyID := requestAPIKeyID(c)
+	if keyID == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Webhook secrets require an API key in the Authorization header.",
+		})
+	}
+
+	secret, err := db.RotateWebhookSecret(*keyID)
+	if err != nil {
+		return apiErrorResponse(c, fiber.StatusInternalServerError, "Failed to rotate webhook secret", err)
+	}
+	return c.JSON(fiber.Map{"secret": secret})
+}
+
+// @Summary List webhook deliveries
+// @Description List the most recent job callback deliveries, e.g. dead letters with status=dead
+// @Tags admin
+// @Produce json
+// @Param status query string false "Only deliveries with this status" Enums(pending,delivered,dead)
+// @Param limit query int false "Maximum number of deliveries (default: 50)" default(50) minimum(1) maximum(500)
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string "Invalid parameters"
+// @Failure 403 {object} map[string]string "Not an admin API key"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /admin/webhooks [get]
+func handleListWebhooks(c *fiber.Ctx) error {
+	if !middleware.IsAdmin(c) {
+		return adminRequiredResponse(c)
+	}
+	status := c.Query("status")
+	if status != "" && status != db.WebhookPending && status != db.WebhookDelivered && status != db.WebhookDead {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid status parameter. Must be pending, delivered or dead.",
+		})
+	}
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 500 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid limit parameter. Must be between 1 and 500.",
+		})
+	}
+
+	deliveries, err := db.ListWebhookDeliveries(status, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list webhook deliveries",
+		})
+	}
+	if deliveries == nil {
+		deliveries = []db.WebhookDelivery{}
+	}
+	return c.JSON(fiber.Map{
+		"deliveries": deliveries,
+	})
+}
+
+// @Summary Get a webhook delivery
+// @Description Get a job callback delivery with every attempt made for it
+// @Tags admin
+// @Produce json
+// @Param id path int true "Delivery ID"
+// @Success 200 {object} db.WebhookDelivery
+// @Failure 403 {object} map[string]string "Not an admin API key"
+// @Failure 404 {object} map[string]string "Delivery not found"
+// @Router /admin/webhooks/{id} [get]
+func handleGetWebhook(c *fiber.Ctx) error {
+	if !middleware.IsAdmin(c) {
+		return adminRequiredResponse(c)
+	}
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Webhook delivery not found",
+		})
+	}
+
+	delivery, err := db.GetWebhookDelivery(id)
+	if errors.Is(err, db.ErrWebhookDeliveryNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Webhook delivery not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load webhook delivery",
+		})
+	}
+	return c.JSON(delivery)
+}
+
+// @Summary Retry a dead webhook delivery
+// @Description Queue a dead-lettered delivery for another full round of attempts
+// @Tags admin
+// @Produce json
+// @Param id path int true "Delivery ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string "Not an admin API key"
+// @Failure 404 {object} map[string]string "No dead delivery with this ID"
+// @Router /admin/webhooks/{id}/retry [post]
+func handleRetryWebhook(c *fiber.Ctx) error {
+	if !middleware.IsAdmin(c) {
+		return adminRequiredResponse(c)
+	}
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err == nil {
+		err = db.RetryWebhookDelivery(id)
+	} else {
+		err = db.ErrWebhookDeliveryNotFound
+	}
+	if errors.Is(err, db.ErrWebhookDeliveryNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No dead webhook delivery with this ID",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retry webhook delivery",
+		})
+	}
+
+	if jobs != nil {
+		jobs.wakeWebhooks()
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"id":      id,
+	})
+}
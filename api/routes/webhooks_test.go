@@ -0,0 +1,223 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keif/image-optimizer/db"
+	"github.com/keif/image-optimizer/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, webhookBackoff(1))
+	assert.Equal(t, time.Minute, webhookBackoff(2))
+	assert.Equal(t, 4*time.Minute, webhookBackoff(4))
+	assert.Equal(t, webhookMaxBackoff, webhookBackoff(20))
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	originalDomains := allowedDomains
+	allowedDomains = []string{"93.184.216.34"} // An IP literal, so no DNS lookup is needed
+	defer func() { allowedDomains = originalDomains }()
+	_ = os.Unsetenv("WEBHOOK_SECRET")
+
+	keyID := 1
+	assert.NoError(t, validateCallbackURL("https://93.184.216.34/done", &keyID))
+	for _, rawURL := range []string{"ftp://93.184.216.34/x", "/relative", "https://1.1.1.1/hook", "http://169.254.169.254/latest"} {
+		assert.Error(t, validateCallbackURL(rawURL, &keyID), rawURL)
+	}
+
+	// Callbacks must resolve to public addresses even without an allowlist
+	allowedDomains = []string{}
+	assert.NoError(t, validateCallbackURL("https://93.184.216.34/done", &keyID))
+	assert.NoError(t, validateCallbackURL("https://100.128.0.1/done", &keyID)) // Just past 100.64.0.0/10
+	for _, rawURL := range []string{
+		"http://127.0.0.1:8080/hook", "http://10.0.0.5/hook", "http://169.254.169.254/latest", "http://[::1]/hook",
+		"http://0.0.0.0:8080/", "http://[::]:8080/", "http://100.64.0.1/hook", "http://100.127.255.254/hook",
+		"http://224.0.0.1/hook", "http://[ff02::1]/hook",
+	} {
+		assert.Error(t, validateCallbackURL(rawURL, &keyID), rawURL)
+	}
+
+	// Without an API key there is no per-key secret to sign with
+	assert.Error(t, validateCallbackURL("https://93.184.216.34/hook", nil))
+	_ = os.Setenv("WEBHOOK_SECRET", "whsec")
+	defer func() { _ = os.Unsetenv("WEBHOOK_SECRET") }()
+	assert.NoError(t, validateCallbackURL("https://93.184.216.34/hook", nil))
+}
+
+func TestJobWebhooks(t *testing.T) {
+	originalDomains := allowedDomains
+	originalBase := webhookRetryBase
+	allowedDomains = []string{}
+	webhookRetryBase = 10 * time.Millisecond
+	_ = os.Setenv("WEBHOOK_SECRET", "whsec")
+	_ = os.Setenv("WEBHOOK_MAX_ATTEMPTS", "3")
+	_ = os.Setenv("ALLOW_PRIVATE_IPS", "true") // The receiver listens on localhost
+	_ = os.Setenv("ADMIN_API_KEY_IDS", "99")
+	const adminKey = "99"
+	defer func() {
+		_ = os.Unsetenv("ADMIN_API_KEY_IDS")
+		allowedDomains = originalDomains
+		webhookRetryBase = originalBase
+		_ = os.Unsetenv("WEBHOOK_SECRET")
+		_ = os.Unsetenv("WEBHOOK_MAX_ATTEMPTS")
+		_ = os.Unsetenv("ALLOW_PRIVATE_IPS")
+	}()
+
+	app := setupJobTestApp(t)
+
+	// The receiver fails every first attempt, and always fails for /dead
+	var mu sync.Mutex
+	seen := map[string]int{}
+	var payloads []WebhookPayload
+	var keySecret string // Signs the callbacks of jobs created with an API key
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get("X-Webhook-Timestamp"), 10, 64)
+		secret := "whsec"
+		if r.URL.Path == "/keyed" {
+			mu.Lock()
+			secret = keySecret
+			mu.Unlock()
+		}
+		if !services.VerifyWebhook(secret, timestamp, body, r.Header.Get("X-Webhook-Signature")) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		seen[r.URL.Path]++
+		if r.URL.Path == "/dead" || seen[r.URL.Path] == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var payload WebhookPayload
+		_ = json.Unmarshal(body, &payload)
+		payloads = append(payloads, payload)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	waitForDelivery := func(t *testing.T, status string) db.WebhookDelivery {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			deliveries, err := db.ListWebhookDeliveries(status, 10)
+			require.NoError(t, err)
+			if len(deliveries) > 0 {
+				return deliveries[0]
+			}
+			require.True(t, time.Now().Before(deadline), "no %s delivery", status)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	status, _, data := sendJobRequest(t, app, "POST", "/jobs?type=echo&msg=hi&callbackUrl="+receiver.URL+"/ok", "", "text=x")
+	require.Equal(t, 202, status, string(data))
+	var job JobResponse
+	require.NoError(t, json.Unmarshal(data, &job))
+
+	delivered := waitForDelivery(t, db.WebhookDelivered)
+	assert.Equal(t, job.ID, delivered.JobID)
+	assert.Equal(t, 2, delivered.Attempts, "first attempt fails, the retry succeeds")
+	mu.Lock()
+	require.Len(t, payloads, 1)
+	assert.Equal(t, webhookJobCompleted, payloads[0].Event)
+	assert.Equal(t, db.JobCompleted, payloads[0].Job.Status)
+	assert.Equal(t, "/jobs/"+job.ID+"/result", payloads[0].Job.ResultURL)
+	mu.Unlock()
+
+	// The callback URL is not forwarded to the job's endpoint
+	_, _, result := sendJobRequest(t, app, "GET", "/jobs/"+job.ID+"/result", "", "")
+	assert.Equal(t, "hi:x:0", string(result))
+
+	status, _, data = sendJobRequest(t, app, "GET", "/admin/webhooks/"+strconv.FormatInt(delivered.ID, 10), adminKey, "")
+	require.Equal(t, 200, status)
+	var detail db.WebhookDelivery
+	require.NoError(t, json.Unmarshal(data, &detail))
+	require.Len(t, detail.AttemptLog, 2)
+	assert.Equal(t, 500, detail.AttemptLog[0].StatusCode)
+	assert.Equal(t, 204, detail.AttemptLog[1].StatusCode)
+
+	// Exhausted retries leave a dead letter, which can be retried
+	status, _, data = sendJobRequest(t, app, "POST", "/jobs?type=echo&callbackUrl="+receiver.URL+"/dead", "", "text=x")
+	require.Equal(t, 202, status, string(data))
+	dead := waitForDelivery(t, db.WebhookDead)
+	assert.Equal(t, 3, dead.Attempts)
+	assert.Equal(t, "callback returned status 500", dead.LastError)
+
+	status, _, data = sendJobRequest(t, app, "GET", "/admin/webhooks?status=dead", adminKey, "")
+	require.Equal(t, 200, status)
+	var list struct {
+		Deliveries []db.WebhookDelivery `json:"deliveries"`
+	}
+	require.NoError(t, json.Unmarshal(data, &list))
+	assert.Len(t, list.Deliveries, 1)
+
+	// Deliveries hold every client's callback URLs and payloads, so other keys
+	// cannot see or retry them
+	deadPath := "/admin/webhooks/" + strconv.FormatInt(dead.ID, 10)
+	for _, key := range []string{"", "2"} {
+		for _, request := range [][2]string{{"GET", "/admin/webhooks"}, {"GET", deadPath}, {"POST", deadPath + "/retry"}} {
+			status, _, _ = sendJobRequest(t, app, request[0], request[1], key, "")
+			assert.Equal(t, 403, status, "%s %s with key %q", request[0], request[1], key)
+		}
+	}
+
+	status, _, _ = sendJobRequest(t, app, "POST", deadPath+"/retry", adminKey, "")
+	assert.Equal(t, 200, status)
+	status, _, _ = sendJobRequest(t, app, "POST", "/admin/webhooks/"+strconv.FormatInt(delivered.ID, 10)+"/retry", adminKey, "")
+	assert.Equal(t, 404, status, "only dead deliveries can be retried")
+	status, _, _ = sendJobRequest(t, app, "GET", "/admin/webhooks?status=bogus", adminKey, "")
+	assert.Equal(t, 400, status)
+
+	// Jobs created with an API key are signed with the key's webhook secret,
+	// not its URL signing secret
+	key, err := db.CreateAPIKey("webhooks")
+	require.NoError(t, err)
+	keyID := strconv.Itoa(key.ID)
+	status, _, _ = sendJobRequest(t, app, "POST", "/jobs/webhook-secret", "", "")
+	assert.Equal(t, 401, status)
+	status, _, data = sendJobRequest(t, app, "POST", "/jobs/webhook-secret", keyID, "")
+	require.Equal(t, 200, status, string(data))
+	var rotated map[string]string
+	require.NoError(t, json.Unmarshal(data, &rotated))
+	signingSecret, err := db.EnsureSigningSecret(key.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, signingSecret, rotated["secret"])
+	mu.Lock()
+	keySecret = rotated["secret"]
+	mu.Unlock()
+
+	status, _, data = sendJobRequest(t, app, "POST", "/jobs?type=echo&callbackUrl="+receiver.URL+"/keyed", keyID, "text=x")
+	require.Equal(t, 202, status, string(data))
+	require.NoError(t, json.Unmarshal(data, &job))
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		deliveries, err := db.ListWebhookDeliveries(db.WebhookDelivered, 10)
+		require.NoError(t, err)
+		if len(deliveries) > 0 && deliveries[0].JobID == job.ID {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "keyed callback not delivered")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Callback URLs are validated when the job is created
+	status, _, _ = sendJobRequest(t, app, "POST", "/jobs?type=echo&callbackUrl=ftp://example.com/x", "", "text=x")
+	assert.Equal(t, 400, status)
+	_ = os.Unsetenv("ALLOW_PRIVATE_IPS")
+	status, _, _ = sendJobRequest(t, app, "POST", "/jobs?type=echo&callbackUrl="+receiver.URL+"/ok", "", "text=x")
+	assert.Equal(t, 403, status, "callbacks to private addresses are rejected")
+}
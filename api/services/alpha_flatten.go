@@ -0,0 +1,249 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// Background and alpha compositing options for flattening transparent images
+const (
+	// BackgroundAuto picks the average color of the image's visible edge pixels
+	BackgroundAuto = "auto"
+
+	// DefaultBackground is used when transparency must be discarded and no background was requested
+	DefaultBackground = "#ffffff"
+
+	// AlphaCompositingStraight blends color * alpha over the background (color channels are unassociated)
+	AlphaCompositingStraight = "straight"
+
+	// AlphaCompositingPremultiplied treats color channels as already multiplied by alpha,
+	// which avoids dark fringes on images exported with premultiplied alpha
+	AlphaCompositingPremultiplied = "premultiplied"
+)
+
+// namedBackgrounds are accepted in addition to hex colors
+var namedBackgrounds = map[string]color.RGBA{
+	"white": {255, 255, 255, 255},
+	"black": {0, 0, 0, 255},
+}
+
+// flattenResult describes what flattenAlpha did
+type flattenResult struct {
+	TransparencyUsed bool   // False if every pixel was already opaque (nothing was discarded)
+	Background       string // Resolved background as #rrggbb
+}
+
+// ValidateBackground reports whether value is "auto", a named color or a hex color
+func ValidateBackground(value string) error {
+	if strings.EqualFold(value, BackgroundAuto) {
+		return nil
+	}
+	_, err := parseBackgroundColor(value)
+	return err
+}
+
+// IsValidAlphaCompositing reports whether mode is a supported compositing mode
+func IsValidAlphaCompositing(mode string) bool {
+	return mode == AlphaCompositingStraight || mode == AlphaCompositingPremultiplied
+}
+
+// parseBackgroundColor parses #rgb, #rrggbb (with or without #) or a named color
+func parseBackgroundColor(value string) (color.RGBA, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if named, ok := namedBackgrounds[value]; ok {
+		return named, nil
+	}
+
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid background color %q", value)
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid background color %q", value)
+	}
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 255}, nil
+}
+
+// formatSupportsAlpha reports whether the output format can store transparency
+func formatSupportsAlpha(format bimg.ImageType) bool {
+	switch format {
+	case bimg.JPEG:
+		return false
+	default:
+		return true
+	}
+}
+
+// flattenAlpha composites a transparent image onto a solid background and returns
+// an opaque PNG for the rest of the pipeline. libvips' own flattening uses a fixed
+// background and straight alpha only, so compositing is done here instead.
+// If no pixel is actually transparent the returned buffer is nil.
+func flattenAlpha(buffer []byte, background, compositing string) ([]byte, *flattenResult, error) {
+	// Normalize to PNG first so every input format (and EXIF orientation) is handled by libvips
+	pngData, err := bimg.NewImage(buffer).Process(bimg.Options{
+		Type:        bimg.PNG,
+		Compression: 1, // Decoded immediately, favor speed
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to convert image for flattening: %w", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode image for flattening: %w", err)
+	}
+
+	result := &flattenResult{TransparencyUsed: hasTransparency(img)}
+	if !result.TransparencyUsed {
+		return nil, result, nil
+	}
+
+	var bg color.RGBA
+	switch {
+	case background == "":
+		bg, _ = parseBackgroundColor(DefaultBackground)
+	case strings.EqualFold(background, BackgroundAuto):
+		bg = edgeAverageColor(img)
+	default:
+		if bg, err = parseBackgroundColor(background); err != nil {
+			return nil, nil, err
+		}
+	}
+	result.Background = fmt.Sprintf("#%02x%02x%02x", bg.R, bg.G, bg.B)
+
+	flattened := compositeOver(img, bg, compositing == AlphaCompositingPremultiplied)
+
+	var out bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&out, flattened); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode flattened image: %w", err)
+	}
+	return out.Bytes(), result, nil
+}
+
+// hasTransparency reports whether any pixel has alpha below fully opaque
+func hasTransparency(img image.Image) bool {
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		for i := 3; i < len(nrgba.Pix); i += 4 {
+			if nrgba.Pix[i] != 0xff {
+				return true
+			}
+		}
+		return false
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// edgeAverageColor averages the outermost ring of pixels, weighted by alpha so fully
+// transparent edge pixels (whose color is meaningless) do not contribute.
+// Falls back to the default background if every edge pixel is transparent.
+func edgeAverageColor(img image.Image) color.RGBA {
+	bounds := img.Bounds()
+	var sumR, sumG, sumB, sumA uint64
+
+	add := func(x, y int) {
+		c := storedNRGBA64(img, x, y)
+		a := uint64(c.A)
+		sumR += uint64(c.R) * a
+		sumG += uint64(c.G) * a
+		sumB += uint64(c.B) * a
+		sumA += a
+	}
+
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		add(x, bounds.Min.Y)
+		if bounds.Dy() > 1 {
+			add(x, bounds.Max.Y-1)
+		}
+	}
+	for y := bounds.Min.Y + 1; y < bounds.Max.Y-1; y++ {
+		add(bounds.Min.X, y)
+		if bounds.Dx() > 1 {
+			add(bounds.Max.X-1, y)
+		}
+	}
+
+	if sumA == 0 {
+		bg, _ := parseBackgroundColor(DefaultBackground)
+		return bg
+	}
+	return color.RGBA{
+		R: uint8((sumR / sumA) >> 8),
+		G: uint8((sumG / sumA) >> 8),
+		B: uint8((sumB / sumA) >> 8),
+		A: 255,
+	}
+}
+
+// compositeOver blends the image onto an opaque background color.
+// Straight: out = c*a + bg*(1-a). Premultiplied: out = c + bg*(1-a), clamped.
+func compositeOver(img image.Image, bg color.RGBA, premultiplied bool) *image.RGBA {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	blend := func(c, a, b uint32) uint8 {
+		// c and a are 16-bit, b is 8-bit; result is 8-bit
+		var v uint32
+		if premultiplied {
+			v = c + b*0x101*(0xffff-a)/0xffff
+		} else {
+			v = (c*a + b*0x101*(0xffff-a)) / 0xffff
+		}
+		if v > 0xffff {
+			v = 0xffff
+		}
+		return uint8(v >> 8)
+	}
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := storedNRGBA64(img, bounds.Min.X+x, bounds.Min.Y+y)
+			a := uint32(c.A)
+			out.Pix[out.PixOffset(x, y)+0] = blend(uint32(c.R), a, uint32(bg.R))
+			out.Pix[out.PixOffset(x, y)+1] = blend(uint32(c.G), a, uint32(bg.G))
+			out.Pix[out.PixOffset(x, y)+2] = blend(uint32(c.B), a, uint32(bg.B))
+			out.Pix[out.PixOffset(x, y)+3] = 0xff
+		}
+	}
+	return out
+}
+
+// storedNRGBA64 returns the channel values as stored in the file. PNG decodes to
+// NRGBA/NRGBA64, which are read directly: converting through the premultiplied
+// color.Color interface would lose the color of fully transparent pixels.
+func storedNRGBA64(img image.Image, x, y int) color.NRGBA64 {
+	switch src := img.(type) {
+	case *image.NRGBA:
+		c := src.NRGBAAt(x, y)
+		return color.NRGBA64{
+			R: uint16(c.R) * 0x101,
+			G: uint16(c.G) * 0x101,
+			B: uint16(c.B) * 0x101,
+			A: uint16(c.A) * 0x101,
+		}
+	case *image.NRGBA64:
+		return src.NRGBA64At(x, y)
+	default:
+		return color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+	}
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseBackgroundColor(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected color.RGBA
+		wantErr  bool
+	}{
+		{"#ffffff", color.RGBA{255, 255, 255, 255}, false},
+		{"FF8000", color.RGBA{255, 128, 0, 255}, false},
+		{"#0f0", color.RGBA{0, 255, 0, 255}, false},
+		{"black", color.RGBA{0, 0, 0, 255}, false},
+		{"#12345", color.RGBA{}, true},
+		{"#gggggg", color.RGBA{}, true},
+		{"purple", color.RGBA{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseBackgroundColor(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBackgroundColor(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("parseBackgroundColor(%q) = %v, expected %v", tt.input, got, tt.expected)
+		}
+	}
+
+	if err := ValidateBackground("auto"); err != nil {
+		t.Errorf("Expected auto to be a valid background, got %v", err)
+	}
+}
+
+func TestCompositeOver_Straight(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{200, 100, 0, 255}) // Opaque - unchanged
+	img.SetNRGBA(1, 0, color.NRGBA{200, 100, 0, 128}) // Half transparent - blended
+
+	out := compositeOver(img, color.RGBA{0, 0, 255, 255}, false)
+
+	if got := out.RGBAAt(0, 0); got != (color.RGBA{200, 100, 0, 255}) {
+		t.Errorf("Expected opaque pixel unchanged, got %v", got)
+	}
+	got := out.RGBAAt(1, 0)
+	if !near(got.R, 100) || !near(got.G, 50) || !near(got.B, 127) || got.A != 255 {
+		t.Errorf("Expected ~{100 50 127 255}, got %v", got)
+	}
+}
+
+func TestCompositeOver_Premultiplied(t *testing.T) {
+	// Color already multiplied by alpha: 50% of (200,100,0) is (100,50,0)
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{100, 50, 0, 128})
+
+	got := compositeOver(img, color.RGBA{0, 0, 255, 255}, true).RGBAAt(0, 0)
+	if !near(got.R, 100) || !near(got.G, 50) || !near(got.B, 127) {
+		t.Errorf("Expected ~{100 50 127}, got %v", got)
+	}
+
+	// The same pixel composited as straight alpha comes out darker (the halo problem)
+	straight := compositeOver(img, color.RGBA{0, 0, 255, 255}, false).RGBAAt(0, 0)
+	if straight.R >= got.R {
+		t.Errorf("Expected straight compositing of premultiplied data to be darker, got %v vs %v", straight, got)
+	}
+}
+
+func TestEdgeAverageColor(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{0, 200, 0, 255})
+		}
+	}
+	img.SetNRGBA(1, 1, color.NRGBA{255, 0, 0, 255}) // Interior pixel is ignored
+	img.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 0})     // Transparent edge pixel is ignored
+
+	if got := edgeAverageColor(img); got != (color.RGBA{0, 200, 0, 255}) {
+		t.Errorf("Expected edge color {0 200 0 255}, got %v", got)
+	}
+}
+
+func TestEdgeAverageColor_TransparentEdges(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 3))
+	img.SetNRGBA(1, 1, color.NRGBA{255, 0, 0, 255})
+
+	if got := edgeAverageColor(img); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("Expected default white for fully transparent edges, got %v", got)
+	}
+}
+
+func TestHasTransparency(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{10, 20, 30, 255})
+		}
+	}
+	if hasTransparency(img) {
+		t.Error("Expected fully opaque image to report no transparency")
+	}
+
+	img.SetNRGBA(1, 1, color.NRGBA{10, 20, 30, 254})
+	if !hasTransparency(img) {
+		t.Error("Expected transparency to be detected")
+	}
+}
+
+// near reports whether an 8-bit channel is within rounding distance of the expected value
+func near(got, expected uint8) bool {
+	diff := int(got) - int(expected)
+	return diff >= -1 && diff <= 1
+}
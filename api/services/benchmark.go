@@ -0,0 +1,368 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+// MaxBenchmarkCells caps the size of a benchmark matrix (every cell is a full encode)
+const MaxBenchmarkCells = 60
+
+// memorySampleInterval is how often memory is polled while a cell is encoding
+const memorySampleInterval = 5 * time.Millisecond
+
+// Default benchmark matrix
+var (
+	DefaultBenchmarkFormats   = []string{"jpeg", "webp", "avif", "png"}
+	DefaultBenchmarkQualities = []int{60, 75, 90}
+	DefaultBenchmarkEfforts   = []int{1, 4, 6}
+)
+
+// ErrBenchmarkTooLarge is returned when the requested matrix exceeds MaxBenchmarkCells
+var ErrBenchmarkTooLarge = errors.New("benchmark matrix too large")
+
+// benchmarkMutex serializes benchmark runs so timings and memory peaks are not skewed by each other
+var benchmarkMutex sync.Mutex
+
+// BenchmarkOptions configures the format x quality x effort matrix
+type BenchmarkOptions struct {
+	Formats   []string
+	Qualities []int
+	Efforts   []int
+}
+
+// BenchmarkCell is the result of encoding the image with one combination of settings
+type BenchmarkCell struct {
+	Format       string  `json:"format"`
+	Quality      int     `json:"quality"`      // 0 for PNG (lossless, quality not varied)
+	Effort       int     `json:"effort"`       // 0 for JPEG (no effort setting)
+	Size         int64   `json:"size"`         // Output size in bytes
+	Savings      float64 `json:"savings"`      // Percentage saved versus the original
+	EncodeTimeMs float64 `json:"encodeTimeMs"` // Wall-clock time of the full optimization pipeline
+	PeakMemory   int64   `json:"peakMemory"`   // Peak process memory growth in bytes while the cell ran (see MemoryScope)
+	SSIM         float64 `json:"ssim"`         // Structural similarity to the original (1.0 = identical)
+	// AlreadyOptimized is set when re-encoding in the source format came out larger and the
+	// pipeline returned the original instead, exactly as /optimize would
+	AlreadyOptimized bool   `json:"alreadyOptimized,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// BenchmarkResult holds every cell of a benchmark run
+type BenchmarkResult struct {
+	Format       string          `json:"format"`
+	Width        int             `json:"width"`
+	Height       int             `json:"height"`
+	OriginalSize int64           `json:"originalSize"`
+	Cells        []BenchmarkCell `json:"cells"`
+	TotalTimeMs  float64         `json:"totalTimeMs"`
+	// MemoryScope says what peakMemory measures: always BenchmarkMemoryProcess,
+	// since Go heap and libvips usage can only be read process-wide
+	MemoryScope string `json:"memoryScope"`
+}
+
+// BenchmarkMemoryProcess marks peakMemory figures as whole-process approximations:
+// requests running alongside a cell are counted in its figure
+const BenchmarkMemoryProcess = "process"
+
+// benchmarkCells expands the options into the list of cells to run.
+// JPEG has no effort setting and PNG output is lossless, so those axes are
+// collapsed instead of encoding identical outputs several times.
+func benchmarkCells(options BenchmarkOptions) []BenchmarkCell {
+	formats := options.Formats
+	if len(formats) == 0 {
+		formats = DefaultBenchmarkFormats
+	}
+	qualities := options.Qualities
+	if len(qualities) == 0 {
+		qualities = DefaultBenchmarkQualities
+	}
+	efforts := options.Efforts
+	if len(efforts) == 0 {
+		efforts = DefaultBenchmarkEfforts
+	}
+
+	cells := make([]BenchmarkCell, 0)
+	for _, format := range formats {
+		formatQualities := qualities
+		if format == "png" {
+			formatQualities = []int{0}
+		}
+		formatEfforts := efforts
+		if format == "jpeg" {
+			formatEfforts = []int{0}
+		}
+		for _, quality := range formatQualities {
+			for _, effort := range formatEfforts {
+				cells = append(cells, BenchmarkCell{Format: format, Quality: quality, Effort: effort})
+			}
+		}
+	}
+	return cells
+}
+
+// CountBenchmarkCells returns how many encodes the given options will run
+func CountBenchmarkCells(options BenchmarkOptions) int {
+	return len(benchmarkCells(options))
+}
+
+// RunBenchmark encodes the image once per cell of the format x quality x effort
+// matrix using the same pipeline as OptimizeImage, and reports size, encode time,
+// peak memory and SSIM against the original for each cell.
+// Cells run sequentially so their timings are comparable.
+func RunBenchmark(buffer []byte, options BenchmarkOptions) (*BenchmarkResult, error) {
+	startTime := time.Now()
+
+	cells := benchmarkCells(options)
+	if len(cells) > MaxBenchmarkCells {
+		return nil, fmt.Errorf("%w: %d cells (max %d)", ErrBenchmarkTooLarge, len(cells), MaxBenchmarkCells)
+	}
+
+	metadata, err := bimg.NewImage(buffer).Metadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image metadata: %w", err)
+	}
+
+	reference, err := decodeGray(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode reference image: %w", err)
+	}
+
+	benchmarkMutex.Lock()
+	defer benchmarkMutex.Unlock()
+
+	result := &BenchmarkResult{
+		Format:       metadata.Type,
+		Width:        metadata.Size.Width,
+		Height:       metadata.Size.Height,
+		OriginalSize: int64(len(buffer)),
+		MemoryScope:  BenchmarkMemoryProcess,
+	}
+
+	for i := range cells {
+		runBenchmarkCell(buffer, reference, &cells[i])
+	}
+
+	result.Cells = cells
+	result.TotalTimeMs = durationMs(time.Since(startTime))
+	return result, nil
+}
+
+// runBenchmarkCell encodes the image with the cell's settings and fills in its measurements
+func runBenchmarkCell(buffer []byte, reference *image.Gray, cell *BenchmarkCell) {
+	options := OptimizeOptions{
+		Format:  getImageTypeFromString(cell.Format),
+		Quality: cell.Quality,
+		Effort:  cell.Effort,
+	}
+
+	// Drop cached operations so every cell decodes the source from scratch
+	bimg.VipsCacheDropAll()
+
+	var optimized *OptimizeResult
+	var encodeErr error
+	var elapsed time.Duration
+	cell.PeakMemory = measurePeakMemory(func() {
+		encodeStart := time.Now()
+		optimized, encodeErr = OptimizeImage(buffer, options)
+		elapsed = time.Since(encodeStart)
+	})
+	cell.EncodeTimeMs = durationMs(elapsed)
+	if encodeErr != nil {
+		cell.Error = encodeErr.Error()
+		return
+	}
+
+	cell.Size = optimized.OptimizedSize
+	cell.AlreadyOptimized = optimized.AlreadyOptimized
+	cell.Savings = float64(optimized.OriginalSize-optimized.OptimizedSize) / float64(optimized.OriginalSize) * 100
+
+	output, err := decodeGray(optimized.OptimizedImage)
+	if err != nil {
+		cell.Error = "failed to decode output for SSIM: " + err.Error()
+		return
+	}
+	ssim, err := ComputeSSIM(reference, output)
+	if err != nil {
+		cell.Error = err.Error()
+		return
+	}
+	cell.SSIM = ssim
+}
+
+// measurePeakMemory runs fn while polling process memory and returns the peak
+// growth over the starting level. libvips allocations are not visible to the Go
+// runtime, so its tracked memory is added to the Go heap. Both are process-wide,
+// so anything else running at the same time is included; memory used by external
+// post-processors (oxipng, cjpeg) is not.
+func measurePeakMemory(fn func()) int64 {
+	runtime.GC()
+	baseline := currentMemory()
+	peak := baseline
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(memorySampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// peak is only read again after wg.Wait, so no lock is needed
+				if current := currentMemory(); current > peak {
+					peak = current
+				}
+			}
+		}
+	}()
+
+	fn()
+	close(done)
+	wg.Wait()
+
+	if current := currentMemory(); current > peak {
+		peak = current
+	}
+	if peak < baseline {
+		return 0
+	}
+	return peak - baseline
+}
+
+// currentMemory returns the Go heap plus libvips tracked memory in bytes
+func currentMemory() int64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.HeapAlloc) + bimg.VipsMemory().Memory
+}
+
+// decodeGray decodes any libvips-supported image into an 8-bit grayscale image
+func decodeGray(buffer []byte) (*image.Gray, error) {
+	pngData, err := bimg.NewImage(buffer).Process(bimg.Options{
+		Type:           bimg.PNG,
+		Interpretation: bimg.InterpretationBW,
+		Compression:    1, // Decoded immediately, favor speed
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		return nil, err
+	}
+	if gray, ok := img.(*image.Gray); ok {
+		return gray, nil
+	}
+
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray.SetGray(x, y, color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray))
+		}
+	}
+	return gray, nil
+}
+
+// ComputeSSIM returns the mean structural similarity of two equally sized grayscale
+// images, computed over 8x8 windows with a stride of 4 pixels
+func ComputeSSIM(a, b *image.Gray) (float64, error) {
+	width, height := a.Bounds().Dx(), a.Bounds().Dy()
+	if width != b.Bounds().Dx() || height != b.Bounds().Dy() {
+		return 0, fmt.Errorf("cannot compare %dx%d image with %dx%d image", width, height, b.Bounds().Dx(), b.Bounds().Dy())
+	}
+
+	const (
+		window = 8
+		stride = 4
+		c1     = (0.01 * 255) * (0.01 * 255)
+		c2     = (0.03 * 255) * (0.03 * 255)
+	)
+
+	// Images smaller than one window are compared as a single window
+	windowW, windowH := minInt(window, width), minInt(window, height)
+	if windowW == 0 || windowH == 0 {
+		return 0, fmt.Errorf("cannot compare empty images")
+	}
+	n := float64(windowW * windowH)
+
+	var total float64
+	var count int
+	for y := 0; y+windowH <= height; y += stride {
+		for x := 0; x+windowW <= width; x += stride {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for wy := 0; wy < windowH; wy++ {
+				rowA := a.Pix[(y+wy)*a.Stride+x:]
+				rowB := b.Pix[(y+wy)*b.Stride+x:]
+				for wx := 0; wx < windowW; wx++ {
+					pa, pb := float64(rowA[wx]), float64(rowB[wx])
+					sumA += pa
+					sumB += pb
+					sumAA += pa * pa
+					sumBB += pb * pb
+					sumAB += pa * pb
+				}
+			}
+
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			covariance := sumAB/n - meanA*meanB
+
+			total += ((2*meanA*meanB + c1) * (2*covariance + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			count++
+		}
+	}
+
+	return total / float64(count), nil
+}
+
+// WriteCSV writes one row per benchmark cell with a header row
+func (r *BenchmarkResult) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"format", "quality", "effort", "size", "savings", "encodeTimeMs", "peakMemory", "ssim", "error"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, cell := range r.Cells {
+		record := []string{
+			cell.Format,
+			strconv.Itoa(cell.Quality),
+			strconv.Itoa(cell.Effort),
+			strconv.FormatInt(cell.Size, 10),
+			strconv.FormatFloat(cell.Savings, 'f', 2, 64),
+			strconv.FormatFloat(cell.EncodeTimeMs, 'f', 2, 64),
+			strconv.FormatInt(cell.PeakMemory, 10),
+			strconv.FormatFloat(cell.SSIM, 'f', 4, 64),
+			cell.Error,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// durationMs converts a duration to fractional milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// toGray converts a test image to *image.Gray
+func toGray(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	gray := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray.Set(x, y, img.At(x, y))
+		}
+	}
+	return gray
+}
+
+func TestComputeSSIM_Identical(t *testing.T) {
+	img := toGray(createGradientImage(64, 48))
+
+	ssim, err := ComputeSSIM(img, img)
+	if err != nil {
+		t.Fatalf("ComputeSSIM failed: %v", err)
+	}
+	if math.Abs(ssim-1) > 1e-9 {
+		t.Errorf("Expected SSIM 1.0 for identical images, got %f", ssim)
+	}
+}
+
+func TestComputeSSIM_Degraded(t *testing.T) {
+	original := toGray(createGradientImage(64, 64))
+
+	// Add mild and heavy noise - heavier distortion must score lower
+	noisy := func(amplitude int) *image.Gray {
+		out := image.NewGray(original.Bounds())
+		copy(out.Pix, original.Pix)
+		for i := range out.Pix {
+			delta := amplitude
+			if i%2 == 0 {
+				delta = -amplitude
+			}
+			out.Pix[i] = uint8(max(0, min(255, int(out.Pix[i])+delta)))
+		}
+		return out
+	}
+
+	mild, err := ComputeSSIM(original, noisy(4))
+	if err != nil {
+		t.Fatalf("ComputeSSIM failed: %v", err)
+	}
+	heavy, err := ComputeSSIM(original, noisy(40))
+	if err != nil {
+		t.Fatalf("ComputeSSIM failed: %v", err)
+	}
+
+	if mild >= 1 || heavy >= mild {
+		t.Errorf("Expected 1 > mild (%f) > heavy (%f)", mild, heavy)
+	}
+}
+
+func TestComputeSSIM_SizeMismatch(t *testing.T) {
+	a := image.NewGray(image.Rect(0, 0, 16, 16))
+	b := image.NewGray(image.Rect(0, 0, 16, 8))
+	if _, err := ComputeSSIM(a, b); err == nil {
+		t.Error("Expected error for mismatched dimensions")
+	}
+}
+
+func TestComputeSSIM_TinyImage(t *testing.T) {
+	a := image.NewGray(image.Rect(0, 0, 3, 2))
+	a.SetGray(1, 1, color.Gray{Y: 200})
+
+	ssim, err := ComputeSSIM(a, a)
+	if err != nil {
+		t.Fatalf("ComputeSSIM failed: %v", err)
+	}
+	if math.Abs(ssim-1) > 1e-9 {
+		t.Errorf("Expected SSIM 1.0, got %f", ssim)
+	}
+}
+
+func TestBenchmarkCells_CollapsesUnusedAxes(t *testing.T) {
+	cells := benchmarkCells(BenchmarkOptions{
+		Formats:   []string{"jpeg", "webp", "png"},
+		Qualities: []int{60, 80},
+		Efforts:   []int{2, 6},
+	})
+
+	// jpeg: 2 qualities x 1, webp: 2 x 2, png: 1 x 2
+	if len(cells) != 8 {
+		t.Fatalf("Expected 8 cells, got %d", len(cells))
+	}
+
+	for _, cell := range cells {
+		if cell.Format == "jpeg" && cell.Effort != 0 {
+			t.Errorf("Expected JPEG cells to have no effort, got %d", cell.Effort)
+		}
+		if cell.Format == "png" && cell.Quality != 0 {
+			t.Errorf("Expected PNG cells to have no quality, got %d", cell.Quality)
+		}
+	}
+}
+
+func TestBenchmarkCells_Defaults(t *testing.T) {
+	expected := len(DefaultBenchmarkQualities) + // jpeg
+		2*len(DefaultBenchmarkQualities)*len(DefaultBenchmarkEfforts) + // webp, avif
+		len(DefaultBenchmarkEfforts) // png
+
+	if got := CountBenchmarkCells(BenchmarkOptions{}); got != expected {
+		t.Errorf("Expected %d default cells, got %d", expected, got)
+	}
+	if expected > MaxBenchmarkCells {
+		t.Errorf("Default matrix (%d cells) exceeds MaxBenchmarkCells (%d)", expected, MaxBenchmarkCells)
+	}
+}
+
+func TestBenchmarkResult_WriteCSV(t *testing.T) {
+	result := &BenchmarkResult{
+		Cells: []BenchmarkCell{
+			{Format: "webp", Quality: 80, Effort: 4, Size: 1234, Savings: 55.5, EncodeTimeMs: 12.25, PeakMemory: 2048, SSIM: 0.98765},
+			{Format: "avif", Quality: 60, Effort: 1, Error: "encoder unavailable"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := result.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected header + 2 rows, got %d rows", len(records))
+	}
+	if records[0][0] != "format" || records[0][7] != "ssim" {
+		t.Errorf("Unexpected header: %v", records[0])
+	}
+	if records[1][3] != "1234" || records[1][7] != "0.9877" {
+		t.Errorf("Unexpected first row: %v", records[1])
+	}
+	if records[2][8] != "encoder unavailable" {
+		t.Errorf("Expected error column in second row, got %v", records[2])
+	}
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/h2non/bimg"
+)
+
+// HDR transfer functions reported in OptimizeResult.HDR
+const (
+	HDRPQ  = "pq"  // SMPTE ST 2084 perceptual quantizer (HDR10)
+	HDRHLG = "hlg" // ARIB STD-B67 hybrid log-gamma
+)
+
+// CICP code points (ITU-T H.273), shared by PNG cICP chunks and HEIF/AVIF nclx boxes
+const (
+	primariesBT2020 = 9
+	primariesP3D65  = 12
+
+	transferPQ  = 16
+	transferHLG = 18
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// colorEncoding describes how pixel values are stored, as read from the file
+// headers. libvips metadata exposes neither bit depth nor the transfer function.
+type colorEncoding struct {
+	BitDepth  int // Bits per channel
+	Primaries int // CICP color primaries (0 = unspecified)
+	Transfer  int // CICP transfer characteristics (0 = unspecified)
+}
+
+// hdr returns the HDR transfer function name, or "" for SDR images
+func (e colorEncoding) hdr() string {
+	switch e.Transfer {
+	case transferPQ:
+		return HDRPQ
+	case transferHLG:
+		return HDRHLG
+	}
+	return ""
+}
+
+// probeEncoding reads bit depth and CICP color signaling from PNG, JPEG and
+// HEIF/AVIF headers. GIF and WebP are always 8-bit; other formats fall back to
+// the libvips interpretation, which distinguishes 8-bit from 16-bit.
+func probeEncoding(buffer []byte) colorEncoding {
+	var encoding colorEncoding
+
+	switch {
+	case bytes.HasPrefix(buffer, pngSignature):
+		encoding = probePNG(buffer)
+	case bytes.HasPrefix(buffer, []byte{0xff, 0xd8}):
+		encoding = probeJPEG(buffer)
+	case len(buffer) >= 12 && string(buffer[4:8]) == "ftyp":
+		encoding = probeISOBMFF(buffer)
+	case bytes.HasPrefix(buffer, []byte("GIF8")),
+		len(buffer) >= 12 && string(buffer[:4]) == "RIFF" && string(buffer[8:12]) == "WEBP":
+		// Always 8 bits per channel
+		encoding.BitDepth = 8
+	}
+
+	if encoding.BitDepth == 0 {
+		encoding.BitDepth = 8
+		if interpretation, err := bimg.ImageInterpretation(buffer); err == nil &&
+			(interpretation == bimg.InterpretationRGB16 || interpretation == bimg.InterpretationGREY16) {
+			encoding.BitDepth = 16
+		}
+	}
+	return encoding
+}
+
+// probePNG reads the IHDR bit depth and the optional cICP chunk
+func probePNG(buffer []byte) colorEncoding {
+	var encoding colorEncoding
+
+	for offset := len(pngSignature); offset+8 <= len(buffer); {
+		length := int(binary.BigEndian.Uint32(buffer[offset:]))
+		chunkType := string(buffer[offset+4 : offset+8])
+		data := buffer[offset+8:]
+		if length < 0 || length > len(data) {
+			break
+		}
+		data = data[:length]
+
+		switch chunkType {
+		case "IHDR":
+			if len(data) >= 10 {
+				encoding.BitDepth = int(data[8])
+				// Palette images store 8-bit colors whatever the index depth
+				if data[9] == 3 {
+					encoding.BitDepth = 8
+				}
+			}
+		case "cICP":
+			if len(data) >= 2 {
+				encoding.Primaries = int(data[0])
+				encoding.Transfer = int(data[1])
+			}
+		case "IDAT", "IEND":
+			// Color chunks must precede the image data
+			return encoding
+		}
+
+		offset += 12 + length // length + type + data + CRC
+	}
+	return encoding
+}
+
+// probeJPEG reads the sample precision from the start-of-frame marker (8 or 12)
+func probeJPEG(buffer []byte) colorEncoding {
+	for offset := 2; offset+4 <= len(buffer); {
+		if buffer[offset] != 0xff {
+			break
+		}
+		marker := buffer[offset+1]
+		length := int(binary.BigEndian.Uint16(buffer[offset+2:]))
+
+		// SOF0-SOF15, except DHT (C4), JPG (C8) and DAC (CC)
+		if marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc {
+			if offset+4 < len(buffer) {
+				return colorEncoding{BitDepth: int(buffer[offset+4])}
+			}
+			break
+		}
+		if marker == 0xda { // Start of scan - no frame header found
+			break
+		}
+		offset += 2 + length
+	}
+	return colorEncoding{}
+}
+
+// probeISOBMFF walks the HEIF/AVIF item properties for pixel depth (pixi, av1C,
+// hvcC) and nclx color signaling (colr). With several images in the file (alpha
+// planes, thumbnails) the highest depth is reported.
+func probeISOBMFF(buffer []byte) colorEncoding {
+	var encoding colorEncoding
+	fromPixi := false
+
+	walkBoxes(buffer, func(boxType string, payload []byte) {
+		switch boxType {
+		case "pixi":
+			// version/flags (4), channel count (1), bits per channel...
+			if len(payload) >= 6 {
+				if !fromPixi {
+					encoding.BitDepth = 0
+					fromPixi = true
+				}
+				encoding.BitDepth = maxInt(encoding.BitDepth, int(payload[5]))
+			}
+		case "av1C":
+			if len(payload) >= 3 && !fromPixi {
+				depth := 8
+				if payload[2]&0x40 != 0 { // high_bitdepth
+					depth = 10
+					if payload[2]&0x20 != 0 { // twelve_bit
+						depth = 12
+					}
+				}
+				encoding.BitDepth = maxInt(encoding.BitDepth, depth)
+			}
+		case "hvcC":
+			if len(payload) >= 21 && !fromPixi {
+				encoding.BitDepth = maxInt(encoding.BitDepth, int(payload[20]&0x07)+8)
+			}
+		case "colr":
+			if len(payload) >= 8 && string(payload[:4]) == "nclx" && encoding.Transfer == 0 {
+				encoding.Primaries = int(binary.BigEndian.Uint16(payload[4:]))
+				encoding.Transfer = int(binary.BigEndian.Uint16(payload[6:]))
+			}
+		}
+	})
+	return encoding
+}
+
+// walkBoxes calls fn for every ISOBMFF box, descending into the containers that
+// hold HEIF item properties (meta > iprp > ipco)
+func walkBoxes(data []byte, fn func(boxType string, payload []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		boxType := string(data[4:8])
+		header := uint64(8)
+
+		switch size {
+		case 0: // Box extends to the end of the data
+			size = uint64(len(data))
+		case 1: // 64-bit size follows the type
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return
+		}
+
+		payload := data[header:size]
+		switch boxType {
+		case "meta":
+			// Full box: skip version and flags
+			if len(payload) >= 4 {
+				walkBoxes(payload[4:], fn)
+			}
+		case "iprp", "ipco":
+			walkBoxes(payload, fn)
+		default:
+			fn(boxType, payload)
+		}
+
+		data = data[size:]
+	}
+}
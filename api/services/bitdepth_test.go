@@ -0,0 +1,125 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// isoBox builds an ISOBMFF box
+func isoBox(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(out, uint32(8+len(body)))
+	copy(out[4:], boxType)
+	return append(out, body...)
+}
+
+// pngChunk builds a PNG chunk (the CRC is not checked by the probe)
+func pngChunk(chunkType string, data []byte) []byte {
+	out := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(out, uint32(len(data)))
+	copy(out[4:], chunkType)
+	out = append(out, data...)
+	return append(out, 0, 0, 0, 0)
+}
+
+func encodeTestPNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestProbeEncoding_PNG(t *testing.T) {
+	rect := image.Rect(0, 0, 4, 4)
+	palette := image.NewPaletted(rect, color.Palette{color.Black, color.White})
+
+	tests := []struct {
+		name     string
+		img      image.Image
+		expected int
+	}{
+		{"8-bit", image.NewNRGBA(rect), 8},
+		{"16-bit", image.NewNRGBA64(rect), 16},
+		{"16-bit gray", image.NewGray16(rect), 16},
+		{"palette", palette, 8},
+	}
+
+	for _, tt := range tests {
+		if got := probeEncoding(encodeTestPNG(t, tt.img)).BitDepth; got != tt.expected {
+			t.Errorf("%s: expected bit depth %d, got %d", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestProbeEncoding_PNGCICP(t *testing.T) {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], 1)
+	binary.BigEndian.PutUint32(ihdr[4:], 1)
+	ihdr[8] = 16 // bit depth
+	ihdr[9] = 2  // truecolor
+
+	data := append([]byte{}, pngSignature...)
+	data = append(data, pngChunk("IHDR", ihdr)...)
+	data = append(data, pngChunk("cICP", []byte{primariesBT2020, transferHLG, 0, 1})...)
+	data = append(data, pngChunk("IEND", nil)...)
+
+	encoding := probeEncoding(data)
+	if encoding.BitDepth != 16 || encoding.Primaries != primariesBT2020 || encoding.hdr() != HDRHLG {
+		t.Errorf("Expected 16-bit BT.2020 HLG, got %+v", encoding)
+	}
+}
+
+func TestProbeEncoding_JPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+
+	encoding := probeEncoding(buf.Bytes())
+	if encoding.BitDepth != 8 || encoding.hdr() != "" {
+		t.Errorf("Expected 8-bit SDR, got %+v", encoding)
+	}
+}
+
+func TestProbeEncoding_AVIF(t *testing.T) {
+	nclx := []byte("nclx\x00\x09\x00\x10\x00\x09\x80") // BT.2020 primaries, PQ transfer
+	av1C := []byte{0x81, 0x00, 0x60, 0x00}             // high_bitdepth + twelve_bit
+
+	build := func(properties ...[]byte) []byte {
+		ftyp := isoBox("ftyp", []byte("avif\x00\x00\x00\x00avifmif1"))
+		meta := isoBox("meta", []byte{0, 0, 0, 0}, isoBox("iprp", isoBox("ipco", properties...)))
+		return append(ftyp, meta...)
+	}
+
+	encoding := probeEncoding(build(isoBox("av1C", av1C), isoBox("colr", nclx)))
+	if encoding.BitDepth != 12 || encoding.hdr() != HDRPQ || encoding.Primaries != primariesBT2020 {
+		t.Errorf("Expected 12-bit BT.2020 PQ, got %+v", encoding)
+	}
+
+	// pixi is authoritative over the codec configuration
+	pixi := isoBox("pixi", []byte{0, 0, 0, 0, 3, 10, 10, 10})
+	if got := probeEncoding(build(isoBox("av1C", av1C), pixi)).BitDepth; got != 10 {
+		t.Errorf("Expected pixi bit depth 10, got %d", got)
+	}
+}
+
+func TestWalkBoxes_Truncated(t *testing.T) {
+	data := isoBox("ftyp", []byte("avif"))
+	data = append(data, 0, 0, 1, 0, 'm', 'e', 't', 'a') // Claims 256 bytes
+
+	var seen []string
+	walkBoxes(data, func(boxType string, _ []byte) {
+		seen = append(seen, boxType)
+	})
+	if len(seen) != 1 || seen[0] != "ftyp" {
+		t.Errorf("Expected only the complete ftyp box, got %v", seen)
+	}
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/image/bmp"
+)
+
+// IsBMP reports whether buffer starts with the BMP file signature
+func IsBMP(buffer []byte) bool {
+	return bytes.HasPrefix(buffer, []byte("BM"))
+}
+
+// BMPSize reads the dimensions from a BMP header without decoding the pixels,
+// for the decompression bomb check (libvips cannot read BMP metadata)
+func BMPSize(buffer []byte) (int, int, error) {
+	config, err := bmp.DecodeConfig(bytes.NewReader(buffer))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read BMP header: %w", err)
+	}
+	return config.Width, config.Height, nil
+}
+
+// bmpToPNG converts a BMP to a lossless PNG for the rest of the pipeline.
+// libvips only loads BMP through ImageMagick, which the server does not require.
+func bmpToPNG(buffer []byte) ([]byte, error) {
+	img, err := bmp.Decode(bytes.NewReader(buffer))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode BMP: %w", err)
+	}
+
+	pngData, err := encodePNGFast(img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert BMP: %w", err)
+	}
+	return pngData, nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/bmp"
+)
+
+func encodeTestBMP(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 10), G: uint8(y * 10), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := bmp.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode BMP: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBMPSize(t *testing.T) {
+	data := encodeTestBMP(t, 12, 7)
+	if !IsBMP(data) {
+		t.Fatal("Expected BMP signature to be detected")
+	}
+
+	width, height, err := BMPSize(data)
+	if err != nil {
+		t.Fatalf("BMPSize failed: %v", err)
+	}
+	if width != 12 || height != 7 {
+		t.Errorf("Expected 12x7, got %dx%d", width, height)
+	}
+
+	if _, _, err := BMPSize([]byte("BMnot really a bitmap")); err == nil {
+		t.Error("Expected error for truncated BMP header")
+	}
+}
+
+func TestBMPToPNG(t *testing.T) {
+	pngData, err := bmpToPNG(encodeTestBMP(t, 12, 7))
+	if err != nil {
+		t.Fatalf("bmpToPNG failed: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		t.Fatalf("Result is not a PNG: %v", err)
+	}
+	if img.Bounds().Dx() != 12 || img.Bounds().Dy() != 7 {
+		t.Errorf("Expected 12x7 PNG, got %v", img.Bounds())
+	}
+
+	// Lossless: pixels must survive the conversion
+	r, g, b, _ := img.At(3, 2).RGBA()
+	if r>>8 != 30 || g>>8 != 20 || b>>8 != 128 {
+		t.Errorf("Expected pixel (30,20,128), got (%d,%d,%d)", r>>8, g>>8, b>>8)
+	}
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+// competitionSSIMTolerance is how far an attempt's SSIM may fall below the default
+// encoding's and still meet the quality requirement. It absorbs rounding noise
+// between encodings that are visually identical.
+const competitionSSIMTolerance = 0.002
+
+// strategyDefault is the attempt that encodes the request as given
+const strategyDefault = "default"
+
+// EncoderAttempt reports one encoding tried by effort=max
+type EncoderAttempt struct {
+	Strategy     string  `json:"strategy"`
+	Size         int64   `json:"size"`
+	TimeMs       float64 `json:"timeMs"`
+	SSIM         float64 `json:"ssim,omitempty"`  // Structural similarity to the source at the output size
+	MeetsQuality bool    `json:"meetsQuality"`    // SSIM within tolerance of the default encoding
+	Selected     bool    `json:"selected"`        // The attempt that was returned
+	Error        string  `json:"error,omitempty"` // Why the attempt failed or could not be scored
+}
+
+// encoderStrategy is one way of encoding the image in the target format. apply
+// adjusts the request's options; encode, when set, replaces the normal pipeline.
+type encoderStrategy struct {
+	name   string
+	apply  func(*OptimizeOptions)
+	encode func(buffer []byte, options OptimizeOptions) (*OptimizeResult, error)
+}
+
+// competitionStrategies lists the encodings worth trying for a target format.
+// The default (the request as given) always comes first; strategies that would
+// leave the options unchanged are dropped by the caller.
+func competitionStrategies(format bimg.ImageType, sourceType string) []encoderStrategy {
+	strategies := []encoderStrategy{{name: strategyDefault, apply: func(*OptimizeOptions) {}}}
+
+	switch format {
+	case bimg.JPEG:
+		strategies = append(strategies,
+			encoderStrategy{name: "baseline", apply: func(o *OptimizeOptions) { o.Progressive = false }},
+			encoderStrategy{name: "progressive", apply: func(o *OptimizeOptions) { o.Progressive = true }},
+		)
+		if sourceType == "jpeg" {
+			// Lossless transcoding of the original coefficients (see transcodeJPEGLossless)
+			strategies = append(strategies,
+				encoderStrategy{name: "lossless-transcode", apply: func(o *OptimizeOptions) { o.LosslessMode = true }})
+		}
+	case bimg.PNG:
+		strategies = append(strategies,
+			encoderStrategy{name: "palette", apply: func(o *OptimizeOptions) { o.Palette = true }},
+			encoderStrategy{name: "palette-oxipng6", apply: func(o *OptimizeOptions) { o.Palette = true; o.OxipngLevel = 6 }},
+			// Lossless mode disables palette quantization and maximizes zlib and oxipng effort
+			encoderStrategy{name: "truecolor-oxipng6", apply: func(o *OptimizeOptions) { o.LosslessMode = true }},
+		)
+	case bimg.WEBP:
+		strategies = append(strategies,
+			encoderStrategy{name: "lossy-effort6", apply: func(o *OptimizeOptions) { o.Effort = 6 }},
+			encoderStrategy{name: "lossless", apply: func(o *OptimizeOptions) { o.Lossless = true; o.Effort = 6 }},
+			encoderStrategy{name: "near-lossless", apply: func(o *OptimizeOptions) { o.Lossless = true; o.Effort = 6 },
+				encode: encodeNearLosslessWebP},
+		)
+	case bimg.AVIF:
+		strategies = append(strategies,
+			encoderStrategy{name: "lossless", apply: func(o *OptimizeOptions) { o.Lossless = true }})
+	case bimg.TIFF:
+		strategies = append(strategies,
+			encoderStrategy{name: "deflate", apply: func(o *OptimizeOptions) { o.TIFFCompression = TIFFCompressionDeflate }},
+			encoderStrategy{name: "jpeg", apply: func(o *OptimizeOptions) { o.TIFFCompression = TIFFCompressionJPEG }},
+		)
+	}
+	return strategies
+}
+
+// optimizeCompetition implements effort=max: the image is encoded with every
+// strategy for the target format, and the smallest result whose SSIM to the
+// source is within competitionSSIMTolerance of the default encoding's is
+// returned. Every attempt is reported in OptimizeResult.Attempts.
+// Attempts run one after another, each through the full pipeline.
+func optimizeCompetition(buffer []byte, options OptimizeOptions) (*OptimizeResult, error) {
+	startTime := time.Now()
+	options.EffortMax = false
+
+	// Every attempt decodes the full image - keep huge inputs to a single pass
+	if int64(len(buffer)) > largeImageThreshold {
+		result, err := OptimizeImage(buffer, options)
+		if err != nil {
+			return nil, err
+		}
+		result.Warnings = append(result.Warnings, fmt.Sprintf(
+			"effort=max is not available for images over %d MB; a single encoding was used", largeImageThreshold/(1024*1024)))
+		return result, nil
+	}
+
+	sourceType := ""
+	if !IsBMP(buffer) {
+		sourceType = bimg.DetermineImageTypeName(buffer)
+	}
+	format := options.Format
+	if format == 0 {
+		format = getImageTypeFromString(sourceType)
+		if IsBMP(buffer) {
+			format = bimg.PNG
+		}
+	}
+
+	var reference *image.Gray
+	var results []*OptimizeResult
+	var attempts []EncoderAttempt
+	for _, strategy := range competitionStrategies(format, sourceType) {
+		attemptOptions := options
+		strategy.apply(&attemptOptions)
+		if strategy.name != strategyDefault && strategy.encode == nil && attemptOptions == options {
+			continue // Same as the default encoding
+		}
+
+		attemptStart := time.Now()
+		var result *OptimizeResult
+		var err error
+		if strategy.encode != nil {
+			result, err = strategy.encode(buffer, attemptOptions)
+		} else {
+			result, err = OptimizeImage(buffer, attemptOptions)
+		}
+		attempt := EncoderAttempt{Strategy: strategy.name, TimeMs: durationMs(time.Since(attemptStart))}
+		if err != nil {
+			if strategy.name == strategyDefault {
+				return nil, err
+			}
+			attempt.Error = err.Error()
+			attempts = append(attempts, attempt)
+			results = append(results, nil)
+			continue
+		}
+
+		attempt.Size = result.OptimizedSize
+		if reference == nil {
+			reference, err = competitionReference(buffer, result.Width, result.Height)
+		}
+		if err == nil {
+			attempt.SSIM, err = attemptSSIM(reference, result.OptimizedImage)
+		}
+		if err != nil {
+			// Without a score the attempt cannot be shown to meet the quality requirement
+			attempt.Error = "quality check failed: " + err.Error()
+		}
+
+		attempts = append(attempts, attempt)
+		results = append(results, result)
+	}
+
+	best := selectAttempt(attempts)
+	winner := results[best]
+	winner.Strategy = attempts[best].Strategy
+	winner.Attempts = attempts
+	winner.ProcessingTime = fmt.Sprintf("%dms", time.Since(startTime).Milliseconds())
+	return winner, nil
+}
+
+// selectAttempt marks which attempts meet the quality requirement and returns the
+// index of the smallest of them. attempts[0] is the default encoding, which always
+// qualifies and wins ties; if it could not be scored, nothing else qualifies.
+func selectAttempt(attempts []EncoderAttempt) int {
+	floor := attempts[0].SSIM - competitionSSIMTolerance
+	scored := attempts[0].Error == ""
+	best := 0
+	for i := range attempts {
+		attempts[i].MeetsQuality = i == 0 || (scored && attempts[i].Error == "" && attempts[i].SSIM >= floor)
+		if attempts[i].MeetsQuality && attempts[i].Size < attempts[best].Size {
+			best = i
+		}
+	}
+	attempts[best].Selected = true
+	return best
+}
+
+// competitionReference decodes the source at the output size, so attempts can be
+// compared with it even when the request resizes the image
+func competitionReference(buffer []byte, width, height int) (*image.Gray, error) {
+	if IsBMP(buffer) {
+		converted, err := bmpToPNG(buffer)
+		if err != nil {
+			return nil, err
+		}
+		buffer = converted
+	}
+
+	reference, err := decodeGray(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode reference image: %w", err)
+	}
+	if reference.Bounds().Dx() == width && reference.Bounds().Dy() == height {
+		return reference, nil
+	}
+
+	resized, err := bimg.NewImage(buffer).Process(bimg.Options{
+		Width:       width,
+		Height:      height,
+		Force:       true,
+		Type:        bimg.PNG,
+		Compression: 1, // Decoded immediately, favor speed
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resize reference image: %w", err)
+	}
+	return decodeGray(resized)
+}
+
+// attemptSSIM compares an attempt's output with the reference
+func attemptSSIM(reference *image.Gray, output []byte) (float64, error) {
+	decoded, err := decodeGray(output)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode output for SSIM: %w", err)
+	}
+	return ComputeSSIM(reference, decoded)
+}
+
+// encodeNearLosslessWebP runs the pipeline with lossless WebP output, then
+// re-encodes the result with libwebp's near-lossless preprocessing, which
+// adjusts pixel values slightly so the lossless coder compresses them better.
+// bimg does not expose the option, so this goes through the vips command line
+// tool; the quality setting controls how much the pixels may change.
+func encodeNearLosslessWebP(buffer []byte, options OptimizeOptions) (*OptimizeResult, error) {
+	result, err := OptimizeImage(buffer, options)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "imgopt-webp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	input := filepath.Join(dir, "input.webp")
+	output := filepath.Join(dir, "output.webp")
+	if err := os.WriteFile(input, result.OptimizedImage, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to stage WebP: %w", err)
+	}
+
+	quality := options.Quality
+	if quality == 0 {
+		quality = 80
+	}
+	if _, err := runVips("vips", "webpsave", input, output,
+		"--near-lossless", "--Q", strconv.Itoa(quality), "--effort", "6", "--strip"); err != nil {
+		return nil, err
+	}
+
+	encoded, err := os.ReadFile(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read near-lossless WebP: %w", err)
+	}
+
+	result.OptimizedImage = encoded
+	result.OptimizedSize = int64(len(encoded))
+	result.AlreadyOptimized = false
+	result.Message = ""
+	result.Lossless = false
+	result.Savings = fmt.Sprintf("%.2f%%", float64(result.OriginalSize-result.OptimizedSize)/float64(result.OriginalSize)*100)
+	return result, nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+func TestSelectAttempt(t *testing.T) {
+	attempts := []EncoderAttempt{
+		{Strategy: strategyDefault, Size: 1000, SSIM: 0.95},
+		{Strategy: "palette", Size: 400, SSIM: 0.90},           // Smallest, but visibly worse
+		{Strategy: "truecolor-oxipng6", Size: 800, SSIM: 0.96}, // Smaller and at least as good
+		{Strategy: "palette-oxipng6", Size: 700, SSIM: 0.949},  // Within tolerance
+		{Strategy: "near-lossless", Size: 100, Error: "vips failed"},
+	}
+
+	best := selectAttempt(attempts)
+	if attempts[best].Strategy != "palette-oxipng6" {
+		t.Errorf("Expected palette-oxipng6 to win, got %s", attempts[best].Strategy)
+	}
+	if !attempts[best].Selected {
+		t.Error("Expected the winner to be marked selected")
+	}
+
+	expected := map[string]bool{
+		strategyDefault:     true,
+		"palette":           false,
+		"truecolor-oxipng6": true,
+		"palette-oxipng6":   true,
+		"near-lossless":     false,
+	}
+	for _, attempt := range attempts {
+		if attempt.MeetsQuality != expected[attempt.Strategy] {
+			t.Errorf("%s: expected meetsQuality=%v", attempt.Strategy, expected[attempt.Strategy])
+		}
+	}
+}
+
+func TestSelectAttempt_UnscoredDefault(t *testing.T) {
+	attempts := []EncoderAttempt{
+		{Strategy: strategyDefault, Size: 1000, Error: "quality check failed"},
+		{Strategy: "lossless", Size: 500, SSIM: 1},
+	}
+
+	if best := selectAttempt(attempts); best != 0 {
+		t.Errorf("Expected the default to win when it cannot be scored, got %s", attempts[best].Strategy)
+	}
+}
+
+func TestCompetitionStrategies(t *testing.T) {
+	names := func(strategies []encoderStrategy) map[string]bool {
+		set := make(map[string]bool)
+		for _, s := range strategies {
+			set[s.name] = true
+		}
+		return set
+	}
+
+	for _, format := range []bimg.ImageType{bimg.JPEG, bimg.PNG, bimg.WEBP, bimg.AVIF, bimg.GIF, bimg.TIFF} {
+		strategies := competitionStrategies(format, "png")
+		if strategies[0].name != strategyDefault {
+			t.Errorf("%s: expected the default strategy first, got %s", bimg.ImageTypeName(format), strategies[0].name)
+		}
+	}
+
+	webp := names(competitionStrategies(bimg.WEBP, "jpeg"))
+	for _, name := range []string{"lossless", "near-lossless", "lossy-effort6"} {
+		if !webp[name] {
+			t.Errorf("Expected WebP strategy %s", name)
+		}
+	}
+
+	if names(competitionStrategies(bimg.JPEG, "png"))["lossless-transcode"] {
+		t.Error("Lossless transcoding needs a JPEG source")
+	}
+	if !names(competitionStrategies(bimg.JPEG, "jpeg"))["lossless-transcode"] {
+		t.Error("Expected lossless transcoding for JPEG sources")
+	}
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Document rendering limits
+const (
+	DefaultDocumentDPI = 150
+	MinDocumentDPI     = 36
+	MaxDocumentDPI     = 600
+
+	// MaxDocumentPages caps how many pages allPages renders in one request
+	MaxDocumentPages = 100
+
+	// pdfPointsPerInch is the PDF user space unit; libvips reports page sizes at 72 DPI
+	pdfPointsPerInch = 72
+
+	// documentTimeout bounds each vips command - a single page of a complex PDF can be slow
+	documentTimeout = 60 * time.Second
+)
+
+// Document kinds accepted by OpenDocument
+const (
+	DocumentPDF  = "pdf"
+	DocumentTIFF = "tiff"
+)
+
+// DocumentOptions controls how a document page is rasterized
+type DocumentOptions struct {
+	Page       int    // 1-based page number
+	DPI        int    // Render resolution for PDF pages (TIFF pages have fixed pixels)
+	Background string // Page background for PDF rendering (hex color, white or black); default white
+}
+
+// Document is a PDF or (multi-page) TIFF staged on disk for the libvips command line
+// tools. bimg always loads the first page at 72 DPI, so page selection and render
+// resolution go through the vips CLI, as oxipng and cjpeg do for encoding.
+type Document struct {
+	Kind  string // DocumentPDF or DocumentTIFF
+	Pages int
+
+	dir  string
+	path string
+}
+
+// DocumentKind returns DocumentPDF or DocumentTIFF based on the file signature,
+// or "" for other formats
+func DocumentKind(buffer []byte) string {
+	switch {
+	case bytes.HasPrefix(buffer, []byte("%PDF-")):
+		return DocumentPDF
+	case bytes.HasPrefix(buffer, []byte("II*\x00")), bytes.HasPrefix(buffer, []byte("MM\x00*")):
+		return DocumentTIFF
+	}
+	return ""
+}
+
+// TIFFPageCount counts the images (IFDs) in a classic TIFF without decoding it.
+// Returns 0 for non-TIFF data; BigTIFF and damaged IFD chains count as one page.
+func TIFFPageCount(buffer []byte) int {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(buffer, []byte("II*\x00")):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(buffer, []byte("MM\x00*")):
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	pages := 0
+	seen := make(map[uint32]bool)
+	offset := uint32(0)
+	if len(buffer) >= 8 {
+		offset = order.Uint32(buffer[4:])
+	}
+	for offset != 0 && !seen[offset] && int64(offset)+2 <= int64(len(buffer)) {
+		seen[offset] = true
+		entries := int64(order.Uint16(buffer[offset:]))
+		next := int64(offset) + 2 + entries*12
+		if next+4 > int64(len(buffer)) {
+			break
+		}
+		pages++
+		offset = order.Uint32(buffer[next:])
+	}
+	return maxInt(pages, 1)
+}
+
+// OpenDocument writes the document to a private temporary directory and reads its
+// page count. Close must be called to remove the files.
+//
+// SECURITY: the path passed to vips is generated here, never taken from the request,
+// and the directory is only readable by the server user.
+func OpenDocument(buffer []byte) (*Document, error) {
+	kind := DocumentKind(buffer)
+	if kind == "" {
+		return nil, fmt.Errorf("not a PDF or TIFF document")
+	}
+
+	dir, err := os.MkdirTemp("", "imgopt-doc-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	doc := &Document{Kind: kind, dir: dir, path: filepath.Join(dir, "document."+kind)}
+	if err := os.WriteFile(doc.path, buffer, 0o600); err != nil {
+		doc.Close()
+		return nil, fmt.Errorf("failed to stage document: %w", err)
+	}
+
+	// Single-page files may not carry n-pages at all
+	doc.Pages = 1
+	if out, err := runVips("vipsheader", "-f", "n-pages", doc.path); err == nil {
+		if pages, err := strconv.Atoi(strings.TrimSpace(out)); err == nil && pages > 0 {
+			doc.Pages = pages
+		}
+	}
+
+	return doc, nil
+}
+
+// Close removes the staged document and any rendered pages
+func (d *Document) Close() {
+	_ = os.RemoveAll(d.dir)
+}
+
+// PageSize returns the pixel dimensions page will have when rendered at dpi, without
+// rendering it, so oversized pages can be rejected before they are decoded
+func (d *Document) PageSize(page, dpi int) (int, int, error) {
+	if err := d.checkPage(page); err != nil {
+		return 0, 0, err
+	}
+
+	out, err := runVips("vipsheader", fmt.Sprintf("%s[page=%d]", d.path, page-1))
+	if err != nil {
+		return 0, 0, err
+	}
+	width, height, err := parseVipsHeaderSize(out)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if d.Kind == DocumentPDF {
+		scale := float64(dpi) / pdfPointsPerInch
+		width = int(float64(width)*scale + 0.5)
+		height = int(float64(height)*scale + 0.5)
+	}
+	return width, height, nil
+}
+
+// RenderPage rasterizes one page to a PNG
+func (d *Document) RenderPage(options DocumentOptions) ([]byte, error) {
+	if err := d.checkPage(options.Page); err != nil {
+		return nil, err
+	}
+
+	output := filepath.Join(d.dir, fmt.Sprintf("page-%d.png", options.Page))
+	defer func() { _ = os.Remove(output) }()
+
+	args := []string{"tiffload", d.path, output, "--page", strconv.Itoa(options.Page - 1)}
+	if d.Kind == DocumentPDF {
+		background := DefaultBackground
+		if options.Background != "" && !strings.EqualFold(options.Background, BackgroundAuto) {
+			background = options.Background
+		}
+		bg, err := parseBackgroundColor(background)
+		if err != nil {
+			return nil, err
+		}
+
+		dpi := options.DPI
+		if dpi == 0 {
+			dpi = DefaultDocumentDPI
+		}
+
+		args = []string{"pdfload", d.path, output,
+			"--page", strconv.Itoa(options.Page - 1),
+			"--dpi", strconv.Itoa(dpi),
+			"--background", fmt.Sprintf("%d %d %d 255", bg.R, bg.G, bg.B),
+		}
+	}
+
+	if _, err := runVips("vips", args...); err != nil {
+		return nil, fmt.Errorf("failed to render page %d: %w", options.Page, err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rendered page %d: %w", options.Page, err)
+	}
+	return data, nil
+}
+
+// checkPage validates a 1-based page number
+func (d *Document) checkPage(page int) error {
+	if page < 1 || page > d.Pages {
+		return fmt.Errorf("page %d does not exist: the document has %d page(s)", page, d.Pages)
+	}
+	return nil
+}
+
+// runVips runs a libvips command line tool and returns its standard output.
+//
+// SECURITY: the binary is fixed, arguments are integers validated by the caller,
+// colors formatted from parsed values, and paths created by OpenDocument.
+func runVips(name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), documentTimeout)
+	defer cancel()
+
+	// #nosec G204 - Binary is hardcoded and arguments are validated (see above)
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("%s timed out after %v", name, documentTimeout)
+		}
+		return "", fmt.Errorf("%s failed: %w - %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// parseVipsHeaderSize extracts the dimensions from vipsheader's summary line,
+// e.g. "/tmp/doc.pdf: 612x792 uchar, 4 bands, srgb, pdfload"
+func parseVipsHeaderSize(out string) (int, int, error) {
+	summary := out
+	if i := strings.LastIndex(out, ": "); i >= 0 {
+		summary = out[i+2:]
+	}
+
+	var width, height int
+	if _, err := fmt.Sscanf(summary, "%dx%d", &width, &height); err != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("unexpected vipsheader output: %q", strings.TrimSpace(out))
+	}
+	return width, height, nil
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestDocumentKind(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"%PDF-1.7\n", DocumentPDF},
+		{"II*\x00\x08\x00\x00\x00", DocumentTIFF},
+		{"MM\x00*\x00\x00\x00\x08", DocumentTIFF},
+		{"\x89PNG\r\n\x1a\n", ""},
+		{"%PD", ""},
+	}
+
+	for _, tt := range tests {
+		if got := DocumentKind([]byte(tt.data)); got != tt.expected {
+			t.Errorf("DocumentKind(%q) = %q, expected %q", tt.data, got, tt.expected)
+		}
+	}
+}
+
+func TestParseVipsHeaderSize(t *testing.T) {
+	width, height, err := parseVipsHeaderSize("/tmp/imgopt-doc-123/document.pdf: 612x792 uchar, 4 bands, srgb, pdfload\n")
+	if err != nil {
+		t.Fatalf("parseVipsHeaderSize failed: %v", err)
+	}
+	if width != 612 || height != 792 {
+		t.Errorf("Expected 612x792, got %dx%d", width, height)
+	}
+
+	if _, _, err := parseVipsHeaderSize("vipsheader: unable to load"); err == nil {
+		t.Error("Expected error for output without dimensions")
+	}
+}
+
+func TestDocumentCheckPage(t *testing.T) {
+	doc := &Document{Kind: DocumentPDF, Pages: 3}
+
+	if err := doc.checkPage(3); err != nil {
+		t.Errorf("Expected page 3 to exist, got %v", err)
+	}
+	for _, page := range []int{0, 4} {
+		err := doc.checkPage(page)
+		if err == nil || !strings.Contains(err.Error(), "3 page(s)") {
+			t.Errorf("Expected page %d to be rejected with the page count, got %v", page, err)
+		}
+	}
+}
+
+// buildTIFF writes a little-endian TIFF header followed by a chain of empty IFDs
+func buildTIFF(pages int) []byte {
+	data := []byte("II*\x00\x08\x00\x00\x00")
+	for i := 0; i < pages; i++ {
+		next := uint32(0)
+		if i < pages-1 {
+			next = uint32(len(data) + 6)
+		}
+		data = append(data, 0, 0) // No entries
+		data = binary.LittleEndian.AppendUint32(data, next)
+	}
+	return data
+}
+
+func TestTIFFPageCount(t *testing.T) {
+	if got := TIFFPageCount(buildTIFF(1)); got != 1 {
+		t.Errorf("Expected 1 page, got %d", got)
+	}
+	if got := TIFFPageCount(buildTIFF(3)); got != 3 {
+		t.Errorf("Expected 3 pages, got %d", got)
+	}
+
+	// An IFD pointing back at itself must not loop forever
+	looped := buildTIFF(1)
+	binary.LittleEndian.PutUint32(looped[10:], 8)
+	if got := TIFFPageCount(looped); got != 1 {
+		t.Errorf("Expected looped IFD chain to count once, got %d", got)
+	}
+
+	if got := TIFFPageCount([]byte("\x89PNG\r\n\x1a\n")); got != 0 {
+		t.Errorf("Expected 0 pages for PNG data, got %d", got)
+	}
+}
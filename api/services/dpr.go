@@ -0,0 +1,76 @@
+package services
+
+import (
+	"math"
+
+	"github.com/h2non/bimg"
+)
+
+// Device pixel ratio limits
+const (
+	MinDPR = 1.0
+	MaxDPR = 4.0
+
+	// minDPRQuality is the lowest quality automatic DPR quality reduction will go to
+	minDPRQuality = 30
+
+	// dprQualityStep is the fraction of quality removed per unit of DPR above 1.
+	// High-density screens hide compression artifacts, so 2x renditions at ~85%
+	// of the 1x quality look equivalent while being considerably smaller.
+	dprQualityStep = 0.15
+)
+
+// DPRInfo reports how a device pixel ratio request was applied
+type DPRInfo struct {
+	Requested float64 `json:"requested"`
+	Effective float64 `json:"effective"` // Lower than requested when capped at the source resolution
+	Width     int     `json:"width"`     // Target width after applying the effective DPR (0 = auto)
+	Height    int     `json:"height"`    // Target height after applying the effective DPR (0 = auto)
+	Quality   int     `json:"quality"`   // Encoder quality used
+	Capped    bool    `json:"capped"`    // True if the source was too small for the requested DPR
+}
+
+// resolveDPR multiplies the requested dimensions by dpr without exceeding the source
+// resolution. The effective ratio never drops below 1, so a DPR request never makes
+// the output smaller than the plain width/height request would.
+func resolveDPR(width, height, sourceWidth, sourceHeight int, dpr float64) (int, int, float64) {
+	effective := dpr
+	if width > 0 && sourceWidth > 0 {
+		effective = math.Min(effective, float64(sourceWidth)/float64(width))
+	}
+	if height > 0 && sourceHeight > 0 {
+		effective = math.Min(effective, float64(sourceHeight)/float64(height))
+	}
+	effective = math.Max(effective, MinDPR)
+
+	// Round to 2 decimals for reporting, but size from the unrounded ratio
+	// so the cap lands exactly on the source dimensions
+	targetWidth := int(math.Round(float64(width) * effective))
+	targetHeight := int(math.Round(float64(height) * effective))
+	return targetWidth, targetHeight, math.Round(effective*100) / 100
+}
+
+// dprQuality lowers quality for high-DPR renditions: 1x keeps the requested
+// quality, 2x uses 85% of it, 3x 70% and 4x 55%, never below minDPRQuality
+func dprQuality(quality int, dpr float64) int {
+	if dpr <= 1 {
+		return quality
+	}
+	adjusted := int(math.Round(float64(quality) * (1 - dprQualityStep*(dpr-1))))
+	if adjusted < minDPRQuality {
+		adjusted = minDPRQuality
+	}
+	if adjusted > quality {
+		return quality
+	}
+	return adjusted
+}
+
+// displaySize returns the image size after EXIF auto-rotation
+func displaySize(metadata bimg.ImageMetadata) (int, int) {
+	// Orientations 5-8 are rotated by 90 degrees, swapping width and height
+	if metadata.Orientation >= 5 && metadata.Orientation <= 8 {
+		return metadata.Size.Height, metadata.Size.Width
+	}
+	return metadata.Size.Width, metadata.Size.Height
+}
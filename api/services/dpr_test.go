@@ -0,0 +1,64 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+func TestResolveDPR(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		srcWidth, srcHeight   int
+		dpr                   float64
+		wantWidth, wantHeight int
+		wantEffective         float64
+	}{
+		{"uncapped", 400, 0, 2000, 1000, 2, 800, 0, 2},
+		{"capped by width", 400, 0, 1000, 500, 3, 1000, 0, 2.5},
+		{"capped by height", 0, 300, 2000, 800, 4, 0, 800, 2.67},
+		{"source smaller than 1x", 800, 0, 500, 500, 2, 800, 0, 1},
+		{"both dimensions", 200, 100, 500, 400, 3, 500, 250, 2.5},
+	}
+
+	for _, tt := range tests {
+		w, h, effective := resolveDPR(tt.width, tt.height, tt.srcWidth, tt.srcHeight, tt.dpr)
+		if w != tt.wantWidth || h != tt.wantHeight || effective != tt.wantEffective {
+			t.Errorf("%s: resolveDPR = (%d, %d, %g), expected (%d, %d, %g)",
+				tt.name, w, h, effective, tt.wantWidth, tt.wantHeight, tt.wantEffective)
+		}
+	}
+}
+
+func TestDPRQuality(t *testing.T) {
+	tests := []struct {
+		quality  int
+		dpr      float64
+		expected int
+	}{
+		{80, 1, 80},
+		{80, 2, 68},
+		{80, 4, 44},
+		{40, 4, minDPRQuality},
+		{20, 3, 20}, // Never raised above the requested quality
+	}
+
+	for _, tt := range tests {
+		if got := dprQuality(tt.quality, tt.dpr); got != tt.expected {
+			t.Errorf("dprQuality(%d, %g) = %d, expected %d", tt.quality, tt.dpr, got, tt.expected)
+		}
+	}
+}
+
+func TestDisplaySize(t *testing.T) {
+	metadata := bimg.ImageMetadata{Size: bimg.ImageSize{Width: 300, Height: 200}}
+	if w, h := displaySize(metadata); w != 300 || h != 200 {
+		t.Errorf("Expected 300x200, got %dx%d", w, h)
+	}
+
+	metadata.Orientation = 6
+	if w, h := displaySize(metadata); w != 200 || h != 300 {
+		t.Errorf("Expected rotated 200x300, got %dx%d", w, h)
+	}
+}
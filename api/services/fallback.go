@@ -0,0 +1,55 @@
+package services
+
+import "github.com/h2non/bimg"
+
+// Original fallback modes (OptimizeOptions.OriginalFallback)
+const (
+	OriginalFallbackAuto   = ""       // Only when the original is equivalent to the requested output
+	OriginalFallbackAlways = "always" // Whenever the original is smaller, even if transforms are skipped
+	OriginalFallbackNever  = "never"  // Always return the optimized output
+)
+
+// Transforms reported in OptimizeResult.SkippedTransforms
+const (
+	TransformToneMap  = "tone-map"
+	TransformTrim     = "trim"
+	TransformFlatten  = "flatten"
+	TransformResize   = "resize"
+	TransformSharpen  = "sharpen"
+	TransformRotate   = "rotate"
+	TransformStrip    = "strip-metadata"
+	TransformBitDepth = "reduce-bit-depth"
+)
+
+// outputTransforms lists the changes in the encoded output that are decided at
+// encode time: dimensions different from the source (after trimming), sharpening,
+// EXIF auto-rotation, metadata removal and bit depth reduction. Returning the
+// original in their place would not give the caller what they asked for.
+func outputTransforms(output []byte, source bimg.ImageMetadata, sourceEncoding colorEncoding,
+	sourceWidth, sourceHeight int, sharpenStrength float64) []string {
+	var transforms []string
+
+	if metadata, err := bimg.NewImage(output).Metadata(); err == nil {
+		width, height := displaySize(metadata)
+		if width != sourceWidth || height != sourceHeight {
+			transforms = append(transforms, TransformResize)
+		}
+	}
+	if sharpenStrength > 0 {
+		transforms = append(transforms, TransformSharpen)
+	}
+
+	// libvips applies the EXIF orientation and drops the tag, and so does lossless
+	// transcoding
+	if source.Orientation > 1 {
+		transforms = append(transforms, TransformRotate)
+	}
+	if (source.EXIF != bimg.EXIF{} || source.Profile) {
+		transforms = append(transforms, TransformStrip)
+	}
+
+	if probeEncoding(output).BitDepth < sourceEncoding.BitDepth {
+		transforms = append(transforms, TransformBitDepth)
+	}
+	return transforms
+}
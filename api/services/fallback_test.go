@@ -0,0 +1,29 @@
+package services
+
+import (
+	"image"
+	"slices"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+func TestOutputTransforms(t *testing.T) {
+	output := encodeTestPNG(t, image.NewNRGBA(image.Rect(0, 0, 4, 4)))
+
+	source := bimg.ImageMetadata{
+		Orientation: 6,
+		EXIF:        bimg.EXIF{Make: "Camera"},
+	}
+	transforms := outputTransforms(output, source, colorEncoding{BitDepth: 16}, 4, 4, 1.5)
+	for _, expected := range []string{TransformSharpen, TransformRotate, TransformStrip, TransformBitDepth} {
+		if !slices.Contains(transforms, expected) {
+			t.Errorf("Expected %s in %v", expected, transforms)
+		}
+	}
+
+	// A plain re-encode changes nothing the original lacks
+	if transforms := outputTransforms(output, bimg.ImageMetadata{}, colorEncoding{BitDepth: 8}, 4, 4, 0); len(transforms) > 0 {
+		t.Errorf("Expected no transforms, got %v", transforms)
+	}
+}
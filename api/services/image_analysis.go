@@ -0,0 +1,590 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"sort"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+// Analysis constants
+const (
+	analysisSampleSize = 512     // Longest edge of the downscaled copy used for trial encodes
+	maxCountedColors   = 1 << 16 // Stop counting distinct colors beyond this (photographic content)
+	paletteColorLimit  = 256     // Images with at most this many colors fit in a PNG palette
+)
+
+// DefaultAnalysisFormats are the candidate output formats trialled by AnalyzeImage
+var DefaultAnalysisFormats = []string{"jpeg", "webp", "avif", "png"}
+
+// DefaultAnalysisQualities are the candidate qualities trialled for lossy formats
+var DefaultAnalysisQualities = []int{60, 70, 80, 90}
+
+// AnalyzeOptions controls which candidates AnalyzeImage estimates
+type AnalyzeOptions struct {
+	Formats   []string // Candidate output formats (jpeg, png, webp, avif)
+	Qualities []int    // Candidate qualities for lossy formats (1-100)
+}
+
+// FormatEstimate is the estimated output size for one format/quality candidate
+type FormatEstimate struct {
+	Format           string `json:"format"`
+	Quality          int    `json:"quality,omitempty"` // Omitted for lossless candidates
+	Lossless         bool   `json:"lossless"`
+	Palette          bool   `json:"palette,omitempty"`
+	EstimatedSize    int64  `json:"estimatedSize"`
+	EstimatedSavings string `json:"estimatedSavings"`
+	SampleSize       int64  `json:"sampleSize"`        // Size of the trial encode of the downscaled copy
+	Warning          string `json:"warning,omitempty"` // e.g. transparency would be lost
+	estimatedSavings float64
+}
+
+// Recommendation holds the suggested /optimize settings for an image
+type Recommendation struct {
+	Format           string   `json:"format"`
+	Quality          int      `json:"quality,omitempty"`
+	Lossless         bool     `json:"lossless"`
+	Palette          bool     `json:"palette"`
+	Progressive      bool     `json:"progressive"`
+	EstimatedSize    int64    `json:"estimatedSize"`
+	EstimatedSavings string   `json:"estimatedSavings"`
+	Reasons          []string `json:"reasons"`
+}
+
+// ImageAnalysis is the dry-run report returned by AnalyzeImage
+type ImageAnalysis struct {
+	Format           string           `json:"format"`
+	Width            int              `json:"width"`
+	Height           int              `json:"height"`
+	FileSize         int64            `json:"fileSize"`
+	Channels         int              `json:"channels"`
+	HasAlpha         bool             `json:"hasAlpha"`         // Image has an alpha channel
+	AlphaUsed        bool             `json:"alphaUsed"`        // At least one pixel is not fully opaque
+	ColorCount       int              `json:"colorCount"`       // Distinct colors in the analysis sample
+	ColorCountCapped bool             `json:"colorCountCapped"` // True if counting stopped at the cap
+	ColorSpace       string           `json:"colorSpace"`
+	HasICCProfile    bool             `json:"hasIccProfile"`
+	HasEXIF          bool             `json:"hasExif"`
+	Orientation      int              `json:"orientation"`
+	Animated         bool             `json:"animated"`
+	FrameCount       int              `json:"frameCount"`
+	Progressive      bool             `json:"progressive"` // Progressive JPEG or interlaced PNG/GIF
+	SampleWidth      int              `json:"sampleWidth"`
+	SampleHeight     int              `json:"sampleHeight"`
+	Estimates        []FormatEstimate `json:"estimates"`
+	Recommendation   Recommendation   `json:"recommendation"`
+	ProcessingTime   string           `json:"processingTime"`
+}
+
+// AnalyzeImage inspects an image and estimates output sizes for candidate
+// formats and qualities without producing the full optimized output.
+// Trial encodes run on a copy downscaled to at most 512px on the longest edge
+// and are scaled up by pixel count, so estimates are approximate.
+func AnalyzeImage(buffer []byte, options AnalyzeOptions) (*ImageAnalysis, error) {
+	startTime := time.Now()
+
+	if len(options.Formats) == 0 {
+		options.Formats = DefaultAnalysisFormats
+	}
+	if len(options.Qualities) == 0 {
+		options.Qualities = DefaultAnalysisQualities
+	}
+
+	// Read the same metadata OptimizeImage uses
+	metadata, err := bimg.NewImage(buffer).Metadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image metadata: %w", err)
+	}
+
+	container := inspectContainer(buffer, metadata.Type)
+
+	analysis := &ImageAnalysis{
+		Format:        metadata.Type,
+		Width:         metadata.Size.Width,
+		Height:        metadata.Size.Height,
+		FileSize:      int64(len(buffer)),
+		Channels:      metadata.Channels,
+		HasAlpha:      metadata.Alpha,
+		ColorSpace:    metadata.Space,
+		HasICCProfile: metadata.Profile,
+		HasEXIF:       container.HasEXIF || metadata.EXIF.Make != "" || metadata.EXIF.Model != "" || metadata.EXIF.Datetime != "",
+		Orientation:   metadata.Orientation,
+		Animated:      container.Animated,
+		FrameCount:    container.FrameCount,
+		Progressive:   container.Progressive,
+		Estimates:     make([]FormatEstimate, 0),
+	}
+
+	// Build a downscaled, lossless sample for color analysis and trial encodes
+	// Nearest-neighbor keeps the color count honest for flat graphics
+	sampleWidth, sampleHeight := fitWithin(analysis.Width, analysis.Height, analysisSampleSize)
+	sample, err := bimg.NewImage(buffer).Process(bimg.Options{
+		Width:        sampleWidth,
+		Height:       sampleHeight,
+		Force:        true,
+		Interpolator: bimg.Nearest,
+		Type:         bimg.PNG,
+		Compression:  1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create analysis sample: %w", err)
+	}
+	analysis.SampleWidth = sampleWidth
+	analysis.SampleHeight = sampleHeight
+
+	sampleImage, _, err := image.Decode(bytes.NewReader(sample))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode analysis sample: %w", err)
+	}
+	analysis.ColorCount, analysis.ColorCountCapped, analysis.AlphaUsed = analyzePixels(sampleImage)
+
+	// Trial encodes - scale sample sizes up by the pixel ratio
+	pixelRatio := float64(analysis.Width*analysis.Height) / float64(sampleWidth*sampleHeight)
+	for _, candidate := range analysisCandidates(options, analysis) {
+		encoded, err := bimg.NewImage(sample).Process(candidate.bimgOptions)
+		if err != nil {
+			// Encoder not available in this libvips build (e.g. AVIF) - skip the candidate
+			continue
+		}
+
+		estimate := candidate.estimate
+		estimate.SampleSize = int64(len(encoded))
+		estimate.EstimatedSize = int64(float64(len(encoded)) * pixelRatio)
+		estimate.estimatedSavings = float64(analysis.FileSize-estimate.EstimatedSize) / float64(analysis.FileSize) * 100
+		estimate.EstimatedSavings = fmt.Sprintf("%.2f%%", estimate.estimatedSavings)
+		analysis.Estimates = append(analysis.Estimates, estimate)
+	}
+
+	analysis.Recommendation = recommendSettings(analysis, options.Qualities)
+	analysis.ProcessingTime = fmt.Sprintf("%dms", time.Since(startTime).Milliseconds())
+
+	return analysis, nil
+}
+
+// analysisCandidate pairs a reported estimate with the bimg options used to produce it
+type analysisCandidate struct {
+	estimate    FormatEstimate
+	bimgOptions bimg.Options
+}
+
+// analysisCandidates expands the requested formats and qualities into trial encodes
+func analysisCandidates(options AnalyzeOptions, analysis *ImageAnalysis) []analysisCandidate {
+	candidates := make([]analysisCandidate, 0)
+	isGraphic := analysis.ColorCount <= paletteColorLimit && !analysis.ColorCountCapped
+
+	for _, format := range options.Formats {
+		imageType := getImageTypeFromString(format)
+		if imageType == bimg.UNKNOWN || !bimg.IsTypeSupportedSave(imageType) {
+			continue
+		}
+
+		warning := ""
+		if imageType == bimg.JPEG && analysis.AlphaUsed {
+			warning = "JPEG does not support transparency - transparent areas will be flattened"
+		}
+
+		// PNG is always lossless - a single candidate, palette-quantized for graphics
+		if imageType == bimg.PNG {
+			candidates = append(candidates, analysisCandidate{
+				estimate: FormatEstimate{Format: format, Lossless: true, Palette: isGraphic},
+				bimgOptions: bimg.Options{
+					Type:          bimg.PNG,
+					Compression:   6,
+					Palette:       isGraphic,
+					StripMetadata: true,
+				},
+			})
+			continue
+		}
+
+		for _, quality := range options.Qualities {
+			opts := bimg.Options{
+				Type:          imageType,
+				Quality:       quality,
+				StripMetadata: true,
+			}
+			if imageType == bimg.AVIF {
+				opts.Speed = 8 // Fastest AVIF effort - this is only a trial encode
+			}
+			candidates = append(candidates, analysisCandidate{
+				estimate:    FormatEstimate{Format: format, Quality: quality, Warning: warning},
+				bimgOptions: opts,
+			})
+		}
+
+		// Flat graphics often compress best with lossless WebP
+		if imageType == bimg.WEBP && isGraphic {
+			candidates = append(candidates, analysisCandidate{
+				estimate: FormatEstimate{Format: format, Lossless: true},
+				bimgOptions: bimg.Options{
+					Type:          bimg.WEBP,
+					Lossless:      true,
+					StripMetadata: true,
+				},
+			})
+		}
+	}
+
+	return candidates
+}
+
+// recommendSettings picks the best candidate estimate for an analyzed image
+func recommendSettings(analysis *ImageAnalysis, qualities []int) Recommendation {
+	reasons := make([]string, 0)
+	isGraphic := analysis.ColorCount <= paletteColorLimit && !analysis.ColorCountCapped
+
+	// Photos are compared at a single target quality so formats are judged like-for-like
+	targetQuality := 80
+	if !containsInt(qualities, targetQuality) {
+		sorted := append([]int(nil), qualities...)
+		sort.Ints(sorted)
+		targetQuality = sorted[len(sorted)/2]
+	}
+
+	// Graphics keep every pixel, photos are compared at the target quality.
+	// If no candidate matches (e.g. only PNG was requested for a photo), fall back
+	// to the smallest candidate that keeps transparency.
+	best := smallestEstimate(analysis, func(estimate FormatEstimate) bool {
+		if isGraphic {
+			return estimate.Lossless
+		}
+		return !estimate.Lossless && estimate.Quality == targetQuality
+	})
+	if best == nil {
+		best = smallestEstimate(analysis, func(FormatEstimate) bool { return true })
+	}
+
+	recommendation := Recommendation{Format: analysis.Format}
+	if best == nil {
+		// Nothing qualified (e.g. no encoders available) - keep the original format
+		reasons = append(reasons, "No candidate encodes were available; keeping the original format")
+		recommendation.EstimatedSize = analysis.FileSize
+		recommendation.EstimatedSavings = "0.00%"
+		recommendation.Reasons = reasons
+		return recommendation
+	}
+
+	recommendation.Format = best.Format
+	recommendation.Quality = best.Quality
+	recommendation.Lossless = best.Lossless
+	recommendation.Palette = best.Palette
+	recommendation.EstimatedSize = best.EstimatedSize
+	recommendation.EstimatedSavings = best.EstimatedSavings
+
+	// The fallback candidate may not match the content, so word the reason from its mode
+	switch {
+	case isGraphic && best.Lossless:
+		reasons = append(reasons, fmt.Sprintf("Image uses %d colors - lossless encoding keeps it pixel-exact", analysis.ColorCount))
+	case isGraphic:
+		reasons = append(reasons, fmt.Sprintf("Image uses %d colors, but no lossless candidate was available - %s was smallest at quality %d",
+			analysis.ColorCount, best.Format, best.Quality))
+	case best.Lossless:
+		reasons = append(reasons, fmt.Sprintf("Photographic content, but no lossy candidate was available - %s was smallest with lossless encoding", best.Format))
+	default:
+		reasons = append(reasons, fmt.Sprintf("Photographic content - %s was smallest at quality %d", best.Format, best.Quality))
+	}
+	if recommendation.Palette {
+		reasons = append(reasons, "Palette mode fits all colors in a 256-color PNG palette")
+	}
+	if analysis.AlphaUsed {
+		reasons = append(reasons, "Transparency is used, so JPEG was excluded")
+	}
+
+	// Progressive JPEGs render sooner and are usually smaller above ~10KB
+	if recommendation.Format == "jpeg" && recommendation.EstimatedSize > 10*1024 {
+		recommendation.Progressive = true
+		reasons = append(reasons, "Progressive encoding is recommended for JPEGs above 10KB")
+	}
+
+	if analysis.HasEXIF {
+		reasons = append(reasons, "EXIF metadata is present and will be stripped")
+	}
+	if analysis.Animated {
+		reasons = append(reasons, fmt.Sprintf("Image is animated (%d frames) - estimates cover the first frame only", analysis.FrameCount))
+	}
+	if best.estimatedSavings <= 0 {
+		reasons = append(reasons, "The original is already smaller than every candidate - it is likely well optimized")
+	}
+
+	recommendation.Reasons = reasons
+	return recommendation
+}
+
+// smallestEstimate returns the smallest estimate accepted by filter, never
+// choosing JPEG when transparency is in use
+func smallestEstimate(analysis *ImageAnalysis, filter func(FormatEstimate) bool) *FormatEstimate {
+	var best *FormatEstimate
+	for i := range analysis.Estimates {
+		estimate := &analysis.Estimates[i]
+		if estimate.Format == "jpeg" && analysis.AlphaUsed {
+			continue
+		}
+		if !filter(*estimate) {
+			continue
+		}
+		if best == nil || estimate.EstimatedSize < best.EstimatedSize {
+			best = estimate
+		}
+	}
+	return best
+}
+
+// analyzePixels counts distinct colors (up to maxCountedColors) and reports whether
+// any pixel is not fully opaque
+func analyzePixels(img image.Image) (colorCount int, capped bool, alphaUsed bool) {
+	bounds := img.Bounds()
+	colors := make(map[uint64]struct{})
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < 0xffff {
+				alphaUsed = true
+			}
+			if !capped {
+				key := uint64(r>>8)<<24 | uint64(g>>8)<<16 | uint64(b>>8)<<8 | uint64(a>>8)
+				colors[key] = struct{}{}
+				if len(colors) >= maxCountedColors {
+					capped = true
+				}
+			}
+		}
+	}
+
+	return len(colors), capped, alphaUsed
+}
+
+// fitWithin scales width and height down to fit within maxEdge, preserving aspect ratio
+func fitWithin(width, height, maxEdge int) (int, int) {
+	if width <= maxEdge && height <= maxEdge {
+		return width, height
+	}
+	if width >= height {
+		return maxEdge, maxInt(1, height*maxEdge/width)
+	}
+	return maxInt(1, width*maxEdge/height), maxEdge
+}
+
+// containsInt reports whether values contains v
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// containerInfo holds properties read directly from the encoded file structure
+// These are not exposed by libvips metadata, so the container is parsed by hand
+type containerInfo struct {
+	Animated    bool
+	FrameCount  int
+	Progressive bool
+	HasEXIF     bool
+}
+
+// inspectContainer parses the encoded file structure for animation, progressive
+// encoding and EXIF presence. Unknown or truncated data yields a best-effort result.
+func inspectContainer(buffer []byte, format string) containerInfo {
+	var info containerInfo
+	switch format {
+	case "jpeg":
+		info = inspectJPEG(buffer)
+	case "png":
+		info = inspectPNG(buffer)
+	case "gif":
+		info = inspectGIF(buffer)
+	case "webp":
+		info = inspectWebP(buffer)
+	case "avif", "heif":
+		info = inspectAVIF(buffer)
+	}
+
+	if info.FrameCount == 0 {
+		info.FrameCount = 1
+	}
+	info.Animated = info.Animated || info.FrameCount > 1
+	return info
+}
+
+// inspectJPEG walks JPEG marker segments up to the first scan
+func inspectJPEG(buf []byte) containerInfo {
+	info := containerInfo{FrameCount: 1}
+	i := 2 // Skip SOI
+	for i+4 <= len(buf) {
+		if buf[i] != 0xFF {
+			break
+		}
+		marker := buf[i+1]
+		if marker == 0xFF { // Fill byte
+			i++
+			continue
+		}
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			i += 2
+			continue
+		}
+		if marker == 0xDA { // Start of scan - no more headers
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(buf[i+2 : i+4]))
+		if length < 2 {
+			break
+		}
+		segment := buf[i+4 : minInt(len(buf), i+2+length)]
+
+		switch marker {
+		case 0xC2, 0xC6, 0xCA, 0xCE: // Progressive SOF markers
+			info.Progressive = true
+		case 0xE1: // APP1
+			if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				info.HasEXIF = true
+			}
+		}
+
+		i += 2 + length
+	}
+	return info
+}
+
+// inspectPNG walks PNG chunks for APNG animation, Adam7 interlacing and eXIf
+func inspectPNG(buf []byte) containerInfo {
+	info := containerInfo{FrameCount: 1}
+	i := 8 // Skip signature
+	for i+8 <= len(buf) {
+		length := int(binary.BigEndian.Uint32(buf[i : i+4]))
+		chunkType := string(buf[i+4 : i+8])
+		data := buf[i+8 : minInt(len(buf), i+8+length)]
+
+		switch chunkType {
+		case "IHDR":
+			if len(data) >= 13 && data[12] == 1 {
+				info.Progressive = true
+			}
+		case "acTL":
+			if len(data) >= 4 {
+				info.FrameCount = int(binary.BigEndian.Uint32(data[0:4]))
+				info.Animated = true
+			}
+		case "eXIf":
+			info.HasEXIF = true
+		case "IEND":
+			return info
+		}
+
+		i += 12 + length // length + type + data + CRC
+	}
+	return info
+}
+
+// inspectGIF counts image descriptors and checks for interlacing
+func inspectGIF(buf []byte) containerInfo {
+	info := containerInfo{}
+	if len(buf) < 13 {
+		return info
+	}
+
+	i := 13 // Header + logical screen descriptor
+	if buf[10]&0x80 != 0 {
+		i += 3 * (1 << (int(buf[10]&0x07) + 1)) // Global color table
+	}
+
+	// skipSubBlocks advances past a sequence of data sub-blocks
+	skipSubBlocks := func(pos int) int {
+		for pos < len(buf) {
+			size := int(buf[pos])
+			pos++
+			if size == 0 {
+				break
+			}
+			pos += size
+		}
+		return pos
+	}
+
+	for i < len(buf) {
+		switch buf[i] {
+		case 0x2C: // Image descriptor
+			if i+10 > len(buf) {
+				return info
+			}
+			info.FrameCount++
+			packed := buf[i+9]
+			if packed&0x40 != 0 {
+				info.Progressive = true
+			}
+			i += 10
+			if packed&0x80 != 0 {
+				i += 3 * (1 << (int(packed&0x07) + 1)) // Local color table
+			}
+			i++ // LZW minimum code size
+			i = skipSubBlocks(i)
+		case 0x21: // Extension
+			i = skipSubBlocks(i + 2)
+		case 0x3B: // Trailer
+			return info
+		default:
+			return info
+		}
+	}
+	return info
+}
+
+// inspectWebP walks RIFF chunks for VP8X flags, animation frames and EXIF
+func inspectWebP(buf []byte) containerInfo {
+	info := containerInfo{}
+	if len(buf) < 12 || string(buf[0:4]) != "RIFF" || string(buf[8:12]) != "WEBP" {
+		return info
+	}
+
+	i := 12
+	for i+8 <= len(buf) {
+		chunkType := string(buf[i : i+4])
+		size := int(binary.LittleEndian.Uint32(buf[i+4 : i+8]))
+		data := buf[i+8 : minInt(len(buf), i+8+size)]
+
+		switch chunkType {
+		case "VP8X":
+			if len(data) > 0 {
+				if data[0]&0x02 != 0 {
+					info.Animated = true
+				}
+				if data[0]&0x08 != 0 {
+					info.HasEXIF = true
+				}
+			}
+		case "ANMF":
+			info.FrameCount++
+		case "EXIF":
+			info.HasEXIF = true
+		}
+
+		i += 8 + size + size%2 // Chunks are padded to an even size
+	}
+	return info
+}
+
+// inspectAVIF checks the ftyp box for the image sequence brand
+func inspectAVIF(buf []byte) containerInfo {
+	info := containerInfo{FrameCount: 1}
+	if len(buf) < 16 || string(buf[4:8]) != "ftyp" {
+		return info
+	}
+	size := minInt(len(buf), int(binary.BigEndian.Uint32(buf[0:4])))
+	for i := 8; i+4 <= size; i += 4 {
+		if string(buf[i:i+4]) == "avis" {
+			info.Animated = true
+		}
+	}
+	// Exif lives in a meta item - a cheap substring check is good enough for a dry run
+	if bytes.Contains(buf[:minInt(len(buf), 64*1024)], []byte("Exif")) {
+		info.HasEXIF = true
+	}
+	return info
+}
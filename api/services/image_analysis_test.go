@@ -0,0 +1,181 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestInspectContainer_PNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createGradientImage(16, 16)); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+
+	info := inspectContainer(buf.Bytes(), "png")
+	if info.Animated || info.FrameCount != 1 {
+		t.Errorf("Expected static single-frame PNG, got animated=%v frames=%d", info.Animated, info.FrameCount)
+	}
+	if info.Progressive {
+		t.Error("Expected non-interlaced PNG")
+	}
+	if info.HasEXIF {
+		t.Error("Expected no EXIF in generated PNG")
+	}
+}
+
+func TestInspectContainer_JPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, createGradientImage(16, 16), &jpeg.Options{Quality: 80}); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+
+	info := inspectContainer(buf.Bytes(), "jpeg")
+	if info.Progressive {
+		t.Error("Expected baseline JPEG (Go encoder does not write progressive)")
+	}
+
+	// Splice an APP1 Exif segment after SOI
+	exif := []byte{0xFF, 0xE1, 0x00, 0x08, 'E', 'x', 'i', 'f', 0x00, 0x00}
+	withExif := append(append([]byte{0xFF, 0xD8}, exif...), buf.Bytes()[2:]...)
+	if !inspectContainer(withExif, "jpeg").HasEXIF {
+		t.Error("Expected EXIF segment to be detected")
+	}
+}
+
+func TestInspectContainer_AnimatedGIF(t *testing.T) {
+	anim := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), palette.Plan9)
+		frame.Set(i, i, color.White)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatalf("Failed to encode GIF: %v", err)
+	}
+
+	info := inspectContainer(buf.Bytes(), "gif")
+	if !info.Animated {
+		t.Error("Expected GIF to be detected as animated")
+	}
+	if info.FrameCount != 3 {
+		t.Errorf("Expected 3 frames, got %d", info.FrameCount)
+	}
+}
+
+func TestInspectContainer_Truncated(t *testing.T) {
+	// Truncated input must never panic
+	for _, format := range []string{"jpeg", "png", "gif", "webp", "avif"} {
+		info := inspectContainer([]byte{0xFF, 0xD8, 0xFF}, format)
+		if info.FrameCount != 1 {
+			t.Errorf("%s: expected default frame count 1, got %d", format, info.FrameCount)
+		}
+	}
+}
+
+func TestAnalyzePixels(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			img.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+	img.Set(0, 0, color.RGBA{0, 0, 0, 0})
+
+	count, capped, alphaUsed := analyzePixels(img)
+	if count != 2 {
+		t.Errorf("Expected 2 colors, got %d", count)
+	}
+	if capped {
+		t.Error("Expected color count not to be capped")
+	}
+	if !alphaUsed {
+		t.Error("Expected transparent pixel to be detected")
+	}
+}
+
+func TestFitWithin(t *testing.T) {
+	tests := []struct {
+		width, height, maxEdge int
+		expectedW, expectedH   int
+	}{
+		{100, 50, 512, 100, 50},
+		{2048, 1024, 512, 512, 256},
+		{1000, 4000, 512, 128, 512},
+	}
+
+	for _, tt := range tests {
+		w, h := fitWithin(tt.width, tt.height, tt.maxEdge)
+		if w != tt.expectedW || h != tt.expectedH {
+			t.Errorf("fitWithin(%d, %d, %d) = %dx%d, expected %dx%d", tt.width, tt.height, tt.maxEdge, w, h, tt.expectedW, tt.expectedH)
+		}
+	}
+}
+
+func TestRecommendSettings_Photo(t *testing.T) {
+	analysis := &ImageAnalysis{
+		Format:           "jpeg",
+		FileSize:         100000,
+		ColorCount:       maxCountedColors,
+		ColorCountCapped: true,
+		Estimates: []FormatEstimate{
+			{Format: "jpeg", Quality: 80, EstimatedSize: 60000},
+			{Format: "webp", Quality: 80, EstimatedSize: 40000},
+			{Format: "webp", Quality: 60, EstimatedSize: 20000}, // Smaller, but not the target quality
+			{Format: "png", Lossless: true, EstimatedSize: 10000},
+		},
+	}
+
+	rec := recommendSettings(analysis, DefaultAnalysisQualities)
+	if rec.Format != "webp" || rec.Quality != 80 {
+		t.Errorf("Expected webp at quality 80, got %s at %d", rec.Format, rec.Quality)
+	}
+}
+
+func TestRecommendSettings_PhotoLosslessFallback(t *testing.T) {
+	analysis := &ImageAnalysis{
+		Format:           "png",
+		FileSize:         100000,
+		ColorCount:       maxCountedColors,
+		ColorCountCapped: true,
+		Estimates: []FormatEstimate{
+			{Format: "png", Lossless: true, EstimatedSize: 90000},
+		},
+	}
+
+	rec := recommendSettings(analysis, DefaultAnalysisQualities)
+	if rec.Format != "png" || !rec.Lossless || rec.Quality != 0 {
+		t.Fatalf("Expected the lossless PNG fallback, got %+v", rec)
+	}
+	if len(rec.Reasons) == 0 || strings.Contains(rec.Reasons[0], "quality") || !strings.Contains(rec.Reasons[0], "lossless") {
+		t.Errorf("Expected a reason describing lossless encoding, got %q", rec.Reasons)
+	}
+}
+
+func TestRecommendSettings_GraphicWithAlpha(t *testing.T) {
+	analysis := &ImageAnalysis{
+		Format:     "png",
+		FileSize:   50000,
+		ColorCount: 12,
+		AlphaUsed:  true,
+		Estimates: []FormatEstimate{
+			{Format: "jpeg", Quality: 80, EstimatedSize: 1000},
+			{Format: "png", Lossless: true, Palette: true, EstimatedSize: 8000},
+			{Format: "webp", Lossless: true, EstimatedSize: 9000},
+		},
+	}
+
+	rec := recommendSettings(analysis, DefaultAnalysisQualities)
+	if rec.Format != "png" || !rec.Palette || !rec.Lossless {
+		t.Errorf("Expected lossless palette PNG, got %+v", rec)
+	}
+}
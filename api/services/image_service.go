@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os/exec"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/h2non/bimg"
@@ -39,6 +40,28 @@ type OptimizeResult struct {
 	ColorSpace         string `json:"colorSpace"`         // Color space of the result (srgb, p3, etc)
 	OriginalColorSpace string `json:"originalColorSpace"` // Original image color space
 	WideGamut          bool   `json:"wideGamut"`          // True if image uses colors beyond sRGB
+
+	SourceBitDepth int    `json:"sourceBitDepth"`       // Bits per channel of the input
+	BitDepth       int    `json:"bitDepth"`             // Bits per channel of the result
+	HDR            string `json:"hdr,omitempty"`        // HDR transfer function of the input (pq, hlg)
+	Lossless       bool   `json:"lossless,omitempty"`   // True if the pixels are bit-exact with the source (lossless JPEG transcoding)
+	ToneMapped     bool   `json:"toneMapped,omitempty"` // True if HDR input was tone-mapped to SDR
+
+	Page      int `json:"page,omitempty"`      // Rendered page, for PDF/TIFF documents
+	PageCount int `json:"pageCount,omitempty"` // Pages in the source document
+
+	DPR        *DPRInfo  `json:"dpr,omitempty"`        // How the device pixel ratio was applied
+	Sharpen    float64   `json:"sharpen,omitempty"`    // Unsharp mask strength applied after resizing
+	Trim       *TrimInfo `json:"trim,omitempty"`       // Trimmed offsets (when trim was requested)
+	Background string    `json:"background,omitempty"` // Color transparency was flattened onto (#rrggbb), if any
+	Warnings   []string  `json:"warnings,omitempty"`   // Non-fatal issues, e.g. transparency discarded
+
+	// SkippedTransforms lists requested changes missing from the result because the
+	// smaller original was returned (allowOriginalFallback=true)
+	SkippedTransforms []string `json:"skippedTransforms,omitempty"`
+
+	Strategy string           `json:"strategy,omitempty"` // Winning encoding strategy (effort=max)
+	Attempts []EncoderAttempt `json:"attempts,omitempty"` // Every encoding tried (effort=max)
 }
 
 // OptimizeOptions contains parameters for image optimization
@@ -67,14 +90,53 @@ type OptimizeOptions struct {
 	// Advanced WebP options
 	Lossless   bool // Lossless WebP encoding (can also be set via LosslessMode)
 	Effort     int  // CPU effort (0-6, default 4)
+	EffortMax  bool // Try several encoding strategies and keep the smallest (effort=max)
 	WebpMethod int  // Encoding method (0-6, higher=better compression but slower)
 
 	// Advanced PNG optimization with OxiPNG
 	OxipngLevel int // OxiPNG optimization level (0-6, default 2, higher=better compression but slower)
+
+	// Border trimming (product photos, scans, padded graphics)
+	Trim          bool // Crop uniform or transparent borders (border color taken from the top-left pixel)
+	TrimThreshold int  // Max per-channel difference (0-255) still treated as border
+	TrimMargin    int  // Pixels of border to keep on each side
+
+	// Device pixel ratio (multiplies Width/Height, capped at the source resolution)
+	DPR        float64 // 1-4, 0 = not requested
+	DPRQuality bool    // Lower quality for high-DPR renditions
+
+	// Resampling
+	LinearResize bool    // Resize in linear light so thin high-contrast detail does not darken
+	Sharpen      float64 // Unsharp mask strength after resizing (0 = automatic for large downscales, SharpenOff disables)
+
+	// Resize mode when both Width and Height are set: "" fits the image and pads it to
+	// exactly Width x Height, FitInside only fits, FitFill stretches, FitCover crops
+	Fit     string
+	Gravity string // Crop anchor for FitCover (GravityCentre by default, GravitySmart finds the interesting area)
+
+	// High bit depth and HDR
+	KeepBitDepth bool // Keep 16-bit PNG / high-bit-depth AVIF output instead of reducing to 8-bit
+	ToneMap      bool // Tone-map HDR (PQ/HLG) sources to SDR
+
+	// When the optimized image is larger than the original: OriginalFallbackAuto returns
+	// the original only if it is equivalent to the requested output, OriginalFallbackAlways
+	// whenever it is smaller (skipping requested transforms), OriginalFallbackNever never
+	OriginalFallback string
+
+	// TIFF output (archival derivatives)
+	TIFFCompression string // lzw (default), deflate/zip, jpeg or none
+
+	// Alpha flattening for output formats without transparency (JPEG)
+	Background       string // Hex color, "white"/"black" or "auto" (average edge color); default white
+	AlphaCompositing string // "straight" (default) or "premultiplied"
 }
 
 // OptimizeImage processes and optimizes image data using libvips
 func OptimizeImage(buffer []byte, options OptimizeOptions) (*OptimizeResult, error) {
+	if options.EffortMax {
+		return optimizeCompetition(buffer, options)
+	}
+
 	startTime := time.Now()
 
 	originalSize := int64(len(buffer))
@@ -86,6 +148,16 @@ func OptimizeImage(buffer []byte, options OptimizeOptions) (*OptimizeResult, err
 		defer func() { <-largeImageSemaphore }() // Release when done
 	}
 
+	// libvips has no BMP loader without ImageMagick - decode BMP here and continue from a lossless PNG
+	fromBMP := IsBMP(buffer)
+	if fromBMP {
+		converted, err := bmpToPNG(buffer)
+		if err != nil {
+			return nil, err
+		}
+		buffer = converted
+	}
+
 	// Get original image metadata before processing
 	originalMetadata, err := bimg.NewImage(buffer).Metadata()
 	if err != nil {
@@ -95,6 +167,9 @@ func OptimizeImage(buffer []byte, options OptimizeOptions) (*OptimizeResult, err
 	// Detect original color space
 	originalColorSpace := detectColorSpace(originalMetadata)
 
+	// Bit depth and HDR signaling come from the file headers - libvips metadata has neither
+	sourceEncoding := probeEncoding(buffer)
+
 	// Handle lossless mode - overrides quality and compression settings
 	if options.LosslessMode {
 		// Force maximum quality for lossless mode
@@ -167,23 +242,7 @@ func OptimizeImage(buffer []byte, options OptimizeOptions) (*OptimizeResult, err
 	// Set interpolation algorithm for resizing
 	// Use bicubic by default for best quality
 	if options.Interpolator != "" {
-		switch options.Interpolator {
-		case "nearest":
-			bimgOptions.Interpolator = bimg.Nearest
-		case "bilinear":
-			bimgOptions.Interpolator = bimg.Bilinear
-		case "bicubic":
-			bimgOptions.Interpolator = bimg.Bicubic
-		case "nohalo":
-			bimgOptions.Interpolator = bimg.Nohalo
-		case "vsqbs", "lanczos2", "lanczos3":
-			// These advanced interpolators map to bicubic in bimg
-			// (bimg uses libvips' bicubic which is high quality)
-			bimgOptions.Interpolator = bimg.Bicubic
-		default:
-			// Default to bicubic for best quality
-			bimgOptions.Interpolator = bimg.Bicubic
-		}
+		bimgOptions.Interpolator = interpolatorFor(options.Interpolator)
 	} else {
 		// Use bicubic as default for best quality, especially in lossless mode or upscaling
 		if options.LosslessMode || (options.Width > 0 && options.Width > originalMetadata.Size.Width) || (options.Height > 0 && options.Height > originalMetadata.Size.Height) {
@@ -242,7 +301,18 @@ func OptimizeImage(buffer []byte, options OptimizeOptions) (*OptimizeResult, err
 	if options.Width > 0 || options.Height > 0 {
 		bimgOptions.Width = options.Width
 		bimgOptions.Height = options.Height
-		bimgOptions.Embed = true // Preserve aspect ratio
+		switch options.Fit {
+		case FitInside:
+			// Without Embed libvips keeps the aspect ratio inside the box and does not pad
+		case FitFill:
+			bimgOptions.Force = true
+		case FitCover:
+			bimgOptions.Crop = true
+			bimgOptions.Enlarge = true
+			bimgOptions.Gravity = gravityFor(options.Gravity)
+		default:
+			bimgOptions.Embed = true // Preserve aspect ratio
+		}
 	}
 
 	// Handle format conversion
@@ -250,10 +320,181 @@ func OptimizeImage(buffer []byte, options OptimizeOptions) (*OptimizeResult, err
 		bimgOptions.Type = options.Format
 	}
 
+	processBuffer := buffer
+	sourceModified := fromBMP // The original BMP can never be returned as-is
+	var warnings []string
+	targetFormat := options.Format
+	if targetFormat == 0 {
+		targetFormat = getImageTypeFromString(originalMetadata.Type)
+	}
+
+	// Transforms that make the output differ from the source beyond re-encoding;
+	// the original is only returned in their place if the caller allows it
+	var transforms []string
+
+	// Tone-map HDR sources first so every later step works on SDR pixels
+	toneMapped := false
+	if hdr := sourceEncoding.hdr(); hdr != "" {
+		if options.ToneMap {
+			mapped, err := toneMapToSDR(processBuffer, sourceEncoding)
+			if err != nil {
+				return nil, fmt.Errorf("failed to tone-map image: %w", err)
+			}
+			processBuffer = mapped
+			sourceModified = true
+			toneMapped = true
+			transforms = append(transforms, TransformToneMap)
+		} else {
+			warnings = append(warnings, fmt.Sprintf("HDR (%s) source was not tone-mapped: highlights may clip and colors look washed out in SDR output (use toneMap=true)", hdr))
+		}
+	} else if options.ToneMap {
+		warnings = append(warnings, "Tone mapping was skipped: the source is not HDR (PQ/HLG)")
+	}
+
+	// Trim uniform borders before resizing so width/height apply to the content
+	var trimInfo *TrimInfo
+	if options.Trim {
+		trimmed, info, err := trimBorders(processBuffer, options.TrimThreshold, options.TrimMargin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to trim image: %w", err)
+		}
+		trimInfo = info
+		if trimmed != nil {
+			processBuffer = trimmed
+			sourceModified = true
+			transforms = append(transforms, TransformTrim)
+		} else {
+			warnings = append(warnings, "Nothing was trimmed: no uniform border found")
+		}
+	}
+
+	// Flatten transparency ourselves when the output format cannot store it,
+	// so the background color and compositing mode are under our control
+	var flattenedBackground string
+	if originalMetadata.Alpha {
+		if !formatSupportsAlpha(targetFormat) {
+			flattened, flatten, err := flattenAlpha(processBuffer, options.Background, options.AlphaCompositing)
+			if err != nil {
+				return nil, fmt.Errorf("failed to flatten transparency: %w", err)
+			}
+			if flatten.TransparencyUsed {
+				processBuffer = flattened
+				sourceModified = true
+				flattenedBackground = flatten.Background
+				transforms = append(transforms, TransformFlatten)
+				compositing := options.AlphaCompositing
+				if compositing == "" {
+					compositing = AlphaCompositingStraight
+				}
+				warnings = append(warnings, fmt.Sprintf("Transparency was discarded: %s has no alpha channel, so the image was flattened onto %s (%s alpha)",
+					bimg.ImageTypeName(targetFormat), flatten.Background, compositing))
+			}
+		} else if options.Background != "" {
+			warnings = append(warnings, "Background was ignored: the output format keeps transparency")
+		}
+	}
+
+	// Size of the image entering the resize step
+	sourceWidth, sourceHeight := displaySize(originalMetadata)
+	if trimInfo != nil {
+		sourceWidth, sourceHeight = trimInfo.Width, trimInfo.Height
+	}
+
+	// Apply the device pixel ratio to the requested dimensions, capped at the source resolution
+	var dprInfo *DPRInfo
+	if options.DPR > 0 {
+		if options.Width == 0 && options.Height == 0 {
+			warnings = append(warnings, "DPR was ignored: it multiplies width/height, and neither was given")
+		} else {
+			width, height, effective := resolveDPR(options.Width, options.Height, sourceWidth, sourceHeight, options.DPR)
+			bimgOptions.Width = width
+			bimgOptions.Height = height
+			if options.DPRQuality && !options.LosslessMode {
+				options.Quality = dprQuality(options.Quality, effective)
+				bimgOptions.Quality = options.Quality
+			}
+			dprInfo = &DPRInfo{
+				Requested: options.DPR,
+				Effective: effective,
+				Width:     width,
+				Height:    height,
+				Quality:   options.Quality,
+				Capped:    effective < options.DPR,
+			}
+		}
+	}
+
+	// Resample in linear light if requested, and sharpen large reductions, which come out soft
+	var sharpenStrength float64
+	if bimgOptions.Width > 0 || bimgOptions.Height > 0 {
+		factor := downscaleFactor(sourceWidth, sourceHeight, bimgOptions.Width, bimgOptions.Height)
+		if options.LinearResize {
+			resized, err := linearResize(processBuffer, bimgOptions)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resize image in linear light: %w", err)
+			}
+			processBuffer = resized
+			sourceModified = true
+			// Already at the target size - only encoding (and sharpening) is left
+			bimgOptions.Width = 0
+			bimgOptions.Height = 0
+			bimgOptions.Embed = false
+		}
+
+		switch {
+		case options.Sharpen > 0:
+			sharpenStrength = options.Sharpen
+		case options.Sharpen == 0:
+			sharpenStrength = autoSharpenStrength(factor)
+		}
+		if sharpenStrength > 0 {
+			bimgOptions.Sharpen = sharpenOptions(sharpenStrength)
+		}
+	}
+
+	// Keep high bit depth where the output format can store it; libvips writes 8-bit by default
+	if options.KeepBitDepth && sourceEncoding.BitDepth > 8 {
+		switch {
+		case targetFormat != bimg.PNG && targetFormat != bimg.AVIF:
+			warnings = append(warnings, fmt.Sprintf("keepBitDepth was ignored: %s output is always 8-bit", bimg.ImageTypeName(targetFormat)))
+		case sourceModified:
+			warnings = append(warnings, "keepBitDepth was ignored: tone mapping, trimming, flattening and linear resizing produce 8-bit images")
+		default:
+			bimgOptions.Interpretation = bimg.InterpretationRGB16
+			if originalMetadata.Channels <= 2 {
+				bimgOptions.Interpretation = bimg.InterpretationGREY16
+			}
+			bimgOptions.Palette = false // Quantizing would reduce to 8-bit
+		}
+	}
+
+	// Intermediates are lossless PNGs - keep the source format unless another was requested
+	if sourceModified {
+		bimgOptions.Type = targetFormat
+	}
+
+	// JPEG to JPEG with the pixels left alone: in lossless mode, rewrite the entropy
+	// coding of the original DCT coefficients instead of decoding and re-encoding
+	var optimizedBuffer []byte
+	lossless := false
+	if options.LosslessMode && !sourceModified && originalMetadata.Type == "jpeg" && sourceEncoding.BitDepth == 8 &&
+		(options.Format == 0 || options.Format == bimg.JPEG) &&
+		bimgOptions.Width == 0 && bimgOptions.Height == 0 && sharpenStrength == 0 {
+		transcoded, err := transcodeJPEGLossless(buffer, originalMetadata.Orientation, !options.ForceSRGB)
+		if err == nil {
+			optimizedBuffer = transcoded
+			lossless = true
+		} else {
+			warnings = append(warnings, fmt.Sprintf("Lossless JPEG optimization unavailable, re-encoded at quality 100 instead: %v", err))
+		}
+	}
+
 	// Process the image
-	optimizedBuffer, err := bimg.NewImage(buffer).Process(bimgOptions)
-	if err != nil {
-		return nil, fmt.Errorf("failed to process image: %w", err)
+	if !lossless {
+		optimizedBuffer, err = bimg.NewImage(processBuffer).Process(bimgOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process image: %w", err)
+		}
 	}
 
 	// Apply format-specific post-processing optimizations
@@ -295,6 +536,20 @@ func OptimizeImage(buffer []byte, options OptimizeOptions) (*OptimizeResult, err
 		// If OxiPNG fails, continue with bimg output (graceful degradation)
 	}
 
+	// Compress TIFF output - bimg's tiffsave always writes it uncompressed
+	if resultFormat == bimg.TIFF {
+		compression, err := NormalizeTIFFCompression(options.TIFFCompression)
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := compressTIFF(optimizedBuffer, compression, options.Quality)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("TIFF %s compression failed, the TIFF was written uncompressed: %v", compression, err))
+		} else {
+			optimizedBuffer = compressed
+		}
+	}
+
 	// Apply MozJPEG post-processing for JPEG format
 	// This provides additional 20-30% compression beyond libjpeg-turbo
 	// Skip for very large images where the time cost outweighs the compression benefit
@@ -327,13 +582,32 @@ func OptimizeImage(buffer []byte, options OptimizeOptions) (*OptimizeResult, err
 	originalFormat := getImageTypeFromString(originalMetadata.Type)
 	formatConversionRequested := options.Format != 0 && options.Format != originalFormat
 
-	if optimizedSize > originalSize && !formatConversionRequested {
-		// Optimization made the file larger and no format conversion was requested
-		// Return original instead to preserve quality
-		alreadyOptimized = true
-		message = "This image is already well-optimized. Returning original file to avoid quality loss."
-		resultBuffer = buffer
-		resultSize = originalSize
+	// Returning the original is only equivalent if nothing but the encoding changed
+	var skippedTransforms []string
+	if optimizedSize > originalSize && !formatConversionRequested && !fromBMP {
+		transforms = append(transforms, outputTransforms(optimizedBuffer, originalMetadata, sourceEncoding,
+			sourceWidth, sourceHeight, sharpenStrength)...)
+
+		switch {
+		case options.OriginalFallback == OriginalFallbackNever:
+			message = "The optimized image is larger than the original; returning it anyway (allowOriginalFallback=false)."
+		case len(transforms) > 0 && options.OriginalFallback != OriginalFallbackAlways:
+			message = fmt.Sprintf("The optimized image is larger than the original, but the original was not returned "+
+				"because it does not include the requested changes (%s). Set allowOriginalFallback=true to prefer the original.",
+				strings.Join(transforms, ", "))
+		default:
+			// Optimization made the file larger and no format conversion was requested
+			// Return original instead to preserve quality
+			alreadyOptimized = true
+			message = "This image is already well-optimized. Returning original file to avoid quality loss."
+			resultBuffer = buffer
+			resultSize = originalSize
+			if len(transforms) > 0 {
+				skippedTransforms = transforms
+				warnings = append(warnings, "The original was returned because it is smaller, so these transforms were skipped: "+
+					strings.Join(transforms, ", "))
+			}
+		}
 	}
 
 	// Get result image metadata (either optimized or original)
@@ -359,11 +633,16 @@ func OptimizeImage(buffer []byte, options OptimizeOptions) (*OptimizeResult, err
 
 	processingTime := time.Since(startTime)
 
+	originalFormatName := originalMetadata.Type
+	if fromBMP {
+		originalFormatName = "bmp"
+	}
+
 	return &OptimizeResult{
 		OriginalSize:       originalSize,
 		OptimizedSize:      resultSize,
 		Format:             formatName,
-		OriginalFormat:     originalMetadata.Type,
+		OriginalFormat:     originalFormatName,
 		Width:              resultMetadata.Size.Width,
 		Height:             resultMetadata.Size.Height,
 		Savings:            fmt.Sprintf("%.2f%%", savingsPercent),
@@ -374,6 +653,17 @@ func OptimizeImage(buffer []byte, options OptimizeOptions) (*OptimizeResult, err
 		ColorSpace:         resultColorSpace,
 		OriginalColorSpace: originalColorSpace,
 		WideGamut:          false, // Simplified: false for now, true ICC profile parsing needed
+		SourceBitDepth:     sourceEncoding.BitDepth,
+		BitDepth:           probeEncoding(resultBuffer).BitDepth,
+		HDR:                sourceEncoding.hdr(),
+		ToneMapped:         toneMapped,
+		Lossless:           lossless,
+		DPR:                dprInfo,
+		Sharpen:            sharpenStrength,
+		Trim:               trimInfo,
+		Background:         flattenedBackground,
+		Warnings:           warnings,
+		SkippedTransforms:  skippedTransforms,
 	}, nil
 }
 
@@ -390,6 +680,8 @@ func getFormatName(imgType string) string {
 		return "gif"
 	case "avif":
 		return "avif"
+	case "tiff":
+		return "tiff"
 	case "svg":
 		return "svg"
 	case "pdf":
@@ -412,6 +704,8 @@ func getImageTypeFromString(format string) bimg.ImageType {
 		return bimg.GIF
 	case "avif":
 		return bimg.AVIF
+	case "tiff":
+		return bimg.TIFF
 	case "svg":
 		return bimg.SVG
 	case "pdf":
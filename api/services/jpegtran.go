@@ -0,0 +1,85 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// jpegtranTimeout bounds a single jpegtran run; transcoding is much faster than encoding
+const jpegtranTimeout = 30 * time.Second
+
+// orientationTransforms maps EXIF orientations to the jpegtran transform that
+// makes the image upright. Orientation 1 (and missing EXIF) needs none.
+var orientationTransforms = map[int][]string{
+	2: {"-flip", "horizontal"},
+	3: {"-rotate", "180"},
+	4: {"-flip", "vertical"},
+	5: {"-transpose"},
+	6: {"-rotate", "90"},
+	7: {"-transverse"},
+	8: {"-rotate", "270"},
+}
+
+// transcodeJPEGLossless optimizes a JPEG without decoding it, like jpegtran: the
+// quantized DCT coefficients are kept and only the entropy coding is redone, with
+// optimized Huffman tables and progressive scans. Metadata is stripped (the ICC
+// profile is kept when keepICC is set), and the EXIF orientation is applied by
+// rotating the coefficients, so the pixels are bit-exact with the upright original.
+//
+// Rotations only stay lossless when the image dimensions are a multiple of the
+// MCU size. Otherwise an error is returned and the caller re-encodes the image,
+// since keeping the orientation tag would mean keeping all the other metadata
+// (GPS position, MakerNote) with it.
+func transcodeJPEGLossless(buffer []byte, orientation int, keepICC bool) ([]byte, error) {
+	copyMode := "none"
+	if keepICC {
+		copyMode = "icc"
+	}
+
+	transform, rotate := orientationTransforms[orientation]
+	if !rotate {
+		return runJPEGTran(buffer, "-copy", copyMode)
+	}
+
+	// -perfect fails instead of dropping the partial edge blocks
+	args := append([]string{"-copy", copyMode, "-perfect"}, transform...)
+	out, err := runJPEGTran(buffer, args...)
+	if err != nil {
+		return nil, fmt.Errorf("EXIF orientation cannot be applied losslessly (dimensions are not a multiple of the JPEG block size): %w", err)
+	}
+	return out, nil
+}
+
+// runJPEGTran runs jpegtran with Huffman optimization and progressive output on
+// top of args, reading the JPEG from stdin.
+//
+// SECURITY: the binary is fixed and every argument comes from the constant
+// tables above; input and output go through pipes, never file paths.
+func runJPEGTran(buffer []byte, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jpegtranTimeout)
+	defer cancel()
+
+	args = append(args, "-optimize", "-progressive")
+
+	// #nosec G204 - Binary is hardcoded and arguments come from constant tables
+	cmd := exec.CommandContext(ctx, "jpegtran", args...)
+	cmd.Stdin = bytes.NewReader(buffer)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("jpegtran timed out after %v", jpegtranTimeout)
+		}
+		return nil, fmt.Errorf("jpegtran failed: %w - %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("jpegtran produced empty output")
+	}
+	return stdout.Bytes(), nil
+}
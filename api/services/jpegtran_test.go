@@ -0,0 +1,68 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os/exec"
+	"testing"
+)
+
+func TestOrientationTransforms(t *testing.T) {
+	if _, ok := orientationTransforms[1]; ok {
+		t.Error("Orientation 1 is already upright and must not be transformed")
+	}
+	for orientation := 2; orientation <= 8; orientation++ {
+		if len(orientationTransforms[orientation]) == 0 {
+			t.Errorf("Missing transform for EXIF orientation %d", orientation)
+		}
+	}
+}
+
+func TestTranscodeJPEGLossless(t *testing.T) {
+	if _, err := exec.LookPath("jpegtran"); err != nil {
+		t.Skip("jpegtran not installed")
+	}
+
+	original := loadTestFixture(t, "test-100x100.jpg")
+	transcoded, err := transcodeJPEGLossless(original, 1, true)
+	if err != nil {
+		t.Fatalf("transcodeJPEGLossless failed: %v", err)
+	}
+
+	before, err := jpeg.Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("Failed to decode original: %v", err)
+	}
+	after, err := jpeg.Decode(bytes.NewReader(transcoded))
+	if err != nil {
+		t.Fatalf("Failed to decode transcoded JPEG: %v", err)
+	}
+
+	bounds := before.Bounds()
+	if after.Bounds() != bounds {
+		t.Fatalf("Expected bounds %v, got %v", bounds, after.Bounds())
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if before.At(x, y) != after.At(x, y) {
+				t.Fatalf("Pixel (%d,%d) changed: %v -> %v", x, y, before.At(x, y), after.At(x, y))
+			}
+		}
+	}
+}
+
+func TestTranscodeJPEGLossless_ImperfectRotation(t *testing.T) {
+	if _, err := exec.LookPath("jpegtran"); err != nil {
+		t.Skip("jpegtran not installed")
+	}
+
+	// 10x10 is not a multiple of the 8x8 (or 16x16) MCU, so rotating is lossy
+	var original bytes.Buffer
+	if err := jpeg.Encode(&original, image.NewGray(image.Rect(0, 0, 10, 10)), nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	if _, err := transcodeJPEGLossless(original.Bytes(), 6, true); err == nil {
+		t.Error("Expected an error so the caller re-encodes instead of keeping all metadata")
+	}
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"  // Register GIF decoder
+	_ "image/jpeg" // Register JPEG decoder
+	_ "image/png"  // Register PNG decoder
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// Perceptual hash algorithms supported for near-duplicate detection
+const (
+	HashAlgorithmAverage    = "ahash" // Average hash: fastest, sensitive to gamma/contrast changes
+	HashAlgorithmDifference = "dhash" // Difference hash: tracks gradients, robust to brightness changes
+	HashAlgorithmPerceptual = "phash" // DCT-based hash: most robust to scaling and recompression
+
+	// DefaultHashAlgorithm is used when no algorithm is requested
+	DefaultHashAlgorithm = HashAlgorithmPerceptual
+
+	// DefaultSimilarityThreshold is the maximum Hamming distance (out of 64 bits)
+	// at which two images are considered near-duplicates
+	DefaultSimilarityThreshold = 10
+
+	// hashSampleSize is the edge length of the grayscale thumbnail all hashes are computed from
+	hashSampleSize = 32
+)
+
+// PerceptualHash is a 64-bit perceptual fingerprint of an image
+// It is serialized as a 16-character hex string in JSON responses
+type PerceptualHash uint64
+
+// String returns the hash as a zero-padded hex string
+func (h PerceptualHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// MarshalJSON encodes the hash as a hex string (uint64 does not survive JavaScript number precision)
+func (h PerceptualHash) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.String())
+}
+
+// UnmarshalJSON decodes a hash from its hex string representation
+func (h *PerceptualHash) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	value, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid perceptual hash %q: %w", s, err)
+	}
+	*h = PerceptualHash(value)
+	return nil
+}
+
+// ImageHashes holds all perceptual hashes computed for a single image
+type ImageHashes struct {
+	AHash PerceptualHash `json:"aHash"`
+	DHash PerceptualHash `json:"dHash"`
+	PHash PerceptualHash `json:"pHash"`
+}
+
+// Get returns the hash for the given algorithm (defaults to pHash)
+func (h ImageHashes) Get(algorithm string) PerceptualHash {
+	switch algorithm {
+	case HashAlgorithmAverage:
+		return h.AHash
+	case HashAlgorithmDifference:
+		return h.DHash
+	default:
+		return h.PHash
+	}
+}
+
+// Distance returns the Hamming distance between two images using the given algorithm
+func (h ImageHashes) Distance(other ImageHashes, algorithm string) int {
+	return HammingDistance(h.Get(algorithm), other.Get(algorithm))
+}
+
+// HammingDistance returns the number of differing bits between two hashes (0-64)
+func HammingDistance(a, b PerceptualHash) int {
+	return bits.OnesCount64(uint64(a) ^ uint64(b))
+}
+
+// Similarity converts a Hamming distance into a percentage (100% = identical hashes)
+func Similarity(distance int) float64 {
+	return float64(64-distance) / 64 * 100
+}
+
+// IsValidHashAlgorithm reports whether the given algorithm name is supported
+func IsValidHashAlgorithm(algorithm string) bool {
+	switch strings.ToLower(algorithm) {
+	case HashAlgorithmAverage, HashAlgorithmDifference, HashAlgorithmPerceptual:
+		return true
+	default:
+		return false
+	}
+}
+
+// ComputePerceptualHashes computes aHash, dHash and pHash for encoded image data
+// libvips produces a small grayscale-ready thumbnail first, so this works for every
+// format libvips can decode (including AVIF) and stays cheap for very large inputs
+func ComputePerceptualHashes(buffer []byte) (*ImageHashes, error) {
+	thumbnail, err := bimg.NewImage(buffer).Process(bimg.Options{
+		Width:        hashSampleSize,
+		Height:       hashSampleSize,
+		Force:        true, // Ignore aspect ratio so resized copies hash identically
+		Interpolator: bimg.Bilinear,
+		Type:         bimg.PNG,
+		Compression:  1, // Thumbnail is decoded immediately, favor speed
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hash thumbnail: %w", err)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(thumbnail))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode hash thumbnail: %w", err)
+	}
+
+	hashes := ComputeImageHashes(img)
+	return &hashes, nil
+}
+
+// ComputeImageHashes computes aHash, dHash and pHash for a decoded image
+func ComputeImageHashes(img image.Image) ImageHashes {
+	return ImageHashes{
+		AHash: averageHash(grayscaleGrid(img, 8, 8)),
+		DHash: differenceHash(grayscaleGrid(img, 9, 8)),
+		PHash: dctHash(grayscaleGrid(img, hashSampleSize, hashSampleSize)),
+	}
+}
+
+// grayscaleGrid downsamples an image to a width x height grid of luma values using box averaging
+func grayscaleGrid(img image.Image, width, height int) [][]float64 {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	sums := make([][]float64, height)
+	counts := make([][]int, height)
+	for y := range sums {
+		sums[y] = make([]float64, width)
+		counts[y] = make([]int, width)
+	}
+
+	for y := 0; y < srcH; y++ {
+		cellY := y * height / srcH
+		for x := 0; x < srcW; x++ {
+			cellX := x * width / srcW
+			gray := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			sums[cellY][cellX] += float64(gray.Y)
+			counts[cellY][cellX]++
+		}
+	}
+
+	// Upscaling leaves some cells empty - fill them from the nearest source pixel
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if counts[y][x] > 0 {
+				sums[y][x] /= float64(counts[y][x])
+				continue
+			}
+			srcX := bounds.Min.X + x*srcW/width
+			srcY := bounds.Min.Y + y*srcH/height
+			sums[y][x] = float64(color.GrayModel.Convert(img.At(srcX, srcY)).(color.Gray).Y)
+		}
+	}
+
+	return sums
+}
+
+// averageHash sets a bit for every cell brighter than the mean of an 8x8 grid
+func averageHash(grid [][]float64) PerceptualHash {
+	var total float64
+	for _, row := range grid {
+		for _, v := range row {
+			total += v
+		}
+	}
+	mean := total / 64
+
+	var hash uint64
+	bit := 0
+	for _, row := range grid {
+		for _, v := range row {
+			if v > mean {
+				hash |= 1 << uint(bit)
+			}
+			bit++
+		}
+	}
+	return PerceptualHash(hash)
+}
+
+// differenceHash sets a bit for every cell brighter than its right neighbor in a 9x8 grid
+func differenceHash(grid [][]float64) PerceptualHash {
+	var hash uint64
+	bit := 0
+	for _, row := range grid {
+		for x := 0; x < 8; x++ {
+			if row[x] > row[x+1] {
+				hash |= 1 << uint(bit)
+			}
+			bit++
+		}
+	}
+	return PerceptualHash(hash)
+}
+
+// dctHash computes the classic pHash: a 2D DCT of the 32x32 grid, keeping the
+// 8x8 lowest frequencies and setting a bit for every coefficient above their median
+func dctHash(grid [][]float64) PerceptualHash {
+	n := len(grid)
+
+	// Precompute the DCT-II cosine table
+	cosTable := make([][]float64, 8)
+	for u := range cosTable {
+		cosTable[u] = make([]float64, n)
+		for x := 0; x < n; x++ {
+			cosTable[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*n))
+		}
+	}
+
+	// Row transform (only the 8 lowest frequencies are needed)
+	rows := make([][]float64, n)
+	for y := 0; y < n; y++ {
+		rows[y] = make([]float64, 8)
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < n; x++ {
+				sum += grid[y][x] * cosTable[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+
+	// Column transform
+	coefficients := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < n; y++ {
+				sum += rows[y][u] * cosTable[v][y]
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+
+	// Median excludes the DC term, which only encodes overall brightness
+	sorted := make([]float64, 63)
+	copy(sorted, coefficients[1:])
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for i, c := range coefficients {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return PerceptualHash(hash)
+}
+
+// GroupNearDuplicates clusters images whose hashes are within threshold bits of
+// each other. Groups are returned as index lists in input order, and the first
+// index of each group is treated as the canonical image. Only groups with more
+// than one member are returned.
+func GroupNearDuplicates(hashes []ImageHashes, algorithm string, threshold int) [][]int {
+	assigned := make([]bool, len(hashes))
+	groups := make([][]int, 0)
+
+	for i := range hashes {
+		if assigned[i] {
+			continue
+		}
+		group := []int{i}
+		for j := i + 1; j < len(hashes); j++ {
+			if assigned[j] {
+				continue
+			}
+			if hashes[i].Distance(hashes[j], algorithm) <= threshold {
+				group = append(group, j)
+				assigned[j] = true
+			}
+		}
+		assigned[i] = true
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// createGradientImage creates a test image with a diagonal gradient and a dark square
+// The pattern scales with the image so different sizes represent the "same" picture
+func createGradientImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8((x*255/width + y*255/height) / 2)
+			if x > width/4 && x < width/2 && y > height/4 && y < height/2 {
+				v = 20
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+// createCheckerImage creates a test image with a checkerboard pattern
+func createCheckerImage(width, height, cell int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (x/cell+y/cell)%2 == 0 {
+				img.Set(x, y, color.RGBA{255, 255, 255, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 0, 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestComputeImageHashes_Identical(t *testing.T) {
+	a := ComputeImageHashes(createGradientImage(128, 96))
+	b := ComputeImageHashes(createGradientImage(128, 96))
+
+	for _, algorithm := range []string{HashAlgorithmAverage, HashAlgorithmDifference, HashAlgorithmPerceptual} {
+		if d := a.Distance(b, algorithm); d != 0 {
+			t.Errorf("%s: expected distance 0 for identical images, got %d", algorithm, d)
+		}
+	}
+}
+
+func TestComputeImageHashes_ResizedCopy(t *testing.T) {
+	original := ComputeImageHashes(createGradientImage(256, 192))
+	resized := ComputeImageHashes(createGradientImage(64, 48))
+
+	for _, algorithm := range []string{HashAlgorithmAverage, HashAlgorithmDifference, HashAlgorithmPerceptual} {
+		if d := original.Distance(resized, algorithm); d > DefaultSimilarityThreshold {
+			t.Errorf("%s: expected resized copy within threshold %d, got distance %d", algorithm, DefaultSimilarityThreshold, d)
+		}
+	}
+}
+
+func TestComputeImageHashes_DifferentImages(t *testing.T) {
+	gradient := ComputeImageHashes(createGradientImage(128, 128))
+	checker := ComputeImageHashes(createCheckerImage(128, 128, 16))
+
+	if d := gradient.Distance(checker, HashAlgorithmPerceptual); d <= DefaultSimilarityThreshold {
+		t.Errorf("Expected different images to exceed threshold %d, got distance %d", DefaultSimilarityThreshold, d)
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	tests := []struct {
+		a, b     PerceptualHash
+		expected int
+	}{
+		{0, 0, 0},
+		{0xFFFFFFFFFFFFFFFF, 0, 64},
+		{0b1011, 0b0001, 2},
+	}
+
+	for _, tt := range tests {
+		if got := HammingDistance(tt.a, tt.b); got != tt.expected {
+			t.Errorf("HammingDistance(%s, %s) = %d, expected %d", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
+
+func TestPerceptualHash_JSONRoundTrip(t *testing.T) {
+	hash := PerceptualHash(0x00ff00ff12345678)
+
+	data, err := json.Marshal(hash)
+	if err != nil {
+		t.Fatalf("Failed to marshal hash: %v", err)
+	}
+	if string(data) != `"00ff00ff12345678"` {
+		t.Errorf("Expected hex string, got %s", data)
+	}
+
+	var decoded PerceptualHash
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal hash: %v", err)
+	}
+	if decoded != hash {
+		t.Errorf("Expected %s after round trip, got %s", hash, decoded)
+	}
+}
+
+func TestGroupNearDuplicates(t *testing.T) {
+	hashes := []ImageHashes{
+		{PHash: 0x0000000000000000},
+		{PHash: 0xFFFFFFFFFFFFFFFF},
+		{PHash: 0x0000000000000003}, // 2 bits from the first
+		{PHash: 0xFFFFFFFFFFFFFFF0}, // 4 bits from the second
+		{PHash: 0x00000000FFFFFFFF}, // 32 bits from both
+	}
+
+	groups := GroupNearDuplicates(hashes, HashAlgorithmPerceptual, 4)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d: %v", len(groups), groups)
+	}
+
+	if len(groups[0]) != 2 || groups[0][0] != 0 || groups[0][1] != 2 {
+		t.Errorf("Expected first group [0 2], got %v", groups[0])
+	}
+	if len(groups[1]) != 2 || groups[1][0] != 1 || groups[1][1] != 3 {
+		t.Errorf("Expected second group [1 3], got %v", groups[1])
+	}
+}
@@ -0,0 +1,582 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+// Pipeline operations
+const (
+	OpCrop      = "crop"
+	OpResize    = "resize"
+	OpRotate    = "rotate"
+	OpFlip      = "flip"
+	OpSharpen   = "sharpen"
+	OpBlur      = "blur"
+	OpGrayscale = "grayscale"
+	OpWatermark = "watermark"
+	OpEncode    = "encode"
+)
+
+// Resize fit modes
+const (
+	FitInside = "inside" // Keep the aspect ratio, fit within width x height (default)
+	FitFill   = "fill"   // Stretch to exactly width x height
+	FitCover  = "cover"  // Keep the aspect ratio, fill width x height and crop the overflow (centered)
+)
+
+// Pipeline limits
+const (
+	MaxPipelineSteps   = 32
+	MaxPipelineOutputs = 10
+
+	// MaxPipelineDimension caps crop and resize sizes; resizes are also checked
+	// against Pipeline.MaxPixels before they run
+	MaxPipelineDimension = 16384
+
+	// Watermark limits and defaults
+	maxWatermarkText        = 200
+	minWatermarkSize        = 4
+	maxWatermarkSize        = 200
+	defaultWatermarkSize    = 10
+	defaultWatermarkOpacity = 0.25
+	defaultWatermarkColor   = "black"
+
+	// maxBlurSigma keeps Gaussian blur kernels to a sane size
+	maxBlurSigma = 100
+)
+
+// Pipeline output containers
+const (
+	PipelineOutputZip       = "zip"
+	PipelineOutputMultipart = "multipart"
+)
+
+// ErrInvalidPipeline marks errors caused by the pipeline definition (or a step that
+// does not fit the image) rather than by processing
+var ErrInvalidPipeline = errors.New("invalid pipeline")
+
+// pipelineFields lists the fields each operation accepts besides "op"
+var pipelineFields = map[string][]string{
+	OpCrop:      {"left", "top", "width", "height"},
+	OpResize:    {"width", "height", "fit", "interpolator", "linear"},
+	OpRotate:    {"angle"},
+	OpFlip:      {"direction"},
+	OpSharpen:   {"strength"},
+	OpBlur:      {"sigma"},
+	OpGrayscale: {},
+	OpWatermark: {"text", "size", "opacity", "color", "tile"},
+	OpEncode:    {"name", "preset", "params"},
+}
+
+// outputNamePattern restricts output names to safe file names (the extension is added)
+var outputNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// Pipeline is an ordered list of operations applied to a decoded image. Encode
+// steps write an output from the current image and leave it unchanged, so one
+// pipeline can produce several outputs at different stages.
+type Pipeline struct {
+	Steps  []PipelineStep `json:"steps"`
+	Output string         `json:"output,omitempty"` // PipelineOutputZip (default) or PipelineOutputMultipart
+
+	// MaxPixels rejects steps whose result exceeds this many pixels (0 = no limit)
+	MaxPixels int `json:"-"`
+}
+
+// PipelineStep is one operation. Which fields apply depends on Op (see pipelineFields).
+type PipelineStep struct {
+	Op string `json:"op"`
+
+	// crop: the area to keep; resize: the target size (0 = derived from the aspect ratio)
+	Left   int `json:"left,omitempty"`
+	Top    int `json:"top,omitempty"`
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+
+	// resize
+	Fit          string `json:"fit,omitempty"`
+	Interpolator string `json:"interpolator,omitempty"`
+	Linear       bool   `json:"linear,omitempty"` // Resample in linear light (fit=inside only)
+
+	Angle     int     `json:"angle,omitempty"`     // rotate: 90, 180 or 270 (clockwise)
+	Direction string  `json:"direction,omitempty"` // flip: horizontal (mirror) or vertical
+	Strength  float64 `json:"strength,omitempty"`  // sharpen: unsharp mask strength, as for /optimize
+	Sigma     float64 `json:"sigma,omitempty"`     // blur: Gaussian sigma in pixels
+
+	// watermark: text drawn with libvips, tiled across the image when Tile is set
+	Text    string  `json:"text,omitempty"`
+	Size    int     `json:"size,omitempty"` // Font size in points (default 10)
+	Opacity float64 `json:"opacity,omitempty"`
+	Color   string  `json:"color,omitempty"` // Hex color, white or black (default black)
+	Tile    bool    `json:"tile,omitempty"`
+
+	// encode: the output file name (without extension) and its optimization parameters,
+	// given as /optimize query parameters. The caller resolves them into Options.
+	Name    string          `json:"name,omitempty"`
+	Preset  string          `json:"preset,omitempty"`
+	Params  PipelineParams  `json:"params,omitempty"`
+	Options OptimizeOptions `json:"-"`
+}
+
+// PipelineParams holds query-style parameters. JSON numbers and booleans are
+// accepted and stored in their string form.
+type PipelineParams map[string]string
+
+// UnmarshalJSON accepts string, number and boolean values
+func (p *PipelineParams) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	params := make(PipelineParams, len(raw))
+	for key, value := range raw {
+		var s string
+		if err := json.Unmarshal(value, &s); err == nil {
+			params[key] = s
+			continue
+		}
+		var scalar interface{}
+		if err := json.Unmarshal(value, &scalar); err != nil {
+			return err
+		}
+		switch v := scalar.(type) {
+		case float64:
+			params[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			params[key] = strconv.FormatBool(v)
+		default:
+			return fmt.Errorf("parameter %q must be a string, number or boolean", key)
+		}
+	}
+	*p = params
+	return nil
+}
+
+// PipelineOutput is one encoded image
+type PipelineOutput struct {
+	Name     string   `json:"name"` // File name including the extension
+	Format   string   `json:"format"`
+	Width    int      `json:"width"`
+	Height   int      `json:"height"`
+	Size     int64    `json:"size"`
+	Warnings []string `json:"warnings,omitempty"`
+	Data     []byte   `json:"-"`
+}
+
+// PipelineStepReport is the timing report of one step
+type PipelineStepReport struct {
+	Step   int     `json:"step"` // 1-based position in the pipeline
+	Op     string  `json:"op"`
+	TimeMs float64 `json:"timeMs"`
+	Width  int     `json:"width"` // Image (or, for encode, output) size after the step
+	Height int     `json:"height"`
+	Output string  `json:"output,omitempty"` // encode: output file name
+	Size   int64   `json:"size,omitempty"`   // encode: output size in bytes
+}
+
+// PipelineResult holds the outputs and per-step timings of a pipeline run
+type PipelineResult struct {
+	Outputs     []PipelineOutput     `json:"outputs"`
+	Steps       []PipelineStepReport `json:"steps"`
+	TotalTimeMs float64              `json:"totalTimeMs"`
+}
+
+// ParsePipeline decodes and validates a pipeline definition. Unknown top-level
+// fields, unknown operations and fields that do not belong to a step's operation
+// are rejected, as are out-of-range values. Encode parameters are checked by the
+// caller when it resolves them into OptimizeOptions.
+func ParsePipeline(data []byte) (*Pipeline, error) {
+	var raw struct {
+		Steps  []map[string]json.RawMessage `json:"steps"`
+		Output string                       `json:"output"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPipeline, err)
+	}
+
+	pipeline := &Pipeline{Output: strings.ToLower(raw.Output)}
+	switch pipeline.Output {
+	case "":
+		pipeline.Output = PipelineOutputZip
+	case PipelineOutputZip, PipelineOutputMultipart:
+	default:
+		return nil, fmt.Errorf("%w: output must be zip or multipart", ErrInvalidPipeline)
+	}
+
+	if len(raw.Steps) == 0 {
+		return nil, fmt.Errorf("%w: steps must not be empty", ErrInvalidPipeline)
+	}
+	if len(raw.Steps) > MaxPipelineSteps {
+		return nil, fmt.Errorf("%w: at most %d steps are allowed", ErrInvalidPipeline, MaxPipelineSteps)
+	}
+
+	outputs := 0
+	names := make(map[string]bool)
+	for i, fields := range raw.Steps {
+		step, err := parsePipelineStep(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%w: step %d: %v", ErrInvalidPipeline, i+1, err)
+		}
+		if err := step.validate(); err != nil {
+			return nil, fmt.Errorf("%w: step %d (%s): %v", ErrInvalidPipeline, i+1, step.Op, err)
+		}
+
+		if step.Op == OpEncode {
+			outputs++
+			if step.Name == "" {
+				step.Name = fmt.Sprintf("output-%d", outputs)
+			}
+			if names[step.Name] {
+				return nil, fmt.Errorf("%w: step %d (encode): duplicate output name %q", ErrInvalidPipeline, i+1, step.Name)
+			}
+			names[step.Name] = true
+		}
+		pipeline.Steps = append(pipeline.Steps, *step)
+	}
+
+	if outputs == 0 {
+		return nil, fmt.Errorf("%w: at least one encode step is required", ErrInvalidPipeline)
+	}
+	if outputs > MaxPipelineOutputs {
+		return nil, fmt.Errorf("%w: at most %d encode steps are allowed", ErrInvalidPipeline, MaxPipelineOutputs)
+	}
+	return pipeline, nil
+}
+
+// parsePipelineStep decodes one step after checking its fields against the operation
+func parsePipelineStep(fields map[string]json.RawMessage) (*PipelineStep, error) {
+	var op string
+	if err := json.Unmarshal(fields["op"], &op); err != nil || op == "" {
+		return nil, errors.New("op must be a string naming the operation")
+	}
+	allowed, ok := pipelineFields[op]
+	if !ok {
+		ops := make([]string, 0, len(pipelineFields))
+		for name := range pipelineFields {
+			ops = append(ops, name)
+		}
+		sort.Strings(ops)
+		return nil, fmt.Errorf("unknown op %q (supported: %s)", op, strings.Join(ops, ", "))
+	}
+
+	for field := range fields {
+		if field != "op" && !containsString(allowed, field) {
+			return nil, fmt.Errorf("%s does not accept %q", op, field)
+		}
+	}
+
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var step PipelineStep
+	if err := json.Unmarshal(encoded, &step); err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+	return &step, nil
+}
+
+// validate checks the values of a step that can be checked without the image
+func (s *PipelineStep) validate() error {
+	switch s.Op {
+	case OpCrop:
+		if s.Left < 0 || s.Top < 0 {
+			return errors.New("left and top must not be negative")
+		}
+		if s.Width < 1 || s.Height < 1 || s.Width > MaxPipelineDimension || s.Height > MaxPipelineDimension {
+			return fmt.Errorf("width and height are required and must be between 1 and %d", MaxPipelineDimension)
+		}
+	case OpResize:
+		if s.Width < 0 || s.Height < 0 || s.Width > MaxPipelineDimension || s.Height > MaxPipelineDimension {
+			return fmt.Errorf("width and height must be between 0 and %d", MaxPipelineDimension)
+		}
+		if s.Width == 0 && s.Height == 0 {
+			return errors.New("width or height is required")
+		}
+		switch s.Fit {
+		case "":
+			s.Fit = FitInside
+		case FitInside, FitFill, FitCover:
+		default:
+			return errors.New("fit must be inside, fill or cover")
+		}
+		if s.Linear && s.Fit != FitInside {
+			return errors.New("linear is only supported with fit=inside")
+		}
+		if s.Interpolator != "" && !IsValidInterpolator(s.Interpolator) {
+			return fmt.Errorf("interpolator must be one of: %s", strings.Join(Interpolators, ", "))
+		}
+	case OpRotate:
+		if s.Angle != 90 && s.Angle != 180 && s.Angle != 270 {
+			return errors.New("angle must be 90, 180 or 270")
+		}
+	case OpFlip:
+		if s.Direction != "horizontal" && s.Direction != "vertical" {
+			return errors.New("direction must be horizontal or vertical")
+		}
+	case OpSharpen:
+		if s.Strength <= 0 || s.Strength > MaxSharpen {
+			return fmt.Errorf("strength must be greater than 0 and at most %g", MaxSharpen)
+		}
+	case OpBlur:
+		if s.Sigma <= 0 || s.Sigma > maxBlurSigma {
+			return fmt.Errorf("sigma must be greater than 0 and at most %d", maxBlurSigma)
+		}
+	case OpWatermark:
+		if strings.TrimSpace(s.Text) == "" || len(s.Text) > maxWatermarkText {
+			return fmt.Errorf("text is required and must be at most %d characters", maxWatermarkText)
+		}
+		if s.Size != 0 && (s.Size < minWatermarkSize || s.Size > maxWatermarkSize) {
+			return fmt.Errorf("size must be between %d and %d", minWatermarkSize, maxWatermarkSize)
+		}
+		if s.Opacity < 0 || s.Opacity > 1 {
+			return errors.New("opacity must be between 0 and 1")
+		}
+		if s.Color != "" {
+			if err := ValidateBackground(s.Color); err != nil || strings.EqualFold(s.Color, BackgroundAuto) {
+				return errors.New("color must be a hex color, white or black")
+			}
+		}
+	case OpEncode:
+		if s.Name != "" && !outputNamePattern.MatchString(s.Name) {
+			return errors.New("name must be 1-64 letters, digits, hyphens and underscores")
+		}
+	}
+	return nil
+}
+
+// RunPipeline executes the steps in order. The source is decoded once into a
+// lossless working image that each step transforms; encode steps run the working
+// image through OptimizeImage with their options. Outputs without a format keep
+// the source format (BMP sources become PNG).
+func RunPipeline(buffer []byte, pipeline *Pipeline) (*PipelineResult, error) {
+	startTime := time.Now()
+
+	sourceFormat := bimg.PNG
+	if IsBMP(buffer) {
+		converted, err := bmpToPNG(buffer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode BMP: %w", err)
+		}
+		buffer = converted
+	} else if format := getImageTypeFromString(bimg.DetermineImageTypeName(buffer)); format != bimg.UNKNOWN {
+		sourceFormat = format
+	}
+
+	metadata, err := bimg.NewImage(buffer).Metadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image metadata: %w", err)
+	}
+	width, height := displaySize(metadata)
+
+	// Encoding the untouched source lets OptimizeImage use its source-specific
+	// paths (lossless JPEG transcoding, original fallback); the first transform
+	// replaces it with the decoded working image
+	working := buffer
+	decoded := false
+	result := &PipelineResult{}
+	for i, step := range pipeline.Steps {
+		stepStart := time.Now()
+		report := PipelineStepReport{Step: i + 1, Op: step.Op}
+
+		if step.Op == OpEncode {
+			options := step.Options
+			if options.Format == 0 {
+				options.Format = sourceFormat
+			}
+			encoded, err := OptimizeImage(working, options)
+			if err != nil {
+				return nil, fmt.Errorf("step %d (encode %s): %w", i+1, step.Name, err)
+			}
+
+			output := PipelineOutput{
+				Name:     step.Name + "." + encoded.Format,
+				Format:   encoded.Format,
+				Width:    encoded.Width,
+				Height:   encoded.Height,
+				Size:     encoded.OptimizedSize,
+				Warnings: encoded.Warnings,
+				Data:     encoded.OptimizedImage,
+			}
+			result.Outputs = append(result.Outputs, output)
+			report.Width, report.Height = output.Width, output.Height
+			report.Output, report.Size = output.Name, output.Size
+		} else {
+			if !decoded {
+				// Decode through libvips so every input format and EXIF orientation is handled
+				working, err = bimg.NewImage(working).Process(bimg.Options{Type: bimg.PNG, Compression: 1})
+				if err != nil {
+					return nil, fmt.Errorf("failed to decode image: %w", err)
+				}
+				decoded = true
+			}
+			working, err = applyPipelineStep(working, step, width, height, pipeline.MaxPixels)
+			if err != nil {
+				return nil, fmt.Errorf("step %d (%s): %w", i+1, step.Op, err)
+			}
+			size, err := bimg.NewImage(working).Size()
+			if err != nil {
+				return nil, fmt.Errorf("step %d (%s): %w", i+1, step.Op, err)
+			}
+			width, height = size.Width, size.Height
+			report.Width, report.Height = width, height
+		}
+
+		report.TimeMs = durationMs(time.Since(stepStart))
+		result.Steps = append(result.Steps, report)
+	}
+
+	result.TotalTimeMs = durationMs(time.Since(startTime))
+	return result, nil
+}
+
+// applyPipelineStep runs one transform on the working image (width x height, upright)
+// and returns the result as a fast, lossless PNG. Resizes beyond maxPixels (0 = no
+// limit) are rejected before any pixels are processed.
+func applyPipelineStep(buffer []byte, step PipelineStep, width, height, maxPixels int) ([]byte, error) {
+	options := bimg.Options{
+		Type:        bimg.PNG,
+		Compression: 1, // Intermediate image, favor speed
+	}
+
+	switch step.Op {
+	case OpCrop:
+		if step.Left+step.Width > width || step.Top+step.Height > height {
+			return nil, fmt.Errorf("%w: the %dx%d area at (%d, %d) does not fit the %dx%d image",
+				ErrInvalidPipeline, step.Width, step.Height, step.Left, step.Top, width, height)
+		}
+		options.Top = step.Top
+		options.Left = step.Left
+		options.AreaWidth = step.Width
+		options.AreaHeight = step.Height
+
+	case OpResize:
+		if step.Interpolator != "" {
+			options.Interpolator = interpolatorFor(step.Interpolator)
+		}
+		switch step.Fit {
+		case FitFill:
+			options.Width, options.Height = fitSize(width, height, step.Width, step.Height, false)
+			options.Force = true
+		case FitCover:
+			if step.Width > 0 && step.Height > 0 {
+				options.Width, options.Height = step.Width, step.Height
+				options.Crop = true
+				options.Enlarge = true
+				options.Gravity = bimg.GravityCentre
+				break
+			}
+			options.Width, options.Height = fitSize(width, height, step.Width, step.Height, true)
+			options.Force = true
+		default:
+			options.Width, options.Height = fitSize(width, height, step.Width, step.Height, true)
+			options.Force = true
+		}
+		if err := checkPipelinePixels(options.Width, options.Height, maxPixels); err != nil {
+			return nil, err
+		}
+		if step.Linear {
+			// Validation limits linear to fit=inside, so the size is exact
+			return linearResize(buffer, bimg.Options{
+				Width:        options.Width,
+				Height:       options.Height,
+				Embed:        true,
+				Interpolator: options.Interpolator,
+			})
+		}
+
+	case OpRotate:
+		options.Rotate = bimg.Angle(step.Angle)
+
+	case OpFlip:
+		if step.Direction == "horizontal" {
+			options.Flop = true
+		} else {
+			options.Flip = true
+		}
+
+	case OpSharpen:
+		options.Sharpen = sharpenOptions(step.Strength)
+
+	case OpBlur:
+		options.GaussianBlur = bimg.GaussianBlur{Sigma: step.Sigma, MinAmpl: 0.2}
+
+	case OpGrayscale:
+		options.Interpretation = bimg.InterpretationBW
+
+	case OpWatermark:
+		color := defaultWatermarkColor
+		if step.Color != "" {
+			color = step.Color
+		}
+		ink, err := parseBackgroundColor(color)
+		if err != nil {
+			return nil, err
+		}
+		size := step.Size
+		if size == 0 {
+			size = defaultWatermarkSize
+		}
+		opacity := step.Opacity
+		if opacity == 0 {
+			opacity = defaultWatermarkOpacity
+		}
+		options.Watermark = bimg.Watermark{
+			Text:        step.Text,
+			Font:        fmt.Sprintf("sans %d", size),
+			Opacity:     float32(opacity),
+			Background:  bimg.Color{R: ink.R, G: ink.G, B: ink.B},
+			NoReplicate: !step.Tile,
+		}
+	}
+
+	return bimg.NewImage(buffer).Process(options)
+}
+
+// checkPipelinePixels rejects a resize whose output would exceed maxPixels (0 = no limit)
+func checkPipelinePixels(width, height, maxPixels int) error {
+	if maxPixels > 0 && int64(width)*int64(height) > int64(maxPixels) {
+		return fmt.Errorf("%w: a %dx%d image exceeds the %d pixel limit", ErrInvalidPipeline, width, height, maxPixels)
+	}
+	return nil
+}
+
+// fitSize computes the output size for resizing width x height to target
+// dimensions. A zero target is derived from the aspect ratio; with keepAspect
+// both dimensions shrink to fit inside the target box.
+func fitSize(width, height, targetWidth, targetHeight int, keepAspect bool) (int, int) {
+	switch {
+	case targetWidth == 0:
+		targetWidth = int(math.Round(float64(width) * float64(targetHeight) / float64(height)))
+	case targetHeight == 0:
+		targetHeight = int(math.Round(float64(height) * float64(targetWidth) / float64(width)))
+	case keepAspect:
+		scale := math.Min(float64(targetWidth)/float64(width), float64(targetHeight)/float64(height))
+		targetWidth = int(math.Round(float64(width) * scale))
+		targetHeight = int(math.Round(float64(height) * scale))
+	}
+	return maxInt(targetWidth, 1), maxInt(targetHeight, 1)
+}
+
+// containsString reports whether values contains s
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
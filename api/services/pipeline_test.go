@@ -0,0 +1,141 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParsePipeline(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`{
+		"steps": [
+			{"op": "crop", "left": 10, "top": 10, "width": 200, "height": 100},
+			{"op": "resize", "width": 100},
+			{"op": "sharpen", "strength": 1.5},
+			{"op": "watermark", "text": "(c) Example", "opacity": 0.4, "color": "#ffffff"},
+			{"op": "encode", "name": "small", "params": {"format": "webp", "quality": 80, "lossless": false}},
+			{"op": "encode", "params": {"format": "jpeg"}}
+		],
+		"output": "multipart"
+	}`))
+	if err != nil {
+		t.Fatalf("Expected a valid pipeline, got %v", err)
+	}
+
+	if len(pipeline.Steps) != 6 || pipeline.Output != PipelineOutputMultipart {
+		t.Fatalf("Unexpected pipeline: %+v", pipeline)
+	}
+	if pipeline.Steps[1].Fit != FitInside {
+		t.Errorf("Expected resize fit to default to inside, got %q", pipeline.Steps[1].Fit)
+	}
+	encode := pipeline.Steps[4]
+	if encode.Params["quality"] != "80" || encode.Params["lossless"] != "false" {
+		t.Errorf("Expected numbers and booleans as strings, got %v", encode.Params)
+	}
+	if pipeline.Steps[5].Name != "output-2" {
+		t.Errorf("Expected a generated output name, got %q", pipeline.Steps[5].Name)
+	}
+}
+
+func TestParsePipeline_Invalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		want       string
+	}{
+		{"not JSON", `steps`, "invalid pipeline"},
+		{"unknown top-level field", `{"steps": [{"op": "encode"}], "format": "webp"}`, "unknown field"},
+		{"no steps", `{"steps": []}`, "steps must not be empty"},
+		{"no encode", `{"steps": [{"op": "resize", "width": 10}]}`, "encode step is required"},
+		{"unknown op", `{"steps": [{"op": "posterize"}]}`, "unknown op"},
+		{"missing op", `{"steps": [{"width": 10}]}`, "op must be"},
+		{"field of another op", `{"steps": [{"op": "resize", "width": 10, "sigma": 2}, {"op": "encode"}]}`, `does not accept "sigma"`},
+		{"wrong type", `{"steps": [{"op": "resize", "width": "wide"}, {"op": "encode"}]}`, "step 1"},
+		{"crop without size", `{"steps": [{"op": "crop", "left": 5}, {"op": "encode"}]}`, "width and height are required"},
+		{"resize without size", `{"steps": [{"op": "resize"}, {"op": "encode"}]}`, "width or height is required"},
+		{"resize too large", `{"steps": [{"op": "resize", "width": 100000}, {"op": "encode"}]}`, "between 0 and"},
+		{"bad fit", `{"steps": [{"op": "resize", "width": 10, "fit": "contain"}, {"op": "encode"}]}`, "fit must be"},
+		{"linear cover", `{"steps": [{"op": "resize", "width": 10, "height": 10, "fit": "cover", "linear": true}, {"op": "encode"}]}`, "linear is only supported"},
+		{"bad interpolator", `{"steps": [{"op": "resize", "width": 10, "interpolator": "magic"}, {"op": "encode"}]}`, "interpolator must be"},
+		{"bad angle", `{"steps": [{"op": "rotate", "angle": 45}, {"op": "encode"}]}`, "angle must be"},
+		{"bad direction", `{"steps": [{"op": "flip", "direction": "diagonal"}, {"op": "encode"}]}`, "direction must be"},
+		{"sharpen too strong", `{"steps": [{"op": "sharpen", "strength": 11}, {"op": "encode"}]}`, "strength must be"},
+		{"blur without sigma", `{"steps": [{"op": "blur"}, {"op": "encode"}]}`, "sigma must be"},
+		{"watermark without text", `{"steps": [{"op": "watermark"}, {"op": "encode"}]}`, "text is required"},
+		{"watermark auto color", `{"steps": [{"op": "watermark", "text": "x", "color": "auto"}, {"op": "encode"}]}`, "color must be"},
+		{"bad output name", `{"steps": [{"op": "encode", "name": "../evil"}]}`, "name must be"},
+		{"duplicate output name", `{"steps": [{"op": "encode", "name": "a"}, {"op": "encode", "name": "a"}]}`, "duplicate output name"},
+		{"bad output", `{"steps": [{"op": "encode"}], "output": "tar"}`, "output must be"},
+		{"object param", `{"steps": [{"op": "encode", "params": {"quality": {"value": 80}}}]}`, "must be a string, number or boolean"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePipeline([]byte(tt.definition))
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if !errors.Is(err, ErrInvalidPipeline) {
+				t.Errorf("Expected ErrInvalidPipeline, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %q", tt.want, err.Error())
+			}
+		})
+	}
+}
+
+func TestParsePipeline_Limits(t *testing.T) {
+	steps := make([]map[string]string, MaxPipelineSteps+1)
+	for i := range steps {
+		steps[i] = map[string]string{"op": OpEncode}
+	}
+	definition, _ := json.Marshal(map[string]interface{}{"steps": steps})
+	if _, err := ParsePipeline(definition); err == nil || !strings.Contains(err.Error(), "steps are allowed") {
+		t.Errorf("Expected the step limit to be enforced, got %v", err)
+	}
+
+	definition, _ = json.Marshal(map[string]interface{}{"steps": steps[:MaxPipelineOutputs+1]})
+	if _, err := ParsePipeline(definition); err == nil || !strings.Contains(err.Error(), "encode steps are allowed") {
+		t.Errorf("Expected the output limit to be enforced, got %v", err)
+	}
+}
+
+func TestFitSize(t *testing.T) {
+	tests := []struct {
+		name                          string
+		width, height                 int
+		targetWidth, targetHeight     int
+		keepAspect                    bool
+		expectedWidth, expectedHeight int
+	}{
+		{"width only", 400, 200, 100, 0, true, 100, 50},
+		{"height only", 400, 200, 0, 50, true, 100, 50},
+		{"inside wide box", 400, 200, 300, 300, true, 300, 150},
+		{"inside tall box", 400, 200, 100, 300, true, 100, 50},
+		{"fill", 400, 200, 300, 300, false, 300, 300},
+		{"never zero", 4000, 10, 100, 0, true, 100, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height := fitSize(tt.width, tt.height, tt.targetWidth, tt.targetHeight, tt.keepAspect)
+			if width != tt.expectedWidth || height != tt.expectedHeight {
+				t.Errorf("fitSize() = %dx%d, expected %dx%d", width, height, tt.expectedWidth, tt.expectedHeight)
+			}
+		})
+	}
+}
+
+func TestCheckPipelinePixels(t *testing.T) {
+	if err := checkPipelinePixels(1000, 1000, 0); err != nil {
+		t.Errorf("Expected no limit with maxPixels 0, got %v", err)
+	}
+	if err := checkPipelinePixels(1000, 1000, 1_000_000); err != nil {
+		t.Errorf("Expected an image at the limit to pass, got %v", err)
+	}
+	if err := checkPipelinePixels(1001, 1000, 1_000_000); !errors.Is(err, ErrInvalidPipeline) {
+		t.Errorf("Expected ErrInvalidPipeline over the limit, got %v", err)
+	}
+}
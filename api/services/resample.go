@@ -0,0 +1,274 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"sync"
+
+	"github.com/h2non/bimg"
+)
+
+// Post-downscale sharpening limits
+const (
+	// SharpenOff disables automatic sharpening after downscaling
+	SharpenOff = -1.0
+
+	// MaxSharpen is the largest accepted sharpening strength override
+	MaxSharpen = 10.0
+
+	// autoSharpenMinScale is the smallest reduction (source / target) that gets
+	// sharpened automatically. Milder reductions barely soften the image.
+	autoSharpenMinScale = 2.0
+
+	// maxAutoSharpen caps the automatic strength so large reductions of noisy
+	// photos do not pick up halos
+	maxAutoSharpen = 3.0
+)
+
+var (
+	// srgbToLinear maps 8-bit sRGB values to 16-bit linear light
+	srgbToLinear [256]uint16
+
+	// linearToSRGB maps 16-bit linear light back to 8-bit sRGB; built on first use
+	linearToSRGB     []uint8
+	linearToSRGBOnce sync.Once
+)
+
+func init() {
+	for i := range srgbToLinear {
+		srgbToLinear[i] = uint16(math.Round(decodeSRGB(float64(i)/255) * 0xffff))
+	}
+}
+
+// decodeSRGB applies the sRGB transfer function inverse (gamma-encoded to linear), 0-1 range
+func decodeSRGB(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// encodeSRGB applies the sRGB transfer function (linear to gamma-encoded), 0-1 range
+func encodeSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// Interpolators lists the accepted interpolation algorithm names
+var Interpolators = []string{"nearest", "bilinear", "bicubic", "nohalo", "vsqbs", "lanczos2", "lanczos3"}
+
+// IsValidInterpolator reports whether name is one of Interpolators
+func IsValidInterpolator(name string) bool {
+	for _, interpolator := range Interpolators {
+		if name == interpolator {
+			return true
+		}
+	}
+	return false
+}
+
+// interpolatorFor maps an interpolation algorithm name to the bimg interpolator
+func interpolatorFor(name string) bimg.Interpolator {
+	switch name {
+	case "nearest":
+		return bimg.Nearest
+	case "bilinear":
+		return bimg.Bilinear
+	case "nohalo":
+		return bimg.Nohalo
+	default:
+		// vsqbs, lanczos2 and lanczos3 map to bicubic in bimg
+		// (bimg uses libvips' bicubic which is high quality)
+		return bimg.Bicubic
+	}
+}
+
+// Crop anchors for FitCover resizes
+const (
+	GravityCentre = "centre"
+	GravityNorth  = "north"
+	GravitySouth  = "south"
+	GravityEast   = "east"
+	GravityWest   = "west"
+	GravitySmart  = "smart" // libvips picks the area with the most detail
+)
+
+// gravityFor maps a crop anchor name to the bimg gravity
+func gravityFor(name string) bimg.Gravity {
+	switch name {
+	case GravityNorth:
+		return bimg.GravityNorth
+	case GravitySouth:
+		return bimg.GravitySouth
+	case GravityEast:
+		return bimg.GravityEast
+	case GravityWest:
+		return bimg.GravityWest
+	case GravitySmart:
+		return bimg.GravitySmart
+	default:
+		return bimg.GravityCentre
+	}
+}
+
+// downscaleFactor returns how much the resize shrinks the image (2 = half size).
+// With both dimensions given the image is fitted inside the box, so the larger
+// ratio wins. Returns 1 or less for upscaling and when no size was requested.
+func downscaleFactor(sourceWidth, sourceHeight, width, height int) float64 {
+	factor := 0.0
+	if width > 0 && sourceWidth > 0 {
+		factor = float64(sourceWidth) / float64(width)
+	}
+	if height > 0 && sourceHeight > 0 {
+		factor = math.Max(factor, float64(sourceHeight)/float64(height))
+	}
+	if factor == 0 {
+		return 1
+	}
+	return factor
+}
+
+// autoSharpenStrength picks an unsharp mask strength for a reduction: nothing
+// below 2x, then one step per halving (2x = 1, 4x = 2, 8x and beyond = 3)
+func autoSharpenStrength(factor float64) float64 {
+	if factor < autoSharpenMinScale {
+		return 0
+	}
+	strength := math.Log2(factor)
+	return math.Round(math.Min(strength, maxAutoSharpen)*100) / 100
+}
+
+// sharpenOptions builds the libvips unsharp mask for a strength. Strength is the
+// slope applied to edges (libvips m2); flat areas get a sixth of it so noise and
+// smooth gradients are left mostly alone.
+func sharpenOptions(strength float64) bimg.Sharpen {
+	return bimg.Sharpen{
+		Radius: 1,
+		X1:     2,
+		Y2:     10,
+		Y3:     20,
+		M1:     strength / 6,
+		M2:     strength,
+	}
+}
+
+// linearResize resamples the image in linear light instead of gamma-encoded sRGB.
+// Averaging sRGB values darkens fine high-contrast detail (thin lines, text, stars),
+// because the encoded midpoint of black and white is much darker than their mean
+// brightness. The image is converted to 16-bit linear light, resized by libvips
+// with the given size, embed and interpolator options, then converted back.
+// The result is a lossless 8-bit PNG for the rest of the pipeline.
+func linearResize(buffer []byte, resize bimg.Options) ([]byte, error) {
+	// Decode through libvips so every input format and EXIF orientation is handled
+	pngData, err := bimg.NewImage(buffer).Process(bimg.Options{
+		Type:        bimg.PNG,
+		Compression: 1, // Decoded immediately, favor speed
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert image for linear resize: %w", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image for linear resize: %w", err)
+	}
+
+	linearPNG, err := encodePNGFast(toLinear(img))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode linear image: %w", err)
+	}
+
+	// RGB16 keeps the 16-bit precision through the resize; 8-bit linear would band in the shadows
+	resized, err := bimg.NewImage(linearPNG).Process(bimg.Options{
+		Width:          resize.Width,
+		Height:         resize.Height,
+		Embed:          resize.Embed,
+		Force:          resize.Force,
+		Crop:           resize.Crop,
+		Enlarge:        resize.Enlarge,
+		Gravity:        resize.Gravity,
+		Interpolator:   resize.Interpolator,
+		Type:           bimg.PNG,
+		Compression:    1,
+		Interpretation: bimg.InterpretationRGB16,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resize linear image: %w", err)
+	}
+
+	resizedImg, err := png.Decode(bytes.NewReader(resized))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode resized image: %w", err)
+	}
+
+	out, err := encodePNGFast(fromLinear(resizedImg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode resized image: %w", err)
+	}
+	return out, nil
+}
+
+// toLinear converts an 8-bit sRGB image to 16-bit linear light. Alpha is not
+// gamma-encoded and is only widened.
+func toLinear(img image.Image) *image.NRGBA64 {
+	bounds := img.Bounds()
+	out := image.NewNRGBA64(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := storedNRGBA64(img, x, y)
+			out.SetNRGBA64(x, y, color.NRGBA64{
+				R: srgbToLinear[c.R>>8],
+				G: srgbToLinear[c.G>>8],
+				B: srgbToLinear[c.B>>8],
+				A: c.A,
+			})
+		}
+	}
+	return out
+}
+
+// linearToSRGBTable returns the 16-bit linear to 8-bit sRGB lookup table
+func linearToSRGBTable() []uint8 {
+	linearToSRGBOnce.Do(func() {
+		linearToSRGB = make([]uint8, 0x10000)
+		for i := range linearToSRGB {
+			linearToSRGB[i] = uint8(math.Round(encodeSRGB(float64(i)/0xffff) * 255))
+		}
+	})
+	return linearToSRGB
+}
+
+// fromLinear converts a 16-bit linear light image back to 8-bit sRGB
+func fromLinear(img image.Image) *image.NRGBA {
+	table := linearToSRGBTable()
+	bounds := img.Bounds()
+	out := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := storedNRGBA64(img, x, y)
+			out.SetNRGBA(x, y, color.NRGBA{
+				R: table[c.R],
+				G: table[c.G],
+				B: table[c.B],
+				A: uint8(c.A >> 8),
+			})
+		}
+	}
+	return out
+}
+
+// encodePNGFast encodes an intermediate image that is decoded again right away
+func encodePNGFast(img image.Image) ([]byte, error) {
+	var out bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&out, img); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSRGBLinearRoundTrip(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{uint8(x), uint8(255 - x), uint8(x / 2), uint8(x)})
+	}
+
+	back := fromLinear(toLinear(img))
+	for x := 0; x < 256; x++ {
+		if got, expected := back.NRGBAAt(x, 0), img.NRGBAAt(x, 0); got != expected {
+			t.Fatalf("Round trip changed pixel %d: got %v, expected %v", x, got, expected)
+		}
+	}
+}
+
+func TestToLinear_Midpoint(t *testing.T) {
+	// Averaging black and white in linear light is 50% brightness, which is
+	// sRGB 188 - not the 128 a gamma-encoded average gives
+	linear := image.NewNRGBA64(image.Rect(0, 0, 1, 1))
+	linear.SetNRGBA64(0, 0, color.NRGBA64{
+		R: (srgbToLinear[0] + srgbToLinear[255]) / 2,
+		A: 0xffff,
+	})
+
+	if got := fromLinear(linear).NRGBAAt(0, 0).R; got != 188 {
+		t.Errorf("Expected linear midpoint to encode as 188, got %d", got)
+	}
+}
+
+func TestDownscaleFactor(t *testing.T) {
+	tests := []struct {
+		srcWidth, srcHeight, width, height int
+		expected                           float64
+	}{
+		{2000, 1000, 500, 0, 4},
+		{2000, 1000, 0, 250, 4},
+		{2000, 1000, 1000, 100, 10}, // Fitted inside the box - the larger ratio wins
+		{500, 500, 1000, 0, 0.5},
+		{500, 500, 0, 0, 1},
+	}
+
+	for _, tt := range tests {
+		if got := downscaleFactor(tt.srcWidth, tt.srcHeight, tt.width, tt.height); got != tt.expected {
+			t.Errorf("downscaleFactor(%d, %d, %d, %d) = %g, expected %g",
+				tt.srcWidth, tt.srcHeight, tt.width, tt.height, got, tt.expected)
+		}
+	}
+}
+
+func TestAutoSharpenStrength(t *testing.T) {
+	tests := []struct {
+		factor   float64
+		expected float64
+	}{
+		{1, 0},
+		{1.9, 0},
+		{2, 1},
+		{4, 2},
+		{8, 3},
+		{32, maxAutoSharpen},
+	}
+
+	for _, tt := range tests {
+		if got := autoSharpenStrength(tt.factor); got != tt.expected {
+			t.Errorf("autoSharpenStrength(%g) = %g, expected %g", tt.factor, got, tt.expected)
+		}
+	}
+}
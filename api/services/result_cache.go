@@ -0,0 +1,334 @@
+package services
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resultCacheExt is the file extension of cached results
+const resultCacheExt = ".gob"
+
+// resultCacheSweepInterval is how often Put scans the whole cache for expired entries
+const resultCacheSweepInterval = time.Minute
+
+// ResultCacheConfig configures the on-disk optimization result cache
+type ResultCacheConfig struct {
+	Dir      string        // Directory holding the cached results
+	MaxBytes int64         // Size cap; least recently used results are evicted beyond it
+	TTL      time.Duration // Results older than this are discarded (0 = no expiry)
+}
+
+// ResultCacheStats reports the cache's size and effectiveness since startup
+type ResultCacheStats struct {
+	Entries     int     `json:"entries"`
+	SizeBytes   int64   `json:"sizeBytes"`
+	MaxBytes    int64   `json:"maxBytes"`
+	TTLSeconds  int64   `json:"ttlSeconds"`
+	Hits        int64   `json:"hits"`
+	Misses      int64   `json:"misses"`
+	HitRate     float64 `json:"hitRate"` // Hits / (hits + misses), 0-1
+	Stores      int64   `json:"stores"`
+	Evictions   int64   `json:"evictions"`   // Removed to stay under the size cap
+	Expirations int64   `json:"expirations"` // Removed after the TTL
+	Purged      int64   `json:"purged"`      // Removed by purge requests
+}
+
+// ResultCache is a content-addressed, size-capped LRU cache of optimization results
+// stored on disk, so identical requests skip the expensive encoders. Entries
+// survive restarts; their recency is then approximated by the file times.
+type ResultCache struct {
+	config ResultCacheConfig
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element // key -> element holding *resultCacheEntry
+	lru       *list.List               // Front = most recently used
+	size      int64
+	lastSweep time.Time
+	stats     ResultCacheStats
+}
+
+// resultCacheEntry is the in-memory index record of one cached result
+type resultCacheEntry struct {
+	key     string
+	size    int64
+	created time.Time
+}
+
+// ResultCacheKey derives the cache key for an input and its options: the SHA-256
+// of the input bytes, then a hash of the canonical (JSON) options. Sharing the
+// input hash as a prefix lets every variant of one image be purged together.
+func ResultCacheKey(input []byte, options OptimizeOptions) string {
+	inputHash := sha256.Sum256(input)
+	canonical, err := json.Marshal(options)
+	if err != nil {
+		// OptimizeOptions only holds plain values, so this cannot happen
+		canonical = []byte(fmt.Sprintf("%+v", options))
+	}
+	optionsHash := sha256.Sum256(canonical)
+	return hex.EncodeToString(inputHash[:]) + "-" + hex.EncodeToString(optionsHash[:8])
+}
+
+// NewResultCache opens (creating if needed) a cache directory and indexes the
+// results already stored there
+func NewResultCache(config ResultCacheConfig) (*ResultCache, error) {
+	if err := os.MkdirAll(config.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	cache := &ResultCache{
+		config:  config,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+
+	var existing []resultCacheEntry
+	err := filepath.WalkDir(config.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), "tmp-") {
+			// Left behind by a write that was interrupted
+			return os.Remove(path)
+		}
+		if !strings.HasSuffix(d.Name(), resultCacheExt) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		existing = append(existing, resultCacheEntry{
+			key:     strings.TrimSuffix(d.Name(), resultCacheExt),
+			size:    info.Size(),
+			created: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index cache directory: %w", err)
+	}
+
+	// Oldest first, so the newest results end up at the front
+	sort.Slice(existing, func(i, j int) bool { return existing[i].created.Before(existing[j].created) })
+	for i := range existing {
+		cache.entries[existing[i].key] = cache.lru.PushFront(&existing[i])
+		cache.size += existing[i].size
+	}
+
+	cache.mu.Lock()
+	cache.removeExpiredLocked(time.Now())
+	cache.evictLocked()
+	cache.mu.Unlock()
+
+	return cache, nil
+}
+
+// Get returns the cached result for key, if present and not expired
+func (rc *ResultCache) Get(key string) (*OptimizeResult, bool) {
+	rc.mu.Lock()
+	element, ok := rc.entries[key]
+	if ok && rc.expired(element.Value.(*resultCacheEntry), time.Now()) {
+		rc.removeLocked(element)
+		rc.stats.Expirations++
+		ok = false
+	}
+	if !ok {
+		rc.stats.Misses++
+		rc.mu.Unlock()
+		return nil, false
+	}
+	rc.lru.MoveToFront(element)
+	rc.mu.Unlock()
+
+	result, err := rc.read(key)
+	if err != nil {
+		log.Printf("warning: dropping unreadable cached result %s: %v", key, err)
+		rc.mu.Lock()
+		if element, ok := rc.entries[key]; ok {
+			rc.removeLocked(element)
+		}
+		rc.stats.Misses++
+		rc.mu.Unlock()
+		return nil, false
+	}
+
+	rc.mu.Lock()
+	rc.stats.Hits++
+	rc.mu.Unlock()
+	return result, true
+}
+
+// Put stores a result under key, evicting the least recently used results to
+// stay under the size cap. Results larger than the whole cache are not stored.
+func (rc *ResultCache) Put(key string, result *OptimizeResult) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(result); err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+	size := int64(encoded.Len())
+	if size > rc.config.MaxBytes {
+		return nil
+	}
+
+	// Write to a temporary file and rename, so readers never see partial results
+	path := rc.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file: %w", err)
+	}
+	if _, err := tmp.Write(encoded.Bytes()); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store cache file: %w", err)
+	}
+	if element, ok := rc.entries[key]; ok {
+		rc.size -= element.Value.(*resultCacheEntry).size
+		rc.lru.Remove(element)
+	}
+	rc.entries[key] = rc.lru.PushFront(&resultCacheEntry{key: key, size: size, created: time.Now()})
+	rc.size += size
+	rc.stats.Stores++
+
+	now := time.Now()
+	if now.Sub(rc.lastSweep) >= resultCacheSweepInterval {
+		rc.removeExpiredLocked(now)
+	}
+	rc.evictLocked()
+	return nil
+}
+
+// Purge removes the result stored under key and reports whether there was one
+func (rc *ResultCache) Purge(key string) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	element, ok := rc.entries[key]
+	if ok {
+		rc.removeLocked(element)
+		rc.stats.Purged++
+	}
+	return ok
+}
+
+// PurgePrefix removes every result whose key starts with prefix ("" = all) and
+// returns how many were removed
+func (rc *ResultCache) PurgePrefix(prefix string) int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	purged := 0
+	for key, element := range rc.entries {
+		if strings.HasPrefix(key, prefix) {
+			rc.removeLocked(element)
+			purged++
+		}
+	}
+	rc.stats.Purged += int64(purged)
+	return purged
+}
+
+// Stats returns a snapshot of the cache statistics
+func (rc *ResultCache) Stats() ResultCacheStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	stats := rc.stats
+	stats.Entries = len(rc.entries)
+	stats.SizeBytes = rc.size
+	stats.MaxBytes = rc.config.MaxBytes
+	stats.TTLSeconds = int64(rc.config.TTL / time.Second)
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
+
+// read loads a cached result from disk
+func (rc *ResultCache) read(key string) (*OptimizeResult, error) {
+	data, err := os.ReadFile(rc.path(key))
+	if err != nil {
+		return nil, err
+	}
+	var result OptimizeResult
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// path returns the file of a key, fanned out over subdirectories by its first
+// two characters to keep directories small
+func (rc *ResultCache) path(key string) string {
+	return filepath.Join(rc.config.Dir, key[:2], key+resultCacheExt)
+}
+
+// expired reports whether an entry has outlived the TTL
+func (rc *ResultCache) expired(entry *resultCacheEntry, now time.Time) bool {
+	return rc.config.TTL > 0 && now.Sub(entry.created) > rc.config.TTL
+}
+
+// removeLocked drops an entry and its file. rc.mu must be held.
+func (rc *ResultCache) removeLocked(element *list.Element) {
+	entry := element.Value.(*resultCacheEntry)
+	if err := os.Remove(rc.path(entry.key)); err != nil && !os.IsNotExist(err) {
+		log.Printf("warning: failed to remove cached result %s: %v", entry.key, err)
+	}
+	rc.lru.Remove(element)
+	delete(rc.entries, entry.key)
+	rc.size -= entry.size
+}
+
+// removeExpiredLocked drops every entry past the TTL. rc.mu must be held.
+func (rc *ResultCache) removeExpiredLocked(now time.Time) {
+	rc.lastSweep = now
+	if rc.config.TTL <= 0 {
+		return
+	}
+	for _, element := range rc.entries {
+		if rc.expired(element.Value.(*resultCacheEntry), now) {
+			rc.removeLocked(element)
+			rc.stats.Expirations++
+		}
+	}
+}
+
+// evictLocked drops least recently used entries until the cache fits its size
+// cap. rc.mu must be held.
+func (rc *ResultCache) evictLocked() {
+	for rc.size > rc.config.MaxBytes {
+		oldest := rc.lru.Back()
+		if oldest == nil {
+			return
+		}
+		rc.removeLocked(oldest)
+		rc.stats.Evictions++
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+func newTestResultCache(t *testing.T, dir string, maxBytes int64, ttl time.Duration) *ResultCache {
+	t.Helper()
+	cache, err := NewResultCache(ResultCacheConfig{Dir: dir, MaxBytes: maxBytes, TTL: ttl})
+	if err != nil {
+		t.Fatalf("Failed to create result cache: %v", err)
+	}
+	return cache
+}
+
+func testCachedResult(size int) *OptimizeResult {
+	return &OptimizeResult{
+		Format:         "webp",
+		OptimizedSize:  int64(size),
+		OptimizedImage: bytes.Repeat([]byte{0xAB}, size),
+		Warnings:       []string{"example warning"},
+		DPR:            &DPRInfo{Requested: 2, Effective: 2},
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	input := []byte("image bytes")
+	key := ResultCacheKey(input, OptimizeOptions{Quality: 80, Format: bimg.WEBP})
+
+	if again := ResultCacheKey(input, OptimizeOptions{Quality: 80, Format: bimg.WEBP}); again != key {
+		t.Errorf("Expected a stable key, got %q and %q", key, again)
+	}
+	other := ResultCacheKey(input, OptimizeOptions{Quality: 81, Format: bimg.WEBP})
+	if other == key {
+		t.Error("Expected different options to give a different key")
+	}
+	// Variants of one input share the input hash prefix
+	if key[:64] != other[:64] || !strings.Contains(key, "-") {
+		t.Errorf("Expected a shared input hash prefix, got %q and %q", key, other)
+	}
+	if ResultCacheKey([]byte("other bytes"), OptimizeOptions{Quality: 80, Format: bimg.WEBP})[:64] == key[:64] {
+		t.Error("Expected different inputs to give a different prefix")
+	}
+}
+
+func TestResultCache_GetPut(t *testing.T) {
+	dir := t.TempDir()
+	cache := newTestResultCache(t, dir, 1<<20, time.Hour)
+	key := ResultCacheKey([]byte("a"), OptimizeOptions{Quality: 80})
+
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("Expected a miss on an empty cache")
+	}
+	if err := cache.Put(key, testCachedResult(100)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	result, ok := cache.Get(key)
+	if !ok {
+		t.Fatal("Expected a hit after Put")
+	}
+	if len(result.OptimizedImage) != 100 || result.Format != "webp" || result.DPR == nil || result.Warnings[0] != "example warning" {
+		t.Errorf("Cached result did not round-trip: %+v", result)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.HitRate != 0.5 || stats.Entries != 1 || stats.Stores != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// Results survive a restart
+	reopened := newTestResultCache(t, dir, 1<<20, time.Hour)
+	if _, ok := reopened.Get(key); !ok {
+		t.Error("Expected the result to be found after reopening the cache")
+	}
+}
+
+func TestResultCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTestResultCache(t, t.TempDir(), 25000, 0)
+	keys := []string{
+		ResultCacheKey([]byte("a"), OptimizeOptions{}),
+		ResultCacheKey([]byte("b"), OptimizeOptions{}),
+		ResultCacheKey([]byte("c"), OptimizeOptions{}),
+	}
+
+	for _, key := range keys[:2] {
+		if err := cache.Put(key, testCachedResult(10000)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	// Touch the first entry so the second one becomes the least recently used
+	if _, ok := cache.Get(keys[0]); !ok {
+		t.Fatal("Expected a hit")
+	}
+	if err := cache.Put(keys[2], testCachedResult(10000)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if _, ok := cache.Get(keys[1]); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	for _, key := range []string{keys[0], keys[2]} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("Expected %s to stay cached", key[:8])
+		}
+	}
+	if stats := cache.Stats(); stats.Evictions != 1 || stats.SizeBytes > 25000 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// Results larger than the whole cache are not stored
+	big := ResultCacheKey([]byte("big"), OptimizeOptions{})
+	if err := cache.Put(big, testCachedResult(50000)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, ok := cache.Get(big); ok {
+		t.Error("Expected an oversized result not to be cached")
+	}
+}
+
+func TestResultCache_TTL(t *testing.T) {
+	cache := newTestResultCache(t, t.TempDir(), 1<<20, time.Hour)
+	key := ResultCacheKey([]byte("a"), OptimizeOptions{})
+	if err := cache.Put(key, testCachedResult(10)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// Age the entry past the TTL
+	cache.mu.Lock()
+	cache.entries[key].Value.(*resultCacheEntry).created = time.Now().Add(-2 * time.Hour)
+	cache.mu.Unlock()
+
+	if _, ok := cache.Get(key); ok {
+		t.Error("Expected an expired entry to miss")
+	}
+	if stats := cache.Stats(); stats.Expirations != 1 || stats.Entries != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestResultCache_Purge(t *testing.T) {
+	cache := newTestResultCache(t, t.TempDir(), 1<<20, 0)
+	imageA80 := ResultCacheKey([]byte("a"), OptimizeOptions{Quality: 80})
+	imageA60 := ResultCacheKey([]byte("a"), OptimizeOptions{Quality: 60})
+	imageB := ResultCacheKey([]byte("b"), OptimizeOptions{Quality: 80})
+	for _, key := range []string{imageA80, imageA60, imageB} {
+		if err := cache.Put(key, testCachedResult(10)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	if !cache.Purge(imageB) {
+		t.Error("Expected Purge to remove an existing key")
+	}
+	if cache.Purge(imageB) {
+		t.Error("Expected Purge to report a missing key")
+	}
+
+	if purged := cache.PurgePrefix(imageA80[:64]); purged != 2 {
+		t.Errorf("Expected 2 results purged by prefix, got %d", purged)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 || stats.SizeBytes != 0 || stats.Purged != 3 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
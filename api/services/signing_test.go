@@ -0,0 +1,100 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignImagePath(t *testing.T) {
+	const secret = "test-secret"
+	const path = "w_400,q_75/aHR0cHM6Ly9leGFtcGxlLmNvbS9jYXQucG5n"
+	now := time.Unix(1700000000, 0)
+
+	segment := SignImagePath(secret, 7, 0, path)
+	signature, err := ParseURLSignature(segment)
+	if err != nil {
+		t.Fatalf("Failed to parse signature %q: %v", segment, err)
+	}
+	if signature.KeyID != 7 || signature.Expires != 0 {
+		t.Errorf("Unexpected signature: %+v", signature)
+	}
+	if err := VerifyImagePath(secret, signature, path, now); err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+
+	if err := VerifyImagePath(secret, signature, "w_4000,q_75/aHR0cHM6Ly9leGFtcGxlLmNvbS9jYXQucG5n", now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for a changed path, got %v", err)
+	}
+	if err := VerifyImagePath("other-secret", signature, path, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for another secret, got %v", err)
+	}
+
+	// The key ID and expiry are covered by the MAC
+	signature.Expires = now.Unix() + 60
+	if err := VerifyImagePath(secret, signature, path, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for a changed expiry, got %v", err)
+	}
+}
+
+func TestSignImagePath_Expiry(t *testing.T) {
+	const secret = "test-secret"
+	const path = "-/https%3A%2F%2Fexample.com%2Fcat.png"
+	expires := time.Unix(1700000000, 0)
+
+	signature, err := ParseURLSignature(SignImagePath(secret, 1, expires.Unix(), path))
+	if err != nil {
+		t.Fatalf("Failed to parse signature: %v", err)
+	}
+	if err := VerifyImagePath(secret, signature, path, expires); err != nil {
+		t.Errorf("Expected signature to be valid until it expires, got %v", err)
+	}
+	if err := VerifyImagePath(secret, signature, path, expires.Add(time.Second)); !errors.Is(err, ErrSignatureExpired) {
+		t.Errorf("Expected ErrSignatureExpired, got %v", err)
+	}
+}
+
+func TestParseURLSignature_Invalid(t *testing.T) {
+	valid := SignImagePath("secret", 1, 0, "-/x")
+	segments := []string{
+		"",
+		UnsignedSignature,
+		"1.0",
+		"x.0." + valid[4:],
+		"0.0." + valid[4:],
+		"1.-5." + valid[4:],
+		"1.0.not-a-mac",
+		valid + ".extra",
+	}
+
+	for _, segment := range segments {
+		if _, err := ParseURLSignature(segment); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("ParseURLSignature(%q) error = %v, want ErrInvalidSignature", segment, err)
+		}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"event":"job.completed"}`)
+
+	mac := hmac.New(sha256.New, []byte("whsec"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	signature := SignWebhook("whsec", 1700000000, body)
+	if signature != want {
+		t.Errorf("SignWebhook = %q, want %q", signature, want)
+	}
+	if !VerifyWebhook("whsec", 1700000000, body, signature) {
+		t.Error("Expected signature to verify")
+	}
+	if VerifyWebhook("whsec", 1700000001, body, signature) {
+		t.Error("Expected a different timestamp to fail verification")
+	}
+	if VerifyWebhook("other", 1700000000, body, signature) {
+		t.Error("Expected another secret to fail verification")
+	}
+}
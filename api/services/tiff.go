@@ -0,0 +1,77 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// TIFF output compression methods
+const (
+	TIFFCompressionLZW     = "lzw"
+	TIFFCompressionDeflate = "deflate" // Also accepted as "zip"
+	TIFFCompressionJPEG    = "jpeg"
+	TIFFCompressionNone    = "none"
+
+	// DefaultTIFFCompression is lossless and readable by every archival tool
+	DefaultTIFFCompression = TIFFCompressionLZW
+)
+
+// NormalizeTIFFCompression validates a compression name and returns its canonical
+// form ("zip" is an alias for deflate, "" means the default)
+func NormalizeTIFFCompression(compression string) (string, error) {
+	switch strings.ToLower(compression) {
+	case "":
+		return DefaultTIFFCompression, nil
+	case TIFFCompressionLZW:
+		return TIFFCompressionLZW, nil
+	case TIFFCompressionDeflate, "zip":
+		return TIFFCompressionDeflate, nil
+	case TIFFCompressionJPEG:
+		return TIFFCompressionJPEG, nil
+	case TIFFCompressionNone:
+		return TIFFCompressionNone, nil
+	}
+	return "", fmt.Errorf("unsupported TIFF compression %q", compression)
+}
+
+// compressTIFF re-encodes an uncompressed TIFF with the requested compression.
+// bimg's tiffsave takes no options, so this goes through the vips command line
+// tool. quality is only used for JPEG compression.
+func compressTIFF(tiffData []byte, compression string, quality int) ([]byte, error) {
+	if compression == TIFFCompressionNone {
+		return tiffData, nil
+	}
+
+	dir, err := os.MkdirTemp("", "imgopt-tiff-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	input := filepath.Join(dir, "input.tif")
+	output := filepath.Join(dir, "output.tif")
+	if err := os.WriteFile(input, tiffData, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to stage TIFF: %w", err)
+	}
+
+	args := []string{"tiffsave", input, output, "--compression", compression}
+	if compression == TIFFCompressionJPEG {
+		args = append(args, "--Q", strconv.Itoa(quality))
+	} else {
+		// Horizontal differencing makes LZW/deflate far more effective on photos and scans
+		args = append(args, "--predictor", "horizontal")
+	}
+
+	if _, err := runVips("vips", args...); err != nil {
+		return nil, err
+	}
+
+	compressed, err := os.ReadFile(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compressed TIFF: %w", err)
+	}
+	return compressed, nil
+}
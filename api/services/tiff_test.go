@@ -0,0 +1,43 @@
+package services
+
+import "testing"
+
+func TestNormalizeTIFFCompression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", TIFFCompressionLZW},
+		{"LZW", TIFFCompressionLZW},
+		{"zip", TIFFCompressionDeflate},
+		{"deflate", TIFFCompressionDeflate},
+		{"jpeg", TIFFCompressionJPEG},
+		{"none", TIFFCompressionNone},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeTIFFCompression(tt.input)
+		if err != nil {
+			t.Errorf("NormalizeTIFFCompression(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("NormalizeTIFFCompression(%q) = %q, expected %q", tt.input, got, tt.expected)
+		}
+	}
+
+	if _, err := NormalizeTIFFCompression("ccitt"); err == nil {
+		t.Error("Expected error for unsupported compression")
+	}
+}
+
+func TestCompressTIFFNone(t *testing.T) {
+	data := []byte("II*\x00\x08\x00\x00\x00")
+	out, err := compressTIFF(data, TIFFCompressionNone, 80)
+	if err != nil {
+		t.Fatalf("compressTIFF failed: %v", err)
+	}
+	if string(out) != string(data) {
+		t.Error("Expected uncompressed TIFF to be returned unchanged")
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+
+	"github.com/h2non/bimg"
+)
+
+const (
+	// sdrReferenceWhite is the HDR luminance (cd/m²) mapped to SDR white (ITU-R BT.2408)
+	sdrReferenceWhite = 203.0
+
+	// hlgPeakLuminance is the nominal display peak HLG is rendered for
+	hlgPeakLuminance = 1000.0
+
+	// pqMaxLuminance is the absolute luminance of a full PQ code value
+	pqMaxLuminance = 10000.0
+)
+
+// gamutToBT709 converts linear RGB in the source primaries to BT.709/sRGB primaries.
+// BT.709 sources need no conversion and have no entry.
+var gamutToBT709 = map[int][3][3]float64{
+	primariesBT2020: {
+		{1.6605, -0.5876, -0.0728},
+		{-0.1246, 1.1329, -0.0083},
+		{-0.0182, -0.1006, 1.1187},
+	},
+	primariesP3D65: {
+		{1.2249, -0.2247, 0},
+		{-0.0420, 1.0419, 0},
+		{-0.0197, -0.0786, 1.0979},
+	},
+}
+
+// pqToNits applies the SMPTE ST 2084 EOTF: code value (0-1) to luminance in cd/m²
+func pqToNits(v float64) float64 {
+	const (
+		m1 = 2610.0 / 16384
+		m2 = 2523.0 / 4096 * 128
+		c1 = 3424.0 / 4096
+		c2 = 2413.0 / 4096 * 32
+		c3 = 2392.0 / 4096 * 32
+	)
+	p := math.Pow(math.Max(v, 0), 1/m2)
+	return pqMaxLuminance * math.Pow(math.Max(p-c1, 0)/(c2-c3*p), 1/m1)
+}
+
+// hlgToScene applies the ARIB STD-B67 inverse OETF: code value (0-1) to scene light (0-1)
+func hlgToScene(v float64) float64 {
+	const (
+		a = 0.17883277
+		b = 0.28466892
+		c = 0.55991073
+	)
+	if v <= 0.5 {
+		return v * v / 3
+	}
+	return (math.Exp((v-c)/a) + b) / 12
+}
+
+// reinhardExtended compresses luminance (1.0 = SDR white) so that peak maps to 1.0
+// while values well below white are left close to unchanged
+func reinhardExtended(l, peak float64) float64 {
+	if peak <= 1 {
+		return math.Min(l, 1)
+	}
+	return l * (1 + l/(peak*peak)) / (1 + l)
+}
+
+// toneMapToSDR converts an HDR image (PQ or HLG transfer) to 8-bit sRGB. Code
+// values are decoded to display light, converted to BT.709 primaries, then
+// luminance above SDR reference white is compressed with an extended Reinhard
+// curve so highlights roll off instead of clipping. The result is a lossless
+// PNG for the rest of the pipeline.
+func toneMapToSDR(buffer []byte, encoding colorEncoding) ([]byte, error) {
+	// Keep the full code value precision - libvips does not apply the HDR transfer itself
+	pngData, err := bimg.NewImage(buffer).Process(bimg.Options{
+		Type:           bimg.PNG,
+		Compression:    1, // Decoded immediately, favor speed
+		Interpretation: bimg.InterpretationRGB16,
+		NoProfile:      true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert image for tone mapping: %w", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image for tone mapping: %w", err)
+	}
+
+	toDisplay := hdrDecoder(encoding.Transfer)
+	if toDisplay == nil {
+		return nil, fmt.Errorf("unsupported HDR transfer characteristics %d", encoding.Transfer)
+	}
+	matrix, convertGamut := gamutToBT709[encoding.Primaries]
+	if encoding.Primaries == 0 {
+		// Unsignaled HDR content is BT.2020 in practice
+		matrix, convertGamut = gamutToBT709[primariesBT2020]
+	}
+
+	// displayLight returns linear BT.709 RGB with 1.0 = SDR reference white
+	displayLight := func(c color.NRGBA64) (float64, float64, float64) {
+		r, g, b := toDisplay(c)
+		if convertGamut {
+			r, g, b = matrix[0][0]*r+matrix[0][1]*g+matrix[0][2]*b,
+				matrix[1][0]*r+matrix[1][1]*g+matrix[1][2]*b,
+				matrix[2][0]*r+matrix[2][1]*g+matrix[2][2]*b
+		}
+		return math.Max(r, 0) / sdrReferenceWhite, math.Max(g, 0) / sdrReferenceWhite, math.Max(b, 0) / sdrReferenceWhite
+	}
+
+	bounds := img.Bounds()
+
+	// Roll off to the brightest pixel actually present rather than the format maximum,
+	// so content mastered to 1000 nits is not compressed as if it reached 10000
+	peak := hlgPeakLuminance / sdrReferenceWhite
+	if encoding.Transfer == transferPQ {
+		peak = 1.0
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b := displayLight(storedNRGBA64(img, x, y))
+				peak = math.Max(peak, bt709Luminance(r, g, b))
+			}
+		}
+	}
+
+	table := linearToSRGBTable()
+	encode := func(v float64) uint8 {
+		return table[int(math.Round(math.Min(math.Max(v, 0), 1)*0xffff))]
+	}
+
+	out := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := storedNRGBA64(img, x, y)
+			r, g, b := displayLight(c)
+
+			// Scale all channels by the luminance ratio to keep hue and saturation
+			if l := bt709Luminance(r, g, b); l > 0 {
+				scale := reinhardExtended(l, peak) / l
+				r, g, b = r*scale, g*scale, b*scale
+			}
+
+			out.SetNRGBA(x, y, color.NRGBA{R: encode(r), G: encode(g), B: encode(b), A: uint8(c.A >> 8)})
+		}
+	}
+
+	mapped, err := encodePNGFast(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tone-mapped image: %w", err)
+	}
+	return mapped, nil
+}
+
+// hdrDecoder returns a function converting 16-bit code values to display light
+// in cd/m² (in the source primaries), or nil for non-HDR transfers
+func hdrDecoder(transfer int) func(color.NRGBA64) (float64, float64, float64) {
+	switch transfer {
+	case transferPQ:
+		nits := make([]float64, 0x10000)
+		for i := range nits {
+			nits[i] = pqToNits(float64(i) / 0xffff)
+		}
+		return func(c color.NRGBA64) (float64, float64, float64) {
+			return nits[c.R], nits[c.G], nits[c.B]
+		}
+	case transferHLG:
+		scene := make([]float64, 0x10000)
+		for i := range scene {
+			scene[i] = hlgToScene(float64(i) / 0xffff)
+		}
+		return func(c color.NRGBA64) (float64, float64, float64) {
+			r, g, b := scene[c.R], scene[c.G], scene[c.B]
+			// HLG OOTF with the BT.2100 system gamma for a 1000 cd/m² display
+			ys := 0.2627*r + 0.6780*g + 0.0593*b
+			if ys <= 0 {
+				return 0, 0, 0
+			}
+			gain := hlgPeakLuminance * math.Pow(ys, 0.2)
+			return r * gain, g * gain, b * gain
+		}
+	}
+	return nil
+}
+
+// bt709Luminance returns the relative luminance of linear BT.709 RGB
+func bt709Luminance(r, g, b float64) float64 {
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
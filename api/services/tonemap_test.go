@@ -0,0 +1,56 @@
+package services
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPQToNits(t *testing.T) {
+	tests := []struct {
+		code     float64
+		expected float64
+	}{
+		{0, 0},
+		{1, 10000},
+		{0.5081, 100}, // SDR peak in PQ
+		{0.7518, 1000},
+	}
+
+	for _, tt := range tests {
+		got := pqToNits(tt.code)
+		if math.Abs(got-tt.expected) > math.Max(0.01*tt.expected, 0.001) {
+			t.Errorf("pqToNits(%g) = %g, expected ~%g", tt.code, got, tt.expected)
+		}
+	}
+}
+
+func TestHLGToScene(t *testing.T) {
+	if got := hlgToScene(0.5); math.Abs(got-1.0/12) > 1e-9 {
+		t.Errorf("Expected 1/12 at the HLG knee, got %g", got)
+	}
+	if got := hlgToScene(1); math.Abs(got-1) > 1e-3 {
+		t.Errorf("Expected full scene light at code 1.0, got %g", got)
+	}
+}
+
+func TestReinhardExtended(t *testing.T) {
+	const peak = 1000 / sdrReferenceWhite
+
+	if got := reinhardExtended(peak, peak); math.Abs(got-1) > 1e-9 {
+		t.Errorf("Expected peak to map to SDR white, got %g", got)
+	}
+
+	previous := 0.0
+	for l := 0.1; l <= peak; l += 0.1 {
+		got := reinhardExtended(l, peak)
+		if got <= previous || got > 1 {
+			t.Fatalf("Expected a monotonic curve within [0, 1], got %g at %g", got, l)
+		}
+		previous = got
+	}
+
+	// SDR content needs no compression
+	if got := reinhardExtended(0.5, 1); got != 0.5 {
+		t.Errorf("Expected no change for an SDR peak, got %g", got)
+	}
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+
+	"github.com/h2non/bimg"
+)
+
+// DefaultTrimThreshold is the maximum per-channel difference (0-255) from the border
+// color for a pixel to still count as border. Matches the libvips find_trim default.
+const DefaultTrimThreshold = 10
+
+// TrimInfo reports what was removed by trimming, in source pixel coordinates
+// (after EXIF auto-rotation, before any resize). Left/Top are the offset of the
+// kept area, so overlays positioned on the original can be shifted by (-Left, -Top).
+type TrimInfo struct {
+	Left           int  `json:"left"`   // Pixels removed from the left edge
+	Top            int  `json:"top"`    // Pixels removed from the top edge
+	Right          int  `json:"right"`  // Pixels removed from the right edge
+	Bottom         int  `json:"bottom"` // Pixels removed from the bottom edge
+	Width          int  `json:"width"`  // Width of the kept area
+	Height         int  `json:"height"` // Height of the kept area
+	OriginalWidth  int  `json:"originalWidth"`
+	OriginalHeight int  `json:"originalHeight"`
+	Transparent    bool `json:"transparent"` // True if the border was transparent rather than a solid color
+}
+
+// trimBorders crops uniform borders from the image. The border color is taken from
+// the top-left pixel, as libvips does; a transparent corner trims by transparency.
+// margin pixels of border are kept on every side. The cropped image is returned as
+// a lossless PNG for the rest of the pipeline, or nil if nothing was trimmed.
+func trimBorders(buffer []byte, threshold, margin int) ([]byte, *TrimInfo, error) {
+	// Decode through libvips so every input format and EXIF orientation is handled
+	pngData, err := bimg.NewImage(buffer).Process(bimg.Options{
+		Type:        bimg.PNG,
+		Compression: 1, // Decoded immediately, favor speed
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to convert image for trimming: %w", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode image for trimming: %w", err)
+	}
+
+	bounds := img.Bounds()
+	info := &TrimInfo{
+		Width:          bounds.Dx(),
+		Height:         bounds.Dy(),
+		OriginalWidth:  bounds.Dx(),
+		OriginalHeight: bounds.Dy(),
+	}
+
+	border := premultiplied8(img.At(bounds.Min.X, bounds.Min.Y))
+	info.Transparent = border.A == 0
+
+	content, ok := findContentBounds(img, border, threshold)
+	if !ok {
+		// Uniform image - there is no content to keep, so leave it untouched
+		return nil, info, nil
+	}
+
+	// Keep the requested margin, clamped to the image
+	content = image.Rect(
+		content.Min.X-margin, content.Min.Y-margin,
+		content.Max.X+margin, content.Max.Y+margin,
+	).Intersect(bounds)
+
+	if content == bounds {
+		return nil, info, nil
+	}
+
+	info.Left = content.Min.X - bounds.Min.X
+	info.Top = content.Min.Y - bounds.Min.Y
+	info.Right = bounds.Max.X - content.Max.X
+	info.Bottom = bounds.Max.Y - content.Max.Y
+	info.Width = content.Dx()
+	info.Height = content.Dy()
+
+	// Crop the already lossless intermediate with libvips rather than re-encoding in Go
+	cropped, err := bimg.NewImage(pngData).Process(bimg.Options{
+		Left:        info.Left,
+		Top:         info.Top,
+		AreaWidth:   info.Width,
+		AreaHeight:  info.Height,
+		Type:        bimg.PNG,
+		Compression: 1,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to crop trimmed image: %w", err)
+	}
+
+	return cropped, info, nil
+}
+
+// findContentBounds returns the smallest rectangle containing every pixel that differs
+// from the border color by more than threshold in any premultiplied channel.
+// ok is false if the whole image matches the border color.
+func findContentBounds(img image.Image, border color.RGBA, threshold int) (image.Rectangle, bool) {
+	bounds := img.Bounds()
+	minX, minY := bounds.Max.X, bounds.Max.Y
+	maxX, maxY := bounds.Min.X-1, bounds.Min.Y-1
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if colorDistance(premultiplied8(img.At(x, y)), border) <= threshold {
+				continue
+			}
+			if x < minX {
+				minX = x
+			}
+			if x > maxX {
+				maxX = x
+			}
+			if y < minY {
+				minY = y
+			}
+			if y > maxY {
+				maxY = y
+			}
+		}
+	}
+
+	if maxX < minX || maxY < minY {
+		return image.Rectangle{}, false
+	}
+	return image.Rect(minX, minY, maxX+1, maxY+1), true
+}
+
+// premultiplied8 converts a color to 8-bit premultiplied RGBA, so fully transparent
+// pixels compare equal regardless of their stored color
+func premultiplied8(c color.Color) color.RGBA {
+	r, g, b, a := c.RGBA()
+	return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+}
+
+// colorDistance returns the largest per-channel difference between two colors
+func colorDistance(a, b color.RGBA) int {
+	diff := func(x, y uint8) int {
+		if x > y {
+			return int(x - y)
+		}
+		return int(y - x)
+	}
+	return maxInt(maxInt(diff(a.R, b.R), diff(a.G, b.G)), maxInt(diff(a.B, b.B), diff(a.A, b.A)))
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// createBorderedImage creates a width x height image filled with border and a
+// content rectangle filled with fill
+func createBorderedImage(width, height int, border, fill color.Color, content image.Rectangle) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if image.Pt(x, y).In(content) {
+				img.Set(x, y, fill)
+			} else {
+				img.Set(x, y, border)
+			}
+		}
+	}
+	return img
+}
+
+func TestFindContentBounds_SolidBorder(t *testing.T) {
+	content := image.Rect(10, 5, 30, 25)
+	img := createBorderedImage(40, 40, color.White, color.RGBA{200, 30, 30, 255}, content)
+
+	got, ok := findContentBounds(img, premultiplied8(img.At(0, 0)), DefaultTrimThreshold)
+	if !ok {
+		t.Fatal("Expected content to be found")
+	}
+	if got != content {
+		t.Errorf("Expected bounds %v, got %v", content, got)
+	}
+}
+
+func TestFindContentBounds_TransparentBorder(t *testing.T) {
+	content := image.Rect(3, 4, 8, 9)
+	img := createBorderedImage(12, 12, color.NRGBA{255, 0, 255, 0}, color.NRGBA{0, 0, 0, 255}, content)
+
+	// A barely visible pixel (alpha 5) stays within the default threshold
+	img.SetNRGBA(0, 11, color.NRGBA{255, 255, 255, 5})
+
+	got, ok := findContentBounds(img, premultiplied8(img.At(0, 0)), DefaultTrimThreshold)
+	if !ok {
+		t.Fatal("Expected content to be found")
+	}
+	if got != content {
+		t.Errorf("Expected bounds %v, got %v", content, got)
+	}
+}
+
+func TestFindContentBounds_Threshold(t *testing.T) {
+	// Off-white noise in the border is ignored at the default threshold but not at 0
+	content := image.Rect(10, 10, 20, 20)
+	img := createBorderedImage(30, 30, color.White, color.Black, content)
+	img.Set(2, 2, color.RGBA{250, 250, 250, 255})
+
+	border := premultiplied8(img.At(0, 0))
+	if got, _ := findContentBounds(img, border, DefaultTrimThreshold); got != content {
+		t.Errorf("Expected noise to be ignored, got %v", got)
+	}
+	if got, _ := findContentBounds(img, border, 0); got != image.Rect(2, 2, 20, 20) {
+		t.Errorf("Expected threshold 0 to keep the noise pixel, got %v", got)
+	}
+}
+
+func TestFindContentBounds_Uniform(t *testing.T) {
+	img := createBorderedImage(8, 8, color.White, color.White, image.Rectangle{})
+	if _, ok := findContentBounds(img, premultiplied8(img.At(0, 0)), DefaultTrimThreshold); ok {
+		t.Error("Expected uniform image to have no content bounds")
+	}
+}
+
+func TestColorDistance(t *testing.T) {
+	tests := []struct {
+		a, b     color.RGBA
+		expected int
+	}{
+		{color.RGBA{0, 0, 0, 0}, color.RGBA{0, 0, 0, 0}, 0},
+		{color.RGBA{255, 255, 255, 255}, color.RGBA{250, 240, 255, 255}, 15},
+		{color.RGBA{0, 0, 0, 0}, color.RGBA{0, 0, 0, 255}, 255},
+	}
+
+	for _, tt := range tests {
+		if got := colorDistance(tt.a, tt.b); got != tt.expected {
+			t.Errorf("colorDistance(%v, %v) = %d, expected %d", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BenchmarkCell mirrors one row of the API's /benchmark response
+type BenchmarkCell struct {
+	Format           string  `json:"format"`
+	Quality          int     `json:"quality"`
+	Effort           int     `json:"effort"`
+	Size             int64   `json:"size"`
+	Savings          float64 `json:"savings"`
+	EncodeTimeMs     float64 `json:"encodeTimeMs"`
+	PeakMemory       int64   `json:"peakMemory"`
+	SSIM             float64 `json:"ssim"`
+	AlreadyOptimized bool    `json:"alreadyOptimized"`
+	Error            string  `json:"error"`
+}
+
+// BenchmarkResult mirrors the API's /benchmark response
+type BenchmarkResult struct {
+	Format       string          `json:"format"`
+	Width        int             `json:"width"`
+	Height       int             `json:"height"`
+	OriginalSize int64           `json:"originalSize"`
+	Cells        []BenchmarkCell `json:"cells"`
+	TotalTimeMs  float64         `json:"totalTimeMs"`
+}
+
+// runBenchmark implements `imgopt benchmark [options] <file>` and returns the exit code
+func runBenchmark(args []string) int {
+	fs := flag.NewFlagSet("benchmark", flag.ExitOnError)
+	formats := fs.String("formats", "", "Comma-separated formats (default: jpeg,webp,avif,png)")
+	qualities := fs.String("qualities", "", "Comma-separated qualities 1-100 (default: 60,75,90)")
+	efforts := fs.String("efforts", "", "Comma-separated encoder efforts 0-6 (default: 1,4,6)")
+	output := fs.String("output", "table", "Output format: table, json, csv")
+	outFile := fs.String("out", "", "Write output to file instead of stdout")
+	configPath := fs.String("config", "", "Path to config file (default: .imgoptrc or ~/.imgoptrc)")
+	endpoint := fs.String("api", apiURL, "API endpoint URL")
+	fs.Usage = func() {
+		fmt.Println("Usage: imgopt benchmark [options] <file>")
+		fmt.Println("\nEncodes one image with every format/quality/effort combination and reports")
+		fmt.Println("output size, encode time, peak memory and SSIM for each.")
+		fmt.Println("\nOptions:")
+		fs.PrintDefaults()
+		fmt.Println("\nExamples:")
+		fmt.Println("  imgopt benchmark photo.jpg")
+		fmt.Println("  imgopt benchmark -formats=webp,avif -qualities=50,70,90 photo.jpg")
+		fmt.Println("  imgopt benchmark -output=csv -out=results.csv photo.jpg")
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	if *output != "table" && *output != "json" && *output != "csv" {
+		fmt.Fprintf(os.Stderr, "Error: Invalid output format %q (use table, json or csv)\n", *output)
+		return 1
+	}
+
+	// Use the API from the config file unless -api was given explicitly
+	apiSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "api" {
+			apiSet = true
+		}
+	})
+	if !apiSet {
+		if configFile := findConfigFile(*configPath); configFile != "" {
+			if fileConfig, err := loadConfigFile(configFile); err == nil && fileConfig.API != "" {
+				*endpoint = fileConfig.API
+			}
+		}
+	}
+
+	if !checkAPIAvailability(*endpoint) {
+		fmt.Fprintf(os.Stderr, "Error: Cannot connect to API at %s\n", *endpoint)
+		fmt.Fprintln(os.Stderr, "Please ensure the image-optimizer API is running.")
+		return 1
+	}
+
+	// The API returns CSV directly; table output is rendered from JSON
+	apiOutput := "json"
+	if *output == "csv" {
+		apiOutput = "csv"
+	}
+
+	query := url.Values{}
+	query.Set("output", apiOutput)
+	if *formats != "" {
+		query.Set("formats", *formats)
+	}
+	if *qualities != "" {
+		query.Set("qualities", *qualities)
+	}
+	if *efforts != "" {
+		query.Set("efforts", *efforts)
+	}
+
+	file := fs.Arg(0)
+	fmt.Fprintf(os.Stderr, "Benchmarking %s (this runs one full encode per combination)...\n", filepath.Base(file))
+	body, err := postBenchmark(file, strings.TrimSuffix(*endpoint, "/optimize")+"/benchmark?"+query.Encode())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var out io.Writer = os.Stdout
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot create output file: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if *output != "table" {
+		if _, err := out.Write(body); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot write output: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	var result BenchmarkResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot parse API response: %v\n", err)
+		return 1
+	}
+	printBenchmarkTable(out, result)
+	return 0
+}
+
+// postBenchmark uploads the file to the benchmark endpoint and returns the response body
+func postBenchmark(filePath, endpoint string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open file: %w", err)
+	}
+	defer file.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	h := make(map[string][]string)
+	h["Content-Disposition"] = []string{fmt.Sprintf(`form-data; name="image"; filename="%s"`, filepath.Base(filePath))}
+	h["Content-Type"] = []string{getContentType(filePath)}
+
+	part, err := writer.CreatePart(h)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create form file: %w", err)
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return nil, fmt.Errorf("cannot copy file data: %w", err)
+	}
+	writer.Close()
+
+	// A full matrix on a large image can take several minutes
+	client := &http.Client{Timeout: 10 * time.Minute}
+	req, err := http.NewRequest("POST", endpoint, body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
+
+// printBenchmarkTable renders the benchmark matrix as an aligned table
+func printBenchmarkTable(w io.Writer, result BenchmarkResult) {
+	fmt.Fprintf(w, "\nSource: %s %dx%d, %s\n\n", result.Format, result.Width, result.Height, formatBytes(result.OriginalSize))
+	fmt.Fprintf(w, "%-6s | %-7s | %-6s | %-10s | %-8s | %-10s | %-10s | %-6s\n",
+		"Format", "Quality", "Effort", "Size", "Savings", "Time", "Peak Mem", "SSIM")
+	fmt.Fprintln(w, strings.Repeat("-", 85))
+
+	for _, cell := range result.Cells {
+		// PNG has no quality axis and JPEG no effort axis
+		quality, effort := fmt.Sprintf("%d", cell.Quality), fmt.Sprintf("%d", cell.Effort)
+		if cell.Format == "png" {
+			quality = "-"
+		}
+		if cell.Format == "jpeg" {
+			effort = "-"
+		}
+
+		if cell.Error != "" {
+			fmt.Fprintf(w, "%-6s | %-7s | %-6s | ✗ %s\n", cell.Format, quality, effort, cell.Error)
+			continue
+		}
+
+		size := formatBytes(cell.Size)
+		if cell.AlreadyOptimized {
+			size += "*"
+		}
+		fmt.Fprintf(w, "%-6s | %-7s | %-6s | %-10s | %7.2f%% | %8.1fms | %-10s | %.4f\n",
+			cell.Format, quality, effort, size, cell.Savings, cell.EncodeTimeMs, formatBytes(cell.PeakMemory), cell.SSIM)
+	}
+
+	fmt.Fprintln(w, strings.Repeat("-", 85))
+	fmt.Fprintf(w, "Total time: %s\n", (time.Duration(result.TotalTimeMs) * time.Millisecond).String())
+	for _, cell := range result.Cells {
+		if cell.AlreadyOptimized {
+			fmt.Fprintln(w, "* Re-encoding was larger than the source; the original would be returned")
+			break
+		}
+	}
+}
@@ -26,6 +26,7 @@ type Config struct {
 	Quality      int
 	Width        int
 	Height       int
+	DPR          float64
 	Format       string
 	Output       string
 	APIEndpoint  string
@@ -37,12 +38,13 @@ type Config struct {
 
 // FileConfig represents the structure of .imgoptrc config file
 type FileConfig struct {
-	Quality int    `yaml:"quality"`
-	Width   int    `yaml:"width"`
-	Height  int    `yaml:"height"`
-	Format  string `yaml:"format"`
-	Output  string `yaml:"output"`
-	API     string `yaml:"api"`
+	Quality int     `yaml:"quality"`
+	Width   int     `yaml:"width"`
+	Height  int     `yaml:"height"`
+	DPR     float64 `yaml:"dpr"`
+	Format  string  `yaml:"format"`
+	Output  string  `yaml:"output"`
+	API     string  `yaml:"api"`
 }
 
 // OptimizeResult represents the optimization statistics
@@ -57,6 +59,14 @@ type OptimizeResult struct {
 }
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "benchmark" {
+		os.Exit(runBenchmark(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sign" {
+		os.Exit(runSign(os.Args[2:]))
+	}
+
 	config := parseFlags()
 
 	if config.ShowVersion {
@@ -177,6 +187,7 @@ func parseFlags() Config {
 	flag.IntVar(&config.Quality, "quality", defaultQuality, "Quality level (1-100)")
 	flag.IntVar(&config.Width, "width", 0, "Target width in pixels (0 = no resize)")
 	flag.IntVar(&config.Height, "height", 0, "Target height in pixels (0 = no resize)")
+	flag.Float64Var(&config.DPR, "dpr", 0, "Device pixel ratio (1-4) multiplying width/height (0 = off)")
 	flag.StringVar(&config.Format, "format", "", "Output format (jpeg, png, webp, avif, gif)")
 	flag.StringVar(&config.Output, "output", "", "Output directory (default: same as input)")
 	flag.StringVar(&config.APIEndpoint, "api", apiURL, "API endpoint URL")
@@ -204,6 +215,9 @@ func parseFlags() Config {
 			if fileConfig.Height > 0 {
 				config.Height = fileConfig.Height
 			}
+			if fileConfig.DPR > 0 {
+				config.DPR = fileConfig.DPR
+			}
 			if fileConfig.Format != "" {
 				config.Format = fileConfig.Format
 			}
@@ -233,6 +247,9 @@ func parseFlags() Config {
 	if flagsSet["height"] {
 		flag.Lookup("height").Value.Set(flag.Lookup("height").Value.String())
 	}
+	if flagsSet["dpr"] {
+		flag.Lookup("dpr").Value.Set(flag.Lookup("dpr").Value.String())
+	}
 	if flagsSet["format"] {
 		flag.Lookup("format").Value.Set(flag.Lookup("format").Value.String())
 	}
@@ -254,6 +271,8 @@ func printUsage() {
 	fmt.Println("imgopt - Image Optimization CLI")
 	fmt.Printf("Version: %s\n\n", version)
 	fmt.Println("Usage: imgopt [options] <file1> [file2] [file3] ...")
+	fmt.Println("       imgopt benchmark [options] <file>")
+	fmt.Println("       imgopt sign [options] <image-url>")
 	fmt.Println("\nOptions:")
 	flag.PrintDefaults()
 	fmt.Println("\nConfiguration File:")
@@ -265,6 +284,7 @@ func printUsage() {
 	fmt.Println("  imgopt photo.jpg")
 	fmt.Println("  imgopt -quality=90 -format=webp photo.jpg")
 	fmt.Println("  imgopt -width=800 -height=600 *.jpg")
+	fmt.Println("  imgopt -width=400 -dpr=2 photo.jpg")
 	fmt.Println("  imgopt -output=optimized/ photo1.jpg photo2.png")
 	fmt.Println("  imgopt -config=custom.imgoptrc photo.jpg")
 }
@@ -343,6 +363,9 @@ func optimizeFile(filePath string, config Config) OptimizeResult {
 	if config.Height > 0 {
 		url += fmt.Sprintf("&height=%d", config.Height)
 	}
+	if config.DPR > 0 {
+		url += fmt.Sprintf("&dpr=%g", config.DPR)
+	}
 	if config.Format != "" {
 		url += fmt.Sprintf("&format=%s", config.Format)
 	}
@@ -413,8 +436,11 @@ func getOutputPath(inputPath string, config Config) string {
 	ext := filepath.Ext(base)
 	nameWithoutExt := strings.TrimSuffix(base, ext)
 
-	// Determine output extension
+	// Determine output extension - BMP input comes back as PNG by default
 	outputExt := ext
+	if strings.EqualFold(ext, ".bmp") {
+		outputExt = ".png"
+	}
 	if config.Format != "" {
 		switch config.Format {
 		case "jpeg", "jpg":
@@ -425,6 +451,8 @@ func getOutputPath(inputPath string, config Config) string {
 			outputExt = ".webp"
 		case "gif":
 			outputExt = ".gif"
+		case "tiff", "tif":
+			outputExt = ".tif"
 		}
 	}
 
@@ -433,7 +461,13 @@ func getOutputPath(inputPath string, config Config) string {
 		dir = config.Output
 	}
 
-	return filepath.Join(dir, nameWithoutExt+"-optimized"+outputExt)
+	// Mark high-density renditions the way srcset conventions do (photo-optimized@2x.jpg)
+	suffix := "-optimized"
+	if config.DPR > 1 {
+		suffix += fmt.Sprintf("@%gx", config.DPR)
+	}
+
+	return filepath.Join(dir, nameWithoutExt+suffix+outputExt)
 }
 
 // getSavingsDisplay formats savings for display
@@ -511,6 +545,10 @@ func getContentType(filePath string) string {
 		return "image/gif"
 	case ".webp":
 		return "image/webp"
+	case ".tif", ".tiff":
+		return "image/tiff"
+	case ".bmp":
+		return "image/bmp"
 	default:
 		return "application/octet-stream"
 	}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// SignResponse mirrors the API's /api/sign response
+type SignResponse struct {
+	URL       string     `json:"url"`
+	Path      string     `json:"path"`
+	Signature string     `json:"signature"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// runSign implements `imgopt sign [options] <image-url>` and returns the exit code
+func runSign(args []string) int {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	options := fs.String("options", "-", "Proxy options, e.g. w_400,q_75,f_auto (- = none)")
+	expires := fs.Duration("expires", 0, "Lifetime of the signed URL, e.g. 24h (0 = never expires)")
+	apiKey := fs.String("key", os.Getenv("IMGOPT_API_KEY"), "API key whose secret signs the URL (default: $IMGOPT_API_KEY)")
+	configPath := fs.String("config", "", "Path to config file (default: .imgoptrc or ~/.imgoptrc)")
+	endpoint := fs.String("api", apiURL, "API endpoint URL")
+	fs.Usage = func() {
+		fmt.Println("Usage: imgopt sign [options] <image-url>")
+		fmt.Println("\nPrints a signed /img proxy URL for a source image. Signing uses the")
+		fmt.Println("secret of the given API key.")
+		fmt.Println("\nOptions:")
+		fs.PrintDefaults()
+		fmt.Println("\nExamples:")
+		fmt.Println("  imgopt sign -key=sk_... https://example.com/photo.jpg")
+		fmt.Println("  imgopt sign -options=w_400,q_75,f_auto -expires=24h https://example.com/photo.jpg")
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	if *apiKey == "" {
+		fmt.Fprintln(os.Stderr, "Error: An API key is required (-key or IMGOPT_API_KEY)")
+		return 1
+	}
+	if *expires < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -expires must not be negative")
+		return 1
+	}
+
+	// Use the API from the config file unless -api was given explicitly
+	apiSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "api" {
+			apiSet = true
+		}
+	})
+	if !apiSet {
+		if configFile := findConfigFile(*configPath); configFile != "" {
+			if fileConfig, err := loadConfigFile(configFile); err == nil && fileConfig.API != "" {
+				*endpoint = fileConfig.API
+			}
+		}
+	}
+
+	request, err := json.Marshal(map[string]interface{}{
+		"url":       fs.Arg(0),
+		"options":   *options,
+		"expiresIn": int64(expires.Seconds()),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	signed, err := postSign(strings.TrimSuffix(*endpoint, "/optimize")+"/api/sign", *apiKey, request)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Println(signed.URL)
+	if signed.ExpiresAt != nil {
+		fmt.Fprintf(os.Stderr, "Expires: %s\n", signed.ExpiresAt.Local().Format(time.RFC1123))
+	}
+	return 0
+}
+
+// postSign asks the API to sign an image URL
+func postSign(endpoint, apiKey string, request []byte) (*SignResponse, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+
+	var signed SignResponse
+	if err := json.Unmarshal(respBody, &signed); err != nil {
+		return nil, fmt.Errorf("cannot parse API response: %w", err)
+	}
+	return &signed, nil
+}